@@ -1,30 +1,95 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"moshr/internal/batch"
 	"moshr/internal/server"
+	"moshr/internal/worker"
 )
 
 func main() {
 	var (
-		port    = flag.String("port", "8080", "server port")
-		webMode = flag.Bool("web", false, "run in web mode")
+		port           = flag.String("port", "8080", "server port")
+		webMode        = flag.Bool("web", false, "run in web mode")
+		maxRunTime     = flag.Duration("max-run-time", 0, "longest a single render may run, 0 for no limit")
+		effectRunTimes = flag.String("effect-max-run-times", "", "per effect limits, e.g. kaleidoscope=20m,chain=1h")
+		maxSweepJobs   = flag.Int("max-sweep-jobs", batch.DefaultMaxSweepJobs, "most moshes a single parameter sweep may queue")
+		workers        = flag.Int("workers", 2, "jobs the web server renders at once itself, 0 to leave them to remote workers")
+		cacheSize      = flag.Int64("cache-size-mb", 10240, "most disk space in MB the render cache may use, 0 to disable it")
+		workerMode     = flag.Bool("worker", false, "run as a remote worker that leases jobs from -coordinator")
+		coordinator    = flag.String("coordinator", "http://localhost:8080", "server a remote worker leases jobs from")
+		workerName     = flag.String("worker-name", "", "name a remote worker leases jobs under, hostname-pid by default")
 	)
 	flag.Parse()
 
+	effectMaxRunTimes, err := parseRunTimes(*effectRunTimes)
+	if err != nil {
+		log.Fatal("Invalid -effect-max-run-times: ", err)
+	}
+
+	if *workerMode {
+		name := *workerName
+		if name == "" {
+			hostname, _ := os.Hostname()
+			name = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := worker.New(*coordinator, name).Run(ctx); err != nil && ctx.Err() == nil {
+			log.Fatal("Worker stopped: ", err)
+		}
+		return
+	}
+
 	if *webMode {
 		fmt.Printf("Starting web server on port %s\n", *port)
-		if err := server.Start(*port); err != nil {
+		opts := server.Options{
+			MaxRunTime:        *maxRunTime,
+			EffectMaxRunTimes: effectMaxRunTimes,
+			MaxSweepJobs:      *maxSweepJobs,
+			Workers:           *workers,
+			CacheMaxBytes:     *cacheSize << 20,
+		}
+		if err := server.Start(*port, opts); err != nil {
 			log.Fatal("Failed to start server:", err)
 		}
 	} else {
 		fmt.Println("Moshr - Video Datamoshing Tool")
 		fmt.Println("Usage: moshr -web to start web interface")
 		fmt.Println("       moshr -port=8080 -web to specify port")
+		fmt.Println("       moshr -web -max-run-time=1h -effect-max-run-times=kaleidoscope=20m to limit renders")
+		fmt.Println("       moshr -worker -coordinator=http://localhost:8080 to render jobs for a server")
 		os.Exit(0)
 	}
 }
+
+// parseRunTimes reads a comma separated list of effect=duration pairs.
+func parseRunTimes(s string) (map[string]time.Duration, error) {
+	limits := make(map[string]time.Duration)
+	if s == "" {
+		return limits, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		effect, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || effect == "" {
+			return nil, fmt.Errorf("expected effect=duration, got %q", pair)
+		}
+		limit, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", effect, err)
+		}
+		limits[effect] = limit
+	}
+	return limits, nil
+}
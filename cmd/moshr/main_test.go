@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRunTimes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{"", map[string]time.Duration{}, false},
+		{"kaleidoscope=20m", map[string]time.Duration{"kaleidoscope": 20 * time.Minute}, false},
+		{"glitch=90s, sonify=1h30m", map[string]time.Duration{"glitch": 90 * time.Second, "sonify": 90 * time.Minute}, false},
+		{"glitch", nil, true},
+		{"=20m", nil, true},
+		{"glitch=20", nil, true},
+		{"glitch=20m,", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRunTimes(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRunTimes(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRunTimes(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package avi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Index flags as stored in idx1 entries
+const (
+	FlagList     uint32 = 0x00000001
+	FlagKeyframe uint32 = 0x00000010
+	FlagNoTime   uint32 = 0x00000100
+)
+
+// Chunk is a single node of the RIFF tree. RIFF and LIST chunks carry a
+// list type and children, every other chunk carries its raw payload.
+type Chunk struct {
+	ID       string
+	ListType string
+	Data     []byte
+	Children []*Chunk
+
+	// Flags holds the idx1 flags for chunks inside the movi list. They are
+	// read from the original index and written back when idx1 is rebuilt.
+	Flags uint32
+}
+
+func (c *Chunk) IsList() bool {
+	return c.ID == "RIFF" || c.ID == "LIST"
+}
+
+// Size returns the payload size as written in the chunk header, excluding
+// the 8 byte header and the pad byte.
+func (c *Chunk) Size() int {
+	if !c.IsList() {
+		return len(c.Data)
+	}
+
+	size := 4
+	for _, child := range c.Children {
+		size += child.paddedSize()
+	}
+	return size
+}
+
+func (c *Chunk) paddedSize() int {
+	size := 8 + c.Size()
+	if size%2 == 1 {
+		size++
+	}
+	return size
+}
+
+// Find returns the first direct child with the given chunk ID.
+func (c *Chunk) Find(id string) *Chunk {
+	for _, child := range c.Children {
+		if child.ID == id {
+			return child
+		}
+	}
+	return nil
+}
+
+// FindList returns the first direct LIST child with the given list type.
+func (c *Chunk) FindList(listType string) *Chunk {
+	for _, child := range c.Children {
+		if child.ID == "LIST" && child.ListType == listType {
+			return child
+		}
+	}
+	return nil
+}
+
+// Copy returns a shallow copy of a leaf chunk that shares its payload.
+func (c *Chunk) Copy() *Chunk {
+	return &Chunk{ID: c.ID, Data: c.Data, Flags: c.Flags}
+}
+
+// StreamNumber returns the stream a movi chunk belongs to, decoded from the
+// two leading digits of its ID ("01wb" -> 1), or -1 for other chunks.
+func StreamNumber(id string) int {
+	if len(id) != 4 || !isDigit(id[0]) || !isDigit(id[1]) {
+		return -1
+	}
+	return int(id[0]-'0')*10 + int(id[1]-'0')
+}
+
+// IsVideo reports whether a movi chunk ID holds compressed or raw video.
+func IsVideo(id string) bool {
+	return StreamNumber(id) >= 0 && (id[2:] == "dc" || id[2:] == "db")
+}
+
+// IsAudio reports whether a movi chunk ID holds audio.
+func IsAudio(id string) bool {
+	return StreamNumber(id) >= 0 && id[2:] == "wb"
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// File is a parsed AVI file.
+type File struct {
+	Root *Chunk
+}
+
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AVI file: %v", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes an AVI file into a chunk tree. Truncated chunks are clipped
+// to the data that is actually present so damaged files still load. OpenDML
+// AVIX extensions are folded into the primary movi list and their standard
+// indexes are dropped, since idx1 is rebuilt on write.
+func Parse(data []byte) (*File, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("file too small to be a valid AVI file")
+	}
+	if string(data[0:4]) != "RIFF" {
+		return nil, fmt.Errorf("not a RIFF file (first 4 bytes: %v)", data[0:4])
+	}
+	if string(data[8:12]) != "AVI " {
+		return nil, fmt.Errorf("not an AVI file (bytes 8-12: %v)", data[8:12])
+	}
+
+	var riffs []*Chunk
+	var offsets []int
+	pos := 0
+	for pos+12 <= len(data) && string(data[pos:pos+4]) == "RIFF" {
+		chunk, next := parseChunk(data, pos, len(data))
+		riffs = append(riffs, chunk)
+		offsets = append(offsets, pos)
+		pos = next
+	}
+
+	file := &File{Root: riffs[0]}
+
+	if movi := file.Movi(); movi != nil {
+		if idx := file.Root.Find("idx1"); idx != nil {
+			applyIndexFlags(movi, moviOffset(data, offsets[0]), parseIndex(idx.Data))
+		} else {
+			markKeyframes(movi)
+		}
+
+		for _, extra := range riffs[1:] {
+			if extra.ListType != "AVIX" {
+				continue
+			}
+			if extMovi := extra.FindList("movi"); extMovi != nil {
+				movi.Children = append(movi.Children, extMovi.Children...)
+			}
+		}
+
+		movi.Children = dropStandardIndexes(movi.Children)
+	}
+
+	return file, nil
+}
+
+func parseChunk(data []byte, pos, end int) (*Chunk, int) {
+	id := string(data[pos : pos+4])
+	size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+
+	dataStart := pos + 8
+	dataEnd := dataStart + size
+	if dataEnd > end || dataEnd < dataStart {
+		dataEnd = end
+	}
+
+	next := dataEnd
+	if size%2 == 1 {
+		next++
+	}
+
+	chunk := &Chunk{ID: id}
+	if (id == "RIFF" || id == "LIST") && dataEnd-dataStart >= 4 {
+		chunk.ListType = string(data[dataStart : dataStart+4])
+		childPos := dataStart + 4
+		for childPos+8 <= dataEnd {
+			child, childNext := parseChunk(data, childPos, dataEnd)
+			chunk.Children = append(chunk.Children, child)
+			childPos = childNext
+		}
+		return chunk, next
+	}
+
+	chunk.Data = data[dataStart:dataEnd]
+	return chunk, next
+}
+
+// moviOffset returns the absolute position of the movi list type in the
+// primary RIFF, which is the base of idx1 offsets in most writers.
+func moviOffset(data []byte, riffPos int) int {
+	pos := riffPos + 12
+	for pos+12 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if id == "LIST" && string(data[pos+8:pos+12]) == "movi" {
+			return pos + 8
+		}
+		pos += 8 + size + size%2
+	}
+	return 0
+}
+
+func dropStandardIndexes(chunks []*Chunk) []*Chunk {
+	kept := chunks[:0]
+	for _, chunk := range chunks {
+		if len(chunk.ID) == 4 && chunk.ID[:2] == "ix" {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+	return kept
+}
+
+// Movi returns the movi list holding the stream data.
+func (f *File) Movi() *Chunk {
+	return f.Root.FindList("movi")
+}
+
+// MainHeader returns the decoded avih header.
+func (f *File) MainHeader() (*MainHeader, error) {
+	hdrl := f.Root.FindList("hdrl")
+	if hdrl == nil {
+		return nil, fmt.Errorf("missing hdrl list")
+	}
+	avih := hdrl.Find("avih")
+	if avih == nil {
+		return nil, fmt.Errorf("missing avih header")
+	}
+	return decodeMainHeader(avih.Data)
+}
+
+// Stream is one strl list of the header.
+type Stream struct {
+	Header *StreamHeader
+	Format []byte
+
+	headerChunk *Chunk
+}
+
+// Streams returns the stream headers in stream number order.
+func (f *File) Streams() []*Stream {
+	hdrl := f.Root.FindList("hdrl")
+	if hdrl == nil {
+		return nil
+	}
+
+	var streams []*Stream
+	for _, child := range hdrl.Children {
+		if child.ID != "LIST" || child.ListType != "strl" {
+			continue
+		}
+		strh := child.Find("strh")
+		if strh == nil {
+			continue
+		}
+		// Keep damaged headers as placeholders so stream numbers line up
+		stream := &Stream{Header: &StreamHeader{}}
+		if header, err := decodeStreamHeader(strh.Data); err == nil {
+			stream.Header = header
+			stream.headerChunk = strh
+		}
+		if strf := child.Find("strf"); strf != nil {
+			stream.Format = strf.Data
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// VideoStream returns the number of the first video stream, or -1.
+func (f *File) VideoStream() int {
+	for i, stream := range f.Streams() {
+		if stream.Header.Type == "vids" {
+			return i
+		}
+	}
+	return -1
+}
+
+// FrameRate returns the video frame rate from the first video stream header,
+// falling back to the main header frame duration.
+func (f *File) FrameRate() float64 {
+	if n := f.VideoStream(); n >= 0 {
+		if rate := f.Streams()[n].Header.FrameRate(); rate > 0 {
+			return rate
+		}
+	}
+	if main, err := f.MainHeader(); err == nil && main.MicroSecPerFrame > 0 {
+		return 1000000 / float64(main.MicroSecPerFrame)
+	}
+	return 0
+}
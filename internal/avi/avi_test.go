@@ -0,0 +1,253 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testHeader builds an hdrl list with a 30 fps video stream and, when
+// withAudio is set, a 16-bit stereo PCM audio stream.
+func testHeader(withAudio bool) *Chunk {
+	avih := make([]byte, 56)
+	binary.LittleEndian.PutUint32(avih[0:], 33333)
+	strh := make([]byte, 56)
+	copy(strh, "vidsXVID")
+	binary.LittleEndian.PutUint32(strh[20:], 1)
+	binary.LittleEndian.PutUint32(strh[24:], 30)
+	hdrl := &Chunk{ID: "LIST", ListType: "hdrl", Children: []*Chunk{
+		{ID: "avih", Data: avih},
+		{ID: "LIST", ListType: "strl", Children: []*Chunk{
+			{ID: "strh", Data: strh},
+			{ID: "strf", Data: make([]byte, 40)},
+		}},
+	}}
+
+	if withAudio {
+		strh := make([]byte, 56)
+		copy(strh, "auds")
+		binary.LittleEndian.PutUint32(strh[20:], 1)
+		binary.LittleEndian.PutUint32(strh[24:], 44100)
+		binary.LittleEndian.PutUint32(strh[44:], 4)
+		hdrl.Children = append(hdrl.Children, &Chunk{ID: "LIST", ListType: "strl", Children: []*Chunk{
+			{ID: "strh", Data: strh},
+			{ID: "strf", Data: make([]byte, 18)},
+		}})
+	}
+	return hdrl
+}
+
+// testFile builds an AVI around the given movi chunks.
+func testFile(withAudio bool, movi ...*Chunk) *File {
+	return &File{Root: &Chunk{ID: "RIFF", ListType: "AVI ", Children: []*Chunk{
+		testHeader(withAudio),
+		{ID: "LIST", ListType: "movi", Children: movi},
+	}}}
+}
+
+// vop returns an MPEG-4 payload whose VOP header has the given coding type.
+func vop(coding byte, size int) []byte {
+	return append([]byte{0, 0, 1, 0xb6, coding << 6}, bytes.Repeat([]byte{0x55}, size)...)
+}
+
+func encodeFile(t *testing.T, f *File) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStreamNumber(t *testing.T) {
+	tests := []struct {
+		id     string
+		number int
+		video  bool
+		audio  bool
+	}{
+		{"00dc", 0, true, false},
+		{"00db", 0, true, false},
+		{"01wb", 1, false, true},
+		{"12dc", 12, true, false},
+		{"02tx", 2, false, false},
+		{"ix00", -1, false, false},
+		{"JUNK", -1, false, false},
+		{"0dc", -1, false, false},
+	}
+
+	for _, tt := range tests {
+		if got := StreamNumber(tt.id); got != tt.number {
+			t.Errorf("StreamNumber(%q) = %d, want %d", tt.id, got, tt.number)
+		}
+		if got := IsVideo(tt.id); got != tt.video {
+			t.Errorf("IsVideo(%q) = %v, want %v", tt.id, got, tt.video)
+		}
+		if got := IsAudio(tt.id); got != tt.audio {
+			t.Errorf("IsAudio(%q) = %v, want %v", tt.id, got, tt.audio)
+		}
+	}
+}
+
+func TestParseRejectsOtherFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"too short", []byte("RIFF")},
+		{"not RIFF", append([]byte("RIFX\x04\x00\x00\x00"), "AVI "...)},
+		{"not AVI", append([]byte("RIFF\x04\x00\x00\x00"), "WAVE"...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestWriteToRoundTrip(t *testing.T) {
+	file := testFile(true,
+		&Chunk{ID: "01wb", Data: make([]byte, 16), Flags: FlagKeyframe},
+		&Chunk{ID: "00dc", Data: vop(0, 40), Flags: FlagKeyframe},
+		&Chunk{ID: "01wb", Data: make([]byte, 16), Flags: FlagKeyframe},
+		// An odd size needs a pad byte
+		&Chunk{ID: "00dc", Data: vop(1, 7)},
+		&Chunk{ID: "00dc", Data: vop(1, 20)},
+	)
+	data := encodeFile(t, file)
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if got := encodeFile(t, parsed); !bytes.Equal(got, data) {
+		t.Error("writing a parsed file changed its bytes")
+	}
+
+	movi := parsed.Movi().Children
+	if len(movi) != 5 {
+		t.Fatalf("got %d movi chunks, want 5", len(movi))
+	}
+	for i, want := range file.Movi().Children {
+		if movi[i].ID != want.ID || !bytes.Equal(movi[i].Data, want.Data) || movi[i].Flags != want.Flags {
+			t.Errorf("chunk %d: got %s with flags %x, want %s with flags %x", i, movi[i].ID, movi[i].Flags, want.ID, want.Flags)
+		}
+	}
+}
+
+func TestWriteToRegeneratesHeaders(t *testing.T) {
+	file := testFile(true,
+		&Chunk{ID: "01wb", Data: make([]byte, 16), Flags: FlagKeyframe},
+		&Chunk{ID: "00dc", Data: vop(0, 90), Flags: FlagKeyframe},
+		&Chunk{ID: "01wb", Data: make([]byte, 32), Flags: FlagKeyframe},
+		&Chunk{ID: "00dc", Data: vop(1, 10)},
+		&Chunk{ID: "00dc", Data: vop(1, 10)},
+	)
+	parsed, err := Parse(encodeFile(t, file))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	main, err := parsed.MainHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.TotalFrames != 3 {
+		t.Errorf("TotalFrames = %d, want 3", main.TotalFrames)
+	}
+	if main.Flags&flagHasIndex == 0 {
+		t.Error("main header does not flag the index")
+	}
+	if main.SuggestedBufferSize != 95 {
+		t.Errorf("SuggestedBufferSize = %d, want 95", main.SuggestedBufferSize)
+	}
+
+	streams := parsed.Streams()
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(streams))
+	}
+	if streams[0].Header.Length != 3 {
+		t.Errorf("video length = %d, want 3", streams[0].Header.Length)
+	}
+	// 48 bytes of 4 byte samples
+	if streams[1].Header.Length != 12 {
+		t.Errorf("audio length = %d, want 12", streams[1].Header.Length)
+	}
+	if rate := parsed.FrameRate(); rate != 30 {
+		t.Errorf("FrameRate = %v, want 30", rate)
+	}
+
+	idx := parsed.Root.Find("idx1")
+	if idx == nil {
+		t.Fatal("no idx1 written")
+	}
+	entries := parseIndex(idx.Data)
+	if len(entries) != 5 {
+		t.Fatalf("got %d index entries, want 5", len(entries))
+	}
+	offset := uint32(4)
+	for i, entry := range entries {
+		chunk := file.Movi().Children[i]
+		if entry.ChunkID != chunk.ID || entry.Offset != offset || entry.Size != uint32(len(chunk.Data)) || entry.Flags != chunk.Flags {
+			t.Errorf("entry %d = %+v, want %s at %d with flags %x", i, entry, chunk.ID, offset, chunk.Flags)
+		}
+		offset += uint32(chunk.paddedSize())
+	}
+}
+
+func TestParseWithoutIndexMarksKeyframes(t *testing.T) {
+	file := testFile(true,
+		&Chunk{ID: "01wb", Data: make([]byte, 16)},
+		&Chunk{ID: "00dc", Data: vop(0, 10)},
+		&Chunk{ID: "00dc", Data: vop(1, 10)},
+		&Chunk{ID: "00dc", Data: []byte{0x7f}},
+	)
+	// Written without finalizing, so the file has no idx1
+	var buf bytes.Buffer
+	if _, err := writeChunk(&buf, file.Root); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// Audio chunks are keyframes and video ones follow their VOP header
+	want := []uint32{FlagKeyframe, FlagKeyframe, 0, 0}
+	for i, chunk := range parsed.Movi().Children {
+		if chunk.Flags != want[i] {
+			t.Errorf("chunk %d flags = %x, want %x", i, chunk.Flags, want[i])
+		}
+	}
+}
+
+func TestParseAcceptsAbsoluteIndexOffsets(t *testing.T) {
+	file := testFile(false,
+		&Chunk{ID: "00dc", Data: []byte{1, 2, 3, 4}, Flags: FlagKeyframe},
+		&Chunk{ID: "00dc", Data: []byte{5, 6, 7, 8}},
+		&Chunk{ID: "00dc", Data: []byte{9, 10, 11, 12}, Flags: FlagKeyframe},
+	)
+	data := encodeFile(t, file)
+
+	// Rewrite the index the way writers with absolute offsets store it
+	base := uint32(moviOffset(data, 0))
+	idx := bytes.Index(data, []byte("idx1")) + 8
+	for pos := idx; pos+indexEntrySize <= len(data); pos += indexEntrySize {
+		offset := binary.LittleEndian.Uint32(data[pos+8:])
+		binary.LittleEndian.PutUint32(data[pos+8:], offset+base)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	for i, chunk := range parsed.Movi().Children {
+		if want := file.Movi().Children[i].Flags; chunk.Flags != want {
+			t.Errorf("chunk %d flags = %x, want %x", i, chunk.Flags, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package avi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MainHeader mirrors the AVIMAINHEADER structure stored in avih.
+type MainHeader struct {
+	MicroSecPerFrame    uint32
+	MaxBytesPerSec      uint32
+	PaddingGranularity  uint32
+	Flags               uint32
+	TotalFrames         uint32
+	InitialFrames       uint32
+	Streams             uint32
+	SuggestedBufferSize uint32
+	Width               uint32
+	Height              uint32
+}
+
+const mainHeaderSize = 40
+
+func decodeMainHeader(data []byte) (*MainHeader, error) {
+	if len(data) < mainHeaderSize {
+		return nil, fmt.Errorf("avih header too short: %d bytes", len(data))
+	}
+
+	le := binary.LittleEndian
+	return &MainHeader{
+		MicroSecPerFrame:    le.Uint32(data[0:4]),
+		MaxBytesPerSec:      le.Uint32(data[4:8]),
+		PaddingGranularity:  le.Uint32(data[8:12]),
+		Flags:               le.Uint32(data[12:16]),
+		TotalFrames:         le.Uint32(data[16:20]),
+		InitialFrames:       le.Uint32(data[20:24]),
+		Streams:             le.Uint32(data[24:28]),
+		SuggestedBufferSize: le.Uint32(data[28:32]),
+		Width:               le.Uint32(data[32:36]),
+		Height:              le.Uint32(data[36:40]),
+	}, nil
+}
+
+// encode writes the header back over data, keeping the reserved tail.
+func (h *MainHeader) encode(data []byte) {
+	le := binary.LittleEndian
+	le.PutUint32(data[0:4], h.MicroSecPerFrame)
+	le.PutUint32(data[4:8], h.MaxBytesPerSec)
+	le.PutUint32(data[8:12], h.PaddingGranularity)
+	le.PutUint32(data[12:16], h.Flags)
+	le.PutUint32(data[16:20], h.TotalFrames)
+	le.PutUint32(data[20:24], h.InitialFrames)
+	le.PutUint32(data[24:28], h.Streams)
+	le.PutUint32(data[28:32], h.SuggestedBufferSize)
+	le.PutUint32(data[32:36], h.Width)
+	le.PutUint32(data[36:40], h.Height)
+}
+
+// StreamHeader mirrors the AVISTREAMHEADER structure stored in strh.
+type StreamHeader struct {
+	Type                string
+	Handler             string
+	Flags               uint32
+	Priority            uint16
+	Language            uint16
+	InitialFrames       uint32
+	Scale               uint32
+	Rate                uint32
+	Start               uint32
+	Length              uint32
+	SuggestedBufferSize uint32
+	Quality             uint32
+	SampleSize          uint32
+}
+
+const streamHeaderSize = 48
+
+func decodeStreamHeader(data []byte) (*StreamHeader, error) {
+	if len(data) < streamHeaderSize {
+		return nil, fmt.Errorf("strh header too short: %d bytes", len(data))
+	}
+
+	le := binary.LittleEndian
+	return &StreamHeader{
+		Type:                string(data[0:4]),
+		Handler:             string(data[4:8]),
+		Flags:               le.Uint32(data[8:12]),
+		Priority:            le.Uint16(data[12:14]),
+		Language:            le.Uint16(data[14:16]),
+		InitialFrames:       le.Uint32(data[16:20]),
+		Scale:               le.Uint32(data[20:24]),
+		Rate:                le.Uint32(data[24:28]),
+		Start:               le.Uint32(data[28:32]),
+		Length:              le.Uint32(data[32:36]),
+		SuggestedBufferSize: le.Uint32(data[36:40]),
+		Quality:             le.Uint32(data[40:44]),
+		SampleSize:          le.Uint32(data[44:48]),
+	}, nil
+}
+
+// encode writes the header back over data, keeping the rcFrame tail.
+func (h *StreamHeader) encode(data []byte) {
+	le := binary.LittleEndian
+	copy(data[0:4], h.Type)
+	copy(data[4:8], h.Handler)
+	le.PutUint32(data[8:12], h.Flags)
+	le.PutUint16(data[12:14], h.Priority)
+	le.PutUint16(data[14:16], h.Language)
+	le.PutUint32(data[16:20], h.InitialFrames)
+	le.PutUint32(data[20:24], h.Scale)
+	le.PutUint32(data[24:28], h.Rate)
+	le.PutUint32(data[28:32], h.Start)
+	le.PutUint32(data[32:36], h.Length)
+	le.PutUint32(data[36:40], h.SuggestedBufferSize)
+	le.PutUint32(data[40:44], h.Quality)
+	le.PutUint32(data[44:48], h.SampleSize)
+}
+
+// FrameRate returns the stream rate in units per second.
+func (h *StreamHeader) FrameRate() float64 {
+	if h.Scale == 0 {
+		return 0
+	}
+	return float64(h.Rate) / float64(h.Scale)
+}
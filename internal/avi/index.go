@@ -0,0 +1,84 @@
+package avi
+
+import (
+	"encoding/binary"
+)
+
+// IndexEntry is one AVIOLDINDEX entry from idx1.
+type IndexEntry struct {
+	ChunkID string
+	Flags   uint32
+	Offset  uint32
+	Size    uint32
+}
+
+const indexEntrySize = 16
+
+func parseIndex(data []byte) []IndexEntry {
+	le := binary.LittleEndian
+	entries := make([]IndexEntry, 0, len(data)/indexEntrySize)
+	for pos := 0; pos+indexEntrySize <= len(data); pos += indexEntrySize {
+		entries = append(entries, IndexEntry{
+			ChunkID: string(data[pos : pos+4]),
+			Flags:   le.Uint32(data[pos+4 : pos+8]),
+			Offset:  le.Uint32(data[pos+8 : pos+12]),
+			Size:    le.Uint32(data[pos+12 : pos+16]),
+		})
+	}
+	return entries
+}
+
+// applyIndexFlags copies idx1 flags onto the movi chunks they describe.
+// Offsets are usually relative to the movi list type, but some writers
+// store absolute file offsets, so both are accepted.
+func applyIndexFlags(movi *Chunk, moviPos int, entries []IndexEntry) {
+	if len(entries) == 0 || len(movi.Children) == 0 {
+		return
+	}
+
+	base := 0
+	if int(entries[0].Offset) == moviPos+4 {
+		base = moviPos
+	}
+
+	flags := make(map[int]uint32, len(entries))
+	for _, entry := range entries {
+		flags[int(entry.Offset)-base] = entry.Flags
+	}
+
+	offset := 4
+	for _, child := range movi.Children {
+		if f, ok := flags[offset]; ok {
+			child.Flags = f
+		}
+		offset += child.paddedSize()
+	}
+}
+
+// buildIndex generates idx1 entries for the current movi children with
+// offsets relative to the movi list type.
+func buildIndex(movi *Chunk) []byte {
+	data := make([]byte, 0, len(movi.Children)*indexEntrySize)
+
+	offset := 4
+	for _, child := range movi.Children {
+		data = append(data, encodeIndexEntry(child, offset)...)
+		offset += child.paddedSize()
+	}
+	return data
+}
+
+func encodeIndexEntry(c *Chunk, offset int) []byte {
+	flags := c.Flags
+	if c.IsList() {
+		flags |= FlagList
+	}
+
+	le := binary.LittleEndian
+	entry := make([]byte, indexEntrySize)
+	copy(entry[0:4], c.ID)
+	le.PutUint32(entry[4:8], flags)
+	le.PutUint32(entry[8:12], uint32(offset))
+	le.PutUint32(entry[12:16], uint32(c.Size()))
+	return entry
+}
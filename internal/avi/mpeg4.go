@@ -0,0 +1,99 @@
+package avi
+
+// FrameType is the coding type of a single video chunk.
+type FrameType int
+
+const (
+	FrameUnknown FrameType = iota
+	FrameI
+	FrameP
+	FrameB
+	FrameS
+	// FrameSkip marks empty or placeholder chunks such as the one byte
+	// N-VOPs Xvid writes when a packed bitstream delays a frame.
+	FrameSkip
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameI:
+		return "I"
+	case FrameP:
+		return "P"
+	case FrameB:
+		return "B"
+	case FrameS:
+		return "S"
+	case FrameSkip:
+		return "skip"
+	default:
+		return "unknown"
+	}
+}
+
+// vopStartCode is the MPEG-4 Part 2 start code prefix 00 00 01 followed by B6.
+const vopStartCode = 0xB6
+
+// VOPType scans an MPEG-4 Part 2 (Xvid, DivX, ffmpeg mpeg4) payload for the
+// first VOP header and returns its vop_coding_type. Keyframes usually start
+// with VOS/VOL headers, so the scan skips over those.
+func VOPType(data []byte) FrameType {
+	for i := 0; i+4 < len(data); i++ {
+		if data[i] != 0x00 || data[i+1] != 0x00 || data[i+2] != 0x01 {
+			continue
+		}
+		if data[i+3] != vopStartCode {
+			continue
+		}
+
+		switch data[i+4] >> 6 {
+		case 0:
+			return FrameI
+		case 1:
+			return FrameP
+		case 2:
+			return FrameB
+		case 3:
+			return FrameS
+		}
+	}
+	return FrameUnknown
+}
+
+// FrameTypeOf classifies a movi video chunk. The VOP header wins when the
+// stream is MPEG-4 Part 2; other codecs fall back to the idx1 keyframe flag.
+func FrameTypeOf(c *Chunk) FrameType {
+	if len(c.Data) <= 1 {
+		return FrameSkip
+	}
+	if t := VOPType(c.Data); t != FrameUnknown {
+		return t
+	}
+	if c.Flags&FlagKeyframe != 0 {
+		return FrameI
+	}
+	return FrameP
+}
+
+// markKeyframes fills in keyframe flags for files that have no idx1.
+func markKeyframes(movi *Chunk) {
+	for _, child := range movi.Children {
+		child.Flags |= defaultFlags(child)
+	}
+}
+
+// defaultFlags guesses the index flags of a chunk that has no idx1 entry.
+// Video keyframes are detected from the VOP header, every other stream
+// chunk is treated as a keyframe the way ffmpeg writes audio.
+func defaultFlags(c *Chunk) uint32 {
+	if IsVideo(c.ID) {
+		if VOPType(c.Data) == FrameI {
+			return FlagKeyframe
+		}
+		return 0
+	}
+	if StreamNumber(c.ID) >= 0 {
+		return FlagKeyframe
+	}
+	return 0
+}
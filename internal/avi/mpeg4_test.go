@@ -0,0 +1,72 @@
+package avi
+
+import "testing"
+
+func TestVOPType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want FrameType
+	}{
+		{"I-frame", vop(0, 4), FrameI},
+		{"P-frame", vop(1, 4), FrameP},
+		{"B-frame", vop(2, 4), FrameB},
+		{"S-frame", vop(3, 4), FrameS},
+		{"after VOS and VOL headers", append([]byte{0, 0, 1, 0xb0, 0xf5, 0, 0, 1, 0x20, 0x08}, vop(0, 4)...), FrameI},
+		{"first VOP wins", append(vop(1, 2), vop(0, 2)...), FrameP},
+		{"no start code", []byte{1, 2, 3, 4, 5, 6}, FrameUnknown},
+		{"other start code", []byte{0, 0, 1, 0xb3, 0x40}, FrameUnknown},
+		{"truncated header", []byte{0, 0, 1, 0xb6}, FrameUnknown},
+		{"empty", nil, FrameUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VOPType(tt.data); got != tt.want {
+				t.Errorf("VOPType = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameTypeOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk *Chunk
+		want  FrameType
+	}{
+		{"empty chunk", &Chunk{ID: "00dc"}, FrameSkip},
+		{"N-VOP placeholder", &Chunk{ID: "00dc", Data: []byte{0x7f}, Flags: FlagKeyframe}, FrameSkip},
+		{"VOP header wins over the flag", &Chunk{ID: "00dc", Data: vop(1, 4), Flags: FlagKeyframe}, FrameP},
+		{"VOP I-frame without the flag", &Chunk{ID: "00dc", Data: vop(0, 4)}, FrameI},
+		{"other codec keyframe", &Chunk{ID: "00dc", Data: []byte{1, 2, 3}, Flags: FlagKeyframe}, FrameI},
+		{"other codec delta frame", &Chunk{ID: "00dc", Data: []byte{1, 2, 3}}, FrameP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FrameTypeOf(tt.chunk); got != tt.want {
+				t.Errorf("FrameTypeOf = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultFlags(t *testing.T) {
+	tests := []struct {
+		chunk *Chunk
+		want  uint32
+	}{
+		{&Chunk{ID: "00dc", Data: vop(0, 4)}, FlagKeyframe},
+		{&Chunk{ID: "00dc", Data: vop(1, 4)}, 0},
+		{&Chunk{ID: "00dc", Data: []byte{1, 2, 3}}, 0},
+		{&Chunk{ID: "01wb", Data: []byte{1, 2, 3}}, FlagKeyframe},
+		{&Chunk{ID: "JUNK", Data: []byte{1, 2, 3}}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := defaultFlags(tt.chunk); got != tt.want {
+			t.Errorf("defaultFlags(%s % x) = %x, want %x", tt.chunk.ID, tt.chunk.Data, got, tt.want)
+		}
+	}
+}
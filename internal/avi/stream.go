@@ -0,0 +1,474 @@
+package avi
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+type span struct {
+	start int64
+	end   int64
+}
+
+// Reader walks an AVI from an io.ReadSeeker. Only the header chunks are
+// kept in memory; movi chunks are read one at a time while iterating.
+type Reader struct {
+	rs       io.ReadSeeker
+	size     int64
+	header   *File
+	movis    []span
+	index    span
+	moviBase int64
+}
+
+func NewReader(rs io.ReadSeeker) (*Reader, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek input: %v", err)
+	}
+
+	r := &Reader{rs: rs, size: size}
+
+	head, err := r.readAt(0, 12)
+	if err != nil {
+		return nil, fmt.Errorf("file too small to be a valid AVI file")
+	}
+	if string(head[0:4]) != "RIFF" {
+		return nil, fmt.Errorf("not a RIFF file (first 4 bytes: %v)", head[0:4])
+	}
+	if string(head[8:12]) != "AVI " {
+		return nil, fmt.Errorf("not an AVI file (bytes 8-12: %v)", head[8:12])
+	}
+
+	root := &Chunk{ID: "RIFF", ListType: "AVI "}
+	r.header = &File{Root: root}
+
+	pos := int64(0)
+	for pos+12 <= size {
+		head, err := r.readAt(pos, 12)
+		if err != nil || string(head[0:4]) != "RIFF" {
+			break
+		}
+		riffSize := int64(binary.LittleEndian.Uint32(head[4:8]))
+		riffEnd := pos + 8 + riffSize
+		if riffEnd > size {
+			riffEnd = size
+		}
+
+		primary := pos == 0
+		if !primary && string(head[8:12]) != "AVIX" {
+			break
+		}
+		if err := r.scanRIFF(root, pos+12, riffEnd, primary); err != nil {
+			return nil, err
+		}
+
+		pos = riffEnd + riffSize%2
+	}
+
+	if len(r.movis) == 0 {
+		return nil, fmt.Errorf("no movi list found")
+	}
+	return r, nil
+}
+
+func (r *Reader) scanRIFF(root *Chunk, pos, end int64, primary bool) error {
+	for pos+8 <= end {
+		head, err := r.readAt(pos, 8)
+		if err != nil {
+			return err
+		}
+		id := string(head[0:4])
+		size := int64(binary.LittleEndian.Uint32(head[4:8]))
+		chunkEnd := pos + 8 + size
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+
+		listType := ""
+		if id == "LIST" && chunkEnd-pos >= 12 {
+			t, err := r.readAt(pos+8, 4)
+			if err != nil {
+				return err
+			}
+			listType = string(t)
+		}
+
+		switch {
+		case listType == "movi":
+			r.movis = append(r.movis, span{pos + 12, chunkEnd})
+			if primary {
+				r.moviBase = pos + 8
+				root.Children = append(root.Children, &Chunk{ID: "LIST", ListType: "movi"})
+			}
+		case !primary:
+			// AVIX segments only contribute their movi data
+		case id == "idx1":
+			r.index = span{pos + 8, chunkEnd}
+		default:
+			data, err := r.readAt(pos, int(chunkEnd-pos))
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint32(data[4:8], uint32(chunkEnd-pos-8))
+			child, _ := parseChunk(data, 0, len(data))
+			root.Children = append(root.Children, child)
+		}
+
+		pos += 8 + size + size%2
+	}
+	return nil
+}
+
+func (r *Reader) readAt(pos int64, n int) ([]byte, error) {
+	if _, err := r.rs.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.rs, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Header returns the in-memory header tree. Its movi list is an empty
+// placeholder; the stream data is only reachable through Chunks.
+func (r *Reader) Header() *File {
+	return r.header
+}
+
+// Chunks calls fn for every movi chunk in file order, including chunks in
+// OpenDML AVIX segments. Each chunk gets a freshly allocated payload and
+// carries its idx1 flags.
+func (r *Reader) Chunks(fn func(c *Chunk) error) error {
+	var cursor *indexCursor
+	if r.index.end > r.index.start {
+		cursor = newIndexCursor(r)
+	}
+
+	for i, m := range r.movis {
+		c := cursor
+		if i > 0 {
+			c = nil
+		}
+		if err := r.walk(m.start, m.end, c, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) walk(pos, end int64, cursor *indexCursor, fn func(c *Chunk) error) error {
+	for pos+8 <= end {
+		head, err := r.readAt(pos, 8)
+		if err != nil {
+			return err
+		}
+		id := string(head[0:4])
+		size := int64(binary.LittleEndian.Uint32(head[4:8]))
+		dataEnd := pos + 8 + size
+		if dataEnd > end {
+			dataEnd = end
+		}
+		next := pos + 8 + size + size%2
+
+		if id == "LIST" {
+			// rec lists group interleaved chunks; they are flattened
+			if dataEnd-pos >= 12 {
+				t, err := r.readAt(pos+8, 4)
+				if err != nil {
+					return err
+				}
+				if string(t) == "rec " {
+					if err := r.walk(pos+12, dataEnd, cursor, fn); err != nil {
+						return err
+					}
+				}
+			}
+			pos = next
+			continue
+		}
+
+		if id[:2] == "ix" {
+			pos = next
+			continue
+		}
+
+		data, err := r.readAt(pos+8, int(dataEnd-pos-8))
+		if err != nil {
+			return err
+		}
+		chunk := &Chunk{ID: id, Data: data}
+
+		flags, ok := uint32(0), false
+		if cursor != nil {
+			flags, ok = cursor.flags(pos - r.moviBase)
+		}
+		if ok {
+			chunk.Flags = flags
+		} else {
+			chunk.Flags = defaultFlags(chunk)
+		}
+
+		if err := fn(chunk); err != nil {
+			return err
+		}
+		pos = next
+	}
+	return nil
+}
+
+// indexCursor reads idx1 in small batches alongside the movi walk, so the
+// index never has to be held in memory as a whole.
+type indexCursor struct {
+	r       *Reader
+	pos     int64
+	base    int64
+	entries []IndexEntry
+	i       int
+}
+
+const indexBatch = 512
+
+func newIndexCursor(r *Reader) *indexCursor {
+	c := &indexCursor{r: r, pos: r.index.start}
+	if c.fill() && int64(c.entries[0].Offset) == r.moviBase+4 {
+		c.base = r.moviBase
+	}
+	return c
+}
+
+func (c *indexCursor) fill() bool {
+	n := c.r.index.end - c.pos
+	if n > indexBatch*indexEntrySize {
+		n = indexBatch * indexEntrySize
+	}
+	n -= n % indexEntrySize
+	if n <= 0 {
+		return false
+	}
+
+	data, err := c.r.readAt(c.pos, int(n))
+	if err != nil {
+		return false
+	}
+	c.pos += n
+	c.entries = parseIndex(data)
+	c.i = 0
+	return len(c.entries) > 0
+}
+
+// flags returns the index flags for the chunk at the given offset relative
+// to the movi list type. Entries are expected in movi order.
+func (c *indexCursor) flags(offset int64) (uint32, bool) {
+	for {
+		if c.i >= len(c.entries) && !c.fill() {
+			return 0, false
+		}
+		entry := c.entries[c.i]
+		rel := int64(entry.Offset) - c.base
+		if rel < offset {
+			c.i++
+			continue
+		}
+		if rel == offset {
+			c.i++
+			return entry.Flags, true
+		}
+		return 0, false
+	}
+}
+
+// Transform maps one movi chunk to the chunks written in its place.
+type Transform func(c *Chunk) ([]*Chunk, error)
+
+// Chain runs transforms one after another, feeding every chunk produced by
+// one transform into the next.
+func Chain(transforms ...Transform) Transform {
+	return func(c *Chunk) ([]*Chunk, error) {
+		chunks := []*Chunk{c}
+		for _, t := range transforms {
+			var next []*Chunk
+			for _, chunk := range chunks {
+				out, err := t(chunk)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, out...)
+			}
+			chunks = next
+		}
+		return chunks, nil
+	}
+}
+
+// Rewrite streams an AVI from rs to w, passing every movi chunk through a
+// transform. Because w cannot seek, the input is walked three times: once
+// to lay out sizes and frame counts, once to write the data and once to
+// write idx1. newTransform is called at the start of every pass and must
+// produce the same chunk layout each time; only payload bytes may differ
+// between passes. Memory use is bounded by the header plus one chunk.
+func Rewrite(rs io.ReadSeeker, w io.Writer, newTransform func(header *File) (Transform, error)) error {
+	return Splice([]io.ReadSeeker{rs}, w, func(header *File, source int) (Transform, error) {
+		return newTransform(header)
+	})
+}
+
+// Splice works like Rewrite but concatenates the movi chunks of several
+// AVIs into one file that keeps the headers of the first. The sources must
+// share the same stream layout, which in practice means they were encoded
+// with the same settings. newTransform is called once per source per pass.
+func Splice(sources []io.ReadSeeker, w io.Writer, newTransform func(header *File, source int) (Transform, error)) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	readers := make([]*Reader, len(sources))
+	for i, rs := range sources {
+		r, err := NewReader(rs)
+		if err != nil {
+			return fmt.Errorf("input %d: %v", i+1, err)
+		}
+		readers[i] = r
+	}
+	header := readers[0].Header()
+
+	if err := checkStreamLayout(header, readers[1:]); err != nil {
+		return err
+	}
+
+	run := func(emit func(c *Chunk) error) error {
+		for i, r := range readers {
+			t, err := newTransform(header, i)
+			if err != nil {
+				return err
+			}
+			err = r.Chunks(func(c *Chunk) error {
+				out, err := t(c)
+				if err != nil {
+					return err
+				}
+				for _, chunk := range out {
+					if err := emit(chunk); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	l := newLayout()
+	if err := run(func(c *Chunk) error {
+		l.add(c)
+		return nil
+	}); err != nil {
+		return err
+	}
+	header.updateHeaders(l)
+
+	indexSize := l.chunks * indexEntrySize
+	riffSize := int64(4)
+	for _, child := range header.Root.Children {
+		if child.ID == "LIST" && child.ListType == "movi" {
+			riffSize += 8 + int64(l.moviSize) + 8 + int64(indexSize)
+		} else {
+			riffSize += int64(child.paddedSize())
+		}
+	}
+	if riffSize > math.MaxUint32 {
+		return fmt.Errorf("output exceeds the AVI size limit (%d bytes)", riffSize)
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if err := writeChunkHeader(bw, "RIFF", int(riffSize)); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("AVI "); err != nil {
+		return err
+	}
+
+	for _, child := range header.Root.Children {
+		if child.ID != "LIST" || child.ListType != "movi" {
+			if _, err := writeChunk(bw, child); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeChunkHeader(bw, "LIST", l.moviSize); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString("movi"); err != nil {
+			return err
+		}
+
+		written := 4
+		if err := run(func(c *Chunk) error {
+			written += c.paddedSize()
+			_, err := writeChunk(bw, c)
+			return err
+		}); err != nil {
+			return err
+		}
+		if written != l.moviSize {
+			return fmt.Errorf("transform changed the movi layout between passes (%d != %d bytes)", written, l.moviSize)
+		}
+
+		if err := writeChunkHeader(bw, "idx1", indexSize); err != nil {
+			return err
+		}
+		offset := 4
+		if err := run(func(c *Chunk) error {
+			_, err := bw.Write(encodeIndexEntry(c, offset))
+			offset += c.paddedSize()
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func checkStreamLayout(header *File, others []*Reader) error {
+	streams := header.Streams()
+	for i, r := range others {
+		other := r.Header().Streams()
+		if len(other) != len(streams) {
+			return fmt.Errorf("input %d has %d streams, expected %d", i+2, len(other), len(streams))
+		}
+		for n := range streams {
+			if other[n].Header.Type != streams[n].Header.Type {
+				return fmt.Errorf("input %d stream %d is %q, expected %q", i+2, n, other[n].Header.Type, streams[n].Header.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// ContextReader wraps rs so reads fail with ctx's error once ctx is done,
+// which stops a Rewrite or Splice that is reading from it.
+func ContextReader(ctx context.Context, rs io.ReadSeeker) io.ReadSeeker {
+	return &contextReader{ctx: ctx, ReadSeeker: rs}
+}
+
+type contextReader struct {
+	ctx context.Context
+	io.ReadSeeker
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadSeeker.Read(p)
+}
@@ -0,0 +1,295 @@
+package avi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testMovi() []*Chunk {
+	return []*Chunk{
+		{ID: "01wb", Data: make([]byte, 16), Flags: FlagKeyframe},
+		{ID: "00dc", Data: vop(0, 40), Flags: FlagKeyframe},
+		{ID: "01wb", Data: make([]byte, 16), Flags: FlagKeyframe},
+		{ID: "00dc", Data: vop(1, 7)},
+		{ID: "00dc", Data: vop(1, 20)},
+		{ID: "00dc", Data: vop(2, 3)},
+	}
+}
+
+// collect reads every movi chunk of an AVI through a Reader.
+func collect(t *testing.T, data []byte) []*Chunk {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	var chunks []*Chunk
+	if err := r.Chunks(func(c *Chunk) error {
+		chunks = append(chunks, c)
+		return nil
+	}); err != nil {
+		t.Fatalf("Chunks failed: %v", err)
+	}
+	return chunks
+}
+
+func TestReaderMatchesParse(t *testing.T) {
+	data := encodeFile(t, testFile(true, testMovi()...))
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	chunks := collect(t, data)
+	want := parsed.Movi().Children
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i := range want {
+		if chunks[i].ID != want[i].ID || !bytes.Equal(chunks[i].Data, want[i].Data) || chunks[i].Flags != want[i].Flags {
+			t.Errorf("chunk %d: got %s with flags %x, want %s with flags %x", i, chunks[i].ID, chunks[i].Flags, want[i].ID, want[i].Flags)
+		}
+	}
+}
+
+func TestReaderFlattensRecLists(t *testing.T) {
+	movi := testMovi()
+	rec := &Chunk{ID: "LIST", ListType: "rec ", Children: movi[:2]}
+	var buf bytes.Buffer
+	if _, err := writeChunk(&buf, testFile(true, append([]*Chunk{rec}, movi[2:]...)...).Root); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := collect(t, buf.Bytes())
+	if len(chunks) != len(movi) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(movi))
+	}
+	for i, c := range chunks {
+		if c.ID != movi[i].ID {
+			t.Errorf("chunk %d is %s, want %s", i, c.ID, movi[i].ID)
+		}
+	}
+}
+
+func TestNewReaderRejectsOtherFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte("RIFF")},
+		{"not AVI", append([]byte("RIFF\x04\x00\x00\x00"), "WAVE"...)},
+		{"no movi", encodeFile(t, &File{Root: &Chunk{ID: "RIFF", ListType: "AVI ", Children: []*Chunk{testHeader(false)}}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(tt.data)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRewriteIdentityMatchesWriteTo(t *testing.T) {
+	data := encodeFile(t, testFile(true, testMovi()...))
+
+	var out bytes.Buffer
+	err := Rewrite(bytes.NewReader(data), &out, func(*File) (Transform, error) {
+		return func(c *Chunk) ([]*Chunk, error) { return []*Chunk{c}, nil }, nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("an identity rewrite changed the file")
+	}
+}
+
+func TestRewriteUpdatesHeaders(t *testing.T) {
+	data := encodeFile(t, testFile(false, testMovi()[1:2]...))
+
+	// Every video chunk is written three times
+	var out bytes.Buffer
+	err := Rewrite(bytes.NewReader(data), &out, func(*File) (Transform, error) {
+		return func(c *Chunk) ([]*Chunk, error) {
+			if !IsVideo(c.ID) {
+				return []*Chunk{c}, nil
+			}
+			return []*Chunk{c, c, c}, nil
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+
+	parsed, err := Parse(out.Bytes())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if got := len(parsed.Movi().Children); got != 3 {
+		t.Errorf("got %d movi chunks, want 3", got)
+	}
+	main, err := parsed.MainHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.TotalFrames != 3 {
+		t.Errorf("TotalFrames = %d, want 3", main.TotalFrames)
+	}
+}
+
+func TestRewriteFailsOnLayoutChange(t *testing.T) {
+	data := encodeFile(t, testFile(true, testMovi()...))
+
+	pass := 0
+	err := Rewrite(bytes.NewReader(data), &bytes.Buffer{}, func(*File) (Transform, error) {
+		pass++
+		extra := pass
+		return func(c *Chunk) ([]*Chunk, error) {
+			return []*Chunk{{ID: c.ID, Data: append(c.Data, make([]byte, extra)...), Flags: c.Flags}}, nil
+		}, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "layout") {
+		t.Errorf("got error %v, want a layout error", err)
+	}
+}
+
+func TestRewriteStopsOnTransformError(t *testing.T) {
+	data := encodeFile(t, testFile(true, testMovi()...))
+
+	err := Rewrite(bytes.NewReader(data), &bytes.Buffer{}, func(*File) (Transform, error) {
+		return func(c *Chunk) ([]*Chunk, error) {
+			return nil, fmt.Errorf("bad chunk %s", c.ID)
+		}, nil
+	})
+	if err == nil || err.Error() != "bad chunk 01wb" {
+		t.Errorf("got error %v, want the transform's error", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	tag := func(suffix string, copies int) Transform {
+		return func(c *Chunk) ([]*Chunk, error) {
+			var out []*Chunk
+			for i := 0; i < copies; i++ {
+				out = append(out, &Chunk{ID: c.ID, Data: append(append([]byte{}, c.Data...), suffix...)})
+			}
+			return out, nil
+		}
+	}
+	drop := func(c *Chunk) ([]*Chunk, error) { return nil, nil }
+
+	tests := []struct {
+		name       string
+		transforms []Transform
+		want       []string
+	}{
+		{"no transforms", nil, []string{"x"}},
+		{"one transform", []Transform{tag("a", 1)}, []string{"xa"}},
+		{"in order", []Transform{tag("a", 1), tag("b", 1)}, []string{"xab"}},
+		{"fans out", []Transform{tag("a", 2), tag("b", 2)}, []string{"xab", "xab", "xab", "xab"}},
+		{"drops", []Transform{tag("a", 2), drop, tag("b", 1)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Chain(tt.transforms...)(&Chunk{ID: "00dc", Data: []byte("x")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range out {
+				got = append(got, string(c.Data))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpliceConcatenatesSources(t *testing.T) {
+	first := encodeFile(t, testFile(true, testMovi()...))
+	second := encodeFile(t, testFile(true, testMovi()[:3]...))
+
+	var sources []int
+	var out bytes.Buffer
+	err := Splice([]io.ReadSeeker{bytes.NewReader(first), bytes.NewReader(second)}, &out, func(header *File, source int) (Transform, error) {
+		sources = append(sources, source)
+		return func(c *Chunk) ([]*Chunk, error) { return []*Chunk{c}, nil }, nil
+	})
+	if err != nil {
+		t.Fatalf("Splice failed: %v", err)
+	}
+	// Once per source in each of the three passes
+	if fmt.Sprint(sources) != "[0 1 0 1 0 1]" {
+		t.Errorf("transforms were made for sources %v", sources)
+	}
+
+	chunks := collect(t, out.Bytes())
+	want := append(testMovi(), testMovi()[:3]...)
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i := range want {
+		if chunks[i].ID != want[i].ID || !bytes.Equal(chunks[i].Data, want[i].Data) {
+			t.Errorf("chunk %d is %s, want %s", i, chunks[i].ID, want[i].ID)
+		}
+	}
+}
+
+func TestSpliceRejectsMismatchedStreams(t *testing.T) {
+	tests := []struct {
+		name   string
+		second *File
+	}{
+		{"missing audio", testFile(false, testMovi()[1:2]...)},
+		{"swapped streams", &File{Root: &Chunk{ID: "RIFF", ListType: "AVI ", Children: []*Chunk{
+			{ID: "LIST", ListType: "hdrl", Children: []*Chunk{
+				testHeader(true).Children[0],
+				testHeader(true).Children[2],
+				testHeader(true).Children[1],
+			}},
+			{ID: "LIST", ListType: "movi", Children: testMovi()[:1]},
+		}}}},
+	}
+
+	first := encodeFile(t, testFile(true, testMovi()...))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := []io.ReadSeeker{bytes.NewReader(first), bytes.NewReader(encodeFile(t, tt.second))}
+			err := Splice(sources, &bytes.Buffer{}, func(*File, int) (Transform, error) {
+				return func(c *Chunk) ([]*Chunk, error) { return []*Chunk{c}, nil }, nil
+			})
+			if err == nil || !strings.Contains(err.Error(), "input 2") {
+				t.Errorf("got error %v, want one about input 2", err)
+			}
+		})
+	}
+}
+
+func TestContextReaderStopsRewrite(t *testing.T) {
+	data := encodeFile(t, testFile(true, testMovi()...))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	chunks := 0
+	err := Rewrite(ContextReader(ctx, bytes.NewReader(data)), &bytes.Buffer{}, func(*File) (Transform, error) {
+		return func(c *Chunk) ([]*Chunk, error) {
+			if chunks++; chunks == 2 {
+				cancel()
+			}
+			return []*Chunk{c}, nil
+		}, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if chunks != 2 {
+		t.Errorf("the rewrite read %d chunks after being cancelled at the second", chunks)
+	}
+}
@@ -0,0 +1,220 @@
+package avi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const flagHasIndex uint32 = 0x00000010
+
+// WriteTo regenerates idx1, every header frame count and every chunk size
+// from the current tree, then serializes the file.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	f.finalize()
+
+	bw := bufio.NewWriter(w)
+	n, err := writeChunk(bw, f.Root)
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+func (f *File) WriteFile(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer out.Close()
+
+	if _, err := f.WriteTo(out); err != nil {
+		return fmt.Errorf("failed to write AVI file: %v", err)
+	}
+	return out.Close()
+}
+
+type streamStats struct {
+	chunks  uint32
+	bytes   uint64
+	maxSize uint32
+}
+
+// layout collects what the headers need to know about the movi contents.
+type layout struct {
+	streams  map[int]*streamStats
+	maxSize  uint32
+	chunks   int
+	moviSize int
+}
+
+func newLayout() *layout {
+	return &layout{streams: make(map[int]*streamStats), moviSize: 4}
+}
+
+func (l *layout) add(c *Chunk) {
+	l.chunks++
+	l.moviSize += c.paddedSize()
+
+	n := StreamNumber(c.ID)
+	if n < 0 {
+		return
+	}
+	s, ok := l.streams[n]
+	if !ok {
+		s = &streamStats{}
+		l.streams[n] = s
+	}
+	size := uint32(len(c.Data))
+	s.chunks++
+	s.bytes += uint64(size)
+	if size > s.maxSize {
+		s.maxSize = size
+	}
+	if size > l.maxSize {
+		l.maxSize = size
+	}
+}
+
+func (f *File) finalize() {
+	movi := f.Movi()
+	if movi == nil {
+		return
+	}
+
+	l := newLayout()
+	for _, child := range movi.Children {
+		l.add(child)
+	}
+	f.updateHeaders(l)
+
+	index := &Chunk{ID: "idx1", Data: buildIndex(movi)}
+	children := make([]*Chunk, 0, len(f.Root.Children)+1)
+	for _, child := range f.Root.Children {
+		if child.ID == "idx1" {
+			continue
+		}
+		children = append(children, child)
+		if child == movi {
+			children = append(children, index)
+		}
+	}
+	f.Root.Children = children
+}
+
+// updateHeaders rewrites stream lengths, frame counts and buffer sizes to
+// match the given movi layout.
+func (f *File) updateHeaders(l *layout) {
+	videoFrames := uint32(0)
+	videoFound := false
+	for i, stream := range f.Streams() {
+		if stream.headerChunk == nil {
+			continue
+		}
+		s := l.streams[i]
+		if s == nil {
+			s = &streamStats{}
+		}
+
+		header := stream.Header
+		if header.Type == "vids" || header.SampleSize == 0 {
+			header.Length = s.chunks
+		} else {
+			header.Length = uint32(s.bytes / uint64(header.SampleSize))
+		}
+		if s.maxSize > header.SuggestedBufferSize {
+			header.SuggestedBufferSize = s.maxSize
+		}
+		header.encode(stream.headerChunk.Data)
+
+		if header.Type == "vids" && !videoFound {
+			videoFrames = s.chunks
+			videoFound = true
+		}
+	}
+
+	hdrl := f.Root.FindList("hdrl")
+	if hdrl == nil {
+		return
+	}
+
+	if avih := hdrl.Find("avih"); avih != nil {
+		if main, err := decodeMainHeader(avih.Data); err == nil {
+			main.TotalFrames = videoFrames
+			main.Flags |= flagHasIndex
+			if l.maxSize > main.SuggestedBufferSize {
+				main.SuggestedBufferSize = l.maxSize
+			}
+			main.encode(avih.Data)
+		}
+	}
+
+	if odml := hdrl.FindList("odml"); odml != nil {
+		if dmlh := odml.Find("dmlh"); dmlh != nil && len(dmlh.Data) >= 4 {
+			binary.LittleEndian.PutUint32(dmlh.Data[0:4], videoFrames)
+		}
+	}
+
+	// OpenDML super indexes point at ix## chunks that no longer exist
+	for _, strl := range hdrl.Children {
+		if strl.ID == "LIST" && strl.ListType == "strl" {
+			if indx := strl.Find("indx"); indx != nil {
+				indx.ID = "JUNK"
+			}
+		}
+	}
+}
+
+func writeChunkHeader(w io.Writer, id string, size int) error {
+	header := make([]byte, 8)
+	copy(header[0:4], id)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(size))
+	_, err := w.Write(header)
+	return err
+}
+
+func writeChunk(w io.Writer, c *Chunk) (int64, error) {
+	var written int64
+
+	header := make([]byte, 8)
+	copy(header[0:4], c.ID)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(c.Size()))
+	n, err := w.Write(header)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	if c.IsList() {
+		n, err := io.WriteString(w, c.ListType)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		for _, child := range c.Children {
+			n, err := writeChunk(w, child)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+	} else {
+		n, err := w.Write(c.Data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if c.Size()%2 == 1 {
+		n, err := w.Write([]byte{0})
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,164 @@
+package batch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"moshr/internal/cache"
+	"moshr/internal/effects"
+	"moshr/internal/version"
+	"moshr/internal/video"
+)
+
+// SetCache makes the processor reuse earlier renders from c instead of
+// running the same job twice. Call it before Start.
+func (bp *BatchProcessor) SetCache(c *cache.Cache) {
+	bp.cache = c
+}
+
+// cacheSpec is everything that decides the output of a job. Parameters
+// are hashed as requested: together with the seed they decide every value
+// the render resolves. The seed is left out for jobs whose effect draws
+// no random values, so those hit the cache whatever seed they were given.
+type cacheSpec struct {
+	Version string            `json:"version"`
+	Kind    string            `json:"kind"`
+	Inputs  map[string]string `json:"inputs"`
+
+	Effect       string                 `json:"effect,omitempty"`
+	Params       video.MoshParams       `json:"params"`
+	EffectParams map[string]interface{} `json:"effect_params,omitempty"`
+	Seed         int64                  `json:"seed,omitempty"`
+	Transfer     *video.TransferParams  `json:"transfer,omitempty"`
+	Steps        []effects.Step         `json:"steps,omitempty"`
+	Mask         *video.Mask            `json:"mask,omitempty"`
+
+	Profile      *video.ExportProfile `json:"profile,omitempty"`
+	Intermediate string               `json:"intermediate,omitempty"`
+
+	Format   string                `json:"format,omitempty"`
+	Segments []video.Segment       `json:"segments,omitempty"`
+	Conform  *video.ConformOptions `json:"conform,omitempty"`
+}
+
+// cacheKey returns the key a job's output is cached under, or "" when it
+// is not cached: without a cache, for previews, which are quicker to make
+// than to look up, and when an input cannot be read.
+func (bp *BatchProcessor) cacheKey(mosh *Mosh) string {
+	if bp.cache == nil || mosh.Kind == KindPreview {
+		return ""
+	}
+
+	spec := cacheSpec{
+		Version: version.Version,
+		Kind:    mosh.Kind,
+		Inputs:  make(map[string]string),
+	}
+	if mosh.Kind == KindExport {
+		profile, err := exportProfile(mosh)
+		if err != nil || profile.Container == video.ContainerPNG {
+			// Image sequences are directories, which are not cached
+			return ""
+		}
+		// Only the settings matter, not the name or description
+		profile.Name, profile.Description, profile.Builtin = "", "", false
+		spec.Profile = &profile
+	}
+	if mosh.Kind == KindConvert {
+		// Empty for the default conversion, so its key stays the same
+		spec.Intermediate = intermediate(mosh).Name()
+	}
+	if mosh.Kind == KindComposition {
+		// The segment files are hashed with the inputs
+		spec.Format = mosh.Format
+		spec.Segments = make([]video.Segment, len(mosh.Segments))
+		for i, segment := range mosh.Segments {
+			segment.Path = ""
+			spec.Segments[i] = segment
+		}
+		spec.Conform = mosh.Conform
+	}
+	if isMosh(mosh) {
+		spec.Kind = KindMosh
+		spec.Effect = mosh.Effect
+		spec.Params = mosh.Params
+		spec.EffectParams = mosh.EffectParams
+		if usesSeed(mosh) {
+			spec.Seed = mosh.Seed
+		}
+		spec.Transfer = mosh.Transfer
+		spec.Steps = mosh.Steps
+		if mosh.Mask != nil {
+			// The mask file is hashed with the inputs
+			mask := *mosh.Mask
+			mask.Path = ""
+			spec.Mask = &mask
+		}
+	}
+
+	for name, path := range JobInputs(mosh) {
+		sum, err := bp.cache.HashFile(path)
+		if err != nil {
+			return ""
+		}
+		spec.Inputs[name] = sum
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// usesSeed reports whether the seed of a mosh can change its render.
+func usesSeed(mosh *Mosh) bool {
+	effect, err := effects.Lookup(mosh.Effect)
+	if err != nil {
+		return true
+	}
+	return effects.UsesSeed(effect, effects.Job{
+		Params:       mosh.Params,
+		EffectParams: mosh.EffectParams,
+		Transfer:     mosh.Transfer,
+		Steps:        mosh.Steps,
+	})
+}
+
+// fromCache places a cached render of a job at outputPath and returns the
+// values it resolved.
+func (bp *BatchProcessor) fromCache(mosh *Mosh, key, outputPath string) (map[string]interface{}, bool) {
+	if key == "" {
+		return nil, false
+	}
+	resolved, hit := bp.cache.Get(key, outputPath)
+	if hit {
+		fmt.Printf("Job %s reused a cached render\n", mosh.ID)
+		bp.moshesMu.Lock()
+		mosh.Cached = true
+		bp.moshesMu.Unlock()
+	}
+	return resolved, hit
+}
+
+// toCache keeps the output of a job that rendered without errors.
+func (bp *BatchProcessor) toCache(mosh *Mosh, key, outputPath string, resolved map[string]interface{}) {
+	if key == "" {
+		return
+	}
+	if err := bp.cache.Put(key, outputPath, resolved); err != nil {
+		fmt.Printf("Failed to cache the output of %s: %v\n", mosh.ID, err)
+	}
+}
+
+// CacheStats returns the statistics of the render cache, false when the
+// processor has none.
+func (bp *BatchProcessor) CacheStats() (cache.Stats, bool) {
+	if bp.cache == nil {
+		return cache.Stats{}, false
+	}
+	return bp.cache.Stats(), true
+}
@@ -0,0 +1,183 @@
+package batch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"moshr/internal/cache"
+	"moshr/internal/video"
+)
+
+// testCache returns a processor with a render cache and an input file.
+func testCache(t *testing.T) (*BatchProcessor, string) {
+	t.Helper()
+	c, err := cache.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.SetCache(c)
+
+	input := filepath.Join(t.TempDir(), "input.avi")
+	if err := os.WriteFile(input, []byte("input frames"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return bp, input
+}
+
+func TestCacheKey(t *testing.T) {
+	bp, input := testCache(t)
+	dir := filepath.Dir(input)
+	copied := filepath.Join(dir, "copy.avi")
+	other := filepath.Join(dir, "other.avi")
+	for path, data := range map[string]string{copied: "input frames", other: "other frames"} {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	glitch := func(change func(*Mosh)) *Mosh {
+		mosh := &Mosh{ID: "mosh_1", Effect: "glitch", InputPath: input, OutputDir: "out", Seed: 1, Params: video.MoshParams{Intensity: 1}}
+		change(mosh)
+		return mosh
+	}
+	datamosh := func(change func(*Mosh)) *Mosh {
+		mosh := &Mosh{ID: "mosh_1", Effect: "datamosh", InputPath: input, Seed: 1}
+		change(mosh)
+		return mosh
+	}
+	composition := func(change func(*Mosh)) *Mosh {
+		mosh := &Mosh{
+			ID:       "composition_1",
+			Kind:     KindComposition,
+			Format:   "mp4",
+			Segments: []video.Segment{{Path: input, Out: 2}, {Path: other, Transition: video.TransitionCut}},
+			Conform:  &video.ConformOptions{Width: 640, Height: 360, Framerate: 30},
+		}
+		change(mosh)
+		return mosh
+	}
+	same := func(*Mosh) {}
+
+	tests := []struct {
+		name     string
+		a, b     *Mosh
+		wantSame bool
+	}{
+		{"identical", glitch(same), glitch(same), true},
+		{"other job and session", glitch(same), glitch(func(m *Mosh) { m.ID, m.SessionID, m.OutputDir, m.Priority = "mosh_2", "s", "elsewhere", 3 }), true},
+		{"copied input", glitch(same), glitch(func(m *Mosh) { m.InputPath = copied }), true},
+		{"other input", glitch(same), glitch(func(m *Mosh) { m.InputPath = other }), false},
+		{"other intensity", glitch(same), glitch(func(m *Mosh) { m.Params.Intensity = 2 }), false},
+		{"other effect parameters", glitch(same), glitch(func(m *Mosh) { m.EffectParams = map[string]interface{}{"iframe_removal": true} }), false},
+		{"other seed", glitch(same), glitch(func(m *Mosh) { m.Seed = 2 }), false},
+		{"datamosh with another seed", datamosh(same), datamosh(func(m *Mosh) { m.Seed = 2 }), true},
+		{
+			"datamosh corrupting audio with another seed",
+			datamosh(func(m *Mosh) { m.Params.AudioBend.Corruption = 0.1 }),
+			datamosh(func(m *Mosh) { m.Params.AudioBend.Corruption = 0.1; m.Seed = 2 }),
+			false,
+		},
+		{
+			"masks in copied files",
+			glitch(func(m *Mosh) { m.Mask = &video.Mask{Kind: video.MaskFile, Path: input} }),
+			glitch(func(m *Mosh) { m.Mask = &video.Mask{Kind: video.MaskFile, Path: copied} }),
+			true,
+		},
+		{
+			"masks in other files",
+			glitch(func(m *Mosh) { m.Mask = &video.Mask{Kind: video.MaskFile, Path: input} }),
+			glitch(func(m *Mosh) { m.Mask = &video.Mask{Kind: video.MaskFile, Path: other} }),
+			false,
+		},
+		{"convert and mosh", glitch(same), glitch(func(m *Mosh) { m.Kind = KindConvert }), false},
+		{"export under another name", &Mosh{Kind: KindExport, InputPath: input, Format: "mp4"}, &Mosh{Kind: KindExport, InputPath: input, Profile: renamed("mp4")}, true},
+		{"exports to other formats", &Mosh{Kind: KindExport, InputPath: input, Format: "mp4"}, &Mosh{Kind: KindExport, InputPath: input, Format: "webm"}, false},
+		{"composition of copied segments", composition(same), composition(func(m *Mosh) { m.Segments[0].Path = copied }), true},
+		{"composition with other trims", composition(same), composition(func(m *Mosh) { m.Segments[0].Out = 3 }), false},
+		{"composition at another size", composition(same), composition(func(m *Mosh) { m.Conform = &video.ConformOptions{Width: 320, Height: 180, Framerate: 30} }), false},
+		{"composition in another container", composition(same), composition(func(m *Mosh) { m.Format = "avi" }), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := bp.cacheKey(tt.a), bp.cacheKey(tt.b)
+			if a == "" || b == "" {
+				t.Fatalf("got keys %q and %q", a, b)
+			}
+			if (a == b) != tt.wantSame {
+				t.Errorf("same key = %v, want %v", a == b, tt.wantSame)
+			}
+		})
+	}
+}
+
+// renamed returns a builtin export profile under another name.
+func renamed(name string) *video.ExportProfile {
+	for _, profile := range video.BuiltinExportProfiles() {
+		if profile.Name == name {
+			profile.Name, profile.Description, profile.Builtin = "mine", "My profile", false
+			return &profile
+		}
+	}
+	return nil
+}
+
+func TestCacheKeySkipsUncachedJobs(t *testing.T) {
+	bp, input := testCache(t)
+
+	tests := []struct {
+		name string
+		bp   *BatchProcessor
+		mosh *Mosh
+	}{
+		{"no cache", NewBatchProcessor(1, nil, nil), &Mosh{Effect: "glitch", InputPath: input}},
+		{"preview", bp, &Mosh{Kind: KindPreview, InputPath: input}},
+		{"missing input", bp, &Mosh{Effect: "glitch", InputPath: input + ".gone"}},
+		{"missing second input", bp, &Mosh{Effect: "transfer", InputPath: input, SecondInputPath: input + ".gone"}},
+		{"image sequence", bp, &Mosh{Kind: KindExport, InputPath: input, Profile: &video.ExportProfile{Container: video.ContainerPNG}}},
+		{"unknown export profile", bp, &Mosh{Kind: KindExport, InputPath: input, Format: "vhs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key := tt.bp.cacheKey(tt.mosh); key != "" {
+				t.Errorf("cacheKey() = %q, want none", key)
+			}
+		})
+	}
+}
+
+func TestLeaseFromCache(t *testing.T) {
+	bp, input := testCache(t)
+	dir := t.TempDir()
+
+	first := &Mosh{ID: "mosh_1", Effect: "datamosh", InputPath: input, OutputDir: dir}
+	bp.AddMosh(first)
+	leased, _, _ := bp.Lease("w1")
+	output, _ := bp.LeaseOutputPath(leased.ID, "w1")
+	if err := os.WriteFile(output, []byte("moshed frames"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.CompleteRemote(leased.ID, "w1", map[string]interface{}{"intensity": 1.0}); err != nil {
+		t.Fatalf("CompleteRemote failed: %v", err)
+	}
+
+	// The same render queued again is finished without reaching a worker
+	bp.AddMosh(&Mosh{ID: "mosh_2", Effect: "datamosh", InputPath: input, OutputDir: dir})
+	if leased, _, ok := bp.Lease("w1"); ok {
+		t.Fatalf("leased %s, which was cached", leased.ID)
+	}
+
+	mosh, _ := bp.GetMosh("mosh_2")
+	if mosh.Status != "completed" || !mosh.Cached || mosh.ResolvedParams["intensity"] != 1.0 {
+		t.Errorf("mosh_2 = %+v, want completed from the cache", mosh)
+	}
+	if data, _ := os.ReadFile(mosh.OutputPath); string(data) != "moshed frames" {
+		t.Errorf("mosh_2 output holds %q", data)
+	}
+	if stats, ok := bp.CacheStats(); !ok || stats.Hits != 1 || stats.Entries != 1 {
+		t.Errorf("CacheStats() = %+v, %v", stats, ok)
+	}
+}
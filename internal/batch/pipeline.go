@@ -0,0 +1,338 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"moshr/internal/effects"
+	"moshr/internal/ids"
+	"moshr/internal/video"
+)
+
+// Job kinds. A job without a kind is a mosh.
+const (
+	KindMosh        = "mosh"
+	KindConvert     = "convert"
+	KindExport      = "export"
+	KindPreview     = "preview"
+	KindComposition = "composition"
+)
+
+// PipelineStep is one job of a pipeline. Needs names the earlier steps it
+// waits for; the job reads the output of the first of them unless it has
+// an input of its own.
+type PipelineStep struct {
+	Name  string   `json:"name"`
+	Needs []string `json:"needs,omitempty"`
+	Job   Mosh     `json:"job"`
+}
+
+// CreatePipeline queues the jobs of a pipeline. Steps may only need steps
+// listed before them, so the graph cannot have cycles. Jobs wait until
+// everything they need has completed, and fail when any of it fails or is
+// cancelled. It returns the pipeline ID and the job ID of every step by
+// name.
+func (bp *BatchProcessor) CreatePipeline(steps []PipelineStep) (string, map[string]string, error) {
+	if len(steps) == 0 {
+		return "", nil, fmt.Errorf("pipeline has no steps")
+	}
+
+	pipelineID := ids.New("pipeline")
+	jobIDs := make(map[string]string, len(steps))
+	kinds := make(map[string]string, len(steps))
+	previewed := make(map[string]bool)
+
+	for i, step := range steps {
+		if step.Name == "" {
+			return "", nil, fmt.Errorf("step %d has no name", i+1)
+		}
+		if _, exists := jobIDs[step.Name]; exists {
+			return "", nil, fmt.Errorf("step %q is defined twice", step.Name)
+		}
+		for _, need := range step.Needs {
+			if _, exists := jobIDs[need]; !exists {
+				return "", nil, fmt.Errorf("step %q needs %q, which is not an earlier step", step.Name, need)
+			}
+		}
+
+		kind := step.Job.Kind
+		if kind == "" {
+			kind = KindMosh
+		}
+		switch kind {
+		case KindMosh:
+			if _, err := effects.Lookup(step.Job.Effect); err != nil {
+				return "", nil, fmt.Errorf("step %q: %v", step.Name, err)
+			}
+		case KindExport:
+			if step.Job.Profile == nil {
+				return "", nil, fmt.Errorf("step %q: export needs a profile", step.Name)
+			}
+			if err := step.Job.Profile.Validate(); err != nil {
+				return "", nil, fmt.Errorf("step %q: %v", step.Name, err)
+			}
+		case KindConvert:
+			if step.Job.Intermediate != nil {
+				if err := step.Job.Intermediate.Validate(); err != nil {
+					return "", nil, fmt.Errorf("step %q: %v", step.Name, err)
+				}
+			}
+		case KindPreview:
+		default:
+			return "", nil, fmt.Errorf("step %q: unknown kind %q", step.Name, kind)
+		}
+		if step.Job.InputPath == "" && len(step.Needs) == 0 {
+			return "", nil, fmt.Errorf("step %q has no input and needs no other step", step.Name)
+		}
+		if kind == KindPreview && len(step.Needs) > 0 && kinds[step.Needs[0]] == KindMosh {
+			previewed[step.Needs[0]] = true
+		}
+
+		jobIDs[step.Name] = ids.New(kind)
+		kinds[step.Name] = kind
+	}
+
+	for _, step := range steps {
+		job := step.Job
+		job.ID = jobIDs[step.Name]
+		job.Kind = kinds[step.Name]
+		job.PipelineID = pipelineID
+		job.DependsOn = nil
+		for _, need := range step.Needs {
+			job.DependsOn = append(job.DependsOn, jobIDs[need])
+		}
+		// The preview step makes the mosh's preview instead
+		job.NoPreview = previewed[step.Name]
+		if job.Kind == KindExport && len(step.Needs) > 0 && kinds[step.Needs[0]] == KindMosh {
+			job.MoshID = jobIDs[step.Needs[0]]
+		}
+
+		bp.AddMosh(&job)
+	}
+
+	return pipelineID, jobIDs, nil
+}
+
+// PipelineMoshes returns the jobs of a pipeline in the order they were
+// created.
+func (bp *BatchProcessor) PipelineMoshes(pipelineID string) []*Mosh {
+	bp.moshesMu.RLock()
+	defer bp.moshesMu.RUnlock()
+
+	var moshes []*Mosh
+	for _, mosh := range bp.moshes {
+		if mosh.PipelineID == pipelineID {
+			moshes = append(moshes, mosh)
+		}
+	}
+	// IDs start with their creation time
+	sort.Slice(moshes, func(i, j int) bool {
+		return idTime(moshes[i].ID) < idTime(moshes[j].ID)
+	})
+	return moshes
+}
+
+func idTime(id string) string {
+	if i := strings.LastIndex(id, "_"); i >= 0 {
+		return id[i+1:]
+	}
+	return id
+}
+
+// releaseDependents queues waiting jobs whose dependencies have all
+// completed and fails those that depend on a job that did not complete,
+// which in turn fails the jobs waiting on them.
+func (bp *BatchProcessor) releaseDependents() {
+	bp.moshesMu.Lock()
+	changed := false
+	for again := true; again; {
+		again = false
+
+		var waiting []*Mosh
+		for _, mosh := range bp.moshes {
+			if mosh.Status == "waiting" {
+				waiting = append(waiting, mosh)
+			}
+		}
+		// Jobs released together keep the order they were created in
+		sort.Slice(waiting, func(i, j int) bool {
+			return idTime(waiting[i].ID) < idTime(waiting[j].ID)
+		})
+
+		for _, mosh := range waiting {
+			ready, reason := bp.dependencies(mosh)
+			switch {
+			case reason != "":
+				fmt.Printf("Job %s failed: %s\n", mosh.ID, reason)
+				mosh.Status = "failed"
+				mosh.Error = reason
+				again = true
+			case ready:
+				if mosh.InputPath == "" {
+					mosh.InputPath = bp.moshes[mosh.DependsOn[0]].OutputPath
+				}
+				mosh.Status = "queued"
+				bp.enqueue(mosh)
+				bp.ready.Signal()
+			default:
+				continue
+			}
+			changed = true
+			if bp.wsHub != nil {
+				bp.wsHub.BroadcastMoshUpdate(mosh.ID, mosh.Status, 0)
+			}
+		}
+	}
+	bp.moshesMu.Unlock()
+
+	if changed {
+		bp.saveQueue()
+	}
+}
+
+// dependencies reports whether every job a waiting job depends on has
+// completed, or why it never will. The caller holds moshesMu.
+func (bp *BatchProcessor) dependencies(mosh *Mosh) (bool, string) {
+	ready := true
+	for _, id := range mosh.DependsOn {
+		dependency, exists := bp.moshes[id]
+		if !exists {
+			return false, fmt.Sprintf("Depends on %s, which no longer exists", id)
+		}
+		switch dependency.Status {
+		case "completed":
+		case "failed":
+			return false, fmt.Sprintf("Depends on %s, which failed", id)
+		case "cancelled":
+			return false, fmt.Sprintf("Depends on %s, which was cancelled", id)
+		default:
+			ready = false
+		}
+	}
+	return ready, ""
+}
+
+// process runs a claimed job and records its outcome.
+func (bp *BatchProcessor) process(ctx context.Context, mosh *Mosh) {
+	fmt.Printf("Starting to process job %s with input: %s\n", mosh.ID, mosh.InputPath)
+
+	outputPath := JobOutputPath(mosh)
+	progress := bp.reporter(mosh)
+	if isMosh(mosh) {
+		// The render fills the bar up to where the preview takes over
+		bp.updateMosh(mosh.ID, "processing", 0.1, "")
+		progress = progress.Span(0.1, 0.9)
+	} else {
+		bp.updateMosh(mosh.ID, "processing", 0, "")
+	}
+
+	key := bp.cacheKey(mosh)
+	if resolved, hit := bp.fromCache(mosh, key, outputPath); hit {
+		bp.finishJob(ctx, mosh, outputPath, resolved, nil)
+		return
+	}
+	if key != "" {
+		// The old output may be a link into the cache, which the render
+		// must not write through
+		os.Remove(outputPath)
+	}
+
+	var converter *video.Converter
+	if bp.converter != nil {
+		converter = bp.converter.WithContext(ctx)
+	}
+	resolved, err := RunJob(ctx, converter, mosh, outputPath, progress, func(index, total int, step effects.Step) {
+		bp.updateStep(mosh.ID, index, total, step)
+	})
+	if err == nil {
+		bp.toCache(mosh, key, outputPath, resolved)
+	}
+	bp.finishJob(ctx, mosh, outputPath, resolved, err)
+}
+
+func isMosh(mosh *Mosh) bool {
+	return mosh.Kind == "" || mosh.Kind == KindMosh
+}
+
+// reporter reports a job's progress against the duration of its input.
+func (bp *BatchProcessor) reporter(mosh *Mosh) *video.Reporter {
+	var duration float64
+	if info, err := bp.analyzer.AnalyzeVideo(mosh.InputPath); err == nil {
+		duration = info.Duration
+	}
+	return video.NewReporter(duration, func(p video.Progress) {
+		bp.updateProgress(mosh.ID, p)
+	})
+}
+
+// finishJob records the outcome of a job that writes outputPath. Moshes
+// also get a preview, unless a preview job makes it, and are recorded in
+// their session.
+func (bp *BatchProcessor) finishJob(ctx context.Context, mosh *Mosh, outputPath string, resolved map[string]interface{}, err error) {
+	bp.moshesMu.Lock()
+	mosh.ResolvedParams = resolved
+	bp.moshesMu.Unlock()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			// Whatever the job left behind is incomplete
+			os.Remove(outputPath)
+		}
+		bp.failMosh(ctx, mosh, err)
+		return
+	}
+
+	bp.moshesMu.Lock()
+	mosh.OutputPath = outputPath
+	bp.moshesMu.Unlock()
+
+	if isMosh(mosh) && bp.converter != nil && !mosh.NoPreview {
+		fmt.Printf("Mosh %s completed successfully, generating preview\n", mosh.ID)
+		bp.updateMosh(mosh.ID, "processing", 0.9, "Generating preview")
+
+		// Generate preview in the same directory as the mosh file
+		previewPath := filepath.Join(mosh.OutputDir, fmt.Sprintf("preview_%s.jpg", mosh.ID))
+		if err := bp.converter.GeneratePreview(outputPath, previewPath, 300, 200); err != nil {
+			fmt.Printf("Failed to generate preview for mosh %s: %v\n", mosh.ID, err)
+		} else {
+			fmt.Printf("Preview generated for mosh %s at %s\n", mosh.ID, previewPath)
+		}
+	}
+
+	if mosh.Kind == KindExport && mosh.MoshID != "" && mosh.Profile != nil {
+		bp.recordExport(mosh, outputPath)
+	}
+
+	// The session must hold the mosh before the job completes, since
+	// completing it releases exports that record themselves against it
+	if isMosh(mosh) {
+		bp.updateSessionMetadata(mosh)
+	}
+
+	fmt.Printf("Job %s completed: %s\n", mosh.ID, outputPath)
+	bp.updateMosh(mosh.ID, "completed", 1.0, "")
+}
+
+// failMosh records why a job stopped: it was cancelled, ran out of time or
+// failed.
+func (bp *BatchProcessor) failMosh(ctx context.Context, mosh *Mosh, err error) {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		fmt.Printf("Mosh %s cancelled\n", mosh.ID)
+		bp.updateMosh(mosh.ID, "cancelled", 0, "")
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		bp.moshesMu.RLock()
+		limit := bp.maxRunTime(mosh)
+		bp.moshesMu.RUnlock()
+		fmt.Printf("Mosh %s ran out of time after %s\n", mosh.ID, limit)
+		bp.updateMosh(mosh.ID, "failed", 0, fmt.Sprintf("Stopped after running for the maximum of %s", limit))
+	default:
+		fmt.Printf("Mosh %s failed: %v\n", mosh.ID, err)
+		bp.updateMosh(mosh.ID, "failed", 0, err.Error())
+	}
+}
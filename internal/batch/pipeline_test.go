@@ -0,0 +1,237 @@
+package batch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"moshr/internal/video"
+)
+
+func TestCreatePipelineValidates(t *testing.T) {
+	mp4 := video.BuiltinExportProfiles()[0]
+	bad := mp4
+	bad.Container = "tape"
+
+	tests := []struct {
+		name    string
+		steps   []PipelineStep
+		wantErr string
+	}{
+		{"no steps", nil, "no steps"},
+		{"unnamed step", []PipelineStep{{Job: Mosh{InputPath: "in.avi", Effect: "datamosh"}}}, "has no name"},
+		{
+			"repeated name",
+			[]PipelineStep{
+				{Name: "a", Job: Mosh{InputPath: "in.avi", Effect: "datamosh"}},
+				{Name: "a", Job: Mosh{InputPath: "in.avi", Effect: "datamosh"}},
+			},
+			"defined twice",
+		},
+		{
+			"later dependency",
+			[]PipelineStep{
+				{Name: "a", Needs: []string{"b"}, Job: Mosh{Effect: "datamosh"}},
+				{Name: "b", Job: Mosh{InputPath: "in.avi", Effect: "datamosh"}},
+			},
+			"not an earlier step",
+		},
+		{"unknown effect", []PipelineStep{{Name: "a", Job: Mosh{InputPath: "in.avi", Effect: "melt"}}}, "step \"a\""},
+		{"export without a profile", []PipelineStep{{Name: "a", Job: Mosh{Kind: KindExport, InputPath: "in.avi"}}}, "needs a profile"},
+		{"bad export profile", []PipelineStep{{Name: "a", Job: Mosh{Kind: KindExport, InputPath: "in.avi", Profile: &bad}}}, "step \"a\""},
+		{
+			"bad intermediate",
+			[]PipelineStep{{Name: "a", Job: Mosh{Kind: KindConvert, InputPath: "in.mp4", Intermediate: &video.AVIOptions{Codec: "vhs"}}}},
+			"step \"a\"",
+		},
+		{"unknown kind", []PipelineStep{{Name: "a", Job: Mosh{Kind: "upload", InputPath: "in.avi"}}}, "unknown kind"},
+		{"no input", []PipelineStep{{Name: "a", Job: Mosh{Effect: "datamosh"}}}, "has no input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := NewBatchProcessor(1, nil, nil)
+			_, _, err := bp.CreatePipeline(tt.steps)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+			if moshes := bp.GetAllMoshes(); len(moshes) != 0 {
+				t.Errorf("a rejected pipeline queued %d jobs", len(moshes))
+			}
+		})
+	}
+}
+
+// testPipeline queues a convert step, two moshes of its output, an export
+// of the first mosh and a preview of the second.
+func testPipeline(t *testing.T, bp *BatchProcessor) (string, map[string]string) {
+	t.Helper()
+	mp4 := video.BuiltinExportProfiles()[0]
+	pipelineID, jobIDs, err := bp.CreatePipeline([]PipelineStep{
+		{Name: "convert", Job: Mosh{Kind: KindConvert, InputPath: "in.mp4"}},
+		{Name: "mosh_a", Needs: []string{"convert"}, Job: Mosh{Effect: "datamosh"}},
+		{Name: "mosh_b", Needs: []string{"convert"}, Job: Mosh{Effect: "datamosh"}},
+		{Name: "export", Needs: []string{"mosh_a"}, Job: Mosh{Kind: KindExport, Profile: &mp4}},
+		{Name: "preview", Needs: []string{"mosh_b"}, Job: Mosh{Kind: KindPreview}},
+	})
+	if err != nil {
+		t.Fatalf("CreatePipeline failed: %v", err)
+	}
+	return pipelineID, jobIDs
+}
+
+func TestCreatePipeline(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	pipelineID, jobIDs := testPipeline(t, bp)
+
+	var names []string
+	for _, mosh := range bp.PipelineMoshes(pipelineID) {
+		for name, id := range jobIDs {
+			if id == mosh.ID {
+				names = append(names, name)
+			}
+		}
+	}
+	if want := []string{"convert", "mosh_a", "mosh_b", "export", "preview"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("pipeline jobs %v, want %v", names, want)
+	}
+	if want := []string{jobIDs["convert"]}; !reflect.DeepEqual(bp.pending, want) {
+		t.Errorf("queued %v, want only the convert step", bp.pending)
+	}
+
+	tests := []struct {
+		step          string
+		wantKind      string
+		wantStatus    string
+		wantNoPreview bool
+		wantMoshID    string
+	}{
+		{"convert", KindConvert, "queued", false, ""},
+		{"mosh_a", KindMosh, "waiting", false, ""},
+		{"mosh_b", KindMosh, "waiting", true, ""},
+		{"export", KindExport, "waiting", false, jobIDs["mosh_a"]},
+		{"preview", KindPreview, "waiting", false, ""},
+	}
+	for _, tt := range tests {
+		mosh, _ := bp.GetMosh(jobIDs[tt.step])
+		if !strings.HasPrefix(mosh.ID, tt.wantKind+"_") || mosh.Kind != tt.wantKind || mosh.PipelineID != pipelineID {
+			t.Errorf("%s is %s of kind %s in %s", tt.step, mosh.ID, mosh.Kind, mosh.PipelineID)
+		}
+		if mosh.Status != tt.wantStatus || mosh.NoPreview != tt.wantNoPreview || mosh.MoshID != tt.wantMoshID {
+			t.Errorf("%s is %s with no preview %v and mosh %q", tt.step, mosh.Status, mosh.NoPreview, mosh.MoshID)
+		}
+	}
+}
+
+func TestReleaseDependents(t *testing.T) {
+	tests := []struct {
+		name    string
+		convert string
+		moshA   string
+		want    map[string]string
+	}{
+		{
+			"convert running",
+			"processing", "waiting",
+			map[string]string{"mosh_a": "waiting", "mosh_b": "waiting", "export": "waiting", "preview": "waiting"},
+		},
+		{
+			"convert completed",
+			"completed", "waiting",
+			map[string]string{"mosh_a": "queued", "mosh_b": "queued", "export": "waiting", "preview": "waiting"},
+		},
+		{
+			"mosh completed",
+			"completed", "completed",
+			map[string]string{"mosh_a": "completed", "mosh_b": "queued", "export": "queued", "preview": "waiting"},
+		},
+		{
+			"mosh failed",
+			"completed", "failed",
+			map[string]string{"mosh_a": "failed", "mosh_b": "queued", "export": "failed", "preview": "waiting"},
+		},
+		{
+			"convert failed",
+			"failed", "waiting",
+			map[string]string{"mosh_a": "failed", "mosh_b": "failed", "export": "failed", "preview": "failed"},
+		},
+		{
+			"convert cancelled",
+			"cancelled", "waiting",
+			map[string]string{"mosh_a": "failed", "mosh_b": "failed", "export": "failed", "preview": "failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := NewBatchProcessor(1, nil, nil)
+			_, jobIDs := testPipeline(t, bp)
+
+			bp.moshesMu.Lock()
+			bp.pending = nil
+			convert := bp.moshes[jobIDs["convert"]]
+			convert.Status = tt.convert
+			convert.OutputPath = "clips/converted.avi"
+			moshA := bp.moshes[jobIDs["mosh_a"]]
+			if tt.moshA != "waiting" {
+				moshA.Status = tt.moshA
+				moshA.InputPath = convert.OutputPath
+				moshA.OutputPath = "moshes/a.avi"
+			}
+			bp.moshesMu.Unlock()
+
+			bp.releaseDependents()
+
+			for step, want := range tt.want {
+				mosh, _ := bp.GetMosh(jobIDs[step])
+				if mosh.Status != want {
+					t.Errorf("%s is %s, want %s", step, mosh.Status, want)
+				}
+				if want == "failed" && step != "mosh_a" && !strings.HasPrefix(mosh.Error, "Depends on") {
+					t.Errorf("%s failed with %q", step, mosh.Error)
+				}
+			}
+
+			var queued []string
+			for _, step := range []string{"mosh_a", "mosh_b", "export"} {
+				if tt.want[step] == "queued" {
+					queued = append(queued, jobIDs[step])
+				}
+			}
+			if !reflect.DeepEqual(bp.pending, queued) {
+				t.Errorf("queued %v, want %v in creation order", bp.pending, queued)
+			}
+
+			if tt.want["mosh_b"] == "queued" {
+				if mosh, _ := bp.GetMosh(jobIDs["mosh_b"]); mosh.InputPath != "clips/converted.avi" {
+					t.Errorf("mosh_b reads %q, want the converted clip", mosh.InputPath)
+				}
+			}
+			if tt.want["export"] == "queued" {
+				if export, _ := bp.GetMosh(jobIDs["export"]); export.InputPath != "moshes/a.avi" {
+					t.Errorf("export reads %q, want the output of mosh_a", export.InputPath)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseDependentsKeepsOwnInput(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.AddMosh(&Mosh{ID: "mosh_1", Status: "queued", InputPath: "in.avi"})
+	bp.AddMosh(&Mosh{ID: "mosh_2", InputPath: "other.avi", DependsOn: []string{"mosh_1"}})
+	bp.AddMosh(&Mosh{ID: "mosh_3", InputPath: "in.avi", DependsOn: []string{"mosh_9"}})
+
+	bp.moshesMu.Lock()
+	bp.moshes["mosh_1"].Status = "completed"
+	bp.moshes["mosh_1"].OutputPath = "out.avi"
+	bp.moshesMu.Unlock()
+	bp.releaseDependents()
+
+	if mosh, _ := bp.GetMosh("mosh_2"); mosh.Status != "queued" || mosh.InputPath != "other.avi" {
+		t.Errorf("mosh_2 is %s reading %q, want queued reading its own input", mosh.Status, mosh.InputPath)
+	}
+	if mosh, _ := bp.GetMosh("mosh_3"); mosh.Status != "failed" || !strings.Contains(mosh.Error, "no longer exists") {
+		t.Errorf("mosh_3 is %s with %q, want failed for its missing dependency", mosh.Status, mosh.Error)
+	}
+}
@@ -1,19 +1,25 @@
 package batch
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"moshr/internal/cache"
 	"moshr/internal/effects"
+	"moshr/internal/ids"
 	"moshr/internal/video"
 )
 
 type Mosh struct {
 	ID             string           `json:"id"`
+	ProjectID      string           `json:"project_id,omitempty"`
+	SessionID      string           `json:"session_id,omitempty"`
 	InputPath      string           `json:"input_path"`
 	OutputDir      string           `json:"output_dir"`
 	Effect         string           `json:"effect"`
@@ -22,48 +28,276 @@ type Mosh struct {
 	Progress       float64          `json:"progress"`
 	Error          string           `json:"error,omitempty"`
 	ConvertedFiles map[string]bool  `json:"converted_files,omitempty"`
+
+	// FPS and ETA, in seconds, describe the render while it runs
+	FPS float64 `json:"fps,omitempty"`
+	ETA float64 `json:"eta,omitempty"`
+
+	// Seed drives every random choice the effect makes. Zero picks a fresh
+	// seed when processing starts; the seed used and the values it resolved
+	// to are stored with the mosh so it can be rendered again.
+	Seed           int64                  `json:"seed"`
+	ResolvedParams map[string]interface{} `json:"resolved_params,omitempty"`
+
+	// EffectParams sets individual parameters from the effect's schema;
+	// anything left out is resolved from the intensity and seed.
+	EffectParams map[string]interface{} `json:"effect_params,omitempty"`
+
+	// Two clip effects read their second clip and settings from here
+	SecondInputPath string                `json:"second_input_path,omitempty"`
+	Transfer        *video.TransferParams `json:"transfer,omitempty"`
+
+	// Steps of a chain mosh, run in order; CurrentStep is the index of the
+	// step being rendered
+	Steps       []effects.Step `json:"steps,omitempty"`
+	CurrentStep int            `json:"current_step"`
+
+	// Mask limits the effect to part of the frame; the rest shows the input
+	Mask *video.Mask `json:"mask,omitempty"`
+
+	// Attempts counts how often processing has started, so a mosh that
+	// keeps taking the server down is not retried forever
+	Attempts int `json:"attempts,omitempty"`
+
+	// Priority orders the queue: higher priorities run first and equal
+	// ones run in the order they were queued
+	Priority int `json:"priority,omitempty"`
+	// MaxRunTime in seconds stops the render early. It can shorten the
+	// limit set for the effect but not extend it.
+	MaxRunTime float64 `json:"max_run_time,omitempty"`
+
+	// SweepPoint holds the swept parameter values of a mosh queued by a
+	// sweep, and Label describes them
+	SweepPoint SweepPoint `json:"sweep_point,omitempty"`
+	Label      string     `json:"label,omitempty"`
+
+	// Kind is what the job does, a mosh unless set. Jobs of a pipeline
+	// wait in the "waiting" status until every job in DependsOn has
+	// completed, and take the output of the first as their input.
+	Kind       string   `json:"kind,omitempty"`
+	PipelineID string   `json:"pipeline_id,omitempty"`
+	DependsOn  []string `json:"depends_on,omitempty"`
+	// OutputPath is the file the job wrote, set when it completes
+	OutputPath string `json:"output_path,omitempty"`
+	// Format names the export profile of an export job, and Profile holds
+	// its settings as they were when the job was queued. MoshID is the
+	// mosh the export is recorded against. Composition jobs name their
+	// container in Format.
+	Format  string               `json:"format,omitempty"`
+	Profile *video.ExportProfile `json:"profile,omitempty"`
+	MoshID  string               `json:"mosh_id,omitempty"`
+	// Segments and Conform describe the sequence a composition job joins;
+	// it has no input of its own
+	Segments []video.Segment       `json:"segments,omitempty"`
+	Conform  *video.ConformOptions `json:"conform,omitempty"`
+	// Intermediate is how a convert job encodes its AVI, the default
+	// conversion when nil
+	Intermediate *video.AVIOptions `json:"intermediate,omitempty"`
+	// NoPreview leaves the preview of a mosh to a later preview job
+	NoPreview bool `json:"no_preview,omitempty"`
+
+	// Worker names the remote worker that leased the job, empty when the
+	// server runs it itself
+	Worker string `json:"worker,omitempty"`
+	// Cached is set when the output was reused from the render cache
+	Cached bool `json:"cached,omitempty"`
 }
 
+var (
+	ErrMoshNotFound = errors.New("mosh not found")
+	ErrMoshFinished = errors.New("mosh has already finished")
+)
+
 type WSHubInterface interface {
 	BroadcastMoshUpdate(moshID, status string, progress float64)
+	BroadcastMoshProgress(moshID, status string, progress video.Progress)
+	BroadcastStepUpdate(moshID string, step, steps int, effect string)
 }
 
 type ConverterInterface interface {
 	GeneratePreview(inputPath, outputPath string, width, height int) error
+	ApplyMask(originalPath, effectedPath, outputPath string, mask video.Mask) error
+	WithContext(ctx context.Context) *video.Converter
 }
 
 type BatchProcessor struct {
-	moshes    map[string]*Mosh
-	moshesMu  sync.RWMutex
-	workers   int
-	queue     chan *Mosh
+	moshes   map[string]*Mosh
+	moshesMu sync.RWMutex
+	workers  int
+
+	// pending holds the IDs of queued moshes in the order they run; ready
+	// wakes a worker when one is added
+	pending []string
+	ready   *sync.Cond
+
+	// running holds the cancel function of every mosh being processed
+	running map[string]context.CancelFunc
+	// leases holds the jobs remote workers are processing
+	leases map[string]*lease
+	// maxRunTimes limit how long a render may take, by effect name; the
+	// "" entry applies to effects without a limit of their own
+	maxRunTimes map[string]time.Duration
+	// maxSweepJobs caps the moshes a single sweep may queue
+	maxSweepJobs int
+
+	// store is the file the queue is persisted to, empty to keep it in
+	// memory only
+	store   string
+	storeMu sync.Mutex
+
 	wsHub     WSHubInterface
 	converter ConverterInterface
+	analyzer  *video.Analyzer
+	cache     *cache.Cache
+
+	// sessionsMu serializes updates to session metadata files
+	sessionsMu sync.Mutex
+
+	// onComplete is called after a job completes
+	onComplete func(mosh *Mosh)
 }
 
 func NewBatchProcessor(workers int, wsHub WSHubInterface, converter ConverterInterface) *BatchProcessor {
-	return &BatchProcessor{
-		moshes:    make(map[string]*Mosh),
-		workers:   workers,
-		queue:     make(chan *Mosh, 100),
-		wsHub:     wsHub,
-		converter: converter,
+	bp := &BatchProcessor{
+		moshes:       make(map[string]*Mosh),
+		workers:      workers,
+		running:      make(map[string]context.CancelFunc),
+		leases:       make(map[string]*lease),
+		maxRunTimes:  make(map[string]time.Duration),
+		maxSweepJobs: DefaultMaxSweepJobs,
+		wsHub:        wsHub,
+		converter:    converter,
+		analyzer:     video.NewAnalyzer(),
 	}
+	bp.ready = sync.NewCond(&bp.moshesMu)
+	return bp
 }
 
 func (bp *BatchProcessor) Start() {
 	for i := 0; i < bp.workers; i++ {
 		go bp.worker()
 	}
+	go bp.expireLeases()
 }
 
+// AddMosh queues a job, or holds it until the jobs it depends on have
+// completed.
 func (bp *BatchProcessor) AddMosh(mosh *Mosh) {
 	bp.moshesMu.Lock()
-	mosh.Status = "queued"
+	if mosh.Seed == 0 {
+		mosh.Seed = effects.NewSeed()
+	}
 	bp.moshes[mosh.ID] = mosh
+	waiting := len(mosh.DependsOn) > 0
+	if waiting {
+		mosh.Status = "waiting"
+	} else {
+		mosh.Status = "queued"
+		bp.enqueue(mosh)
+		bp.ready.Signal()
+	}
 	bp.moshesMu.Unlock()
 
-	bp.queue <- mosh
+	bp.saveQueue()
+	if waiting {
+		// Its dependencies may have finished already
+		bp.releaseDependents()
+	}
+}
+
+// SetOnComplete registers a function called after each job completes.
+// Call it before Start.
+func (bp *BatchProcessor) SetOnComplete(fn func(mosh *Mosh)) {
+	bp.onComplete = fn
+}
+
+// enqueue places a mosh behind every pending mosh of the same or a higher
+// priority. The caller holds moshesMu.
+func (bp *BatchProcessor) enqueue(mosh *Mosh) {
+	i := len(bp.pending)
+	for i > 0 && bp.moshes[bp.pending[i-1]].Priority < mosh.Priority {
+		i--
+	}
+	bp.pending = append(bp.pending, "")
+	copy(bp.pending[i+1:], bp.pending[i:])
+	bp.pending[i] = mosh.ID
+}
+
+// SetMaxRunTime limits how long a render of effect may run before it is
+// stopped and marked failed. An empty effect name sets the limit of every
+// effect without its own, and zero removes a limit.
+func (bp *BatchProcessor) SetMaxRunTime(effect string, limit time.Duration) {
+	bp.moshesMu.Lock()
+	defer bp.moshesMu.Unlock()
+
+	if limit <= 0 {
+		delete(bp.maxRunTimes, effect)
+		return
+	}
+	bp.maxRunTimes[effect] = limit
+}
+
+// maxRunTime is the limit of a single mosh, zero for none. The caller
+// holds moshesMu.
+func (bp *BatchProcessor) maxRunTime(mosh *Mosh) time.Duration {
+	limit, ok := bp.maxRunTimes[mosh.Effect]
+	if !ok {
+		limit = bp.maxRunTimes[""]
+	}
+	if mosh.MaxRunTime > 0 {
+		requested := time.Duration(mosh.MaxRunTime * float64(time.Second))
+		if limit == 0 || requested < limit {
+			limit = requested
+		}
+	}
+	return limit
+}
+
+// Cancel removes a queued mosh from the queue or stops a running one. Both
+// end up with the "cancelled" status.
+func (bp *BatchProcessor) Cancel(id string) error {
+	bp.moshesMu.Lock()
+	mosh, exists := bp.moshes[id]
+	if !exists {
+		bp.moshesMu.Unlock()
+		return ErrMoshNotFound
+	}
+
+	switch mosh.Status {
+	case "queued", "waiting":
+		for i, pendingID := range bp.pending {
+			if pendingID == id {
+				bp.pending = append(bp.pending[:i], bp.pending[i+1:]...)
+				break
+			}
+		}
+		bp.moshesMu.Unlock()
+		fmt.Printf("Cancelled queued mosh %s\n", id)
+		bp.updateMosh(id, "cancelled", 0, "")
+		return nil
+	case "processing":
+		if _, remote := bp.leases[id]; remote {
+			// The worker finds out when its lease is refused
+			delete(bp.leases, id)
+			bp.moshesMu.Unlock()
+			fmt.Printf("Cancelled mosh %s leased by %s\n", id, mosh.Worker)
+			bp.updateMosh(id, "cancelled", 0, "")
+			return nil
+		}
+		cancel, running := bp.running[id]
+		bp.moshesMu.Unlock()
+		if !running {
+			// A remote worker is handing in its result
+			return ErrMoshFinished
+		}
+		// The worker sees the cancelled context and updates the status
+		fmt.Printf("Cancelling running mosh %s\n", id)
+		cancel()
+		return nil
+	default:
+		bp.moshesMu.Unlock()
+		return ErrMoshFinished
+	}
 }
 
 func (bp *BatchProcessor) GetMosh(id string) (*Mosh, bool) {
@@ -73,6 +307,21 @@ func (bp *BatchProcessor) GetMosh(id string) (*Mosh, bool) {
 	return mosh, exists
 }
 
+// SessionMoshes returns the moshes of a session that the processor knows
+// about, including queued and running ones.
+func (bp *BatchProcessor) SessionMoshes(sessionID string) []*Mosh {
+	bp.moshesMu.RLock()
+	defer bp.moshesMu.RUnlock()
+
+	var moshes []*Mosh
+	for _, mosh := range bp.moshes {
+		if mosh.SessionID == sessionID {
+			moshes = append(moshes, mosh)
+		}
+	}
+	return moshes
+}
+
 func (bp *BatchProcessor) GetAllMoshes() []*Mosh {
 	bp.moshesMu.RLock()
 	defer bp.moshesMu.RUnlock()
@@ -85,188 +334,144 @@ func (bp *BatchProcessor) GetAllMoshes() []*Mosh {
 }
 
 func (bp *BatchProcessor) worker() {
-	for mosh := range bp.queue {
+	for {
+		mosh, ctx := bp.next()
 		fmt.Printf("Processing mosh: %s\n", mosh.ID)
-		bp.processMosh(mosh)
+		bp.process(ctx, mosh)
+
+		bp.moshesMu.Lock()
+		cancel := bp.running[mosh.ID]
+		delete(bp.running, mosh.ID)
+		bp.moshesMu.Unlock()
+		cancel()
 	}
 }
 
-func (bp *BatchProcessor) processMosh(mosh *Mosh) {
-	fmt.Printf("Starting to process mosh %s with input: %s\n", mosh.ID, mosh.InputPath)
-	bp.updateMosh(mosh.ID, "processing", 0.1, "")
-
-	outputPath := filepath.Join(mosh.OutputDir, fmt.Sprintf("moshed_%s.avi", mosh.ID))
-	fmt.Printf("Output path: %s\n", outputPath)
-
-	// Start file size monitoring
-	done := make(chan bool)
-	go bp.monitorFileSize(mosh.ID, outputPath, done)
-	defer func() {
-		done <- true
-		close(done)
-	}()
-
-	var err error
-	switch mosh.Effect {
-	case "datamosh":
-		fmt.Printf("Using datamosh effect\n")
-		effect := effects.NewDatamoshEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "glitch":
-		fmt.Printf("Using glitch effect\n")
-		effect := effects.NewGlitchEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "corruption":
-		fmt.Printf("Using corruption effect\n")
-		effect := effects.NewCorruptionEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "byte_corruption":
-		fmt.Printf("Using byte corruption effect\n")
-		effect := effects.NewCorruptionEffect()
-		err = effect.ApplyByteCorruption(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "channel_shift":
-		fmt.Printf("Using channel shift effect\n")
-		effect := effects.NewCorruptionEffect()
-		err = effect.ApplyChannelShift(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "pixel_sort":
-		fmt.Printf("Using pixel sort effect\n")
-		effect := effects.NewCorruptionEffect()
-		err = effect.ApplyPixelSort(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "scanline_displace":
-		fmt.Printf("Using scanline displacement effect\n")
-		effect := effects.NewCorruptionEffect()
-		err = effect.ApplyScanlineDisplace(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "duallayer":
-		fmt.Printf("Using dual layer effect\n")
-		effect := effects.NewDualLayerEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "rgbdrift":
-		fmt.Printf("Using RGB drift effect\n")
-		effect := effects.NewRGBDriftEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "echotrail":
-		fmt.Printf("Using echo trail effect\n")
-		effect := effects.NewEchoTrailEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "glitchmosaic":
-		fmt.Printf("Using glitch mosaic effect\n")
-		effect := effects.NewGlitchMosaicEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "chromaticblur":
-		fmt.Printf("Using chromatic blur effect\n")
-		effect := effects.NewChromaticBlurEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	case "kaleidoscope":
-		fmt.Printf("Using kaleidoscope effect\n")
-		effect := effects.NewKaleidoscopeEffect()
-		err = effect.Apply(mosh.InputPath, outputPath, mosh.Params.Intensity)
-	default:
-		fmt.Printf("Using default mosher\n")
-		mosher := video.NewMosher()
-		err = mosher.MoshVideo(mosh.InputPath, outputPath, mosh.Params)
+// next blocks until a mosh is queued and claims it for the calling worker.
+// The returned context is cancelled by Cancel and when the mosh runs past
+// its maximum run time.
+func (bp *BatchProcessor) next() (*Mosh, context.Context) {
+	bp.moshesMu.Lock()
+	for len(bp.pending) == 0 {
+		bp.ready.Wait()
 	}
-
-	if err != nil {
-		fmt.Printf("Mosh %s failed: %v\n", mosh.ID, err)
-		bp.updateMosh(mosh.ID, "failed", 0, err.Error())
-	} else {
-		fmt.Printf("Mosh %s completed successfully, generating preview\n", mosh.ID)
-		bp.updateMosh(mosh.ID, "processing", 0.9, "Generating preview")
-
-		// Generate preview in the same directory as the mosh file
-		previewPath := filepath.Join(mosh.OutputDir, fmt.Sprintf("preview_%s.jpg", mosh.ID))
-		if bp.converter != nil {
-			previewErr := bp.converter.GeneratePreview(outputPath, previewPath, 300, 200)
-			if previewErr != nil {
-				fmt.Printf("Failed to generate preview for mosh %s: %v\n", mosh.ID, previewErr)
-			} else {
-				fmt.Printf("Preview generated for mosh %s at %s\n", mosh.ID, previewPath)
-			}
+	mosh := bp.moshes[bp.pending[0]]
+	bp.pending = bp.pending[1:]
+	mosh.Status = "processing"
+	mosh.Attempts++
+
+	// The time limit wraps the context Cancel stops, and the stored cancel
+	// releases both
+	ctx, cancel := context.WithCancel(context.Background())
+	if limit := bp.maxRunTime(mosh); limit > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, limit)
+		cancelRun := cancel
+		cancel = func() {
+			stop()
+			cancelRun()
 		}
-
-		bp.updateMosh(mosh.ID, "completed", 1.0, "")
-
-		// Update session metadata with the correct effect
-		bp.updateSessionMetadata(mosh)
 	}
+	bp.running[mosh.ID] = cancel
+	bp.moshesMu.Unlock()
+
+	bp.saveQueue()
+	return mosh, ctx
 }
 
 func (bp *BatchProcessor) updateMosh(id, status string, progress float64, errorMsg string) {
 	bp.moshesMu.Lock()
-	defer bp.moshesMu.Unlock()
-
-	if mosh, exists := bp.moshes[id]; exists {
+	mosh, exists := bp.moshes[id]
+	changed := false
+	if exists {
+		changed = mosh.Status != status
 		mosh.Status = status
 		mosh.Progress = progress
 		mosh.Error = errorMsg
+		if status != "processing" {
+			mosh.FPS, mosh.ETA = 0, 0
+		}
 
 		// Broadcast update via WebSocket if hub is available
 		if bp.wsHub != nil {
 			bp.wsHub.BroadcastMoshUpdate(id, status, progress)
 		}
 	}
+	bp.moshesMu.Unlock()
+
+	// Progress alone is not worth a write, status changes are
+	if !changed {
+		return
+	}
+	bp.saveQueue()
+
+	// Finished jobs release or fail the jobs waiting on them
+	switch status {
+	case "completed":
+		if bp.onComplete != nil {
+			bp.onComplete(mosh)
+		}
+		bp.releaseDependents()
+	case "failed", "cancelled":
+		bp.releaseDependents()
+	}
 }
 
-func (bp *BatchProcessor) monitorFileSize(moshID, outputPath string, done chan bool) {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+// updateStep records the chain step a mosh is on. Progress within the
+// chain comes from the steps themselves.
+func (bp *BatchProcessor) updateStep(id string, index, total int, step effects.Step) {
+	fmt.Printf("Mosh %s: step %d/%d (%s)\n", id, index+1, total, step.Effect)
 
-	startTime := time.Now()
-	lastSize := int64(0)
+	bp.moshesMu.Lock()
+	if mosh, exists := bp.moshes[id]; exists {
+		mosh.CurrentStep = index
+	}
+	bp.moshesMu.Unlock()
 
-	for {
-		select {
-		case <-done:
-			return
-		case <-ticker.C:
-			stat, err := os.Stat(outputPath)
-			if err != nil {
-				continue
-			}
+	if bp.wsHub != nil {
+		bp.wsHub.BroadcastStepUpdate(id, index, total, step.Effect)
+	}
+}
 
-			currentSize := stat.Size()
-			elapsed := time.Since(startTime).Seconds()
-
-			// Calculate progress based on file size growth
-			var progress float64
-			if currentSize > lastSize {
-				// File is growing - estimate progress based on time and size growth
-				sizeIncrease := currentSize - lastSize
-				if sizeIncrease > 0 {
-					// Rough progress estimate: 0.2 + (0.6 * elapsed/estimated_total_time)
-					progress = 0.2 + (0.6 * elapsed / 30.0) // Assume 30 second average processing time
-					if progress > 0.8 {
-						progress = 0.8 // Cap at 80% until actual completion
-					}
-				}
-			} else if elapsed > 5 {
-				// If no size change after 5 seconds, show some progress anyway
-				progress = 0.3 + (elapsed/60.0)*0.4 // Slow progress over 60 seconds
-				if progress > 0.7 {
-					progress = 0.7
-				}
-			}
+// updateProgress records a progress report from a running render and
+// passes it on to WebSocket clients.
+func (bp *BatchProcessor) updateProgress(id string, progress video.Progress) {
+	bp.moshesMu.Lock()
+	defer bp.moshesMu.Unlock()
 
-			if progress > 0.1 {
-				bp.updateMosh(moshID, "processing", progress, fmt.Sprintf("Processing... (%.1f MB)", float64(currentSize)/1024/1024))
-			}
+	mosh, exists := bp.moshes[id]
+	if !exists || mosh.Status != "processing" {
+		return
+	}
+	mosh.Progress = progress.Fraction
+	mosh.FPS = progress.FPS
+	mosh.ETA = progress.ETA
 
-			lastSize = currentSize
-		}
+	if bp.wsHub != nil {
+		bp.wsHub.BroadcastMoshProgress(id, mosh.Status, progress)
 	}
 }
 
-func (bp *BatchProcessor) CreateBatchFromPresets(inputPath, outputDir, effect string, presets []video.MoshParams) []string {
+// CreateBatchFromPresets queues one mosh per preset. Every mosh copies the
+// project, session, input, output directory, effect, effect parameters,
+// mask, priority and maximum run time of template.
+func (bp *BatchProcessor) CreateBatchFromPresets(template Mosh, presets []video.MoshParams) []string {
 	var moshIDs []string
 
-	for i, params := range presets {
-		moshID := fmt.Sprintf("batch_%d", i)
+	for _, params := range presets {
+		moshID := ids.New("mosh")
 		mosh := &Mosh{
-			ID:        moshID,
-			InputPath: inputPath,
-			OutputDir: outputDir,
-			Effect:    effect,
-			Params:    params,
+			ID:           moshID,
+			ProjectID:    template.ProjectID,
+			SessionID:    template.SessionID,
+			InputPath:    template.InputPath,
+			OutputDir:    template.OutputDir,
+			Effect:       template.Effect,
+			Params:       params,
+			EffectParams: template.EffectParams,
+			Mask:         template.Mask,
+			Priority:     template.Priority,
+			MaxRunTime:   template.MaxRunTime,
 		}
 
 		bp.AddMosh(mosh)
@@ -277,7 +482,9 @@ func (bp *BatchProcessor) CreateBatchFromPresets(inputPath, outputDir, effect st
 }
 
 func (bp *BatchProcessor) updateSessionMetadata(mosh *Mosh) {
-	// Extract session ID from output directory
+	bp.sessionsMu.Lock()
+	defer bp.sessionsMu.Unlock()
+
 	sessionDir := mosh.OutputDir
 	sessionFile := filepath.Join(sessionDir, "session.json")
 
@@ -289,10 +496,15 @@ func (bp *BatchProcessor) updateSessionMetadata(mosh *Mosh) {
 	if data, err := os.ReadFile(sessionFile); err == nil {
 		json.Unmarshal(data, &session)
 	} else {
-		// Create new session
-		sessionID := filepath.Base(sessionDir)
+		// Create new session. Moshes queued before sessions were recorded
+		// with them only know their output directory.
+		sessionID := mosh.SessionID
+		if sessionID == "" {
+			sessionID = filepath.Base(sessionDir)
+		}
 		session = map[string]interface{}{
 			"id":         sessionID,
+			"project_id": mosh.ProjectID,
 			"name":       fmt.Sprintf("Session: %s", sessionID),
 			"created_at": time.Now(),
 			"source":     fmt.Sprintf("Created with %s effect", mosh.Effect),
@@ -310,7 +522,21 @@ func (bp *BatchProcessor) updateSessionMetadata(mosh *Mosh) {
 			if existingMosh["id"] == mosh.ID {
 				// Update existing mosh with correct effect
 				existingMosh["effect"] = mosh.Effect
+				existingMosh["params"] = moshParamsMap(mosh)
 				existingMosh["file_path"] = filepath.Join(sessionDir, fmt.Sprintf("moshed_%s.avi", mosh.ID))
+				existingMosh["input_path"] = mosh.InputPath
+				existingMosh["seed"] = mosh.Seed
+				existingMosh["resolved_params"] = mosh.ResolvedParams
+				if len(mosh.Steps) > 0 {
+					existingMosh["steps"] = mosh.Steps
+				}
+				if mosh.Mask != nil {
+					existingMosh["mask"] = mosh.Mask
+				}
+				if mosh.SweepPoint != nil {
+					existingMosh["sweep_point"] = mosh.SweepPoint
+					existingMosh["label"] = mosh.Label
+				}
 				moshes[i] = existingMosh
 				found = true
 				break
@@ -321,13 +547,24 @@ func (bp *BatchProcessor) updateSessionMetadata(mosh *Mosh) {
 	if !found {
 		// Add new mosh metadata
 		newMosh := map[string]interface{}{
-			"id":        mosh.ID,
-			"effect":    mosh.Effect, // THE CORRECT FUCKING EFFECT
-			"file_path": filepath.Join(sessionDir, fmt.Sprintf("moshed_%s.avi", mosh.ID)),
-			"params": map[string]interface{}{
-				"intensity": mosh.Params.Intensity,
-			},
-			"created_at": time.Now(),
+			"id":              mosh.ID,
+			"effect":          mosh.Effect, // THE CORRECT FUCKING EFFECT
+			"file_path":       filepath.Join(sessionDir, fmt.Sprintf("moshed_%s.avi", mosh.ID)),
+			"params":          moshParamsMap(mosh),
+			"input_path":      mosh.InputPath,
+			"seed":            mosh.Seed,
+			"resolved_params": mosh.ResolvedParams,
+			"created_at":      time.Now(),
+		}
+		if len(mosh.Steps) > 0 {
+			newMosh["steps"] = mosh.Steps
+		}
+		if mosh.Mask != nil {
+			newMosh["mask"] = mosh.Mask
+		}
+		if mosh.SweepPoint != nil {
+			newMosh["sweep_point"] = mosh.SweepPoint
+			newMosh["label"] = mosh.Label
 		}
 		moshes = append(moshes, newMosh)
 	}
@@ -340,3 +577,80 @@ func (bp *BatchProcessor) updateSessionMetadata(mosh *Mosh) {
 		fmt.Printf("Session metadata updated with effect: %s\n", mosh.Effect)
 	}
 }
+
+// moshParamsMap flattens mosh parameters, including frame windows and the
+// settings of two clip effects, into the generic map stored in session
+// metadata.
+func moshParamsMap(mosh *Mosh) map[string]interface{} {
+	result := map[string]interface{}{
+		"intensity": mosh.Params.Intensity,
+	}
+
+	if data, err := json.Marshal(mosh.Params); err == nil {
+		json.Unmarshal(data, &result)
+	}
+
+	if len(mosh.EffectParams) > 0 {
+		result["effect_params"] = mosh.EffectParams
+	}
+	if mosh.SecondInputPath != "" {
+		result["second_input_path"] = mosh.SecondInputPath
+	}
+	if mosh.Transfer != nil {
+		if data, err := json.Marshal(mosh.Transfer); err == nil {
+			json.Unmarshal(data, &result)
+		}
+	}
+	return result
+}
+
+// recordExport adds a finished export to the metadata of the mosh it was
+// made from, replacing an earlier export with the same profile.
+func (bp *BatchProcessor) recordExport(export *Mosh, outputPath string) {
+	bp.sessionsMu.Lock()
+	defer bp.sessionsMu.Unlock()
+
+	sessionFile := filepath.Join(export.OutputDir, "session.json")
+	data, err := os.ReadFile(sessionFile)
+	if err != nil {
+		fmt.Printf("Failed to record export %s: %v\n", export.ID, err)
+		return
+	}
+	var session map[string]interface{}
+	if err := json.Unmarshal(data, &session); err != nil {
+		fmt.Printf("Failed to record export %s: %v\n", export.ID, err)
+		return
+	}
+
+	moshes, _ := session["moshes"].([]interface{})
+	for _, moshInterface := range moshes {
+		existingMosh, ok := moshInterface.(map[string]interface{})
+		if !ok || existingMosh["id"] != export.MoshID {
+			continue
+		}
+
+		record := map[string]interface{}{
+			"profile":    export.Profile.Name,
+			"container":  export.Profile.Container,
+			"path":       outputPath,
+			"job_id":     export.ID,
+			"created_at": time.Now(),
+		}
+		exports, _ := existingMosh["exports"].([]interface{})
+		kept := []interface{}{}
+		for _, existing := range exports {
+			if e, ok := existing.(map[string]interface{}); ok && e["profile"] == export.Profile.Name {
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		existingMosh["exports"] = append(kept, record)
+
+		if data, err := json.MarshalIndent(session, "", "  "); err == nil {
+			os.WriteFile(sessionFile, data, 0644)
+			fmt.Printf("Recorded %s export of mosh %s\n", export.Profile.Name, export.MoshID)
+		}
+		return
+	}
+	fmt.Printf("Mosh %s of export %s is not in its session\n", export.MoshID, export.ID)
+}
@@ -0,0 +1,222 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"moshr/internal/video"
+)
+
+func TestEnqueueOrdersByPriority(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	for _, mosh := range []*Mosh{
+		{ID: "a"},
+		{ID: "b", Priority: 2},
+		{ID: "c"},
+		{ID: "d", Priority: 2},
+		{ID: "e", Priority: -1},
+		{ID: "f", Priority: 1},
+	} {
+		bp.AddMosh(mosh)
+	}
+
+	if want := []string{"b", "d", "f", "a", "c", "e"}; !reflect.DeepEqual(bp.pending, want) {
+		t.Errorf("queue = %v, want %v", bp.pending, want)
+	}
+}
+
+func TestMaxRunTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits map[string]time.Duration
+		mosh   Mosh
+		want   time.Duration
+	}{
+		{"no limits", nil, Mosh{Effect: "glitch"}, 0},
+		{"default limit", map[string]time.Duration{"": time.Hour}, Mosh{Effect: "glitch"}, time.Hour},
+		{"effect limit wins", map[string]time.Duration{"": time.Hour, "glitch": time.Minute}, Mosh{Effect: "glitch"}, time.Minute},
+		{"other effect's limit", map[string]time.Duration{"sonify": time.Minute}, Mosh{Effect: "glitch"}, 0},
+		{"job shortens the limit", map[string]time.Duration{"": time.Hour}, Mosh{Effect: "glitch", MaxRunTime: 30}, 30 * time.Second},
+		{"job cannot extend the limit", map[string]time.Duration{"": time.Minute}, Mosh{Effect: "glitch", MaxRunTime: 600}, time.Minute},
+		{"job limit without a server limit", nil, Mosh{Effect: "glitch", MaxRunTime: 1.5}, 1500 * time.Millisecond},
+		{"zero removes a limit", map[string]time.Duration{"": time.Hour, "glitch": 0}, Mosh{Effect: "glitch"}, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := NewBatchProcessor(1, nil, nil)
+			for effect, limit := range tt.limits {
+				bp.SetMaxRunTime(effect, limit)
+			}
+			if got := bp.maxRunTime(&tt.mosh); got != tt.want {
+				t.Errorf("maxRunTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.AddMosh(&Mosh{ID: "queued"})
+	bp.AddMosh(&Mosh{ID: "waiting", DependsOn: []string{"queued"}})
+	bp.AddMosh(&Mosh{ID: "done"})
+	bp.updateMosh("done", "completed", 1, "")
+
+	if err := bp.Cancel("missing"); err != ErrMoshNotFound {
+		t.Errorf("cancelling an unknown mosh: got %v, want ErrMoshNotFound", err)
+	}
+	if err := bp.Cancel("done"); err != ErrMoshFinished {
+		t.Errorf("cancelling a finished mosh: got %v, want ErrMoshFinished", err)
+	}
+
+	if err := bp.Cancel("queued"); err != nil {
+		t.Fatalf("cancelling a queued mosh failed: %v", err)
+	}
+	for id, want := range map[string]string{"queued": "cancelled", "waiting": "failed"} {
+		if mosh, _ := bp.GetMosh(id); mosh.Status != want {
+			t.Errorf("%s is %s, want %s", id, mosh.Status, want)
+		}
+	}
+	for _, id := range bp.pending {
+		if id == "queued" {
+			t.Error("the cancelled mosh is still queued")
+		}
+	}
+}
+
+func TestCancelRunningMosh(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRunTime   float64
+		wantDeadline bool
+	}{
+		{"without a time limit", 0, false},
+		{"with a time limit", 60, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := NewBatchProcessor(1, nil, nil)
+			bp.AddMosh(&Mosh{ID: "running", MaxRunTime: tt.maxRunTime})
+
+			mosh, ctx := bp.next()
+			if mosh.ID != "running" || mosh.Status != "processing" || mosh.Attempts != 1 {
+				t.Fatalf("next() claimed %s as %s after %d attempts", mosh.ID, mosh.Status, mosh.Attempts)
+			}
+			if _, ok := ctx.Deadline(); ok != tt.wantDeadline {
+				t.Errorf("context has a deadline: %v, want %v", ok, tt.wantDeadline)
+			}
+
+			if err := bp.Cancel("running"); err != nil {
+				t.Fatalf("Cancel failed: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("cancelling did not stop the context")
+			}
+			if ctx.Err() != context.Canceled {
+				t.Errorf("context error = %v, want context.Canceled", ctx.Err())
+			}
+		})
+	}
+}
+
+func TestRunTimeLimitStopsMosh(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.AddMosh(&Mosh{ID: "slow", MaxRunTime: 0.01})
+
+	_, ctx := bp.next()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the time limit did not stop the context")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("context error = %v, want context.DeadlineExceeded", ctx.Err())
+	}
+}
+
+func TestCreateBatchFromPresets(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	template := Mosh{ProjectID: "project_1", SessionID: "session_1", Effect: "datamosh", Priority: 3}
+	presets := []video.MoshParams{{Intensity: 1}, {Intensity: 2}}
+
+	first := bp.CreateBatchFromPresets(template, presets)
+	second := bp.CreateBatchFromPresets(Mosh{SessionID: "session_2"}, presets)
+
+	seen := make(map[string]bool)
+	for _, id := range append(first, second...) {
+		if seen[id] {
+			t.Errorf("%s was used twice", id)
+		}
+		seen[id] = true
+	}
+	if len(bp.GetAllMoshes()) != 4 {
+		t.Errorf("the processor holds %d moshes, want 4", len(bp.GetAllMoshes()))
+	}
+
+	moshes := bp.SessionMoshes("session_1")
+	if len(moshes) != 2 {
+		t.Fatalf("session_1 has %d moshes, want 2", len(moshes))
+	}
+	for _, mosh := range moshes {
+		if mosh.ProjectID != "project_1" || mosh.Priority != 3 {
+			t.Errorf("mosh %+v does not follow the template", mosh)
+		}
+	}
+	if moshes := bp.SessionMoshes("session_3"); len(moshes) != 0 {
+		t.Errorf("an unknown session has %d moshes", len(moshes))
+	}
+}
+
+func TestRecordExport(t *testing.T) {
+	dir := t.TempDir()
+	session := `{"id": "session_1", "moshes": [{"id": "mosh_1"}, {"id": "mosh_2"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "session.json"), []byte(session), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bp := NewBatchProcessor(1, nil, nil)
+	profiles := video.BuiltinExportProfiles()
+	export := func(id string, profile video.ExportProfile, path string) {
+		bp.recordExport(&Mosh{ID: id, Kind: KindExport, MoshID: "mosh_1", OutputDir: dir, Profile: &profile}, path)
+	}
+	export("export_1", profiles[0], "first")
+	export("export_2", profiles[1], "second")
+	export("export_3", profiles[0], "third")
+
+	data, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Moshes []struct {
+			ID      string `json:"id"`
+			Exports []struct {
+				Profile string `json:"profile"`
+				Path    string `json:"path"`
+				JobID   string `json:"job_id"`
+			} `json:"exports"`
+		} `json:"moshes"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	exports := saved.Moshes[0].Exports
+	if len(exports) != 2 {
+		t.Fatalf("mosh_1 has %d exports, want one per profile: %+v", len(exports), exports)
+	}
+	if exports[0].Profile != profiles[1].Name || exports[1].Profile != profiles[0].Name || exports[1].Path != "third" || exports[1].JobID != "export_3" {
+		t.Errorf("exports = %+v, want the later export replacing the earlier one", exports)
+	}
+	if len(saved.Moshes[1].Exports) != 0 {
+		t.Errorf("mosh_2 got exports %+v", saved.Moshes[1].Exports)
+	}
+}
@@ -0,0 +1,153 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxAttempts is how many times a mosh may be started before a restart
+// that interrupts it marks it failed instead of queueing it again.
+const maxAttempts = 3
+
+// queueFile is the on-disk form of the queue. Moshes are stored in the
+// order they will run, with the ones that were processing first, followed
+// by jobs waiting on others and the finished jobs they wait on.
+type queueFile struct {
+	Moshes []*Mosh `json:"moshes"`
+}
+
+// Restore loads the queue persisted at path and keeps persisting to it.
+// Queued moshes are queued again; moshes that were processing when the
+// server stopped start over unless they have used up their attempts or
+// their input is gone, in which case they are marked failed. Call it
+// before Start.
+func (bp *BatchProcessor) Restore(path string) error {
+	bp.store = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved queueFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse queue file: %v", err)
+	}
+
+	bp.moshesMu.Lock()
+	for _, mosh := range saved.Moshes {
+		bp.moshes[mosh.ID] = mosh
+		interrupted := mosh.Status == "processing"
+
+		switch {
+		case mosh.Status == "completed" || mosh.Status == "failed" || mosh.Status == "cancelled":
+			// Kept for the jobs that depend on them
+			continue
+		case mosh.Status == "waiting":
+			// Released below once every job is back
+			continue
+		case interrupted && mosh.Attempts >= maxAttempts:
+			mosh.Status = "failed"
+			mosh.Error = fmt.Sprintf("Interrupted by a restart after %d attempts", mosh.Attempts)
+		case !inputsExist(mosh):
+			mosh.Status = "failed"
+			mosh.Error = "Input file no longer exists"
+		default:
+			mosh.Status = "queued"
+			mosh.Error = ""
+			mosh.Worker = ""
+			bp.enqueue(mosh)
+		}
+		mosh.Progress = 0
+		mosh.CurrentStep = 0
+
+		fmt.Printf("Restored mosh %s as %s\n", mosh.ID, mosh.Status)
+	}
+	bp.moshesMu.Unlock()
+
+	bp.saveQueue()
+	bp.releaseDependents()
+	return nil
+}
+
+// saveQueue writes the moshes that are still to run to the queue file.
+// Finished moshes are recorded in their session instead.
+func (bp *BatchProcessor) saveQueue() {
+	if bp.store == "" {
+		return
+	}
+
+	// Held from the snapshot to the write so an older snapshot can never
+	// overwrite a newer one
+	bp.storeMu.Lock()
+	defer bp.storeMu.Unlock()
+
+	bp.moshesMu.RLock()
+	saved := queueFile{Moshes: []*Mosh{}}
+	for _, mosh := range bp.moshes {
+		if mosh.Status == "processing" {
+			saved.Moshes = append(saved.Moshes, mosh)
+		}
+	}
+	for _, id := range bp.pending {
+		if mosh, exists := bp.moshes[id]; exists {
+			saved.Moshes = append(saved.Moshes, mosh)
+		}
+	}
+	needed := make(map[string]bool)
+	for _, mosh := range bp.moshes {
+		if mosh.Status == "waiting" {
+			saved.Moshes = append(saved.Moshes, mosh)
+			for _, id := range mosh.DependsOn {
+				needed[id] = true
+			}
+		}
+	}
+	for id := range needed {
+		if mosh, exists := bp.moshes[id]; exists && mosh.Status != "waiting" && mosh.Status != "queued" && mosh.Status != "processing" {
+			saved.Moshes = append(saved.Moshes, mosh)
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	bp.moshesMu.RUnlock()
+
+	if err != nil {
+		fmt.Printf("Failed to encode queue: %v\n", err)
+		return
+	}
+
+	// Written beside the queue file and renamed over it, so a crash while
+	// writing leaves the previous queue intact
+	if err := os.MkdirAll(filepath.Dir(bp.store), 0755); err != nil {
+		fmt.Printf("Failed to save queue: %v\n", err)
+		return
+	}
+	tmp := bp.store + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fmt.Printf("Failed to save queue: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, bp.store); err != nil {
+		fmt.Printf("Failed to save queue: %v\n", err)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// inputsExist reports whether every file a job reads is still there.
+func inputsExist(mosh *Mosh) bool {
+	for _, path := range JobInputs(mosh) {
+		if !fileExists(path) {
+			return false
+		}
+	}
+	return true
+}
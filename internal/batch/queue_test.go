@@ -0,0 +1,133 @@
+package batch
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"moshr/internal/video"
+)
+
+// writeQueue writes moshes as a queue file in a temporary directory.
+func writeQueue(t *testing.T, moshes ...*Mosh) string {
+	t.Helper()
+	data, err := json.Marshal(queueFile{Moshes: moshes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "queue.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRestore(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.avi")
+	if err := os.WriteFile(input, []byte("avi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.avi")
+
+	tests := []struct {
+		name       string
+		mosh       *Mosh
+		wantStatus string
+		wantQueued bool
+	}{
+		{"queued", &Mosh{Status: "queued", InputPath: input}, "queued", true},
+		{"interrupted", &Mosh{Status: "processing", InputPath: input, Attempts: 2, Worker: "w1", Progress: 0.5}, "queued", true},
+		{"interrupted too often", &Mosh{Status: "processing", InputPath: input, Attempts: maxAttempts}, "failed", false},
+		{"input gone", &Mosh{Status: "queued", InputPath: missing}, "failed", false},
+		{"completed", &Mosh{Status: "completed", InputPath: missing}, "completed", false},
+		{"cancelled", &Mosh{Status: "cancelled", InputPath: input}, "cancelled", false},
+		{"mask gone", &Mosh{Status: "queued", InputPath: input, Mask: &video.Mask{Kind: video.MaskFile, Path: missing}}, "failed", false},
+		{"composition", &Mosh{Status: "queued", Kind: KindComposition, Segments: []video.Segment{{Path: input}, {Path: input}}}, "queued", true},
+		{"composition segment gone", &Mosh{Status: "queued", Kind: KindComposition, Segments: []video.Segment{{Path: input}, {Path: missing}}}, "failed", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mosh.ID = "mosh_1"
+			bp := NewBatchProcessor(1, nil, nil)
+			if err := bp.Restore(writeQueue(t, tt.mosh)); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+
+			mosh, ok := bp.GetMosh("mosh_1")
+			if !ok {
+				t.Fatal("the mosh was not restored")
+			}
+			if mosh.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", mosh.Status, tt.wantStatus)
+			}
+			if queued := len(bp.pending) == 1; queued != tt.wantQueued {
+				t.Errorf("queued = %v, want %v", queued, tt.wantQueued)
+			}
+			if tt.wantQueued && (mosh.Worker != "" || mosh.Progress != 0) {
+				t.Errorf("requeued mosh kept worker %q and progress %v", mosh.Worker, mosh.Progress)
+			}
+		})
+	}
+}
+
+func TestRestoreReleasesWaitingJobs(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	err := bp.Restore(writeQueue(t,
+		&Mosh{ID: "export_2", Status: "waiting", DependsOn: []string{"mosh_1"}},
+		&Mosh{ID: "mosh_1", Status: "completed", OutputPath: "moshes/out.avi"},
+	))
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	export, _ := bp.GetMosh("export_2")
+	if export.Status != "queued" || export.InputPath != "moshes/out.avi" {
+		t.Errorf("export is %s reading %q, want queued reading the mosh output", export.Status, export.InputPath)
+	}
+}
+
+func TestRestoreKeepsQueueOrder(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.avi")
+	if err := os.WriteFile(input, []byte("avi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(t.TempDir(), "queue", "queue.json")
+
+	bp := NewBatchProcessor(1, nil, nil)
+	if err := bp.Restore(store); err != nil {
+		t.Fatalf("Restore without a queue file failed: %v", err)
+	}
+	bp.AddMosh(&Mosh{ID: "mosh_a", InputPath: input})
+	bp.AddMosh(&Mosh{ID: "mosh_b", InputPath: input, Priority: 5})
+	bp.AddMosh(&Mosh{ID: "mosh_c", InputPath: input})
+	bp.AddMosh(&Mosh{ID: "mosh_d", InputPath: input, DependsOn: []string{"mosh_c"}})
+
+	restored := NewBatchProcessor(1, nil, nil)
+	if err := restored.Restore(store); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if want := []string{"mosh_b", "mosh_a", "mosh_c"}; !reflect.DeepEqual(restored.pending, want) {
+		t.Errorf("restored queue %v, want %v", restored.pending, want)
+	}
+	if mosh, ok := restored.GetMosh("mosh_d"); !ok || mosh.Status != "waiting" {
+		t.Error("the waiting mosh was not restored as waiting")
+	}
+	for _, id := range []string{"mosh_a", "mosh_b"} {
+		if mosh, _ := restored.GetMosh(id); mosh.Seed == 0 {
+			t.Errorf("%s lost its seed", id)
+		}
+	}
+}
+
+func TestRestoreRejectsBrokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBatchProcessor(1, nil, nil).Restore(path); err == nil {
+		t.Error("expected an error for a broken queue file")
+	}
+}
@@ -0,0 +1,341 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"moshr/internal/effects"
+	"moshr/internal/video"
+)
+
+// LeaseTimeout is how long a remote worker may go without reporting on a
+// leased job before the job is taken back and queued again.
+const LeaseTimeout = 30 * time.Second
+
+// Names of the files a job reads, as remote workers fetch them. The
+// segments of a composition are named InputSegment followed by their
+// position, from 1.
+const (
+	InputMain    = "input"
+	InputSecond  = "second"
+	InputMask    = "mask"
+	InputSegment = "segment"
+)
+
+// ErrLeaseLost is returned to a remote worker reporting on a job it no
+// longer holds: the lease expired, the job was cancelled or it ran out of
+// time.
+var ErrLeaseLost = errors.New("lease is no longer held")
+
+// lease is a claim a remote worker holds on a job. It expires unless the
+// worker renews it, and ends at deadline when the job has a maximum run
+// time.
+type lease struct {
+	worker   string
+	expires  time.Time
+	deadline time.Time
+}
+
+// JobInputs returns the files a job reads by name.
+func JobInputs(mosh *Mosh) map[string]string {
+	if mosh.Kind == KindComposition {
+		inputs := make(map[string]string, len(mosh.Segments))
+		for i, segment := range mosh.Segments {
+			inputs[fmt.Sprintf("%s%d", InputSegment, i+1)] = segment.Path
+		}
+		return inputs
+	}
+
+	inputs := map[string]string{InputMain: mosh.InputPath}
+	if mosh.SecondInputPath != "" {
+		inputs[InputSecond] = mosh.SecondInputPath
+	}
+	if mosh.Mask != nil && mosh.Mask.Path != "" {
+		inputs[InputMask] = mosh.Mask.Path
+	}
+	return inputs
+}
+
+// SetJobInput points the named input of a job at path, such as a copy a
+// remote worker downloaded.
+func SetJobInput(mosh *Mosh, name, path string) error {
+	switch name {
+	case InputMain:
+		mosh.InputPath = path
+	case InputSecond:
+		mosh.SecondInputPath = path
+	case InputMask:
+		if mosh.Mask == nil {
+			return fmt.Errorf("job has no mask")
+		}
+		mask := *mosh.Mask
+		mask.Path = path
+		mosh.Mask = &mask
+	default:
+		position, err := strconv.Atoi(strings.TrimPrefix(name, InputSegment))
+		if !strings.HasPrefix(name, InputSegment) || err != nil {
+			return fmt.Errorf("unknown input %q", name)
+		}
+		if position < 1 || position > len(mosh.Segments) {
+			return fmt.Errorf("job has no segment %d", position)
+		}
+		// The segments are shared with the job they were copied from
+		segments := append([]video.Segment(nil), mosh.Segments...)
+		segments[position-1].Path = path
+		mosh.Segments = segments
+	}
+	return nil
+}
+
+// Lease claims the next queued job for a remote worker without waiting.
+// It returns a copy of the job and its maximum run time, or false when
+// nothing is queued. The worker must renew the lease within LeaseTimeout.
+func (bp *BatchProcessor) Lease(worker string) (*Mosh, time.Duration, bool) {
+	for {
+		leased, limit, ok := bp.claimLease(worker)
+		if !ok || !bp.leaseFromCache(leased) {
+			return leased, limit, ok
+		}
+	}
+}
+
+// claimLease takes the next queued job for a worker.
+func (bp *BatchProcessor) claimLease(worker string) (*Mosh, time.Duration, bool) {
+	bp.moshesMu.Lock()
+	if len(bp.pending) == 0 {
+		bp.moshesMu.Unlock()
+		return nil, 0, false
+	}
+	mosh := bp.moshes[bp.pending[0]]
+	bp.pending = bp.pending[1:]
+	mosh.Status = "processing"
+	mosh.Progress = 0
+	mosh.Attempts++
+	mosh.Worker = worker
+
+	now := time.Now()
+	l := &lease{worker: worker, expires: now.Add(LeaseTimeout)}
+	limit := bp.maxRunTime(mosh)
+	if limit > 0 {
+		l.deadline = now.Add(limit)
+	}
+	bp.leases[mosh.ID] = l
+
+	if bp.wsHub != nil {
+		bp.wsHub.BroadcastMoshUpdate(mosh.ID, mosh.Status, 0)
+	}
+	leased := *mosh
+	bp.moshesMu.Unlock()
+
+	fmt.Printf("Mosh %s leased by %s\n", leased.ID, worker)
+	bp.saveQueue()
+	return &leased, limit, true
+}
+
+// leaseFromCache finishes a leased job from the render cache on the
+// worker's behalf, reporting whether it did.
+func (bp *BatchProcessor) leaseFromCache(leased *Mosh) bool {
+	key := bp.cacheKey(leased)
+	if key == "" {
+		return false
+	}
+	outputPath := JobOutputPath(leased)
+	resolved, hit := bp.cache.Get(key, outputPath)
+	if !hit {
+		return false
+	}
+
+	bp.moshesMu.Lock()
+	mosh := bp.moshes[leased.ID]
+	_, held := bp.leases[leased.ID]
+	if held {
+		// It was cancelled otherwise
+		delete(bp.leases, leased.ID)
+		mosh.Worker = ""
+		mosh.Cached = true
+	}
+	bp.moshesMu.Unlock()
+
+	if held {
+		fmt.Printf("Job %s reused a cached render\n", leased.ID)
+		bp.finishJob(context.Background(), mosh, outputPath, resolved, nil)
+	}
+	return true
+}
+
+// heldLease returns the job a worker holds a lease on. The caller holds
+// moshesMu.
+func (bp *BatchProcessor) heldLease(id, worker string) (*Mosh, *lease, error) {
+	mosh, exists := bp.moshes[id]
+	if !exists {
+		return nil, nil, ErrMoshNotFound
+	}
+	l, leased := bp.leases[id]
+	if !leased || l.worker != worker || mosh.Status != "processing" {
+		return nil, nil, ErrLeaseLost
+	}
+	return mosh, l, nil
+}
+
+// Renew extends a worker's lease on a job. Workers renew while they run
+// even when they have no progress to report.
+func (bp *BatchProcessor) Renew(id, worker string) error {
+	bp.moshesMu.Lock()
+	defer bp.moshesMu.Unlock()
+
+	_, l, err := bp.heldLease(id, worker)
+	if err != nil {
+		return err
+	}
+	l.expires = time.Now().Add(LeaseTimeout)
+	return nil
+}
+
+// RemoteProgress records the progress a worker reports and renews its
+// lease.
+func (bp *BatchProcessor) RemoteProgress(id, worker string, progress video.Progress) error {
+	if err := bp.Renew(id, worker); err != nil {
+		return err
+	}
+	bp.updateProgress(id, progress)
+	return nil
+}
+
+// RemoteStep records the chain step a worker is on and renews its lease.
+func (bp *BatchProcessor) RemoteStep(id, worker string, index, total int, step effects.Step) error {
+	if err := bp.Renew(id, worker); err != nil {
+		return err
+	}
+	bp.updateStep(id, index, total, step)
+	return nil
+}
+
+// LeaseInputs returns the files a worker may fetch for a job it holds.
+func (bp *BatchProcessor) LeaseInputs(id, worker string) (map[string]string, error) {
+	bp.moshesMu.Lock()
+	defer bp.moshesMu.Unlock()
+
+	mosh, l, err := bp.heldLease(id, worker)
+	if err != nil {
+		return nil, err
+	}
+	// Downloading a large input counts as reporting
+	l.expires = time.Now().Add(LeaseTimeout)
+	return JobInputs(mosh), nil
+}
+
+// LeaseOutputPath returns where the output a worker uploads for a job
+// belongs.
+func (bp *BatchProcessor) LeaseOutputPath(id, worker string) (string, error) {
+	bp.moshesMu.Lock()
+	defer bp.moshesMu.Unlock()
+
+	mosh, l, err := bp.heldLease(id, worker)
+	if err != nil {
+		return "", err
+	}
+	// Uploading a large output counts as reporting
+	l.expires = time.Now().Add(LeaseTimeout)
+	return JobOutputPath(mosh), nil
+}
+
+// CompleteRemote ends a worker's lease on a job whose output it uploaded
+// and finishes the job as if it had run here, with the values the render
+// resolved.
+func (bp *BatchProcessor) CompleteRemote(id, worker string, resolved map[string]interface{}) error {
+	bp.moshesMu.Lock()
+	mosh, _, err := bp.heldLease(id, worker)
+	if err != nil {
+		bp.moshesMu.Unlock()
+		return err
+	}
+	outputPath := JobOutputPath(mosh)
+	if !fileExists(outputPath) {
+		bp.moshesMu.Unlock()
+		return fmt.Errorf("no output was uploaded for %s", id)
+	}
+	delete(bp.leases, id)
+	bp.moshesMu.Unlock()
+
+	bp.toCache(mosh, bp.cacheKey(mosh), outputPath, resolved)
+	bp.finishJob(context.Background(), mosh, outputPath, resolved, nil)
+	return nil
+}
+
+// FailRemote ends a worker's lease on a job that failed and records why.
+func (bp *BatchProcessor) FailRemote(id, worker, reason string) error {
+	bp.moshesMu.Lock()
+	mosh, _, err := bp.heldLease(id, worker)
+	if err != nil {
+		bp.moshesMu.Unlock()
+		return err
+	}
+	delete(bp.leases, id)
+	outputPath := JobOutputPath(mosh)
+	bp.moshesMu.Unlock()
+
+	os.Remove(outputPath)
+	fmt.Printf("Mosh %s failed on %s: %s\n", id, worker, reason)
+	bp.updateMosh(id, "failed", 0, reason)
+	return nil
+}
+
+// expireLeases takes back jobs from workers that stopped reporting and
+// fails leased jobs that run out of time, checking every second.
+func (bp *BatchProcessor) expireLeases() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		bp.expireLeasesAt(now)
+	}
+}
+
+// expireLeasesAt takes back the jobs whose leases expired by now, queueing
+// them again until they have used up their attempts, and fails leased
+// jobs that ran past their maximum run time.
+func (bp *BatchProcessor) expireLeasesAt(now time.Time) {
+	failed := make(map[string]string)
+	requeued := false
+
+	bp.moshesMu.Lock()
+	for id, l := range bp.leases {
+		mosh := bp.moshes[id]
+		switch {
+		case !l.deadline.IsZero() && now.After(l.deadline):
+			delete(bp.leases, id)
+			failed[id] = fmt.Sprintf("Stopped after running for the maximum of %s", bp.maxRunTime(mosh))
+		case now.After(l.expires):
+			delete(bp.leases, id)
+			if mosh.Attempts >= maxAttempts {
+				failed[id] = fmt.Sprintf("Worker %s stopped responding after %d attempts", l.worker, mosh.Attempts)
+				continue
+			}
+			fmt.Printf("Lease of %s on mosh %s expired, queueing it again\n", l.worker, id)
+			mosh.Status = "queued"
+			mosh.Progress = 0
+			mosh.CurrentStep = 0
+			mosh.Worker = ""
+			bp.enqueue(mosh)
+			bp.ready.Signal()
+			requeued = true
+			if bp.wsHub != nil {
+				bp.wsHub.BroadcastMoshUpdate(id, mosh.Status, 0)
+			}
+		}
+	}
+	bp.moshesMu.Unlock()
+
+	if requeued {
+		bp.saveQueue()
+	}
+	for id, reason := range failed {
+		fmt.Printf("Mosh %s failed: %s\n", id, reason)
+		bp.updateMosh(id, "failed", 0, reason)
+	}
+}
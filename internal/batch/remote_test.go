@@ -0,0 +1,263 @@
+package batch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"moshr/internal/video"
+)
+
+func TestJobInputs(t *testing.T) {
+	tests := []struct {
+		name string
+		mosh Mosh
+		want map[string]string
+	}{
+		{"one input", Mosh{InputPath: "a.avi"}, map[string]string{InputMain: "a.avi"}},
+		{"transfer", Mosh{InputPath: "a.avi", SecondInputPath: "b.avi"}, map[string]string{InputMain: "a.avi", InputSecond: "b.avi"}},
+		{"mask image", Mosh{InputPath: "a.avi", Mask: &video.Mask{Kind: video.MaskFile, Path: "m.png"}}, map[string]string{InputMain: "a.avi", InputMask: "m.png"}},
+		{"drawn mask", Mosh{InputPath: "a.avi", Mask: &video.Mask{Kind: video.MaskRect}}, map[string]string{InputMain: "a.avi"}},
+		{"composition", Mosh{Kind: KindComposition, Segments: []video.Segment{{Path: "a.avi"}, {Path: "b.mp4"}}}, map[string]string{"segment1": "a.avi", "segment2": "b.mp4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JobInputs(&tt.mosh); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("JobInputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetJobInput(t *testing.T) {
+	mask := &video.Mask{Kind: video.MaskFile, Path: "m.png"}
+	mosh := Mosh{InputPath: "a.avi", Mask: mask}
+	segments := []video.Segment{{Path: "a.avi"}, {Path: "b.mp4"}}
+	composition := Mosh{Kind: KindComposition, Segments: segments}
+
+	tests := []struct {
+		name    string
+		mosh    Mosh
+		input   string
+		wantErr bool
+	}{
+		{"main", mosh, InputMain, false},
+		{"second", mosh, InputSecond, false},
+		{"mask", mosh, InputMask, false},
+		{"mask of a job without one", Mosh{InputPath: "a.avi"}, InputMask, true},
+		{"unknown", mosh, "audio", true},
+		{"segment", composition, "segment2", false},
+		{"segment past the last", composition, "segment3", true},
+		{"segment without a position", composition, InputSegment, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetJobInput(&tt.mosh, tt.input, "local/copy")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetJobInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && JobInputs(&tt.mosh)[tt.input] != "local/copy" {
+				t.Errorf("input %s = %q, want the local copy", tt.input, JobInputs(&tt.mosh)[tt.input])
+			}
+		})
+	}
+	if mask.Path != "m.png" {
+		t.Error("SetJobInput changed the mask the job was queued with")
+	}
+	if segments[1].Path != "b.mp4" {
+		t.Error("SetJobInput changed the segments the job was queued with")
+	}
+}
+
+func TestLease(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	if _, _, ok := bp.Lease("w1"); ok {
+		t.Fatal("leased a job from an empty queue")
+	}
+
+	bp.SetMaxRunTime("glitch", time.Minute)
+	bp.AddMosh(&Mosh{ID: "mosh_1", Effect: "datamosh"})
+	bp.AddMosh(&Mosh{ID: "mosh_2", Effect: "glitch", Priority: 1})
+
+	leased, limit, ok := bp.Lease("w1")
+	if !ok || leased.ID != "mosh_2" || limit != time.Minute {
+		t.Fatalf("leased %v with limit %v, want mosh_2 with a minute", leased, limit)
+	}
+	mosh, _ := bp.GetMosh("mosh_2")
+	if mosh.Status != "processing" || mosh.Worker != "w1" || mosh.Attempts != 1 {
+		t.Errorf("leased mosh is %s on %q after %d attempts", mosh.Status, mosh.Worker, mosh.Attempts)
+	}
+	if leased == mosh {
+		t.Error("the worker got the queued mosh itself rather than a copy")
+	}
+	if l := bp.leases["mosh_2"]; l.deadline.IsZero() || l.expires.IsZero() {
+		t.Errorf("lease %+v has no expiry or deadline", l)
+	}
+
+	leased, limit, ok = bp.Lease("w2")
+	if !ok || leased.ID != "mosh_1" || limit != 0 || !bp.leases["mosh_1"].deadline.IsZero() {
+		t.Errorf("second lease %v has limit %v", leased, limit)
+	}
+}
+
+func TestHeldLease(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.AddMosh(&Mosh{ID: "mosh_1", InputPath: "a.avi", OutputDir: "out"})
+	bp.Lease("w1")
+	bp.AddMosh(&Mosh{ID: "mosh_2"})
+
+	tests := []struct {
+		name   string
+		id     string
+		worker string
+		want   error
+	}{
+		{"holder", "mosh_1", "w1", nil},
+		{"other worker", "mosh_1", "w2", ErrLeaseLost},
+		{"not leased", "mosh_2", "w1", ErrLeaseLost},
+		{"unknown job", "mosh_3", "w1", ErrMoshNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bp.Renew(tt.id, tt.worker); !errors.Is(err, tt.want) {
+				t.Errorf("Renew() error = %v, want %v", err, tt.want)
+			}
+			if err := bp.RemoteProgress(tt.id, tt.worker, video.Progress{Fraction: 0.5}); !errors.Is(err, tt.want) {
+				t.Errorf("RemoteProgress() error = %v, want %v", err, tt.want)
+			}
+			if _, err := bp.LeaseInputs(tt.id, tt.worker); !errors.Is(err, tt.want) {
+				t.Errorf("LeaseInputs() error = %v, want %v", err, tt.want)
+			}
+			if _, err := bp.LeaseOutputPath(tt.id, tt.worker); !errors.Is(err, tt.want) {
+				t.Errorf("LeaseOutputPath() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if mosh, _ := bp.GetMosh("mosh_1"); mosh.Progress != 0.5 {
+		t.Errorf("progress = %v, want the holder's 0.5", mosh.Progress)
+	}
+	if inputs, _ := bp.LeaseInputs("mosh_1", "w1"); inputs[InputMain] != "a.avi" {
+		t.Errorf("inputs = %v", inputs)
+	}
+	if path, _ := bp.LeaseOutputPath("mosh_1", "w1"); path != filepath.Join("out", "moshed_mosh_1.avi") {
+		t.Errorf("output path = %s", path)
+	}
+
+	if err := bp.Cancel("mosh_1"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if err := bp.Renew("mosh_1", "w1"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew() of a cancelled job error = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestCompleteRemote(t *testing.T) {
+	dir := t.TempDir()
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.AddMosh(&Mosh{ID: "mosh_1", Effect: "datamosh", OutputDir: dir})
+	bp.Lease("w1")
+
+	if err := bp.CompleteRemote("mosh_1", "w1", nil); err == nil {
+		t.Fatal("completed a job without an uploaded output")
+	}
+
+	output, _ := bp.LeaseOutputPath("mosh_1", "w1")
+	if err := os.WriteFile(output, []byte("avi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolved := map[string]interface{}{"intensity": 2.0}
+	if err := bp.CompleteRemote("mosh_1", "w2", resolved); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("another worker completed the job: %v", err)
+	}
+	if err := bp.CompleteRemote("mosh_1", "w1", resolved); err != nil {
+		t.Fatalf("CompleteRemote failed: %v", err)
+	}
+
+	mosh, _ := bp.GetMosh("mosh_1")
+	if mosh.Status != "completed" || mosh.OutputPath != output || !reflect.DeepEqual(mosh.ResolvedParams, resolved) {
+		t.Errorf("completed mosh = %+v", mosh)
+	}
+	if _, held := bp.leases["mosh_1"]; held {
+		t.Error("the lease outlived the job")
+	}
+	if err := bp.CompleteRemote("mosh_1", "w1", resolved); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("completing twice error = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestFailRemote(t *testing.T) {
+	dir := t.TempDir()
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.AddMosh(&Mosh{ID: "mosh_1", OutputDir: dir})
+	bp.Lease("w1")
+
+	output, _ := bp.LeaseOutputPath("mosh_1", "w1")
+	if err := os.WriteFile(output, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.FailRemote("mosh_1", "w2", "crashed"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("another worker failed the job: %v", err)
+	}
+	if err := bp.FailRemote("mosh_1", "w1", "crashed"); err != nil {
+		t.Fatalf("FailRemote failed: %v", err)
+	}
+
+	mosh, _ := bp.GetMosh("mosh_1")
+	if mosh.Status != "failed" || mosh.Error != "crashed" {
+		t.Errorf("mosh is %s with %q, want failed with the worker's reason", mosh.Status, mosh.Error)
+	}
+	if fileExists(output) {
+		t.Error("the partial output was kept")
+	}
+}
+
+func TestExpireLeases(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      time.Duration
+		attempts   int
+		after      time.Duration
+		wantStatus string
+		wantLease  bool
+	}{
+		{"reporting", 0, 0, LeaseTimeout / 2, "processing", true},
+		{"stopped reporting", 0, 0, LeaseTimeout + time.Second, "queued", false},
+		{"stopped reporting too often", 0, maxAttempts - 1, LeaseTimeout + time.Second, "failed", false},
+		{"within the run time", time.Minute, 0, LeaseTimeout / 2, "processing", true},
+		{"past the run time", 10 * time.Second, 0, LeaseTimeout / 2, "failed", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := NewBatchProcessor(1, nil, nil)
+			bp.SetMaxRunTime("", tt.limit)
+			bp.AddMosh(&Mosh{ID: "mosh_1", Attempts: tt.attempts, Progress: 0.3})
+			bp.Lease("w1")
+
+			bp.expireLeasesAt(time.Now().Add(tt.after))
+
+			mosh, _ := bp.GetMosh("mosh_1")
+			if mosh.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", mosh.Status, tt.wantStatus)
+			}
+			if _, held := bp.leases["mosh_1"]; held != tt.wantLease {
+				t.Errorf("lease held = %v, want %v", held, tt.wantLease)
+			}
+			if tt.wantStatus == "queued" {
+				if mosh.Worker != "" || !reflect.DeepEqual(bp.pending, []string{"mosh_1"}) {
+					t.Errorf("requeued mosh is on %q with queue %v", mosh.Worker, bp.pending)
+				}
+				if leased, _, ok := bp.Lease("w2"); !ok || leased.ID != "mosh_1" || leased.Attempts != 2 {
+					t.Errorf("another worker could not take over the job")
+				}
+			}
+		})
+	}
+}
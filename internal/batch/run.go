@@ -0,0 +1,136 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"moshr/internal/effects"
+	"moshr/internal/video"
+)
+
+// JobOutputPath returns the file a job writes. Moshes and converts write to
+// their output directory, unless a convert names its output up front;
+// exports sit beside the mosh they export, named after their profile,
+// previews are named after the mosh they show and compositions after
+// their job.
+func JobOutputPath(mosh *Mosh) string {
+	switch mosh.Kind {
+	case KindConvert:
+		if mosh.OutputPath != "" {
+			return mosh.OutputPath
+		}
+		return filepath.Join(mosh.OutputDir, fmt.Sprintf("converted_%s.avi", mosh.ID))
+	case KindExport:
+		base := strings.TrimSuffix(mosh.InputPath, filepath.Ext(mosh.InputPath))
+		profile, err := exportProfile(mosh)
+		if err != nil {
+			return base + "_" + mosh.Format
+		}
+		return base + "_" + profile.Name + profile.Extension()
+	case KindPreview:
+		if len(mosh.DependsOn) > 0 {
+			return filepath.Join(filepath.Dir(mosh.InputPath), fmt.Sprintf("preview_%s.jpg", mosh.DependsOn[0]))
+		}
+		return filepath.Join(mosh.OutputDir, fmt.Sprintf("preview_%s.jpg", mosh.ID))
+	case KindComposition:
+		return filepath.Join(mosh.OutputDir, fmt.Sprintf("%s.%s", mosh.ID, mosh.Format))
+	default:
+		return filepath.Join(mosh.OutputDir, fmt.Sprintf("moshed_%s.avi", mosh.ID))
+	}
+}
+
+// RunJob does the work of a job, writing its result to outputPath, and
+// returns the random values a mosh resolved. It only renders; recording
+// the outcome is left to the caller, so the processor's own workers and
+// remote workers share it. converter may be nil for moshes without a mask.
+func RunJob(ctx context.Context, converter *video.Converter, mosh *Mosh, outputPath string, progress *video.Reporter, onStep func(index, total int, step effects.Step)) (map[string]interface{}, error) {
+	if mosh.Kind != "" && mosh.Kind != KindMosh && converter == nil {
+		return nil, fmt.Errorf("%s jobs need a converter", mosh.Kind)
+	}
+
+	switch mosh.Kind {
+	case "", KindMosh:
+		return renderMosh(ctx, converter, mosh, outputPath, progress, onStep)
+	case KindConvert:
+		return nil, converter.MP4ToAVIWithProgress(mosh.InputPath, outputPath, intermediate(mosh), progress)
+	case KindExport:
+		profile, err := exportProfile(mosh)
+		if err != nil {
+			return nil, err
+		}
+		return nil, converter.Export(mosh.InputPath, outputPath, profile, progress)
+	case KindPreview:
+		return nil, converter.GeneratePreview(mosh.InputPath, outputPath, 300, 200)
+	case KindComposition:
+		if mosh.Conform == nil {
+			return nil, fmt.Errorf("composition job has no conform options")
+		}
+		return nil, converter.RenderSequence(mosh.Segments, outputPath, *mosh.Conform, progress)
+	}
+	return nil, fmt.Errorf("unknown job kind %q", mosh.Kind)
+}
+
+// renderMosh applies the mosh's effect. Masked moshes render the effect to
+// a side file first and composite it over the input into outputPath.
+func renderMosh(ctx context.Context, converter *video.Converter, mosh *Mosh, outputPath string, progress *video.Reporter, onStep func(index, total int, step effects.Step)) (map[string]interface{}, error) {
+	renderPath := outputPath
+	renderEnd := 1.0
+	if mosh.Mask != nil {
+		renderPath = filepath.Join(filepath.Dir(outputPath), fmt.Sprintf("unmasked_%s.avi", mosh.ID))
+		defer os.Remove(renderPath)
+		renderEnd = 0.95
+	}
+
+	effect, err := effects.Lookup(mosh.Effect)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Using %s effect\n", effect.Name())
+	resolved, err := effect.Render(effects.Job{
+		InputPath:       mosh.InputPath,
+		OutputPath:      renderPath,
+		Params:          mosh.Params,
+		Seed:            mosh.Seed,
+		EffectParams:    mosh.EffectParams,
+		SecondInputPath: mosh.SecondInputPath,
+		Transfer:        mosh.Transfer,
+		Steps:           mosh.Steps,
+		OnStep:          onStep,
+		Context:         ctx,
+		Progress:        progress.Span(0, renderEnd),
+	})
+	if err != nil || mosh.Mask == nil {
+		return resolved, err
+	}
+
+	if converter == nil {
+		return resolved, fmt.Errorf("masks need a converter")
+	}
+	return resolved, converter.ApplyMask(mosh.InputPath, renderPath, outputPath, *mosh.Mask)
+}
+
+// exportProfile returns the profile of an export job. Jobs queued before
+// profiles existed name a built-in profile in Format.
+func exportProfile(mosh *Mosh) (video.ExportProfile, error) {
+	if mosh.Profile != nil {
+		return *mosh.Profile, nil
+	}
+	for _, profile := range video.BuiltinExportProfiles() {
+		if profile.Name == mosh.Format {
+			return profile, nil
+		}
+	}
+	return video.ExportProfile{}, fmt.Errorf("unknown export profile %q", mosh.Format)
+}
+
+// intermediate returns the options a convert job encodes with.
+func intermediate(mosh *Mosh) video.AVIOptions {
+	if mosh.Intermediate == nil {
+		return video.AVIOptions{}
+	}
+	return *mosh.Intermediate
+}
@@ -0,0 +1,315 @@
+package batch
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"moshr/internal/effects"
+	"moshr/internal/ids"
+)
+
+// Sweep modes
+const (
+	SweepGrid   = "grid"
+	SweepRandom = "random"
+)
+
+// DefaultMaxSweepJobs caps the moshes a single sweep may queue unless the
+// processor is given another limit.
+const DefaultMaxSweepJobs = 64
+
+// Sweep varies effect parameters across a batch. A grid renders every
+// combination of the listed values; a random sweep draws Samples points
+// from the ranges using Seed.
+type Sweep struct {
+	Mode   string                `json:"mode"`
+	Params map[string]SweepRange `json:"params"`
+	// Samples is the number of points a random sweep draws
+	Samples int `json:"samples,omitempty"`
+	// Seed drives the random draws; zero picks one
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// SweepRange lists the values of one parameter, or spans Min to Max in
+// Steps evenly spaced values for a grid. Random sweeps draw from Values
+// when given and anywhere between Min and Max otherwise. Bool and enum
+// parameters without values cover all of their options.
+type SweepRange struct {
+	Values []interface{} `json:"values,omitempty"`
+	Min    *float64      `json:"min,omitempty"`
+	Max    *float64      `json:"max,omitempty"`
+	Steps  int           `json:"steps,omitempty"`
+}
+
+// SweepPoint is the parameter values one mosh of a sweep was rendered with.
+type SweepPoint map[string]interface{}
+
+// Label describes the point as name=value pairs sorted by name.
+func (p SweepPoint) Label() string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", name, p[name]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// SetMaxSweepJobs limits how many moshes a single sweep may queue.
+func (bp *BatchProcessor) SetMaxSweepJobs(limit int) {
+	bp.moshesMu.Lock()
+	defer bp.moshesMu.Unlock()
+	bp.maxSweepJobs = limit
+}
+
+// CreateSweep expands a sweep over the template's effect and queues one
+// mosh per point. Every mosh shares the template's render seed so the
+// results differ only by the swept parameters. It returns the mosh IDs,
+// the points in the same order, and the seed used for random draws.
+func (bp *BatchProcessor) CreateSweep(template Mosh, sweep Sweep) ([]string, []SweepPoint, int64, error) {
+	effect, err := effects.Lookup(template.Effect)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	bp.moshesMu.RLock()
+	limit := bp.maxSweepJobs
+	bp.moshesMu.RUnlock()
+
+	if sweep.Mode == SweepRandom && sweep.Seed == 0 {
+		sweep.Seed = effects.NewSeed()
+	}
+	points, err := expandSweep(effect, sweep, limit)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if template.Seed == 0 {
+		template.Seed = effects.NewSeed()
+	}
+
+	moshIDs := make([]string, 0, len(points))
+	for _, point := range points {
+		effectParams := make(map[string]interface{}, len(template.EffectParams)+len(point))
+		for name, value := range template.EffectParams {
+			effectParams[name] = value
+		}
+		for name, value := range point {
+			effectParams[name] = value
+		}
+
+		intensity := template.Params.Intensity
+		if v, ok := effectParams["intensity"].(float64); ok {
+			intensity = v
+		}
+		params := effect.NewParams(intensity, template.Params.Windows)
+		params.Animate = template.Params.Animate
+
+		moshID := ids.New("mosh")
+		bp.AddMosh(&Mosh{
+			ID:           moshID,
+			ProjectID:    template.ProjectID,
+			SessionID:    template.SessionID,
+			InputPath:    template.InputPath,
+			OutputDir:    template.OutputDir,
+			Effect:       template.Effect,
+			Params:       params,
+			Seed:         template.Seed,
+			EffectParams: effectParams,
+			Mask:         template.Mask,
+			Priority:     template.Priority,
+			MaxRunTime:   template.MaxRunTime,
+			SweepPoint:   point,
+			Label:        point.Label(),
+		})
+		moshIDs = append(moshIDs, moshID)
+	}
+
+	return moshIDs, points, sweep.Seed, nil
+}
+
+// expandSweep turns a sweep into the parameter points to render, checking
+// every point against the effect's schema. It refuses sweeps of more than
+// limit points before building them.
+func expandSweep(effect effects.Effect, sweep Sweep, limit int) ([]SweepPoint, error) {
+	if len(sweep.Params) == 0 {
+		return nil, fmt.Errorf("sweep has no parameters")
+	}
+
+	specs := make(map[string]effects.ParamSpec)
+	for _, spec := range effect.Schema() {
+		specs[spec.Name] = spec
+	}
+	names := make([]string, 0, len(sweep.Params))
+	for name := range sweep.Params {
+		if _, ok := specs[name]; !ok {
+			return nil, fmt.Errorf("%s has no parameter %q", effect.Name(), name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var points []SweepPoint
+	switch sweep.Mode {
+	case "", SweepGrid:
+		values := make([][]interface{}, len(names))
+		total := 1
+		for i, name := range names {
+			v, err := gridValues(specs[name], sweep.Params[name], limit)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			values[i] = v
+			total *= len(v)
+			if total > limit {
+				return nil, fmt.Errorf("sweep has more than the maximum of %d jobs", limit)
+			}
+		}
+
+		// The last parameter varies fastest
+		for n := 0; n < total; n++ {
+			point := make(SweepPoint, len(names))
+			rest := n
+			for i := len(names) - 1; i >= 0; i-- {
+				point[names[i]] = values[i][rest%len(values[i])]
+				rest /= len(values[i])
+			}
+			points = append(points, point)
+		}
+	case SweepRandom:
+		if sweep.Samples <= 0 {
+			return nil, fmt.Errorf("random sweep needs a number of samples")
+		}
+		if sweep.Samples > limit {
+			return nil, fmt.Errorf("sweep has more than the maximum of %d jobs", limit)
+		}
+
+		rng := rand.New(rand.NewSource(sweep.Seed))
+		for n := 0; n < sweep.Samples; n++ {
+			point := make(SweepPoint, len(names))
+			for _, name := range names {
+				value, err := sampleValue(rng, specs[name], sweep.Params[name])
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				point[name] = value
+			}
+			points = append(points, point)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sweep mode %q", sweep.Mode)
+	}
+
+	for _, point := range points {
+		if err := effects.ValidateParams(effect, point); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// gridValues lists the values a grid covers for one parameter. A list or
+// range of more than limit values is refused before any are built.
+func gridValues(spec effects.ParamSpec, r SweepRange, limit int) ([]interface{}, error) {
+	if len(r.Values) > limit || r.Steps > limit {
+		return nil, fmt.Errorf("sweep has more than the maximum of %d jobs", limit)
+	}
+	if len(r.Values) > 0 {
+		return r.Values, nil
+	}
+
+	switch spec.Type {
+	case "bool":
+		return []interface{}{false, true}, nil
+	case "enum":
+		values := make([]interface{}, len(spec.Options))
+		for i, option := range spec.Options {
+			values[i] = option
+		}
+		return values, nil
+	}
+
+	lo, hi, err := r.bounds(spec)
+	if err != nil {
+		return nil, err
+	}
+	if r.Steps < 1 {
+		return nil, fmt.Errorf("a range needs a number of steps")
+	}
+	if r.Steps == 1 {
+		return []interface{}{roundValue(spec, lo)}, nil
+	}
+
+	var values []interface{}
+	for i := 0; i < r.Steps; i++ {
+		value := roundValue(spec, lo+(hi-lo)*float64(i)/float64(r.Steps-1))
+		// Integer ranges with more steps than values round to repeats
+		if len(values) > 0 && values[len(values)-1] == value {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// sampleValue draws one value of a parameter for a random sweep.
+func sampleValue(rng *rand.Rand, spec effects.ParamSpec, r SweepRange) (interface{}, error) {
+	if len(r.Values) > 0 {
+		return r.Values[rng.Intn(len(r.Values))], nil
+	}
+
+	switch spec.Type {
+	case "bool":
+		return rng.Intn(2) == 1, nil
+	case "enum":
+		return spec.Options[rng.Intn(len(spec.Options))], nil
+	}
+
+	lo, hi, err := r.bounds(spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Type == "int" {
+		lo, hi = math.Ceil(lo), math.Floor(hi)
+		if hi < lo {
+			return nil, fmt.Errorf("range holds no whole numbers")
+		}
+		return lo + float64(rng.Int63n(int64(hi-lo)+1)), nil
+	}
+	return roundValue(spec, lo+rng.Float64()*(hi-lo)), nil
+}
+
+// bounds returns the range's span, defaulting to the parameter's own.
+func (r SweepRange) bounds(spec effects.ParamSpec) (float64, float64, error) {
+	if spec.Type != "int" && spec.Type != "float" {
+		return 0, 0, fmt.Errorf("%s parameters need a list of values", spec.Type)
+	}
+
+	lo, hi := spec.Min, spec.Max
+	if r.Min != nil {
+		lo = *r.Min
+	}
+	if r.Max != nil {
+		hi = *r.Max
+	}
+	if hi < lo {
+		return 0, 0, fmt.Errorf("max %g is below min %g", hi, lo)
+	}
+	return lo, hi, nil
+}
+
+// roundValue keeps integer parameters whole and floats to a readable
+// precision, as a float64 like the numbers decoded from JSON.
+func roundValue(spec effects.ParamSpec, value float64) float64 {
+	if spec.Type == "int" {
+		return math.Round(value)
+	}
+	return math.Round(value*1e6) / 1e6
+}
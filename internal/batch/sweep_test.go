@@ -0,0 +1,221 @@
+package batch
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"moshr/internal/effects"
+	"moshr/internal/video"
+)
+
+// sweepEffect is an effect with one parameter of every type.
+type sweepEffect struct{}
+
+func (sweepEffect) Name() string        { return "sweep_test" }
+func (sweepEffect) Description() string { return "Test effect" }
+
+func (sweepEffect) Schema() []effects.ParamSpec {
+	return []effects.ParamSpec{
+		{Name: "count", Type: "int", Min: 0, Max: 10},
+		{Name: "level", Type: "float", Min: 0, Max: 1},
+		{Name: "mode", Type: "enum", Options: []string{"x", "y"}},
+		{Name: "flip", Type: "bool"},
+	}
+}
+
+func (sweepEffect) NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams {
+	return video.MoshParams{Intensity: intensity}
+}
+func (sweepEffect) Presets(windows []video.MoshWindow) []video.MoshParams  { return nil }
+func (sweepEffect) Render(job effects.Job) (map[string]interface{}, error) { return nil, nil }
+
+func float(v float64) *float64 { return &v }
+
+func TestSweepPointLabel(t *testing.T) {
+	point := SweepPoint{"mode": "x", "count": 3.0, "flip": true}
+	if got, want := point.Label(), "count=3, flip=true, mode=x"; got != want {
+		t.Errorf("Label() = %q, want %q", got, want)
+	}
+}
+
+func TestExpandGridSweep(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]SweepRange
+		want   []string
+	}{
+		{
+			"int steps",
+			map[string]SweepRange{"count": {Min: float(0), Max: float(10), Steps: 3}},
+			[]string{"count=0", "count=5", "count=10"},
+		},
+		{
+			"schema bounds",
+			map[string]SweepRange{"level": {Steps: 3}},
+			[]string{"level=0", "level=0.5", "level=1"},
+		},
+		{
+			"one step",
+			map[string]SweepRange{"level": {Min: float(0.25), Steps: 1}},
+			[]string{"level=0.25"},
+		},
+		{
+			"repeated whole numbers collapse",
+			map[string]SweepRange{"count": {Min: float(0), Max: float(2), Steps: 5}},
+			[]string{"count=0", "count=1", "count=2"},
+		},
+		{
+			"floats round",
+			map[string]SweepRange{"level": {Min: float(0), Max: float(1), Steps: 4}},
+			[]string{"level=0", "level=0.333333", "level=0.666667", "level=1"},
+		},
+		{
+			"options and values",
+			map[string]SweepRange{"flip": {}, "mode": {}, "count": {Values: []interface{}{1.0, 7.0}}},
+			[]string{
+				"count=1, flip=false, mode=x", "count=1, flip=false, mode=y",
+				"count=1, flip=true, mode=x", "count=1, flip=true, mode=y",
+				"count=7, flip=false, mode=x", "count=7, flip=false, mode=y",
+				"count=7, flip=true, mode=x", "count=7, flip=true, mode=y",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := expandSweep(sweepEffect{}, Sweep{Mode: SweepGrid, Params: tt.params}, 64)
+			if err != nil {
+				t.Fatalf("expandSweep failed: %v", err)
+			}
+			var got []string
+			for _, point := range points {
+				got = append(got, point.Label())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandSweepErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		sweep Sweep
+	}{
+		{"no parameters", Sweep{}},
+		{"unknown parameter", Sweep{Params: map[string]SweepRange{"speed": {Steps: 2}}}},
+		{"unknown mode", Sweep{Mode: "spiral", Params: map[string]SweepRange{"flip": {}}}},
+		{"range without steps", Sweep{Params: map[string]SweepRange{"level": {}}}},
+		{"max below min", Sweep{Params: map[string]SweepRange{"level": {Min: float(1), Max: float(0), Steps: 2}}}},
+		{"enum range", Sweep{Mode: SweepRandom, Samples: 1, Params: map[string]SweepRange{"mode": {Values: []interface{}{"z"}}}}},
+		{"values outside the schema", Sweep{Params: map[string]SweepRange{"count": {Values: []interface{}{11.0}}}}},
+		{"grid over the limit", Sweep{Params: map[string]SweepRange{"count": {Steps: 11}, "level": {Steps: 2}}}},
+		{"steps over the limit", Sweep{Params: map[string]SweepRange{"level": {Min: float(0), Max: float(1), Steps: 1000000000}}}},
+		{"values over the limit", Sweep{Params: map[string]SweepRange{"level": {Values: make([]interface{}, 21)}}}},
+		{"random without samples", Sweep{Mode: SweepRandom, Params: map[string]SweepRange{"level": {}}}},
+		{"random over the limit", Sweep{Mode: SweepRandom, Samples: 21, Params: map[string]SweepRange{"level": {}}}},
+		{"no whole numbers", Sweep{Mode: SweepRandom, Samples: 1, Params: map[string]SweepRange{"count": {Min: float(1.2), Max: float(1.8)}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := expandSweep(sweepEffect{}, tt.sweep, 20); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestExpandRandomSweep(t *testing.T) {
+	sweep := Sweep{
+		Mode:    SweepRandom,
+		Samples: 20,
+		Seed:    5,
+		Params: map[string]SweepRange{
+			"count": {Min: float(2), Max: float(4)},
+			"level": {Min: float(0.2), Max: float(0.3)},
+			"mode":  {},
+		},
+	}
+
+	points, err := expandSweep(sweepEffect{}, sweep, 64)
+	if err != nil {
+		t.Fatalf("expandSweep failed: %v", err)
+	}
+	if len(points) != 20 {
+		t.Fatalf("got %d points, want 20", len(points))
+	}
+	for _, point := range points {
+		count, level := point["count"].(float64), point["level"].(float64)
+		if count < 2 || count > 4 || count != math.Trunc(count) {
+			t.Errorf("count %v is not a whole number from 2 to 4", count)
+		}
+		if level < 0.2 || level > 0.3 {
+			t.Errorf("level %v is outside 0.2 to 0.3", level)
+		}
+		if mode := point["mode"]; mode != "x" && mode != "y" {
+			t.Errorf("mode %v is not an option", mode)
+		}
+	}
+
+	again, _ := expandSweep(sweepEffect{}, sweep, 64)
+	if !reflect.DeepEqual(again, points) {
+		t.Error("the same seed drew different points")
+	}
+	sweep.Seed = 6
+	if other, _ := expandSweep(sweepEffect{}, sweep, 64); reflect.DeepEqual(other, points) {
+		t.Error("seeds 5 and 6 drew the same points")
+	}
+}
+
+func TestCreateSweep(t *testing.T) {
+	bp := NewBatchProcessor(1, nil, nil)
+	bp.SetMaxSweepJobs(3)
+
+	template := Mosh{
+		SessionID:    "session_1",
+		Effect:       "rgbdrift",
+		Params:       video.MoshParams{Intensity: 1},
+		EffectParams: map[string]interface{}{"intensity": 2.0},
+		Priority:     4,
+	}
+	sweep := Sweep{Params: map[string]SweepRange{"intensity": {Values: []interface{}{0.5, 1.5}}}}
+
+	moshIDs, points, _, err := bp.CreateSweep(template, sweep)
+	if err != nil {
+		t.Fatalf("CreateSweep failed: %v", err)
+	}
+	if len(moshIDs) != 2 || len(points) != 2 {
+		t.Fatalf("got %d moshes and %d points, want 2", len(moshIDs), len(points))
+	}
+
+	var seed int64
+	for i, id := range moshIDs {
+		mosh, ok := bp.GetMosh(id)
+		if !ok {
+			t.Fatalf("mosh %s was not queued", id)
+		}
+		want := points[i]["intensity"].(float64)
+		if mosh.Params.Intensity != want || mosh.EffectParams["intensity"] != want {
+			t.Errorf("mosh %d renders at %v, want the swept %v", i, mosh.Params.Intensity, want)
+		}
+		if mosh.Label != fmt.Sprintf("intensity=%v", want) || mosh.Priority != 4 || mosh.SessionID != "session_1" {
+			t.Errorf("mosh %d = %+v does not follow the template", i, mosh)
+		}
+		if i > 0 && mosh.Seed != seed {
+			t.Error("the moshes of a sweep do not share a seed")
+		}
+		seed = mosh.Seed
+	}
+
+	sweep.Params["intensity"] = SweepRange{Values: []interface{}{0.5, 1, 1.5, 2}}
+	if _, _, _, err := bp.CreateSweep(template, sweep); err == nil {
+		t.Error("expected an error for a sweep past the job limit")
+	}
+	if _, _, _, err := bp.CreateSweep(Mosh{Effect: "melt"}, sweep); err == nil {
+		t.Error("expected an error for an unknown effect")
+	}
+}
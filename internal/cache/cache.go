@@ -0,0 +1,299 @@
+// Package cache keeps finished renders on disk by a hash of everything
+// that decides their content, so a job that was already rendered once is
+// served from the cache instead of being rendered again.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const indexFile = "index.json"
+
+// Cache is a directory of rendered files named by key, evicting the least
+// recently used ones once they take up more than the maximum size.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	size    int64
+	// hashes remembers the contents hash of input files by path, valid
+	// while their size and modification time stay the same
+	hashes map[string]fileHash
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// Entry is one cached render.
+type Entry struct {
+	Key  string `json:"key"`
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	// Resolved holds the random values the render resolved, which a job
+	// served from the cache reports as its own
+	Resolved map[string]interface{} `json:"resolved,omitempty"`
+	Created  time.Time              `json:"created"`
+	LastUsed time.Time              `json:"last_used"`
+}
+
+// Stats describe the cache's contents and how well it has done since the
+// server started.
+type Stats struct {
+	Entries   int     `json:"entries"`
+	SizeBytes int64   `json:"size_bytes"`
+	MaxBytes  int64   `json:"max_bytes"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// Open loads the cache kept in dir, creating it if needed. Entries whose
+// files have gone are dropped.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*Entry),
+		hashes:   make(map[string]fileHash),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var entries []*Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse cache index: %v", err)
+		}
+		for _, entry := range entries {
+			if info, err := os.Stat(filepath.Join(dir, entry.File)); err == nil {
+				entry.Size = info.Size()
+				c.entries[entry.Key] = entry
+				c.size += entry.Size
+			}
+		}
+	}
+
+	c.evict()
+	return c, nil
+}
+
+// HashFile returns the SHA-256 of a file's contents, hashing it again only
+// when its size or modification time changed.
+func (c *Cache) HashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	known, ok := c.hashes[path]
+	c.mu.Unlock()
+	if ok && known.size == info.Size() && known.modTime.Equal(info.ModTime()) {
+		return known.sum, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	c.hashes[path] = fileHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// Get places the render cached under key at outputPath, replacing
+// anything there, and returns the values it resolved. It links the file
+// when it can and copies it otherwise.
+func (c *Cache) Get(key, outputPath string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	if err := place(filepath.Join(c.dir, entry.File), outputPath); err != nil {
+		fmt.Printf("Failed to reuse cached render %s: %v\n", key, err)
+		c.misses++
+		return nil, false
+	}
+
+	entry.LastUsed = time.Now()
+	c.hits++
+	c.save()
+	return entry.Resolved, true
+}
+
+// Put stores the render at path under key. Renders larger than the whole
+// cache are not kept.
+func (c *Cache) Put(key, path string, resolved map[string]interface{}) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; exists {
+		return nil
+	}
+
+	file := key + filepath.Ext(path)
+	if err := place(path, filepath.Join(c.dir, file)); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	c.entries[key] = &Entry{
+		Key:      key,
+		File:     file,
+		Size:     info.Size(),
+		Resolved: resolved,
+		Created:  now,
+		LastUsed: now,
+	}
+	c.size += info.Size()
+
+	c.evict()
+	c.save()
+	return nil
+}
+
+// Stats returns the cache's current statistics.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		Entries:   len(c.entries),
+		SizeBytes: c.size,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// evict removes the least recently used entries until the cache fits its
+// maximum size. The caller holds mu, except while opening.
+func (c *Cache) evict() {
+	if c.size <= c.maxBytes {
+		return
+	}
+
+	entries := make([]*Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+
+	for _, entry := range entries {
+		if c.size <= c.maxBytes {
+			break
+		}
+		os.Remove(filepath.Join(c.dir, entry.File))
+		delete(c.entries, entry.Key)
+		c.size -= entry.Size
+		c.evictions++
+	}
+	c.save()
+}
+
+// save writes the index. The caller holds mu.
+func (c *Cache) save() {
+	entries := make([]*Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(c.dir, indexFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		fmt.Printf("Failed to save cache index: %v\n", err)
+		return
+	}
+	os.Rename(path+".tmp", path)
+}
+
+// place makes dst a hard link to src, or a copy when the two cannot be
+// linked, such as across file systems. Nothing may write to either file in
+// place afterwards.
+func place(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeFile writes size bytes of content to a file in dir.
+func writeFile(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = name[0] + byte(i)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPutAndGet(t *testing.T) {
+	c, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	work := t.TempDir()
+	render := writeFile(t, work, "render.avi", 10)
+	resolved := map[string]interface{}{"intensity": 2.0}
+
+	if _, hit := c.Get("a", filepath.Join(work, "out.avi")); hit {
+		t.Fatal("hit in an empty cache")
+	}
+	if err := c.Put("a", render, resolved); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// A second render under the same key is not kept
+	if err := c.Put("a", writeFile(t, work, "other.avi", 20), nil); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	out := filepath.Join(work, "out.avi")
+	writeFile(t, work, "out.avi", 3)
+	got, hit := c.Get("a", out)
+	if !hit || !reflect.DeepEqual(got, resolved) {
+		t.Fatalf("Get() = %v, %v, want the resolved values", got, hit)
+	}
+	want, _ := os.ReadFile(render)
+	if data, _ := os.ReadFile(out); !reflect.DeepEqual(data, want) {
+		t.Error("the output does not hold the cached render")
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 1 || stats.SizeBytes != 10 || stats.MaxBytes != 100 || stats.Hits != 1 || stats.Misses != 1 || stats.HitRate != 0.5 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestPutSkipsRendersLargerThanTheCache(t *testing.T) {
+	c, err := Open(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := c.Put("a", writeFile(t, t.TempDir(), "render.avi", 11), nil); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("kept %d entries, want none", stats.Entries)
+	}
+	if err := c.Put("b", filepath.Join(t.TempDir(), "missing.avi"), nil); err == nil {
+		t.Error("expected an error for a missing render")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	tests := []struct {
+		name          string
+		used          []string
+		wantKept      []string
+		wantEvictions int64
+	}{
+		{"oldest goes", nil, []string{"b", "c", "d"}, 1},
+		{"used entry stays", []string{"a"}, []string{"a", "c", "d"}, 1},
+		{"least recently used goes", []string{"b", "a"}, []string{"a", "b", "d"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := Open(dir, 30)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			work := t.TempDir()
+			start := time.Now().Add(-time.Hour)
+			for i, key := range []string{"a", "b", "c"} {
+				if err := c.Put(key, writeFile(t, work, key+".avi", 10), nil); err != nil {
+					t.Fatal(err)
+				}
+				c.entries[key].LastUsed = start.Add(time.Duration(i) * time.Minute)
+			}
+			for _, key := range tt.used {
+				if _, hit := c.Get(key, filepath.Join(work, "out.avi")); !hit {
+					t.Fatalf("%s was not cached", key)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if err := c.Put("d", writeFile(t, work, "d.avi", 10), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			var kept []string
+			for _, key := range []string{"a", "b", "c", "d"} {
+				_, cached := c.entries[key]
+				if cached {
+					kept = append(kept, key)
+				}
+				if _, err := os.Stat(filepath.Join(dir, key+".avi")); (err == nil) != cached {
+					t.Errorf("file of %s exists = %v, want %v", key, err == nil, cached)
+				}
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept %v, want %v", kept, tt.wantKept)
+			}
+			if stats := c.Stats(); stats.Evictions != tt.wantEvictions || stats.SizeBytes != 30 {
+				t.Errorf("Stats() = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestOpenReloadsIndex(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 100)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	work := t.TempDir()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Put(key, writeFile(t, work, key+".avi", 20), map[string]interface{}{"key": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Remove(filepath.Join(dir, "b.avi"))
+
+	reopened, err := Open(dir, 100)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if stats := reopened.Stats(); stats.Entries != 2 || stats.SizeBytes != 40 {
+		t.Errorf("reopened cache holds %d entries of %d bytes, want 2 of 40", stats.Entries, stats.SizeBytes)
+	}
+	if resolved, hit := reopened.Get("c", filepath.Join(work, "out.avi")); !hit || resolved["key"] != "c" {
+		t.Errorf("Get(c) = %v, %v after reopening", resolved, hit)
+	}
+
+	// A smaller maximum evicts on opening
+	if smaller, err := Open(dir, 20); err != nil || smaller.Stats().Entries != 1 {
+		t.Errorf("cache opened with room for one entry holds %d", smaller.Stats().Entries)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, indexFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, 100); err == nil {
+		t.Error("expected an error for a broken index")
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	c, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.avi", 10)
+	b := writeFile(t, dir, "b.avi", 10)
+
+	sumA, err := c.HashFile(a)
+	if err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	if again, _ := c.HashFile(a); again != sumA {
+		t.Error("hashing the same file twice gave different sums")
+	}
+	if sumB, _ := c.HashFile(b); sumB == sumA {
+		t.Error("different contents hashed the same")
+	}
+
+	if err := os.WriteFile(a, []byte("changed contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := c.HashFile(a); changed == sumA {
+		t.Error("a changed file kept its old hash")
+	}
+	if _, err := c.HashFile(filepath.Join(dir, "missing.avi")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
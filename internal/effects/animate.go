@@ -0,0 +1,61 @@
+package effects
+
+import (
+	"fmt"
+
+	"moshr/internal/video"
+)
+
+// renderIntensity is the intensity an effect renders at. With an intensity
+// curve the effect renders at the curve's peak and blendEnvelope fades it
+// against the original to follow the curve.
+func renderIntensity(params video.MoshParams) float64 {
+	if curve, ok := params.Animate["intensity"]; ok {
+		return curve.Peak()
+	}
+	return params.Intensity
+}
+
+// paramExpr returns the ffmpeg expression for a parameter: its curve in the
+// time variable t when animated, otherwise the constant value.
+func paramExpr(animate map[string]video.Curve, name string, value float64, t string) string {
+	if curve, ok := animate[name]; ok {
+		return curve.Expr(t)
+	}
+	return fmt.Sprintf("%g", value)
+}
+
+// paramPeak is the largest absolute value a parameter reaches, used to size
+// pads and canvases that cannot change during the clip.
+func paramPeak(animate map[string]video.Curve, name string, value float64) float64 {
+	if curve, ok := animate[name]; ok {
+		return curve.Peak()
+	}
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// blendEnvelope fades the single unlabeled output of filterComplex against
+// the original video following the intensity curve, normalized to its
+// peak. Without an intensity curve the filter is returned unchanged.
+func blendEnvelope(filterComplex string, animate map[string]video.Curve) string {
+	curve, ok := animate["intensity"]
+	if !ok {
+		return filterComplex
+	}
+
+	mix := "1"
+	if peak := curve.Peak(); peak > 0 {
+		mix = fmt.Sprintf("clip(%s/%g,0,1)", curve.Expr("T"), peak)
+	}
+
+	return filterComplex + "[fx];" +
+		// Effects may pad or resize the picture, so the original is
+		// scaled to match before blending
+		"[0:v][fx]scale2ref[orig_sized][fx_sized];" +
+		"[fx_sized]format=yuv420p[fx_fmt];" +
+		"[orig_sized]format=yuv420p[orig_fmt];" +
+		fmt.Sprintf("[fx_fmt][orig_fmt]blend=all_expr='A*(%s)+B*(1-(%s))'", mix, mix)
+}
@@ -0,0 +1,64 @@
+package effects
+
+import (
+	"strings"
+	"testing"
+
+	"moshr/internal/video"
+)
+
+func TestAnimatedParams(t *testing.T) {
+	ramp := video.Curve{Keyframes: []video.Keyframe{{Time: 0, Value: 0.5}, {Time: 2, Value: -2.5}}}
+	animate := map[string]video.Curve{"intensity": ramp, "offset": ramp}
+
+	tests := []struct {
+		name      string
+		animate   map[string]video.Curve
+		param     string
+		value     float64
+		wantExpr  string
+		wantPeak  float64
+		intensity float64
+	}{
+		{"fixed", nil, "offset", -3, "-3", 3, 1.5},
+		{"animated", animate, "offset", 7, ramp.Expr("T"), 2.5, 2.5},
+		{"other param animated", animate, "size", 0.25, "0.25", 0.25, 2.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := paramExpr(tt.animate, tt.param, tt.value, "T"); got != tt.wantExpr {
+				t.Errorf("paramExpr() = %s, want %s", got, tt.wantExpr)
+			}
+			if got := paramPeak(tt.animate, tt.param, tt.value); got != tt.wantPeak {
+				t.Errorf("paramPeak() = %v, want %v", got, tt.wantPeak)
+			}
+			params := video.MoshParams{Intensity: 1.5, Animate: tt.animate}
+			if got := renderIntensity(params); got != tt.intensity {
+				t.Errorf("renderIntensity() = %v, want %v", got, tt.intensity)
+			}
+		})
+	}
+}
+
+func TestBlendEnvelope(t *testing.T) {
+	filter := "hue=s=2"
+	if got := blendEnvelope(filter, nil); got != filter {
+		t.Errorf("without an intensity curve got %s, want the filter unchanged", got)
+	}
+
+	curve := video.Curve{Keyframes: []video.Keyframe{{Time: 0, Value: 0}, {Time: 1, Value: 2}}}
+	got := blendEnvelope(filter, map[string]video.Curve{"intensity": curve})
+	if !strings.HasPrefix(got, filter+"[fx];") {
+		t.Errorf("blend does not label the effect output: %s", got)
+	}
+	if mix := "clip(" + curve.Expr("T") + "/2,0,1)"; !strings.Contains(got, mix) {
+		t.Errorf("blend does not normalize the curve to its peak: %s", got)
+	}
+
+	// A curve that stays at zero shows the effect throughout
+	flat := video.Curve{Keyframes: []video.Keyframe{{Time: 0, Value: 0}}}
+	if got := blendEnvelope(filter, map[string]video.Curve{"intensity": flat}); !strings.Contains(got, "A*(1)+B*(1-(1))") {
+		t.Errorf("a zero peak curve got %s", got)
+	}
+}
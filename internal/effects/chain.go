@@ -0,0 +1,179 @@
+package effects
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"moshr/internal/video"
+)
+
+// ChainEffectName is the effect name of moshes that run several steps.
+const ChainEffectName = "chain"
+
+// Step is one effect in a chain, with the parameters it renders with.
+type Step struct {
+	Effect       string                 `json:"effect"`
+	Params       video.MoshParams       `json:"params"`
+	EffectParams map[string]interface{} `json:"effect_params,omitempty"`
+	// Seed of this step; zero derives one from the chain's seed
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// ChainEffect feeds the output of each step into the next one through
+// temporary files, so several looks can be stacked in a single mosh.
+type ChainEffect struct{}
+
+func init() {
+	Register(&ChainEffect{})
+}
+
+func (ch *ChainEffect) Name() string { return ChainEffectName }
+
+func (ch *ChainEffect) Description() string {
+	return "Runs several effects one after another, each on the output of the last"
+}
+
+// Schema is empty; every step is described by its own effect's schema.
+func (ch *ChainEffect) Schema() []ParamSpec {
+	return nil
+}
+
+func (ch *ChainEffect) NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams {
+	return video.MoshParams{Intensity: intensity, Windows: windows}
+}
+
+// Presets is empty since a chain has nothing to vary without its steps.
+func (ch *ChainEffect) Presets(windows []video.MoshWindow) []video.MoshParams {
+	return nil
+}
+
+// Render runs the job's steps in order. Steps without a seed get one drawn
+// from the job's seed, so rendering the chain again with the same seed
+// repeats every step. The values each step resolved are returned under
+// "steps", in step order.
+func (ch *ChainEffect) Render(job Job) (map[string]interface{}, error) {
+	if err := ValidateSteps(job.Steps); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "moshr_chain_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	seeds := StepSeeds(job.Seed, job.Steps)
+	resolved := make([]map[string]interface{}, 0, len(job.Steps))
+	input := job.InputPath
+
+	for i, step := range job.Steps {
+		if job.Context != nil && job.Context.Err() != nil {
+			return map[string]interface{}{"steps": resolved}, job.Context.Err()
+		}
+		if job.OnStep != nil {
+			job.OnStep(i, len(job.Steps), step)
+		}
+
+		effect, _ := Lookup(step.Effect)
+
+		output := job.OutputPath
+		if i < len(job.Steps)-1 {
+			output = filepath.Join(tempDir, fmt.Sprintf("step_%d.avi", i))
+		}
+
+		stepJob := Job{
+			InputPath:    input,
+			OutputPath:   output,
+			Params:       step.Params,
+			Seed:         seeds[i],
+			EffectParams: step.EffectParams,
+			Context:      job.Context,
+			// Every step gets an equal share of the chain's progress
+			Progress: job.Progress.Span(float64(i)/float64(len(job.Steps)), float64(i+1)/float64(len(job.Steps))),
+		}
+		// Only the first step sees the original clip, so only it can
+		// combine it with a second one
+		if i == 0 {
+			stepJob.SecondInputPath = job.SecondInputPath
+			stepJob.Transfer = job.Transfer
+		}
+
+		values, err := effect.Render(stepJob)
+		if err != nil {
+			return map[string]interface{}{"steps": resolved}, fmt.Errorf("step %d (%s): %v", i+1, step.Effect, err)
+		}
+
+		entry := map[string]interface{}{"effect": step.Effect, "seed": seeds[i]}
+		for name, value := range values {
+			entry[name] = value
+		}
+		resolved = append(resolved, entry)
+
+		input = output
+	}
+
+	return map[string]interface{}{"steps": resolved}, nil
+}
+
+// IgnoresSeed is true when every step either pins its own seed or runs an
+// effect that ignores it, since the chain's seed only seeds the others.
+func (ch *ChainEffect) IgnoresSeed(job Job) bool {
+	for _, step := range job.Steps {
+		if step.Seed != 0 {
+			continue
+		}
+		effect, err := Lookup(step.Effect)
+		if err != nil || UsesSeed(effect, Job{Params: step.Params, EffectParams: step.EffectParams}) {
+			return false
+		}
+	}
+	return true
+}
+
+// StepSeeds returns the seed every step renders with, drawing the ones not
+// set on the step from the chain seed.
+func StepSeeds(seed int64, steps []Step) []int64 {
+	rng := rand.New(rand.NewSource(seed))
+	seeds := make([]int64, len(steps))
+	for i, step := range steps {
+		// Always draw so a pinned seed does not shift the ones after it
+		seeds[i] = rng.Int63n(MaxSeed) + 1
+		if step.Seed != 0 {
+			seeds[i] = step.Seed
+		}
+	}
+	return seeds
+}
+
+// ValidateSteps checks that every step names a known effect and that its
+// parameters fit the effect's schema. Chains cannot be nested.
+func ValidateSteps(steps []Step) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("a chain needs at least one step")
+	}
+
+	for i, step := range steps {
+		if step.Effect == ChainEffectName {
+			return fmt.Errorf("step %d: chains cannot be nested", i+1)
+		}
+		effect, err := Lookup(step.Effect)
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if err := step.Params.Validate(); err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if err := ValidateParams(effect, step.EffectParams); err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if err := ValidateAnimation(effect, step.Params.Animate); err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if step.Seed < 0 || step.Seed > MaxSeed {
+			return fmt.Errorf("step %d: seed must be between 1 and %d", i+1, int64(MaxSeed))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package effects
+
+import (
+	"context"
+	"testing"
+
+	"moshr/internal/video"
+)
+
+func TestStepSeeds(t *testing.T) {
+	steps := []Step{{Effect: "glitch"}, {Effect: "rgbdrift"}, {Effect: "echotrail"}}
+
+	seeds := StepSeeds(42, steps)
+	if len(seeds) != len(steps) {
+		t.Fatalf("got %d seeds for %d steps", len(seeds), len(steps))
+	}
+	for i, seed := range seeds {
+		if seed < 1 || seed > MaxSeed {
+			t.Errorf("seed %d = %d is out of range", i, seed)
+		}
+	}
+	if again := StepSeeds(42, steps); !equalSeeds(again, seeds) {
+		t.Errorf("chain seed 42 gave %v, then %v", seeds, again)
+	}
+	if other := StepSeeds(43, steps); equalSeeds(other, seeds) {
+		t.Error("chain seeds 42 and 43 gave the same step seeds")
+	}
+
+	// Pinning the middle seed leaves the others alone
+	pinned := append([]Step{}, steps...)
+	pinned[1].Seed = 7
+	got := StepSeeds(42, pinned)
+	if want := []int64{seeds[0], 7, seeds[2]}; !equalSeeds(got, want) {
+		t.Errorf("with a pinned seed got %v, want %v", got, want)
+	}
+}
+
+func equalSeeds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValidateSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []Step
+		wantErr bool
+	}{
+		{"no steps", nil, true},
+		{"valid steps", []Step{
+			{Effect: "glitch", Params: video.MoshParams{Intensity: 1}},
+			{Effect: "rgbdrift", EffectParams: map[string]interface{}{"intensity": 2.0}, Seed: 9},
+		}, false},
+		{"unknown effect", []Step{{Effect: "melt"}}, true},
+		{"nested chain", []Step{{Effect: ChainEffectName}}, true},
+		{"invalid mosh params", []Step{{Effect: "datamosh", Params: video.MoshParams{DurationMode: "stretch"}}}, true},
+		{"unknown effect param", []Step{{Effect: "glitch", EffectParams: map[string]interface{}{"speed": 1.0}}}, true},
+		{"animating a fixed param", []Step{{Effect: "glitch", Params: video.MoshParams{Animate: map[string]video.Curve{
+			"corruption_seed": {Keyframes: []video.Keyframe{{Time: 0, Value: 1}}},
+		}}}}, true},
+		{"negative seed", []Step{{Effect: "glitch", Seed: -1}}, true},
+		{"seed past MaxSeed", []Step{{Effect: "glitch", Seed: MaxSeed + 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSteps(tt.steps)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChainRenderStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	started := 0
+	_, err := (&ChainEffect{}).Render(Job{
+		InputPath:  "missing.avi",
+		OutputPath: "out.avi",
+		Steps:      []Step{{Effect: "glitch"}},
+		Context:    ctx,
+		OnStep:     func(int, int, Step) { started++ },
+	})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if started != 0 {
+		t.Errorf("%d steps started after cancelling", started)
+	}
+}
@@ -2,83 +2,133 @@ package effects
 
 import (
 	"fmt"
-	"math/rand"
-	"os/exec"
-	"time"
+
+	"moshr/internal/video"
 )
 
 type ChromaticBlurEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewChromaticBlurEffect() *ChromaticBlurEffect {
+	return NewChromaticBlurEffectWithSeed(NewSeed())
+}
+
+func NewChromaticBlurEffectWithSeed(seed int64) *ChromaticBlurEffect {
 	return &ChromaticBlurEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (c *ChromaticBlurEffect) Apply(inputPath, outputPath string, intensity float64) error {
-	// Generate random blur parameters for each channel
-	redBlurX := 1 + int(intensity*15)   // 1-15 horizontal blur for red
-	redBlurY := 1 + int(intensity*8)    // 1-8 vertical blur for red
-	greenBlurX := 1 + int(intensity*12) // Different blur amounts for each channel
-	greenBlurY := 1 + int(intensity*10)
-	blueBlurX := 1 + int(intensity*20) // Blue gets the most blur
-	blueBlurY := 1 + int(intensity*6)
+func init() {
+	Register(&simpleEffect{
+		name:         "chromaticblur",
+		description:  "Blurs each color channel in its own direction",
+		maxIntensity: 3,
+		presets:      []float64{1, 2, 3},
+		animated:     true,
+		schema: []ParamSpec{
+			{Name: "red_blur_radius", Type: "int", Description: "Blur radius of the red channel", Min: 1, Max: 60, Default: 5},
+			{Name: "green_blur_radius", Type: "int", Description: "Blur radius of the green channel", Min: 1, Max: 60, Default: 3},
+			{Name: "blue_blur_radius", Type: "int", Description: "Blur radius of the blue channel", Min: 1, Max: 60, Default: 8},
+			{Name: "red_direction", Type: "enum", Description: "Blur direction of the red channel", Default: "horizontal", Options: blurDirections},
+			{Name: "green_direction", Type: "enum", Description: "Blur direction of the green channel", Default: "vertical", Options: blurDirections},
+			{Name: "blue_direction", Type: "enum", Description: "Blur direction of the blue channel", Default: "radial", Options: blurDirections},
+			{Name: "saturation", Type: "float", Description: "Saturation of the recombined image", Min: 0, Max: 3, Default: 1.3, Animatable: true},
+			{Name: "motion_blur", Type: "bool", Description: "Blend neighbouring frames for a trailing blur", Default: false},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewChromaticBlurEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(renderIntensity(job.Params))
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			params.Animate = job.Params.Animate
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
 
+// ResolveParams draws random blur directions for an intensity. Each channel
+// gets the blur radius that matches its direction.
+func (c *ChromaticBlurEffect) ResolveParams(intensity float64) ChromaticBlurParams {
 	// Random blur directions for variety
-	blurDirections := []string{"horizontal", "vertical", "diagonal", "radial"}
 	redDirection := blurDirections[c.rng.Intn(len(blurDirections))]
 	greenDirection := blurDirections[c.rng.Intn(len(blurDirections))]
 	blueDirection := blurDirections[c.rng.Intn(len(blurDirections))]
 
-	// Color saturation and contrast adjustments
-	redSaturation := 1.0 + intensity*0.5
-	greenSaturation := 1.0 + intensity*0.3
-	blueSaturation := 1.0 + intensity*0.7
+	return ChromaticBlurParams{
+		Intensity: intensity,
+		// Different blur amounts for each channel, blue gets the most
+		RedBlurRadius:   blurRadius(redDirection, 1+int(intensity*15), 1+int(intensity*8)),
+		GreenBlurRadius: blurRadius(greenDirection, 1+int(intensity*12), 1+int(intensity*10)),
+		BlueBlurRadius:  blurRadius(blueDirection, 1+int(intensity*20), 1+int(intensity*6)),
+		RedDirection:    redDirection,
+		GreenDirection:  greenDirection,
+		BlueDirection:   blueDirection,
+		Saturation:      1.0 + intensity*0.3,
+		MotionBlur:      intensity > 1.5,
+	}
+}
 
-	var redBlurFilter, greenBlurFilter, blueBlurFilter string
+var blurDirections = []string{"horizontal", "vertical", "diagonal", "radial"}
 
-	// Create direction-specific blur filters for each channel
-	switch redDirection {
-	case "horizontal":
-		redBlurFilter = fmt.Sprintf("boxblur=%d:1", redBlurX)
-	case "vertical":
-		redBlurFilter = fmt.Sprintf("boxblur=1:%d", redBlurY)
-	case "diagonal":
-		redBlurFilter = fmt.Sprintf("boxblur=%d:%d", redBlurX/2, redBlurY/2)
-	case "radial":
-		redBlurFilter = fmt.Sprintf("gblur=sigma=%d", redBlurX)
+func blurRadius(direction string, x, y int) int {
+	if direction == "vertical" {
+		return y
 	}
+	return x
+}
 
-	switch greenDirection {
-	case "horizontal":
-		greenBlurFilter = fmt.Sprintf("boxblur=%d:1", greenBlurX)
+// blurFilter builds the blur for one channel from its direction and radius.
+func blurFilter(direction string, radius int) string {
+	switch direction {
 	case "vertical":
-		greenBlurFilter = fmt.Sprintf("boxblur=1:%d", greenBlurY)
+		return fmt.Sprintf("boxblur=1:%d", radius)
 	case "diagonal":
-		greenBlurFilter = fmt.Sprintf("boxblur=%d:%d", greenBlurX/2, greenBlurY/2)
+		return fmt.Sprintf("boxblur=%d:%d", max(1, radius/2), max(1, radius/2))
 	case "radial":
-		greenBlurFilter = fmt.Sprintf("gblur=sigma=%d", greenBlurX)
+		return fmt.Sprintf("gblur=sigma=%d", radius)
+	default:
+		return fmt.Sprintf("boxblur=%d:1", radius)
 	}
+}
 
-	switch blueDirection {
-	case "horizontal":
-		blueBlurFilter = fmt.Sprintf("boxblur=%d:1", blueBlurX)
-	case "vertical":
-		blueBlurFilter = fmt.Sprintf("boxblur=1:%d", blueBlurY)
-	case "diagonal":
-		blueBlurFilter = fmt.Sprintf("boxblur=%d:%d", blueBlurX/2, blueBlurY/2)
-	case "radial":
-		blueBlurFilter = fmt.Sprintf("gblur=sigma=%d", blueBlurX)
-	}
+func (c *ChromaticBlurEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return c.ApplyWithParams(inputPath, outputPath, c.ResolveParams(intensity))
+}
+
+// ApplyWithParams blurs each color channel in its resolved direction and
+// screens the channels back together.
+func (c *ChromaticBlurEffect) ApplyWithParams(inputPath, outputPath string, params ChromaticBlurParams) error {
+	c.resolveParams(params)
+	intensity := params.Intensity
+
+	// Color saturation and contrast adjustments
+	redSaturation := 1.0 + intensity*0.5
+	greenSaturation := 1.0 + intensity*0.3
+	blueSaturation := 1.0 + intensity*0.7
+
+	// Create direction-specific blur filters for each channel
+	redBlurFilter := blurFilter(params.RedDirection, params.RedBlurRadius)
+	greenBlurFilter := blurFilter(params.GreenDirection, params.GreenBlurRadius)
+	blueBlurFilter := blurFilter(params.BlueDirection, params.BlueBlurRadius)
 
 	// Add motion blur effect for more dreamy look
 	motionBlur := ""
-	if intensity > 1.5 {
+	if params.MotionBlur {
 		motionBlur = fmt.Sprintf(",tblend=all_mode=average:all_opacity=%.2f", 0.3+intensity*0.1)
 	}
 
+	// An animated saturation is evaluated on every frame
+	saturation := fmt.Sprintf("%.2f", params.Saturation)
+	if _, ok := params.Animate["saturation"]; ok {
+		saturation = fmt.Sprintf("'%s':eval=frame", paramExpr(params.Animate, "saturation", params.Saturation, "t"))
+	}
+
 	// Create complex filter for chromatic blur effect
 	filterComplex := fmt.Sprintf(
 		"[0:v]split=3[r_base][g_base][b_base];"+
@@ -101,7 +151,7 @@ func (c *ChromaticBlurEffect) Apply(inputPath, outputPath string, intensity floa
 			"[r_layer][g_layer]overlay=0:0:format=auto,"+
 			"[b_layer]overlay=0:0:format=auto,"+
 			// Final adjustments
-			"eq=brightness=%.2f:contrast=%.2f:saturation=%.2f",
+			"eq=brightness=%.2f:contrast=%.2f:saturation=%s",
 		// Red channel parameters
 		redSaturation, 1.0+intensity*0.2,
 		redBlurFilter, motionBlur,
@@ -114,11 +164,12 @@ func (c *ChromaticBlurEffect) Apply(inputPath, outputPath string, intensity floa
 		// Final adjustments
 		-0.05*intensity,   // Slight darkening
 		1.0+intensity*0.1, // Contrast boost
-		1.0+intensity*0.3, // Overall saturation boost
+		saturation,        // Overall saturation boost
 	)
+	filterComplex = blendEnvelope(filterComplex, params.Animate)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(c.runContext(), inputPath, outputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30")
+	return c.progress.Run(cmd)
 }
 
 func (c *ChromaticBlurEffect) CreatePresets() []ChromaticBlurParams {
@@ -169,4 +220,6 @@ type ChromaticBlurParams struct {
 	BlueDirection   string  `json:"blue_direction"`
 	Saturation      float64 `json:"saturation"`
 	MotionBlur      bool    `json:"motion_blur"`
+	// Animate drives the saturation or the intensity with curves over time
+	Animate map[string]video.Curve `json:"animate,omitempty"`
 }
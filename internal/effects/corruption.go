@@ -3,58 +3,130 @@ package effects
 import (
 	"fmt"
 	"math/rand"
-	"os"
-	"os/exec"
-	"time"
+	"moshr/internal/avi"
 )
 
+// corruptionModes names the modes Apply picks from, in draw order.
+var corruptionModes = []string{"byte_corruption", "channel_shift", "pixel_sort", "scanline_displace"}
+
 type CorruptionEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewCorruptionEffect() *CorruptionEffect {
+	return NewCorruptionEffectWithSeed(NewSeed())
+}
+
+func NewCorruptionEffectWithSeed(seed int64) *CorruptionEffect {
 	return &CorruptionEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (c *CorruptionEffect) Apply(inputPath, outputPath string, intensity float64) error {
-	effect := c.rng.Intn(4)
-
-	switch effect {
-	case 0:
-		return c.applyByteCorruption(inputPath, outputPath, intensity)
-	case 1:
-		return c.applyChannelShift(inputPath, outputPath, intensity)
-	case 2:
-		return c.applyPixelSort(inputPath, outputPath, intensity)
-	case 3:
-		return c.applyScanlineDisplace(inputPath, outputPath, intensity)
-	default:
-		return c.applyByteCorruption(inputPath, outputPath, intensity)
+// CorruptionParams pick a corruption mode and, for byte corruption, the seed
+// of the bytes it overwrites.
+type CorruptionParams struct {
+	Intensity      float64 `json:"intensity"`
+	Mode           string  `json:"mode"`
+	CorruptionSeed int64   `json:"corruption_seed,omitempty"`
+}
+
+func init() {
+	seedSpec := ParamSpec{Name: "corruption_seed", Type: "int", Description: "Seed of the overwritten bytes", Min: 1, Max: MaxSeed}
+
+	modes := []struct {
+		name        string
+		description string
+		// mode is empty for the effect that picks one at random
+		mode   string
+		schema []ParamSpec
+	}{
+		{"corruption", "Applies one of the corruption modes picked at random", "", []ParamSpec{
+			{Name: "mode", Type: "enum", Description: "Corruption mode to apply", Default: corruptionModes[0], Options: corruptionModes},
+			seedSpec,
+		}},
+		{"byte_corruption", "Overwrites random bytes of the stream data", "byte_corruption", []ParamSpec{seedSpec}},
+		{"channel_shift", "Shifts the color channels away from each other", "channel_shift", nil},
+		{"pixel_sort", "Pixelates the picture into noisy, oversaturated blocks", "pixel_sort", nil},
+		{"scanline_displace", "Stretches the top and bottom halves of the picture against each other", "scanline_displace", nil},
+	}
+
+	for _, mode := range modes {
+		name := mode.mode
+		Register(&simpleEffect{
+			name:         mode.name,
+			description:  mode.description,
+			maxIntensity: 3,
+			presets:      []float64{0.4, 0.7, 1.0},
+			schema:       mode.schema,
+			render: func(job Job) (map[string]interface{}, error) {
+				effect := NewCorruptionEffectWithSeed(job.Seed)
+				effect.bind(job)
+				params := effect.ResolveParams(name, job.Params.Intensity)
+				if err := decodeParams(job.EffectParams, &params); err != nil {
+					return nil, err
+				}
+				if name != "" {
+					params.Mode = name
+				}
+				err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+				return effect.ResolvedParams(), err
+			},
+		})
 	}
 }
 
-func (c *CorruptionEffect) applyByteCorruption(inputPath, outputPath string, intensity float64) error {
-	data, err := os.ReadFile(inputPath)
-	if err != nil {
-		return fmt.Errorf("failed to read input file: %v", err)
+// ResolveParams draws the random values of a corruption mode. An empty mode
+// picks one of corruptionModes at random.
+func (c *CorruptionEffect) ResolveParams(mode string, intensity float64) CorruptionParams {
+	if mode == "" {
+		mode = corruptionModes[c.rng.Intn(len(corruptionModes))]
 	}
 
-	corruptedData := make([]byte, len(data))
-	copy(corruptedData, data)
+	params := CorruptionParams{Intensity: intensity, Mode: mode}
+	if mode == "byte_corruption" {
+		params.CorruptionSeed = c.rng.Int63n(MaxSeed) + 1
+	}
+	return params
+}
 
-	// Skip first 12 bytes (RIFF header) and corrupt random bytes
-	startPos := 12
-	corruptionRate := intensity * 0.0001 // 0.01% corruption at max intensity
+func (c *CorruptionEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return c.ApplyWithParams(inputPath, outputPath, c.ResolveParams("", intensity))
+}
 
-	for i := startPos; i < len(corruptedData); i++ {
-		if c.rng.Float64() < corruptionRate {
-			corruptedData[i] = byte(c.rng.Intn(256))
-		}
+// ApplyWithParams runs the corruption mode the params name. Byte
+// corruption replays the params' seed, so only it draws random values.
+func (c *CorruptionEffect) ApplyWithParams(inputPath, outputPath string, params CorruptionParams) error {
+	c.resolveParams(params)
+
+	switch params.Mode {
+	case "channel_shift":
+		return c.applyChannelShift(inputPath, outputPath, params.Intensity)
+	case "pixel_sort":
+		return c.applyPixelSort(inputPath, outputPath, params.Intensity)
+	case "scanline_displace":
+		return c.applyScanlineDisplace(inputPath, outputPath, params.Intensity)
+	default:
+		return c.applyByteCorruption(inputPath, outputPath, params.Intensity, params.CorruptionSeed)
 	}
+}
+
+func (c *CorruptionEffect) applyByteCorruption(inputPath, outputPath string, intensity float64, seed int64) error {
+	// Corrupt random bytes of the stream payloads, leaving headers intact
+	corruptionRate := intensity * 0.0001 // 0.01% corruption at max intensity
 
-	return os.WriteFile(outputPath, corruptedData, 0644)
+	// Each pass over the file replays the same generator
+	return c.rewriteAVI(inputPath, outputPath, func(header *avi.File) (avi.Transform, error) {
+		rng := rand.New(rand.NewSource(seed))
+		corrupter := newByteCorrupter(rng, corruptionRate)
+		return func(chunk *avi.Chunk) ([]*avi.Chunk, error) {
+			corrupter.each(chunk.Data, func(i int) {
+				chunk.Data[i] = byte(rng.Intn(256))
+			})
+			return []*avi.Chunk{chunk}, nil
+		}, nil
+	})
 }
 
 func (c *CorruptionEffect) applyChannelShift(inputPath, outputPath string, intensity float64) error {
@@ -69,8 +141,8 @@ func (c *CorruptionEffect) applyChannelShift(inputPath, outputPath string, inten
 		"[rg][b_shifted]blend=all_mode=addition",
 		shiftAmount, shiftAmount, shiftAmount/3)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(c.runContext(), inputPath, outputPath, "-filter_complex", filterComplex)
+	return c.progress.Run(cmd)
 }
 
 func (c *CorruptionEffect) applyPixelSort(inputPath, outputPath string, intensity float64) error {
@@ -84,8 +156,8 @@ func (c *CorruptionEffect) applyPixelSort(inputPath, outputPath string, intensit
 		"hue=s=%.1f",
 		noise, blockSize, blockSize, blockSize, blockSize, 1.0+intensity)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-vf", filterComplex, "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(c.runContext(), inputPath, outputPath, "-vf", filterComplex)
+	return c.progress.Run(cmd)
 }
 
 func (c *CorruptionEffect) applyScanlineDisplace(inputPath, outputPath string, intensity float64) error {
@@ -98,13 +170,13 @@ func (c *CorruptionEffect) applyScanlineDisplace(inputPath, outputPath string, i
 		"[top][bottom]vstack",
 		strength, strength, strength, strength)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(c.runContext(), inputPath, outputPath, "-filter_complex", filterComplex)
+	return c.progress.Run(cmd)
 }
 
 // Specific effect methods for direct access
 func (c *CorruptionEffect) ApplyByteCorruption(inputPath, outputPath string, intensity float64) error {
-	return c.applyByteCorruption(inputPath, outputPath, intensity)
+	return c.ApplyWithParams(inputPath, outputPath, c.ResolveParams("byte_corruption", intensity))
 }
 
 func (c *CorruptionEffect) ApplyChannelShift(inputPath, outputPath string, intensity float64) error {
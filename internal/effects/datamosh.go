@@ -14,12 +14,86 @@ func NewDatamoshEffect() *DatamoshEffect {
 	}
 }
 
+func init() {
+	Register(NewDatamoshEffect())
+}
+
+func (d *DatamoshEffect) Name() string { return "datamosh" }
+
+func (d *DatamoshEffect) Description() string {
+	return "Drops keyframes and repeats P-frames so motion smears across the picture"
+}
+
+func (d *DatamoshEffect) Schema() []ParamSpec {
+	schema := intensitySchema(3, 1)
+	schema[0].Animatable = true
+	schema = append(schema, moshSchema(1)...)
+	schema = append(schema, durationSchema()...)
+	return append(schema, audioSchema()...)
+}
+
+func (d *DatamoshEffect) NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams {
+	return d.GenerateParams(intensity, windows...)
+}
+
+func (d *DatamoshEffect) Presets(windows []video.MoshWindow) []video.MoshParams {
+	presets := d.CreatePresets()
+	for i := range presets {
+		presets[i].Windows = windows
+	}
+	return presets
+}
+
+// Render moshes with the job's resolved parameters. Datamoshing draws no
+// random values, so nothing is returned besides the error; audio
+// corruption follows the job's seed unless it has a seed of its own.
+func (d *DatamoshEffect) Render(job Job) (map[string]interface{}, error) {
+	params := job.Params
+	if err := decodeParams(job.EffectParams, &params); err != nil {
+		return nil, err
+	}
+	if params.AudioBend.Seed == 0 {
+		params.AudioBend.Seed = job.Seed
+	}
+	effect := &DatamoshEffect{mosher: d.mosher.WithContext(job.Context).WithProgress(job.Progress)}
+	return nil, effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+}
+
+// IgnoresSeed is true unless the audio is corrupted without a seed of its
+// own, the only random values a datamosh draws.
+func (d *DatamoshEffect) IgnoresSeed(job Job) bool {
+	params := job.Params
+	if err := decodeParams(job.EffectParams, &params); err != nil {
+		return false
+	}
+	return params.AudioBend.Corruption == 0 || params.AudioBend.Seed != 0
+}
+
 func (d *DatamoshEffect) Apply(inputPath, outputPath string, intensity float64) error {
 	params := d.GenerateParams(intensity)
 	return d.mosher.MoshVideo(inputPath, outputPath, params)
 }
 
-func (d *DatamoshEffect) GenerateParams(intensity float64) video.MoshParams {
+// ApplyWithParams moshes with fully resolved parameters, including any
+// frame windows. An intensity curve becomes a duplication count curve, in
+// proportion to the count the constant intensity would give.
+func (d *DatamoshEffect) ApplyWithParams(inputPath, outputPath string, params video.MoshParams) error {
+	intensity, animated := params.Animate["intensity"]
+	if _, ok := params.Animate["duplication_count"]; animated && !ok && params.Intensity > 0 {
+		animate := make(map[string]video.Curve, len(params.Animate)+1)
+		for name, curve := range params.Animate {
+			animate[name] = curve
+		}
+		animate["duplication_count"] = intensity.Scale(float64(params.DuplicationCount) / params.Intensity)
+		params.Animate = animate
+	}
+	return d.mosher.MoshVideo(inputPath, outputPath, params)
+}
+
+// GenerateParams derives mosh parameters from an intensity. Optional windows
+// limit the mosh to parts of the clip; windows without their own
+// duplication count use the one derived from the intensity.
+func (d *DatamoshEffect) GenerateParams(intensity float64, windows ...video.MoshWindow) video.MoshParams {
 	params := video.MoshParams{
 		Intensity: intensity,
 	}
@@ -36,6 +110,13 @@ func (d *DatamoshEffect) GenerateParams(intensity float64) video.MoshParams {
 		params.DuplicationCount = int(intensity*60) + 20 // 20-80 duplications
 	}
 
+	if len(windows) > 0 {
+		params.Windows = windows
+		if params.DuplicationCount == 0 {
+			params.DuplicationCount = int(intensity*60) + 20
+		}
+	}
+
 	return params
 }
 
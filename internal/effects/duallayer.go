@@ -2,22 +2,52 @@ package effects
 
 import (
 	"fmt"
-	"math/rand"
-	"os/exec"
-	"time"
 )
 
 type DualLayerEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewDualLayerEffect() *DualLayerEffect {
+	return NewDualLayerEffectWithSeed(NewSeed())
+}
+
+func NewDualLayerEffectWithSeed(seed int64) *DualLayerEffect {
 	return &DualLayerEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (d *DualLayerEffect) Apply(inputPath, outputPath string, intensity float64) error {
+func init() {
+	Register(&simpleEffect{
+		name:         "duallayer",
+		description:  "Overlays offset green and purple copies of the picture",
+		maxIntensity: 3,
+		presets:      []float64{1, 2, 3},
+		schema: []ParamSpec{
+			{Name: "green_offset_x", Type: "int", Description: "Horizontal offset of the green layer in pixels", Min: -240, Max: 240, Default: -30},
+			{Name: "green_offset_y", Type: "int", Description: "Vertical offset of the green layer in pixels", Min: -240, Max: 240, Default: 20},
+			{Name: "purple_offset_x", Type: "int", Description: "Horizontal offset of the purple layer in pixels", Min: -240, Max: 240, Default: 40},
+			{Name: "purple_offset_y", Type: "int", Description: "Vertical offset of the purple layer in pixels", Min: -240, Max: 240, Default: -15},
+			{Name: "green_shift", Type: "float", Description: "Green balance boost of the green layer", Min: 0, Max: 5, Default: 2.0},
+			{Name: "purple_shift", Type: "float", Description: "Red and blue balance boost of the purple layer", Min: 0, Max: 5, Default: 2.0},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewDualLayerEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(job.Params.Intensity)
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
+
+// ResolveParams draws random layer offsets for an intensity.
+func (d *DualLayerEffect) ResolveParams(intensity float64) DualLayerParams {
 	// Generate much larger random offsets for both layers - now supports intensity up to 3.0
 	maxOffset := int(intensity * 80) // Up to 240 pixels at max intensity
 
@@ -36,60 +66,20 @@ func (d *DualLayerEffect) Apply(inputPath, outputPath string, intensity float64)
 		purpleOffsetY = d.rng.Intn(10) - 5 // -5 to +5
 	}
 
-	// Much more dramatic color shifts - can now go beyond 1.0 for extreme effects
-	greenShift := 0.5 + (intensity * 1.5)  // 0.5 to 5.0 green boost
-	purpleShift := 0.5 + (intensity * 1.5) // 0.5 to 5.0 purple boost
-
-	// Create dramatic chromatic aberration effect with much stronger color separation
-	// Calculate canvas size to accommodate all offsets
-	canvasW := abs(greenOffsetX) + abs(purpleOffsetX) + 100 // Extra padding
-	canvasH := abs(greenOffsetY) + abs(purpleOffsetY) + 100 // Extra padding
-
-	// Calculate positions for each layer on the canvas
-	origX := max(abs(greenOffsetX), abs(purpleOffsetX)) + 50
-	origY := max(abs(greenOffsetY), abs(purpleOffsetY)) + 50
-	greenX := origX + greenOffsetX
-	greenY := origY + greenOffsetY
-	purpleX := origX + purpleOffsetX
-	purpleY := origY + purpleOffsetY
-
-	filterComplex := fmt.Sprintf(
-		"[0:v]split=3[orig][green_base][purple_base];"+
-			// Green layer: subtle green tint with transparency
-			"[green_base]"+
-			"hue=h=120:s=%.1f,"+ // Green hue shift with saturation boost
-			"colorbalance=gs=%.2f,"+ // Moderate green balance
-			"format=rgba,colorkey=0x000000:0.3:0.1,"+ // Add transparency
-			"pad=iw+%d:ih+%d:%d:%d[green_layer];"+
-			// Purple layer: magenta/purple tint with transparency
-			"[purple_base]"+
-			"hue=h=300:s=%.1f,"+ // Purple/magenta hue shift
-			"colorbalance=rs=%.2f:bs=%.2f,"+ // Red and blue balance for purple
-			"format=rgba,colorkey=0x000000:0.3:0.1,"+ // Add transparency
-			"pad=iw+%d:ih+%d:%d:%d[purple_layer];"+
-			// Original layer stays mostly normal
-			"[orig]"+
-			"pad=iw+%d:ih+%d:%d:%d[orig_layer];"+
-			// Composite with blend modes for better color mixing
-			"[orig_layer][green_layer]overlay=%d:%d:format=auto,"+
-			"[purple_layer]overlay=%d:%d:format=auto",
-		// Green layer parameters
-		1.0+intensity*0.5, // Moderate saturation boost
-		greenShift*0.3,    // Gentle green balance
-		canvasW, canvasH, greenX, greenY,
-		// Purple layer parameters
-		1.0+intensity*0.5,                // Moderate saturation boost
-		purpleShift*0.3, purpleShift*0.4, // Red and blue for purple
-		canvasW, canvasH, purpleX, purpleY,
-		// Original layer
-		canvasW, canvasH, origX, origY,
-		// Overlay positions
-		greenX, greenY,
-		purpleX, purpleY,
-	)
+	return DualLayerParams{
+		Intensity:     intensity,
+		GreenOffsetX:  greenOffsetX,
+		GreenOffsetY:  greenOffsetY,
+		PurpleOffsetX: purpleOffsetX,
+		PurpleOffsetY: purpleOffsetY,
+		// Much more dramatic color shifts - can now go beyond 1.0 for extreme effects
+		GreenShift:  0.5 + (intensity * 1.5), // 0.5 to 5.0 green boost
+		PurpleShift: 0.5 + (intensity * 1.5), // 0.5 to 5.0 purple boost
+	}
+}
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+func (d *DualLayerEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return d.ApplyWithParams(inputPath, outputPath, d.ResolveParams(intensity))
 }
 
 func (d *DualLayerEffect) CreatePresets() []DualLayerParams {
@@ -126,29 +116,58 @@ func (d *DualLayerEffect) CreatePresets() []DualLayerParams {
 
 func (d *DualLayerEffect) ApplyWithParams(inputPath, outputPath string, params DualLayerParams) error {
 	// Apply effect with specific parameters instead of random generation
+	d.resolveParams(params)
+
+	// Create dramatic chromatic aberration effect with much stronger color separation
+	// Calculate canvas size to accommodate all offsets
+	canvasW := abs(params.GreenOffsetX) + abs(params.PurpleOffsetX) + 100 // Extra padding
+	canvasH := abs(params.GreenOffsetY) + abs(params.PurpleOffsetY) + 100 // Extra padding
+
+	// Calculate positions for each layer on the canvas
+	origX := max(abs(params.GreenOffsetX), abs(params.PurpleOffsetX)) + 50
+	origY := max(abs(params.GreenOffsetY), abs(params.PurpleOffsetY)) + 50
+	greenX := origX + params.GreenOffsetX
+	greenY := origY + params.GreenOffsetY
+	purpleX := origX + params.PurpleOffsetX
+	purpleY := origY + params.PurpleOffsetY
+
 	filterComplex := fmt.Sprintf(
 		"[0:v]split=3[orig][green_base][purple_base];"+
+			// Green layer: subtle green tint with transparency
 			"[green_base]"+
-			"colorbalance=gs=%.2f:bs=-0.2,"+
+			"hue=h=120:s=%.1f,"+ // Green hue shift with saturation boost
+			"colorbalance=gs=%.2f,"+ // Moderate green balance
+			"format=rgba,colorkey=0x000000:0.3:0.1,"+ // Add transparency
 			"pad=iw+%d:ih+%d:%d:%d[green_layer];"+
+			// Purple layer: magenta/purple tint with transparency
 			"[purple_base]"+
-			"colorbalance=rs=%.2f:bs=%.2f,"+
+			"hue=h=300:s=%.1f,"+ // Purple/magenta hue shift
+			"colorbalance=rs=%.2f:bs=%.2f,"+ // Red and blue balance for purple
+			"format=rgba,colorkey=0x000000:0.3:0.1,"+ // Add transparency
 			"pad=iw+%d:ih+%d:%d:%d[purple_layer];"+
-			"[orig]pad=iw+%d:ih+%d:%d:%d[orig_layer];"+
-			"[orig_layer][green_layer]overlay=0:0:format=auto,"+
-			"[purple_layer]overlay=0:0:format=auto",
-		params.GreenShift,
-		abs(params.GreenOffsetX)+abs(params.PurpleOffsetX), abs(params.GreenOffsetY)+abs(params.PurpleOffsetY),
-		max(0, params.GreenOffsetX), max(0, params.GreenOffsetY),
-		params.PurpleShift, params.PurpleShift,
-		abs(params.GreenOffsetX)+abs(params.PurpleOffsetX), abs(params.GreenOffsetY)+abs(params.PurpleOffsetY),
-		max(0, params.PurpleOffsetX), max(0, params.PurpleOffsetY),
-		abs(params.GreenOffsetX)+abs(params.PurpleOffsetX), abs(params.GreenOffsetY)+abs(params.PurpleOffsetY),
-		max(0, -params.GreenOffsetX-params.PurpleOffsetX), max(0, -params.GreenOffsetY-params.PurpleOffsetY),
+			// Original layer stays mostly normal
+			"[orig]"+
+			"pad=iw+%d:ih+%d:%d:%d[orig_layer];"+
+			// Composite with blend modes for better color mixing
+			"[orig_layer][green_layer]overlay=%d:%d:format=auto,"+
+			"[purple_layer]overlay=%d:%d:format=auto",
+		// Green layer parameters
+		1.0+params.Intensity*0.5, // Moderate saturation boost
+		params.GreenShift*0.3,    // Gentle green balance
+		canvasW, canvasH, greenX, greenY,
+		// Purple layer parameters
+		1.0+params.Intensity*0.5,                       // Moderate saturation boost
+		params.PurpleShift*0.3, params.PurpleShift*0.4, // Red and blue for purple
+		canvasW, canvasH, purpleX, purpleY,
+		// Original layer
+		canvasW, canvasH, origX, origY,
+		// Overlay positions
+		greenX, greenY,
+		purpleX, purpleY,
 	)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(d.runContext(), inputPath, outputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30")
+	return d.progress.Run(cmd)
 }
 
 type DualLayerParams struct {
@@ -2,23 +2,54 @@ package effects
 
 import (
 	"fmt"
-	"math/rand"
-	"os/exec"
 	"strings"
-	"time"
+
+	"moshr/internal/video"
 )
 
 type EchoTrailEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewEchoTrailEffect() *EchoTrailEffect {
+	return NewEchoTrailEffectWithSeed(NewSeed())
+}
+
+func NewEchoTrailEffectWithSeed(seed int64) *EchoTrailEffect {
 	return &EchoTrailEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (e *EchoTrailEffect) Apply(inputPath, outputPath string, intensity float64) error {
+func init() {
+	Register(&simpleEffect{
+		name:         "echotrail",
+		description:  "Layers delayed, hue shifted copies of the picture into motion trails",
+		maxIntensity: 3,
+		presets:      []float64{1, 2, 3},
+		animated:     true,
+		schema: []ParamSpec{
+			{Name: "num_trails", Type: "int", Description: "Number of echo layers", Min: 1, Max: 8, Default: 4},
+			{Name: "base_alpha", Type: "float", Description: "Opacity of the nearest trail; further trails fade out", Min: 0, Max: 1, Default: 0.6, Animatable: true},
+			{Name: "color_shift", Type: "bool", Description: "Tint every trail with its own hue", Default: true},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewEchoTrailEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(renderIntensity(job.Params))
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			params.Animate = job.Params.Animate
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
+
+// ResolveParams draws random trail colors and offsets for an intensity.
+func (e *EchoTrailEffect) ResolveParams(intensity float64) EchoTrailParams {
 	// Calculate number of trails based on intensity
 	numTrails := int(3 + intensity*5) // 3-8 trails at max intensity
 	if numTrails > 8 {
@@ -31,6 +62,52 @@ func (e *EchoTrailEffect) Apply(inputPath, outputPath string, intensity float64)
 		maxDelay = 2
 	}
 
+	params := EchoTrailParams{
+		Intensity:  intensity,
+		NumTrails:  numTrails,
+		MaxDelay:   maxDelay,
+		BaseAlpha:  0.3 + intensity*0.4, // Scale based on intensity
+		ColorShift: true,
+	}
+	params.Trails = e.resolveTrails(intensity, numTrails, nil)
+	return params
+}
+
+// resolveTrails draws colors and offsets for any trails not given yet.
+func (e *EchoTrailEffect) resolveTrails(intensity float64, numTrails int, trails []EchoTrail) []EchoTrail {
+	spread := int(intensity * 20)
+	if spread < 1 {
+		spread = 1
+	}
+
+	for len(trails) < numTrails {
+		trails = append(trails, EchoTrail{
+			// Random color shift for each trail
+			HueShift: e.rng.Intn(360),
+			// Random offset for each trail
+			OffsetX: e.rng.Intn(spread) - int(intensity*10), // -10 to +10
+			OffsetY: e.rng.Intn(spread) - int(intensity*10), // -10 to +10
+		})
+	}
+	return trails[:numTrails]
+}
+
+func (e *EchoTrailEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return e.ApplyWithParams(inputPath, outputPath, e.ResolveParams(intensity))
+}
+
+// ApplyWithParams overlays the resolved trails, filling in any the params
+// leave out, each more transparent than the one before.
+func (e *EchoTrailEffect) ApplyWithParams(inputPath, outputPath string, params EchoTrailParams) error {
+	numTrails := params.NumTrails
+	if numTrails < 1 {
+		numTrails = 1
+	}
+	params.Trails = e.resolveTrails(params.Intensity, numTrails, params.Trails)
+	e.resolveParams(params)
+
+	intensity := params.Intensity
+
 	var filterParts []string
 	var overlayChain string
 
@@ -45,35 +122,31 @@ func (e *EchoTrailEffect) Apply(inputPath, outputPath string, intensity float64)
 	filterParts[0] += strings.Join(streamLabels, "")
 
 	// Create trail effects for each stream
-	for i := 0; i < numTrails; i++ {
-		trailDelay := (i + 1) * (maxDelay / numTrails) // Distribute delays evenly
-		if trailDelay == 0 {
-			trailDelay = i + 1
-		}
-
+	for i, trail := range params.Trails {
 		// Calculate transparency (further trails are more transparent)
 		alpha := 1.0 - (float64(i+1) / float64(numTrails+1))
-		alpha = alpha * (0.3 + intensity*0.4) // Scale based on intensity
+		alphaExpr := fmt.Sprintf("%.2f", alpha*params.BaseAlpha)
+		if _, ok := params.Animate["base_alpha"]; ok {
+			alphaExpr = fmt.Sprintf("%.3f*%s", alpha, paramExpr(params.Animate, "base_alpha", params.BaseAlpha, "T"))
+		}
 
-		// Random color shift for each trail
-		hueShift := e.rng.Intn(360)       // Random hue
+		hueShift := trail.HueShift
+		if !params.ColorShift {
+			hueShift = 0
+		}
 		saturation := 0.8 + intensity*0.5 // Boost saturation
 
-		// Random offset for each trail
-		offsetX := e.rng.Intn(int(intensity*20)) - int(intensity*10) // -10 to +10
-		offsetY := e.rng.Intn(int(intensity*20)) - int(intensity*10) // -10 to +10
-
 		trailFilter := fmt.Sprintf(
 			"[trail%d_base]"+
 				"tblend=all_mode=average:all_opacity=%.2f,"+ // Time blend for motion blur
 				"hue=h=%d:s=%.2f,"+ // Color shift
 				"format=rgba,"+
-				"geq=a='%.2f*alpha(X,Y)':r=r:g=g:b=b,"+ // Apply transparency
+				"geq=a='%s*alpha(X,Y)':r=r:g=g:b=b,"+ // Apply transparency
 				"pad=iw+%d:ih+%d:%d:%d[trail%d]",
 			i, 0.7, // Blend opacity for motion blur
 			hueShift, saturation,
-			alpha,
-			abs(offsetX)+10, abs(offsetY)+10, max(0, offsetX)+5, max(0, offsetY)+5,
+			alphaExpr,
+			abs(trail.OffsetX)+10, abs(trail.OffsetY)+10, max(0, trail.OffsetX)+5, max(0, trail.OffsetY)+5,
 			i,
 		)
 		filterParts = append(filterParts, trailFilter)
@@ -102,9 +175,10 @@ func (e *EchoTrailEffect) Apply(inputPath, outputPath string, intensity float64)
 
 	// Combine all filter parts
 	filterComplex := strings.Join(filterParts, ";") + ";" + overlayChain
+	filterComplex = blendEnvelope(filterComplex, params.Animate)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(e.runContext(), inputPath, outputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30")
+	return e.progress.Run(cmd)
 }
 
 func (e *EchoTrailEffect) CreatePresets() []EchoTrailParams {
@@ -137,10 +211,20 @@ func (e *EchoTrailEffect) CreatePresets() []EchoTrailParams {
 }
 
 type EchoTrailParams struct {
-	Intensity  float64 `json:"intensity"`
-	NumTrails  int     `json:"num_trails"`
-	MaxDelay   int     `json:"max_delay"`
-	BaseAlpha  float64 `json:"base_alpha"`
-	BlurAmount float64 `json:"blur_amount"`
-	ColorShift bool    `json:"color_shift"`
+	Intensity  float64     `json:"intensity"`
+	NumTrails  int         `json:"num_trails"`
+	MaxDelay   int         `json:"max_delay"`
+	BaseAlpha  float64     `json:"base_alpha"`
+	BlurAmount float64     `json:"blur_amount"`
+	ColorShift bool        `json:"color_shift"`
+	Trails     []EchoTrail `json:"trails,omitempty"`
+	// Animate drives the base alpha or the intensity with curves over time
+	Animate map[string]video.Curve `json:"animate,omitempty"`
+}
+
+// EchoTrail is the color and offset of a single trail.
+type EchoTrail struct {
+	HueShift int `json:"hue_shift"`
+	OffsetX  int `json:"offset_x"`
+	OffsetY  int `json:"offset_y"`
 }
@@ -0,0 +1,304 @@
+package effects
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"moshr/internal/video"
+)
+
+// ParamSpec describes one parameter an effect accepts.
+type ParamSpec struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Min         float64     `json:"min"`
+	Max         float64     `json:"max"`
+	Default     interface{} `json:"default"`
+	// Options lists the accepted values of "enum" parameters
+	Options []string `json:"options,omitempty"`
+	// Animatable parameters can follow a curve over time
+	Animatable bool `json:"animatable,omitempty"`
+}
+
+// Job is a single render handed to an effect.
+type Job struct {
+	InputPath  string
+	OutputPath string
+	Params     video.MoshParams
+	Seed       int64
+	// EffectParams overrides individual effect parameters by schema name;
+	// anything left out is derived from the intensity and the seed.
+	EffectParams map[string]interface{}
+
+	// Only used by effects that combine two clips
+	SecondInputPath string
+	Transfer        *video.TransferParams
+
+	// Only used by the chain effect: the steps to run and a callback made
+	// before each one starts
+	Steps  []Step
+	OnStep func(index, total int, step Step)
+
+	// Context cancels the render; nil renders without a deadline
+	Context context.Context
+	// Progress receives the render's progress; nil reports nothing
+	Progress *video.Reporter
+}
+
+// Effect is a mosh effect that the processor and the API look up by name.
+// Effects register themselves from an init function in their own file.
+type Effect interface {
+	Name() string
+	Description() string
+	Schema() []ParamSpec
+	// NewParams builds the parameters for a single render.
+	NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams
+	// Presets returns the parameter sets rendered for a batch.
+	Presets(windows []video.MoshWindow) []video.MoshParams
+	// Render applies the effect to the job's input and returns the random
+	// values it resolved.
+	Render(job Job) (map[string]interface{}, error)
+}
+
+// SeedIgnorer is implemented by effects that can tell when a job's seed
+// has no say in what they render, which lets identical jobs with
+// different seeds share a cached render.
+type SeedIgnorer interface {
+	IgnoresSeed(job Job) bool
+}
+
+// UsesSeed reports whether a job's seed can change what e renders. Effects
+// that do not implement SeedIgnorer are taken to draw random values.
+func UsesSeed(e Effect, job Job) bool {
+	if ignorer, ok := e.(SeedIgnorer); ok {
+		return !ignorer.IgnoresSeed(job)
+	}
+	return true
+}
+
+var registry = make(map[string]Effect)
+
+// Register adds an effect to the registry. Registering a name twice is a
+// programming error and panics.
+func Register(e Effect) {
+	if _, exists := registry[e.Name()]; exists {
+		panic(fmt.Sprintf("effect %q registered twice", e.Name()))
+	}
+	registry[e.Name()] = e
+}
+
+// Lookup returns the effect registered under name.
+func Lookup(name string) (Effect, error) {
+	e, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown effect %q", name)
+	}
+	return e, nil
+}
+
+// All returns every registered effect sorted by name.
+func All() []Effect {
+	all := make([]Effect, 0, len(registry))
+	for _, e := range registry {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+	return all
+}
+
+// ValidateParams checks a parameter object against an effect's schema.
+func ValidateParams(e Effect, params map[string]interface{}) error {
+	specs := make(map[string]ParamSpec)
+	for _, spec := range e.Schema() {
+		specs[spec.Name] = spec
+	}
+
+	for name, value := range params {
+		spec, ok := specs[name]
+		if !ok {
+			return fmt.Errorf("%s has no parameter %q", e.Name(), name)
+		}
+		if err := spec.check(value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ResolveIntensity checks a top-level intensity against an effect's
+// intensity parameter, using the parameter's default when it is zero.
+// Effects without an intensity parameter get the value back unchanged.
+func ResolveIntensity(e Effect, intensity float64) (float64, error) {
+	for _, spec := range e.Schema() {
+		if spec.Name != "intensity" {
+			continue
+		}
+		if intensity == 0 {
+			if v, ok := spec.Default.(float64); ok {
+				return v, nil
+			}
+		}
+		if err := spec.check(intensity); err != nil {
+			return 0, fmt.Errorf("intensity: %v", err)
+		}
+	}
+	return intensity, nil
+}
+
+// ValidateAnimation checks that every animated parameter exists in an
+// effect's schema and can be animated, and that its curve is well formed.
+func ValidateAnimation(e Effect, animate map[string]video.Curve) error {
+	specs := make(map[string]ParamSpec)
+	for _, spec := range e.Schema() {
+		specs[spec.Name] = spec
+	}
+
+	for name, curve := range animate {
+		spec, ok := specs[name]
+		if !ok {
+			return fmt.Errorf("%s has no parameter %q", e.Name(), name)
+		}
+		if !spec.Animatable {
+			return fmt.Errorf("%s cannot animate %q", e.Name(), name)
+		}
+		if err := curve.Validate(); err != nil {
+			return fmt.Errorf("animate %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (p ParamSpec) check(value interface{}) error {
+	switch p.Type {
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected a boolean")
+		}
+		return nil
+	case "enum":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string")
+		}
+		for _, option := range p.Options {
+			if s == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of %v", p.Options)
+	case "int", "float":
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("expected a number")
+		}
+		if p.Type == "int" && n != math.Trunc(n) {
+			return fmt.Errorf("expected a whole number")
+		}
+		if n < p.Min || n > p.Max {
+			return fmt.Errorf("must be between %g and %g", p.Min, p.Max)
+		}
+		return nil
+	}
+	return nil
+}
+
+// decodeParams overlays a parameter object onto an effect's typed params.
+func decodeParams(params map[string]interface{}, target interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("invalid effect params: %v", err)
+	}
+	return nil
+}
+
+func intensitySchema(maxIntensity, defaultIntensity float64) []ParamSpec {
+	return []ParamSpec{
+		{
+			Name:        "intensity",
+			Type:        "float",
+			Description: "Overall strength of the effect",
+			Min:         0.1,
+			Max:         maxIntensity,
+			Default:     defaultIntensity,
+		},
+	}
+}
+
+// moshSchema describes the frame moshing flags of video.MoshParams.
+func moshSchema(defaultDuplication int) []ParamSpec {
+	return []ParamSpec{
+		{Name: "iframe_removal", Type: "bool", Description: "Drop keyframes so motion smears across cuts", Default: false},
+		{Name: "pframe_duplication", Type: "bool", Description: "Repeat P-frames to bloom their motion", Default: false},
+		{Name: "duplication_count", Type: "int", Description: "How many times each P-frame is repeated", Min: 0, Max: 60, Default: defaultDuplication, Animatable: true},
+	}
+}
+
+// durationSchema describes the duration and audio modes of
+// video.MoshParams.
+func durationSchema() []ParamSpec {
+	return []ParamSpec{
+		{Name: "duration_mode", Type: "enum", Description: "Keep the clip's length by dropping frames after blooms or by limiting blooms to the frames removed keyframes free", Default: "", Options: []string{"", video.DurationDrop, video.DurationBudget}},
+		{Name: "audio", Type: "enum", Description: "Keep the original audio, time-stretch it to the moshed length, mute it or glitch it with the video", Default: video.AudioOriginal, Options: []string{video.AudioOriginal, video.AudioStretch, video.AudioMute, video.AudioGlitch}},
+	}
+}
+
+// audioSchema describes the audio databending of video.AudioBend.
+func audioSchema() []ParamSpec {
+	return []ParamSpec{
+		{Name: "audio_bitcrush", Type: "int", Description: "Bits kept of every PCM audio sample, 0 to leave them", Min: 0, Max: 15, Default: 0},
+		{Name: "audio_corruption", Type: "float", Description: "Share of audio bytes replaced with random ones", Min: 0, Max: 1, Default: 0.0},
+		{Name: "audio_reverse", Type: "bool", Description: "Play every PCM audio chunk backwards", Default: false},
+		{Name: "audio_tape_stop", Type: "bool", Description: "Repeat the audio with each bloom, winding it down like a stopping tape", Default: false},
+		{Name: "audio_seed", Type: "int", Description: "Seed of the audio corruption, 0 to follow the mosh's seed", Min: 0, Max: MaxSeed, Default: 0},
+	}
+}
+
+// simpleEffect registers effects that render from an intensity alone.
+type simpleEffect struct {
+	name         string
+	description  string
+	maxIntensity float64
+	presets      []float64
+	// schema lists the parameters accepted besides intensity
+	schema []ParamSpec
+	// animated effects accept an intensity curve
+	animated bool
+	render   func(job Job) (map[string]interface{}, error)
+}
+
+func (s *simpleEffect) Name() string        { return s.name }
+func (s *simpleEffect) Description() string { return s.description }
+
+func (s *simpleEffect) Schema() []ParamSpec {
+	schema := intensitySchema(s.maxIntensity, s.presets[0])
+	schema[0].Animatable = s.animated
+	return append(schema, s.schema...)
+}
+
+func (s *simpleEffect) NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams {
+	return video.MoshParams{Intensity: intensity}
+}
+
+func (s *simpleEffect) Presets(windows []video.MoshWindow) []video.MoshParams {
+	presets := make([]video.MoshParams, 0, len(s.presets))
+	for _, intensity := range s.presets {
+		presets = append(presets, video.MoshParams{Intensity: intensity})
+	}
+	return presets
+}
+
+func (s *simpleEffect) Render(job Job) (map[string]interface{}, error) {
+	return s.render(job)
+}
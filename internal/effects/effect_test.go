@@ -0,0 +1,229 @@
+package effects
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"moshr/internal/video"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"datamosh", false},
+		{"glitch", false},
+		{"byte_corruption", false},
+		{"transfer", false},
+		{"chain", false},
+		{"", true},
+		{"Datamosh", true},
+		{"melt", true},
+	}
+
+	for _, tt := range tests {
+		e, err := Lookup(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Lookup(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && e.Name() != tt.name {
+			t.Errorf("Lookup(%q) returned %q", tt.name, e.Name())
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	all := All()
+	if len(all) != len(registry) {
+		t.Fatalf("All() returned %d effects, registry holds %d", len(all), len(registry))
+	}
+
+	names := make([]string, len(all))
+	for i, e := range all {
+		names[i] = e.Name()
+		if e.Description() == "" {
+			t.Errorf("%s has no description", e.Name())
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("All() is not sorted by name: %v", names)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	existing, err := Lookup("datamosh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering datamosh twice did not panic")
+		}
+		if e, _ := Lookup("datamosh"); e != existing {
+			t.Error("the failed registration replaced the effect")
+		}
+	}()
+	Register(&simpleEffect{name: "datamosh", presets: []float64{1}})
+}
+
+func TestValidateParams(t *testing.T) {
+	effect := &simpleEffect{
+		name:         "test",
+		maxIntensity: 3,
+		presets:      []float64{1},
+		schema: []ParamSpec{
+			{Name: "enabled", Type: "bool"},
+			{Name: "mode", Type: "enum", Options: []string{"a", "b"}},
+			{Name: "count", Type: "int", Min: 0, Max: 10},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"all valid", map[string]interface{}{"intensity": 2.5, "enabled": true, "mode": "b", "count": 10.0}, false},
+		{"unknown parameter", map[string]interface{}{"speed": 1.0}, true},
+		{"bool as string", map[string]interface{}{"enabled": "true"}, true},
+		{"enum outside options", map[string]interface{}{"mode": "c"}, true},
+		{"enum as number", map[string]interface{}{"mode": 1.0}, true},
+		{"fractional int", map[string]interface{}{"count": 2.5}, true},
+		{"int below min", map[string]interface{}{"count": -1.0}, true},
+		{"int above max", map[string]interface{}{"count": 11.0}, true},
+		{"number as string", map[string]interface{}{"count": "3"}, true},
+		{"float below min", map[string]interface{}{"intensity": 0.05}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateParams(effect, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveIntensity(t *testing.T) {
+	effect := &simpleEffect{name: "test", maxIntensity: 3, presets: []float64{1.5}}
+
+	tests := []struct {
+		name      string
+		intensity float64
+		want      float64
+		wantErr   bool
+	}{
+		{"zero takes the default", 0, 1.5, false},
+		{"in range", 2.5, 2.5, false},
+		{"at the max", 3, 3, false},
+		{"below min", 0.05, 0, true},
+		{"negative", -1, 0, true},
+		{"above max", 3.5, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveIntensity(effect, tt.intensity)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveIntensity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveIntensity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got, err := ResolveIntensity(&ChainEffect{}, 0)
+	if err != nil || got != 0 {
+		t.Errorf("chain ResolveIntensity() = %v, %v, want 0 unchanged", got, err)
+	}
+}
+
+func TestSchemaDefaultsAreValid(t *testing.T) {
+	for _, e := range All() {
+		seen := make(map[string]bool)
+		for _, spec := range e.Schema() {
+			if seen[spec.Name] {
+				t.Errorf("%s lists %s twice", e.Name(), spec.Name)
+			}
+			seen[spec.Name] = true
+
+			if spec.Default == nil {
+				continue
+			}
+			// Defaults are sent to the browser and come back as JSON
+			data, err := json.Marshal(spec.Default)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value interface{}
+			if err := json.Unmarshal(data, &value); err != nil {
+				t.Fatal(err)
+			}
+			if err := spec.check(value); err != nil {
+				t.Errorf("%s default of %s is invalid: %v", e.Name(), spec.Name, err)
+			}
+		}
+	}
+}
+
+func TestDecodeParams(t *testing.T) {
+	params := SonifyParams{Filter: "echo", Delay: 220, SampleFormat: "u8"}
+	err := decodeParams(map[string]interface{}{"filter": "phaser", "delay": 3.0}, &params)
+	if err != nil {
+		t.Fatalf("decodeParams failed: %v", err)
+	}
+	if params.Filter != "phaser" || params.Delay != 3 || params.SampleFormat != "u8" {
+		t.Errorf("got %+v, want only filter and delay overridden", params)
+	}
+
+	if err := decodeParams(map[string]interface{}{"delay": "long"}, &params); err == nil {
+		t.Error("expected an error for a string delay")
+	}
+	if err := decodeParams(nil, &params); err != nil {
+		t.Errorf("decoding no params failed: %v", err)
+	}
+}
+
+func TestUsesSeed(t *testing.T) {
+	corrupted := video.MoshParams{AudioBend: video.AudioBend{Corruption: 0.1}}
+
+	tests := []struct {
+		name   string
+		effect string
+		job    Job
+		want   bool
+	}{
+		{"datamosh", "datamosh", Job{}, false},
+		{"datamosh with reversed audio", "datamosh", Job{Params: video.MoshParams{AudioBend: video.AudioBend{Reverse: true}}}, false},
+		{"datamosh corrupting audio", "datamosh", Job{Params: corrupted}, true},
+		{"datamosh corrupting audio by effect parameter", "datamosh", Job{EffectParams: map[string]interface{}{"audio_corruption": 0.1}}, true},
+		{"datamosh corrupting audio with its own seed", "datamosh", Job{EffectParams: map[string]interface{}{"audio_corruption": 0.1, "audio_seed": 4.0}}, false},
+		{"datamosh with bad parameters", "datamosh", Job{EffectParams: map[string]interface{}{"audio_corruption": "lots"}}, true},
+		{"transfer", "transfer", Job{}, false},
+		{"glitch", "glitch", Job{}, true},
+		{"chain of pinned steps", ChainEffectName, Job{Steps: []Step{{Effect: "glitch", Seed: 3}, {Effect: "rgbdrift", Seed: 4}}}, false},
+		{"chain of datamosh steps", ChainEffectName, Job{Steps: []Step{{Effect: "datamosh"}, {Effect: "glitch", Seed: 3}}}, false},
+		{"chain with a random step", ChainEffectName, Job{Steps: []Step{{Effect: "datamosh"}, {Effect: "glitch"}}}, true},
+		{"chain with a step corrupting audio", ChainEffectName, Job{Steps: []Step{{Effect: "datamosh", Params: corrupted}}}, true},
+		{"chain with an unknown step", ChainEffectName, Job{Steps: []Step{{Effect: "melt"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effect, err := Lookup(tt.effect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := UsesSeed(effect, tt.job); got != tt.want {
+				t.Errorf("UsesSeed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,149 +3,201 @@ package effects
 import (
 	"fmt"
 	"math/rand"
+	"moshr/internal/avi"
 	"moshr/internal/video"
-	"os"
-	"time"
 )
 
 type GlitchEffect struct {
+	seeded
+	runner
 	mosher     *video.Mosher
 	corruption *CorruptionEffect
-	rng        *rand.Rand
 }
 
 func NewGlitchEffect() *GlitchEffect {
+	return NewGlitchEffectWithSeed(NewSeed())
+}
+
+func NewGlitchEffectWithSeed(seed int64) *GlitchEffect {
 	return &GlitchEffect{
+		seeded:     newSeeded(seed),
 		mosher:     video.NewMosher(),
-		corruption: NewCorruptionEffect(),
-		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		corruption: NewCorruptionEffectWithSeed(seed),
 	}
 }
 
-func (g *GlitchEffect) Apply(inputPath, outputPath string, intensity float64) error {
-	fmt.Printf("GLITCH: Starting enhanced glitch effect on %s -> %s with intensity %.2f\n", inputPath, outputPath, intensity)
+func init() {
+	Register(NewGlitchEffect())
+}
 
-	// Read the input file
-	data, err := os.ReadFile(inputPath)
-	if err != nil {
-		fmt.Printf("GLITCH: Failed to read input file: %v - falling back to moshing\n", err)
-		params := g.GenerateRandomParams(intensity)
-		return g.mosher.MoshVideo(inputPath, outputPath, params)
-	}
+func (g *GlitchEffect) Name() string { return "glitch" }
 
-	fmt.Printf("GLITCH: Read %d bytes from input file\n", len(data))
+func (g *GlitchEffect) Description() string {
+	return "Corrupts stream bytes and stutters frames with light moshing on top"
+}
 
-	// Apply byte-level corruption for immediate visual difference
-	corruptedData := g.applyAdvancedByteCorruption(data, intensity)
+func (g *GlitchEffect) Schema() []ParamSpec {
+	schema := append(intensitySchema(3, 1), moshSchema(1)...)
+	return append(schema, ParamSpec{
+		Name:        "corruption_seed",
+		Type:        "int",
+		Description: "Seed of the byte corruption pass",
+		Min:         1,
+		Max:         MaxSeed,
+	})
+}
 
-	// Write corrupted data to temp file
-	tempPath := outputPath + ".temp"
-	err = os.WriteFile(tempPath, corruptedData, 0644)
-	if err != nil {
-		fmt.Printf("GLITCH: Failed to write corrupted temp file: %v - falling back to moshing\n", err)
-		params := g.GenerateRandomParams(intensity)
-		return g.mosher.MoshVideo(inputPath, outputPath, params)
+// NewParams draws from a fresh generator since the registered effect is
+// shared between requests.
+func (g *GlitchEffect) NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams {
+	return NewGlitchEffect().GenerateRandomParams(intensity)
+}
+
+// Presets ignores frame windows; glitch always moshes the whole clip.
+func (g *GlitchEffect) Presets(windows []video.MoshWindow) []video.MoshParams {
+	return g.CreatePresets()
+}
+
+func (g *GlitchEffect) Render(job Job) (map[string]interface{}, error) {
+	effect := NewGlitchEffectWithSeed(job.Seed)
+	effect.bind(job)
+	params := effect.ResolveParams(job.Params.Intensity)
+	if err := decodeParams(job.EffectParams, &params); err != nil {
+		return nil, err
 	}
+	params.Animate = job.Params.Animate
+	err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+	return effect.ResolvedParams(), err
+}
 
-	fmt.Printf("GLITCH: Wrote %d bytes of corrupted data to temp file\n", len(corruptedData))
+// GlitchParams are the values a glitch render is fully described by.
+type GlitchParams struct {
+	Intensity         float64 `json:"intensity"`
+	IFrameRemoval     bool    `json:"iframe_removal"`
+	PFrameDuplication bool    `json:"pframe_duplication"`
+	DuplicationCount  int     `json:"duplication_count"`
+	CorruptionSeed    int64   `json:"corruption_seed"`
+	// Animate can drive the duplication count with a curve over time
+	Animate map[string]video.Curve `json:"animate,omitempty"`
+}
 
+// ResolveParams draws the moshing flags and the corruption seed for an
+// intensity.
+func (g *GlitchEffect) ResolveParams(intensity float64) GlitchParams {
 	// Apply minimal moshing to the corrupted result for very subtle compound effects
-	params := g.GenerateRandomParams(intensity * 0.1) // Minimal moshing on corrupted data
-	fmt.Printf("GLITCH: Applying moshing to corrupted data with params: %+v\n", params)
-	err = g.mosher.MoshVideo(tempPath, outputPath, params)
+	mosh := g.GenerateRandomParams(intensity * 0.1) // Minimal moshing on corrupted data
 
-	// Cleanup temp file
-	fmt.Printf("GLITCH: Cleaning up temp file %s\n", tempPath)
-	os.Remove(tempPath)
-
-	if err != nil {
-		fmt.Printf("GLITCH: Final moshing step failed: %v\n", err)
-	} else {
-		fmt.Printf("GLITCH: Enhanced glitch effect completed successfully\n")
+	return GlitchParams{
+		Intensity:         intensity,
+		IFrameRemoval:     mosh.IFrameRemoval,
+		PFrameDuplication: mosh.PFrameDuplication,
+		DuplicationCount:  mosh.DuplicationCount,
+		CorruptionSeed:    g.rng.Int63n(MaxSeed) + 1,
 	}
+}
 
-	return err
+func (g *GlitchEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return g.ApplyWithParams(inputPath, outputPath, g.ResolveParams(intensity))
 }
 
-// Advanced byte corruption that creates more visible artifacts
-func (g *GlitchEffect) applyAdvancedByteCorruption(data []byte, intensity float64) []byte {
-	corruptedData := make([]byte, len(data))
-	copy(corruptedData, data)
+// ApplyWithParams corrupts chunk payloads with the params' corruption
+// seed and moshes the result in the same pass over the file.
+func (g *GlitchEffect) ApplyWithParams(inputPath, outputPath string, params GlitchParams) error {
+	fmt.Printf("GLITCH: Starting enhanced glitch effect on %s -> %s with params: %+v\n", inputPath, outputPath, params)
+	g.resolveParams(params)
+
+	mosh := video.MoshParams{
+		Intensity:         params.Intensity * 0.1,
+		IFrameRemoval:     params.IFrameRemoval,
+		PFrameDuplication: params.PFrameDuplication,
+		DuplicationCount:  params.DuplicationCount,
+		Animate:           params.Animate,
+	}
 
-	// Skip AVI header (first 12 bytes: RIFF + size + AVI)
-	startPos := 12
-	if len(data) < startPos {
-		return corruptedData
+	// Every pass over the file must corrupt the same bytes, so each one gets
+	// its own generator seeded from the same value
+	err := g.rewriteAVI(inputPath, outputPath, func(header *avi.File) (avi.Transform, error) {
+		moshTransform, err := g.mosher.NewTransform(header, mosh)
+		if err != nil {
+			return nil, err
+		}
+		corrupt := g.newCorruptionTransform(rand.New(rand.NewSource(params.CorruptionSeed)), params.Intensity)
+		return avi.Chain(corrupt, moshTransform), nil
+	})
+	if err != nil {
+		fmt.Printf("GLITCH: Glitch pass failed: %v\n", err)
+		return err
 	}
 
+	fmt.Printf("GLITCH: Enhanced glitch effect completed successfully\n")
+	return nil
+}
+
+// Advanced byte corruption that creates more visible artifacts. Only chunk
+// payloads are touched so the container itself stays readable.
+func (g *GlitchEffect) newCorruptionTransform(rng *rand.Rand, intensity float64) avi.Transform {
 	// Extremely conservative corruption rate to avoid breaking files
 	corruptionRate := intensity * 0.00005 // Up to 0.005% corruption at max intensity
+	corrupter := newByteCorrupter(rng, corruptionRate)
 
-	// Apply different types of corruption
-	for i := startPos; i < len(corruptedData); i++ {
-		if g.rng.Float64() < corruptionRate {
+	return func(chunk *avi.Chunk) ([]*avi.Chunk, error) {
+		data := chunk.Data
+
+		// Apply different types of corruption
+		corrupter.each(data, func(i int) {
 			// Favor gentler corruption types
-			corruptionType := g.rng.Intn(10)
+			corruptionType := rng.Intn(10)
 			switch {
 			case corruptionType < 6:
 				// Bit flip (most common - 60% chance)
-				bitPos := g.rng.Intn(8)
-				corruptedData[i] ^= (1 << bitPos)
+				bitPos := rng.Intn(8)
+				data[i] ^= (1 << bitPos)
 			case corruptionType < 8:
 				// Byte swap with nearby byte (20% chance)
-				if i+1 < len(corruptedData) {
-					corruptedData[i], corruptedData[i+1] = corruptedData[i+1], corruptedData[i]
+				if i+1 < len(data) {
+					data[i], data[i+1] = data[i+1], data[i]
 				}
 			case corruptionType < 9:
 				// Light random change (10% chance) - small values only
-				corruptedData[i] = byte(g.rng.Intn(32)) // 0-31 only
+				data[i] = byte(rng.Intn(32)) // 0-31 only
 			default:
 				// Rare full random byte (10% chance)
-				corruptedData[i] = byte(g.rng.Intn(256))
+				data[i] = byte(rng.Intn(256))
 			}
+		})
+
+		// Only add chunk-level corruption at maximum intensity
+		if intensity >= 1.0 {
+			g.corruptVideoChunk(rng, chunk, intensity)
 		}
-	}
 
-	// Only add chunk-level corruption at maximum intensity
-	if intensity >= 1.0 {
-		g.corruptVideoChunks(corruptedData, intensity)
+		return []*avi.Chunk{chunk}, nil
 	}
-
-	return corruptedData
 }
 
 // Corrupt video chunks specifically for more visible glitch effects
-func (g *GlitchEffect) corruptVideoChunks(data []byte, intensity float64) {
-	// Look for video chunk identifiers and corrupt them extremely rarely
+func (g *GlitchEffect) corruptVideoChunk(rng *rand.Rand, chunk *avi.Chunk, intensity float64) {
 	chunkCorruptionRate := intensity * 0.005 // Up to 0.5% chunk corruption
 
-	for i := 12; i < len(data)-8; i++ {
-		// Look for common AVI chunk identifiers
-		if i+4 < len(data) {
-			chunk := string(data[i : i+4])
-			if chunk == "00dc" || chunk == "01dc" || chunk == "00db" || chunk == "01db" {
-				if g.rng.Float64() < chunkCorruptionRate {
-					// Only do very light corruption - single bit flips instead of full byte changes
-					if g.rng.Float64() < 0.7 {
-						// Light bit flip in chunk data area (skip identifier and size)
-						if i+8 < len(data) {
-							dataPos := i + 8 + g.rng.Intn(16) // Corrupt in first 16 bytes of chunk data
-							if dataPos < len(data) {
-								bitPos := g.rng.Intn(8)
-								data[dataPos] ^= (1 << bitPos)
-							}
-						}
-					} else {
-						// Very rarely, do a single byte change in chunk data
-						if i+8 < len(data) {
-							dataPos := i + 8 + g.rng.Intn(8)
-							if dataPos < len(data) {
-								data[dataPos] = byte(g.rng.Intn(256))
-							}
-						}
-					}
-				}
-			}
+	if !avi.IsVideo(chunk.ID) || len(chunk.Data) == 0 {
+		return
+	}
+	if rng.Float64() >= chunkCorruptionRate {
+		return
+	}
+
+	if rng.Float64() < 0.7 {
+		// Light bit flip in the first 16 bytes of chunk data
+		dataPos := rng.Intn(16)
+		if dataPos < len(chunk.Data) {
+			bitPos := rng.Intn(8)
+			chunk.Data[dataPos] ^= (1 << bitPos)
+		}
+	} else {
+		// Very rarely, do a single byte change in chunk data
+		dataPos := rng.Intn(8)
+		if dataPos < len(chunk.Data) {
+			chunk.Data[dataPos] = byte(rng.Intn(256))
 		}
 	}
 }
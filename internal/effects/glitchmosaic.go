@@ -2,22 +2,51 @@ package effects
 
 import (
 	"fmt"
-	"math/rand"
-	"os/exec"
-	"time"
 )
 
 type GlitchMosaicEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewGlitchMosaicEffect() *GlitchMosaicEffect {
+	return NewGlitchMosaicEffectWithSeed(NewSeed())
+}
+
+func NewGlitchMosaicEffectWithSeed(seed int64) *GlitchMosaicEffect {
 	return &GlitchMosaicEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (g *GlitchMosaicEffect) Apply(inputPath, outputPath string, intensity float64) error {
+func init() {
+	Register(&simpleEffect{
+		name:         "glitchmosaic",
+		description:  "Breaks the picture into noisy, color shifted blocks",
+		maxIntensity: 3,
+		presets:      []float64{1, 2, 3},
+		schema: []ParamSpec{
+			{Name: "grid_size", Type: "int", Description: "Dimensions are trimmed to a multiple of this grid", Min: 1, Max: 32, Default: 10},
+			{Name: "block_size", Type: "int", Description: "Size of the mosaic blocks in pixels", Min: 1, Max: 32, Default: 2},
+			{Name: "scramble_rate", Type: "float", Description: "Amount of temporal noise inside the blocks", Min: 0, Max: 1, Default: 0.3},
+			{Name: "color_shift", Type: "bool", Description: "Rotate the hue of the mosaic", Default: true},
+			{Name: "hue_shift", Type: "int", Description: "Hue rotation in degrees", Min: -180, Max: 180, Default: 0},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewGlitchMosaicEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(job.Params.Intensity)
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
+
+// ResolveParams draws a random hue shift for an intensity.
+func (g *GlitchMosaicEffect) ResolveParams(intensity float64) GlitchMosaicParams {
 	// Simplified mosaic effect that's more reliable
 	gridSize := int(4 + intensity*6) // 4x4 to 10x10 grid (reduced complexity)
 	if gridSize > 10 {
@@ -30,29 +59,53 @@ func (g *GlitchMosaicEffect) Apply(inputPath, outputPath string, intensity float
 		blockSize = 1
 	}
 
-	// Random parameters
-	noiseAmount := intensity * 0.3
+	return GlitchMosaicParams{
+		Intensity:    intensity,
+		GridSize:     gridSize,
+		BlockSize:    blockSize,
+		ScrambleRate: intensity * 0.3,
+		ColorShift:   true,
+		HueShift:     g.rng.Intn(60) - 30,
+	}
+}
+
+func (g *GlitchMosaicEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return g.ApplyWithParams(inputPath, outputPath, g.ResolveParams(intensity))
+}
+
+// ApplyWithParams pixelates the picture into blocks of the params' size,
+// adds noise and shifts the hue by the resolved amount.
+func (g *GlitchMosaicEffect) ApplyWithParams(inputPath, outputPath string, params GlitchMosaicParams) error {
+	g.resolveParams(params)
+	intensity := params.Intensity
+
+	gridSize := max(1, params.GridSize)
+	blockSize := max(1, params.BlockSize)
 	scrambleIntensity := intensity * 50
+	hueShift := params.HueShift
+	if !params.ColorShift {
+		hueShift = 0
+	}
 
 	// Simple but effective mosaic filter
 	filterComplex := fmt.Sprintf(
 		"[0:v]"+
-			"scale=iw/%d:ih/%d:flags=neighbor,"+        // Downscale to create blocks
-			"noise=alls=%f:allf=t+u,"+                  // Add temporal noise
-			"scale=iw*%d:ih*%d:flags=neighbor,"+        // Scale back up
-			"hue=h=%d:s=%.2f,"+                         // Random color shift
-			"crop=iw-mod(iw\\,%d):ih-mod(ih\\,%d),"+    // Clean up dimensions
+			"scale=iw/%d:ih/%d:flags=neighbor,"+ // Downscale to create blocks
+			"noise=alls=%f:allf=t+u,"+ // Add temporal noise
+			"scale=iw*%d:ih*%d:flags=neighbor,"+ // Scale back up
+			"hue=h=%d:s=%.2f,"+ // Random color shift
+			"crop=iw-mod(iw\\,%d):ih-mod(ih\\,%d),"+ // Clean up dimensions
 			"unsharp=luma_msize_x=3:luma_msize_y=3:luma_amount=%.2f", // Sharpen for digital look
 		blockSize, blockSize,
-		noiseAmount,
+		params.ScrambleRate,
 		blockSize, blockSize,
-		g.rng.Intn(60)-30, 1.0+intensity*0.5,
+		hueShift, 1.0+intensity*0.5,
 		gridSize, gridSize,
 		scrambleIntensity,
 	)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(g.runContext(), inputPath, outputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30")
+	return g.progress.Run(cmd)
 }
 
 func (g *GlitchMosaicEffect) CreatePresets() []GlitchMosaicParams {
@@ -87,4 +140,6 @@ type GlitchMosaicParams struct {
 	TimeOffset   int     `json:"time_offset"`
 	ScrambleRate float64 `json:"scramble_rate"`
 	ColorShift   bool    `json:"color_shift"`
+	BlockSize    int     `json:"block_size"`
+	HueShift     int     `json:"hue_shift"`
 }
@@ -3,37 +3,111 @@ package effects
 import (
 	"fmt"
 	"math"
-	"math/rand"
-	"os/exec"
 	"strings"
-	"time"
+
+	"moshr/internal/video"
 )
 
 type KaleidoscopeEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewKaleidoscopeEffect() *KaleidoscopeEffect {
+	return NewKaleidoscopeEffectWithSeed(NewSeed())
+}
+
+func NewKaleidoscopeEffectWithSeed(seed int64) *KaleidoscopeEffect {
 	return &KaleidoscopeEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (k *KaleidoscopeEffect) Apply(inputPath, outputPath string, intensity float64) error {
+func init() {
+	Register(&simpleEffect{
+		name:         "kaleidoscope",
+		description:  "Mirrors rotated fragments of the picture around the center",
+		maxIntensity: 3,
+		presets:      []float64{1, 2, 3},
+		animated:     true,
+		schema: []ParamSpec{
+			{Name: "num_segments", Type: "int", Description: "Number of mirrored fragments", Min: 1, Max: 8, Default: 6},
+			{Name: "fragment_size", Type: "float", Description: "Share of the frame each fragment is cut from", Min: 0.1, Max: 1, Default: 0.4},
+			{Name: "zoom", Type: "float", Description: "Scale of each fragment", Min: 0.25, Max: 6, Default: 1.5},
+			{Name: "rotation", Type: "float", Description: "Base rotation of the fragments in degrees", Min: -360, Max: 360, Default: 90.0, Animatable: true},
+			{Name: "time_delay", Type: "bool", Description: "Blend every other fragment with the previous frame", Default: false},
+			{Name: "blur_amount", Type: "float", Description: "Softening blur applied to the result", Min: 0, Max: 5, Default: 1.0},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewKaleidoscopeEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(renderIntensity(job.Params))
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			params.Animate = job.Params.Animate
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
+
+// ResolveParams draws random segment variations for an intensity.
+func (k *KaleidoscopeEffect) ResolveParams(intensity float64) KaleidoscopeParams {
 	// Number of kaleidoscope segments
 	numSegments := int(4 + intensity*4) // 4-8 segments
 	if numSegments > 8 {
 		numSegments = 8
 	}
 
+	params := KaleidoscopeParams{
+		Intensity:    intensity,
+		NumSegments:  numSegments,
+		FragmentSize: 0.3 + intensity*0.4, // How much of the original to use as fragment
+		Zoom:         1.0 + intensity*1.5, // Zoom level for fragments
+		Rotation:     intensity * 180,     // Random rotation amount
+		TimeDelay:    intensity > 1.5,
+		BlurAmount:   intensity * 0.5,
+	}
+	params.Segments = k.resolveSegments(numSegments, nil)
+	return params
+}
+
+// resolveSegments draws variations for any segments not given yet.
+func (k *KaleidoscopeEffect) resolveSegments(numSegments int, segments []KaleidoscopeSegment) []KaleidoscopeSegment {
+	for len(segments) < numSegments {
+		segments = append(segments, KaleidoscopeSegment{
+			// Random parameters for each segment
+			RotationOffset: k.rng.Float64()*60 - 30,    // ±30 degrees variation
+			ZoomOffset:     k.rng.Float64()*0.5 - 0.25, // ±0.25 zoom variation
+			// Random crop position for fragment source
+			CropX: k.rng.Float64(),
+			CropY: k.rng.Float64(),
+		})
+	}
+	return segments[:numSegments]
+}
+
+func (k *KaleidoscopeEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return k.ApplyWithParams(inputPath, outputPath, k.ResolveParams(intensity))
+}
+
+// ApplyWithParams rotates, zooms and crops the resolved segments, filling
+// in any the params leave out, and overlays them on the picture.
+func (k *KaleidoscopeEffect) ApplyWithParams(inputPath, outputPath string, params KaleidoscopeParams) error {
+	numSegments := params.NumSegments
+	if numSegments < 1 {
+		numSegments = 1
+	}
+	params.Segments = k.resolveSegments(numSegments, params.Segments)
+	k.resolveParams(params)
+
+	intensity := params.Intensity
+	fragmentSize := params.FragmentSize
+
 	// Calculate rotation angles for each segment
 	angleStep := 360.0 / float64(numSegments)
 
-	// Fragment parameters
-	fragmentSize := 0.3 + intensity*0.4 // How much of the original to use as fragment
-	zoom := 1.0 + intensity*1.5         // Zoom level for fragments
-	rotation := intensity * 180         // Random rotation amount
-
 	var filterParts []string
 	var overlayChain []string
 
@@ -48,16 +122,11 @@ func (k *KaleidoscopeEffect) Apply(inputPath, outputPath string, intensity float
 	filterParts[0] += strings.Join(streamLabels, "")
 
 	// Create each kaleidoscope segment
-	for i := 0; i < numSegments; i++ {
-		_ = float64(i) * angleStep // angle for future use
-
-		// Random parameters for each segment
-		segmentRotation := rotation + k.rng.Float64()*60 - 30 // ±30 degrees variation
-		segmentZoom := zoom + k.rng.Float64()*0.5 - 0.25      // ±0.25 zoom variation
-
-		// Random crop position for fragment source
-		cropX := k.rng.Float64() * (1.0 - fragmentSize)
-		cropY := k.rng.Float64() * (1.0 - fragmentSize)
+	for i, segment := range params.Segments {
+		segmentRotation := params.Rotation + segment.RotationOffset
+		segmentZoom := params.Zoom + segment.ZoomOffset
+		cropX := segment.CropX * (1.0 - fragmentSize)
+		cropY := segment.CropY * (1.0 - fragmentSize)
 
 		// Color shift for each segment
 		hueShift := float64(i * 45) // 45 degree hue shifts
@@ -65,24 +134,33 @@ func (k *KaleidoscopeEffect) Apply(inputPath, outputPath string, intensity float
 
 		// Time delay for some segments (creates temporal kaleidoscope)
 		timeDelay := ""
-		if i%2 == 1 && intensity > 1.5 {
+		if i%2 == 1 && params.TimeDelay {
 			timeDelay = "tblend=all_mode=average:all_opacity=0.6,"
 		}
 
+		rotate := fmt.Sprintf("rotate=%.2f*PI/180:fillcolor=black:ow=rotw(%.2f*PI/180):oh=roth(%.2f*PI/180)",
+			segmentRotation, segmentRotation, segmentRotation)
+		if _, ok := params.Animate["rotation"]; ok {
+			// The output size is fixed when the filter starts, so an
+			// animated rotation gets room for every angle
+			rotate = fmt.Sprintf("rotate='(%s+%g)*PI/180':fillcolor=black:ow='hypot(iw,ih)':oh=ow",
+				paramExpr(params.Animate, "rotation", params.Rotation, "t"), segment.RotationOffset)
+		}
+
 		// Create segment filter
 		segmentFilter := fmt.Sprintf(
 			"[seg%d_base]"+
 				"%s"+ // Optional time delay
 				"crop=iw*%.3f:ih*%.3f:iw*%.3f:ih*%.3f,"+ // Crop fragment
 				"scale=iw*%.2f:ih*%.2f,"+ // Scale fragment
-				"rotate=%.2f*PI/180:fillcolor=black:ow=rotw(%.2f*PI/180):oh=roth(%.2f*PI/180),"+ // Rotate
+				"%s,"+ // Rotate
 				"hue=h=%.1f:s=%.2f,"+ // Color shift
 				"pad=iw*2:ih*2:iw*0.5:ih*0.5[seg%d]", // Pad for positioning
 			i,
 			timeDelay,
 			fragmentSize, fragmentSize, cropX, cropY,
 			segmentZoom, segmentZoom,
-			segmentRotation, segmentRotation, segmentRotation,
+			rotate,
 			hueShift, satBoost,
 			i,
 		)
@@ -134,7 +212,7 @@ func (k *KaleidoscopeEffect) Apply(inputPath, outputPath string, intensity float
 		1.0+intensity*0.4, // Saturation
 		1.0+intensity*0.2, // Contrast
 		-0.1*intensity,    // Brightness
-		intensity*0.5,     // Blur amount
+		params.BlurAmount, // Blur amount
 		0.5+intensity*0.3, // Sharpening
 	)
 	overlayChain = append(overlayChain, finalFilter)
@@ -142,9 +220,10 @@ func (k *KaleidoscopeEffect) Apply(inputPath, outputPath string, intensity float
 	// Combine all parts
 	allParts := append(filterParts, overlayChain...)
 	filterComplex := strings.Join(allParts, ";")
+	filterComplex = blendEnvelope(filterComplex, params.Animate)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(k.runContext(), inputPath, outputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30")
+	return k.progress.Run(cmd)
 }
 
 func (k *KaleidoscopeEffect) CreatePresets() []KaleidoscopeParams {
@@ -187,4 +266,18 @@ type KaleidoscopeParams struct {
 	Rotation     float64 `json:"rotation"`
 	TimeDelay    bool    `json:"time_delay"`
 	BlurAmount   float64 `json:"blur_amount"`
+
+	Segments []KaleidoscopeSegment `json:"segments,omitempty"`
+	// Animate drives the rotation or the intensity with curves over time
+	Animate map[string]video.Curve `json:"animate,omitempty"`
+}
+
+// KaleidoscopeSegment holds the random variation of one segment. Rotation
+// and zoom are offsets from the base values, the crop position is a
+// fraction of the space left around the fragment.
+type KaleidoscopeSegment struct {
+	RotationOffset float64 `json:"rotation_offset"`
+	ZoomOffset     float64 `json:"zoom_offset"`
+	CropX          float64 `json:"crop_x"`
+	CropY          float64 `json:"crop_y"`
 }
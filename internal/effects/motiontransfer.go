@@ -0,0 +1,133 @@
+package effects
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"moshr/internal/video"
+)
+
+// MotionTransferEffect moshes two clips together: the last keyframe of clip
+// A is smeared by the motion of clip B.
+type MotionTransferEffect struct {
+	mosher    *video.Mosher
+	converter *video.Converter
+}
+
+func NewMotionTransferEffect() *MotionTransferEffect {
+	return &MotionTransferEffect{
+		mosher:    video.NewMosher(),
+		converter: video.NewConverter(),
+	}
+}
+
+func init() {
+	Register(NewMotionTransferEffect())
+}
+
+func (m *MotionTransferEffect) Name() string { return "transfer" }
+
+func (m *MotionTransferEffect) Description() string {
+	return "Smears the last keyframe of one clip with the motion of a second clip"
+}
+
+func (m *MotionTransferEffect) Schema() []ParamSpec {
+	return append(intensitySchema(1, 0.5),
+		ParamSpec{Name: "trim_to_last_keyframe", Type: "bool", Description: "Start the output at the last keyframe of the first clip", Default: false},
+		ParamSpec{Name: "duplication_count", Type: "int", Description: "How many times each P-frame of the second clip is repeated", Min: 0, Max: 20, Default: 3},
+	)
+}
+
+func (m *MotionTransferEffect) NewParams(intensity float64, windows []video.MoshWindow) video.MoshParams {
+	return video.MoshParams{Intensity: intensity}
+}
+
+func (m *MotionTransferEffect) Presets(windows []video.MoshWindow) []video.MoshParams {
+	return []video.MoshParams{
+		{Intensity: 0.2},
+		{Intensity: 0.5},
+		{Intensity: 1.0},
+	}
+}
+
+func (m *MotionTransferEffect) Render(job Job) (map[string]interface{}, error) {
+	if job.SecondInputPath == "" {
+		return nil, fmt.Errorf("motion transfer needs a second clip")
+	}
+
+	params := m.GenerateParams(job.Params.Intensity)
+	if job.Transfer != nil {
+		params = *job.Transfer
+	}
+	if err := decodeParams(job.EffectParams, &params); err != nil {
+		return nil, err
+	}
+	effect := &MotionTransferEffect{
+		mosher:    m.mosher.WithContext(job.Context),
+		converter: m.converter.WithContext(job.Context),
+	}
+	return nil, effect.ApplyPair(job.InputPath, job.SecondInputPath, job.OutputPath, params)
+}
+
+// IgnoresSeed is always true: a motion transfer draws no random values.
+func (m *MotionTransferEffect) IgnoresSeed(job Job) bool {
+	return true
+}
+
+// ApplyPair conforms both clips to the same Xvid settings, using the size
+// and frame rate of clip A, then splices their chunks so clip B's P-frames
+// play on top of clip A's image.
+func (m *MotionTransferEffect) ApplyPair(inputA, inputB, outputPath string, params video.TransferParams) error {
+	infoA, err := m.converter.GetVideoInfo(inputA)
+	if err != nil {
+		return fmt.Errorf("failed to probe clip A: %v", err)
+	}
+	infoB, err := m.converter.GetVideoInfo(inputB)
+	if err != nil {
+		return fmt.Errorf("failed to probe clip B: %v", err)
+	}
+
+	opts := video.ConformOptions{
+		Width:     infoA.Width - infoA.Width%2,
+		Height:    infoA.Height - infoA.Height%2,
+		Framerate: infoA.Framerate,
+		Audio:     infoA.AudioCodec != "" || infoB.AudioCodec != "",
+	}
+	if opts.Framerate <= 0 {
+		opts.Framerate = 30
+	}
+
+	tempDir, err := os.MkdirTemp("", "moshr_transfer_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	conformedA := filepath.Join(tempDir, "a.avi")
+	conformedB := filepath.Join(tempDir, "b.avi")
+
+	if err := m.converter.ConformAVI(inputA, conformedA, opts); err != nil {
+		return fmt.Errorf("failed to conform clip A: %v", err)
+	}
+	if err := m.converter.ConformAVI(inputB, conformedB, opts); err != nil {
+		return fmt.Errorf("failed to conform clip B: %v", err)
+	}
+
+	return m.mosher.TransferMotion(conformedA, conformedB, outputPath, params)
+}
+
+// GenerateParams derives transfer parameters from an intensity. Higher
+// intensities repeat clip B's motion to push the smear further.
+func (m *MotionTransferEffect) GenerateParams(intensity float64) video.TransferParams {
+	params := video.TransferParams{
+		Intensity:        intensity,
+		DuplicationCount: 1,
+	}
+
+	if intensity > 0.3 {
+		params.DuplicationCount = int(intensity*4) + 1 // 2-5 repeats
+	}
+
+	return params
+}
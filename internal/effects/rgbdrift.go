@@ -2,22 +2,61 @@ package effects
 
 import (
 	"fmt"
-	"math/rand"
-	"os/exec"
-	"time"
+	"math"
+
+	"moshr/internal/video"
 )
 
 type RGBDriftEffect struct {
-	rng *rand.Rand
+	seeded
+	runner
 }
 
 func NewRGBDriftEffect() *RGBDriftEffect {
+	return NewRGBDriftEffectWithSeed(NewSeed())
+}
+
+func NewRGBDriftEffectWithSeed(seed int64) *RGBDriftEffect {
 	return &RGBDriftEffect{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		seeded: newSeeded(seed),
 	}
 }
 
-func (r *RGBDriftEffect) Apply(inputPath, outputPath string, intensity float64) error {
+func init() {
+	Register(&simpleEffect{
+		name:         "rgbdrift",
+		description:  "Separates the color channels and drifts them apart in waves",
+		maxIntensity: 3,
+		presets:      []float64{1, 2, 3},
+		animated:     true,
+		schema: []ParamSpec{
+			{Name: "red_drift_x", Type: "int", Description: "Base horizontal drift of the red channel in pixels", Min: -90, Max: 90, Default: -15, Animatable: true},
+			{Name: "red_drift_y", Type: "int", Description: "Base vertical drift of the red channel in pixels", Min: -60, Max: 60, Default: 10, Animatable: true},
+			{Name: "green_drift_x", Type: "int", Description: "Base horizontal drift of the green channel in pixels", Min: -90, Max: 90, Default: 20, Animatable: true},
+			{Name: "green_drift_y", Type: "int", Description: "Base vertical drift of the green channel in pixels", Min: -60, Max: 60, Default: -5, Animatable: true},
+			{Name: "blue_drift_x", Type: "int", Description: "Base horizontal drift of the blue channel in pixels", Min: -90, Max: 90, Default: -10, Animatable: true},
+			{Name: "blue_drift_y", Type: "int", Description: "Base vertical drift of the blue channel in pixels", Min: -60, Max: 60, Default: 15, Animatable: true},
+			{Name: "wave_amplitude", Type: "int", Description: "How far the channels sway around their drift in pixels", Min: 0, Max: 60, Default: 10, Animatable: true},
+			{Name: "red_wave_speed", Type: "float", Description: "Sway speed of the red channel", Min: 0, Max: 2, Default: 0.2},
+			{Name: "green_wave_speed", Type: "float", Description: "Sway speed of the green channel", Min: 0, Max: 2, Default: 0.25},
+			{Name: "blue_wave_speed", Type: "float", Description: "Sway speed of the blue channel", Min: 0, Max: 2, Default: 0.18},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewRGBDriftEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(renderIntensity(job.Params))
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			params.Animate = job.Params.Animate
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
+
+// ResolveParams draws random channel drifts for an intensity.
+func (r *RGBDriftEffect) ResolveParams(intensity float64) RGBDriftParams {
 	// Generate random drift parameters for each channel
 	redDriftX := r.rng.Intn(int(intensity*60)) - int(intensity*30)   // -30 to +30 at max intensity
 	redDriftY := r.rng.Intn(int(intensity*40)) - int(intensity*20)   // -20 to +20 at max intensity
@@ -33,6 +72,70 @@ func (r *RGBDriftEffect) Apply(inputPath, outputPath string, intensity float64)
 
 	waveAmplitude := int(intensity * 20) // How much the waves can move channels
 
+	return RGBDriftParams{
+		Intensity:      intensity,
+		RedDriftX:      redDriftX,
+		RedDriftY:      redDriftY,
+		GreenDriftX:    greenDriftX,
+		GreenDriftY:    greenDriftY,
+		BlueDriftX:     blueDriftX,
+		BlueDriftY:     blueDriftY,
+		RedWaveSpeed:   redWaveSpeed,
+		GreenWaveSpeed: greenWaveSpeed,
+		BlueWaveSpeed:  blueWaveSpeed,
+		WaveAmplitude:  waveAmplitude,
+	}
+}
+
+func (r *RGBDriftEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return r.ApplyWithParams(inputPath, outputPath, r.ResolveParams(intensity))
+}
+
+// ApplyWithParams pads and offsets every color channel by its resolved
+// drift, waving each at its own speed.
+func (r *RGBDriftEffect) ApplyWithParams(inputPath, outputPath string, params RGBDriftParams) error {
+	r.resolveParams(params)
+
+	animate := params.Animate
+	amp := paramExpr(animate, "wave_amplitude", float64(params.WaveAmplitude), "T")
+	drift := func(name string, value int) string {
+		return paramExpr(animate, name, float64(value), "T")
+	}
+
+	// Pads are sized for the furthest a channel can drift
+	pad := func(nameX string, x int, nameY string, y int) []interface{} {
+		waveAmplitude := int(math.Ceil(paramPeak(animate, "wave_amplitude", float64(params.WaveAmplitude))))
+		reachX := int(math.Ceil(paramPeak(animate, nameX, float64(x))))
+		reachY := int(math.Ceil(paramPeak(animate, nameY, float64(y))))
+		offsetX, offsetY := max(0, x), max(0, y)
+		if _, ok := animate[nameX]; ok {
+			offsetX = reachX
+		}
+		if _, ok := animate[nameY]; ok {
+			offsetY = reachY
+		}
+		return []interface{}{
+			reachX + waveAmplitude*2, reachY + waveAmplitude*2,
+			offsetX + waveAmplitude, offsetY + waveAmplitude,
+		}
+	}
+
+	// Red channel parameters
+	args := pad("red_drift_x", params.RedDriftX, "red_drift_y", params.RedDriftY)
+	args = append(args,
+		drift("red_drift_x", params.RedDriftX), amp, params.RedWaveSpeed,
+		drift("red_drift_y", params.RedDriftY), amp, params.RedWaveSpeed)
+	// Green channel parameters
+	args = append(args, pad("green_drift_x", params.GreenDriftX, "green_drift_y", params.GreenDriftY)...)
+	args = append(args,
+		drift("green_drift_x", params.GreenDriftX), amp, params.GreenWaveSpeed,
+		drift("green_drift_y", params.GreenDriftY), amp, params.GreenWaveSpeed)
+	// Blue channel parameters
+	args = append(args, pad("blue_drift_x", params.BlueDriftX, "blue_drift_y", params.BlueDriftY)...)
+	args = append(args,
+		drift("blue_drift_x", params.BlueDriftX), amp, params.BlueWaveSpeed, params.BlueWaveSpeed,
+		drift("blue_drift_y", params.BlueDriftY), amp, params.BlueWaveSpeed, params.BlueWaveSpeed)
+
 	// Create complex filter for RGB channel separation with dynamic movement
 	filterComplex := fmt.Sprintf(
 		"[0:v]split=3[r_base][g_base][b_base];"+
@@ -41,7 +144,7 @@ func (r *RGBDriftEffect) Apply(inputPath, outputPath string, intensity float64)
 			"lutrgb=g=0:b=0,"+ // Extract red channel only
 			"pad=iw+%d:ih+%d:%d:%d,"+ // Pad for movement
 			"geq="+
-			"r='r(X-(%d+%d*sin(T*%f)),Y-(%d+%d*sin(T*%f+1)))':"+ // Dynamic red movement
+			"r='r(X-(%s+%s*sin(T*%f)),Y-(%s+%s*sin(T*%f+1)))':"+ // Dynamic red movement
 			"g=0:b=0[r_layer];"+
 			// Green channel with cosine wave vertical drift
 			"[g_base]"+
@@ -49,7 +152,7 @@ func (r *RGBDriftEffect) Apply(inputPath, outputPath string, intensity float64)
 			"pad=iw+%d:ih+%d:%d:%d,"+
 			"geq="+
 			"r=0:"+
-			"g='g(X-(%d+%d*cos(T*%f)),Y-(%d+%d*cos(T*%f+2)))':"+ // Dynamic green movement
+			"g='g(X-(%s+%s*cos(T*%f)),Y-(%s+%s*cos(T*%f+2)))':"+ // Dynamic green movement
 			"b=0[g_layer];"+
 			// Blue channel with figure-8 pattern drift
 			"[b_base]"+
@@ -57,29 +160,16 @@ func (r *RGBDriftEffect) Apply(inputPath, outputPath string, intensity float64)
 			"pad=iw+%d:ih+%d:%d:%d,"+
 			"geq="+
 			"r=0:g=0:"+
-			"b='b(X-(%d+%d*sin(T*%f)*cos(T*%f)),Y-(%d+%d*cos(T*%f)*sin(T*%f+3)))'[b_layer];"+ // Figure-8 movement
+			"b='b(X-(%s+%s*sin(T*%f)*cos(T*%f)),Y-(%s+%s*cos(T*%f)*sin(T*%f+3)))'[b_layer];"+ // Figure-8 movement
 			// Composite all channels back together
 			"[r_layer][g_layer]overlay=0:0:format=auto,"+
 			"[b_layer]overlay=0:0:format=auto",
-		// Red channel parameters
-		abs(redDriftX)+waveAmplitude*2, abs(redDriftY)+waveAmplitude*2,
-		max(0, redDriftX)+waveAmplitude, max(0, redDriftY)+waveAmplitude,
-		redDriftX, waveAmplitude, redWaveSpeed,
-		redDriftY, waveAmplitude, redWaveSpeed,
-		// Green channel parameters
-		abs(greenDriftX)+waveAmplitude*2, abs(greenDriftY)+waveAmplitude*2,
-		max(0, greenDriftX)+waveAmplitude, max(0, greenDriftY)+waveAmplitude,
-		greenDriftX, waveAmplitude, greenWaveSpeed,
-		greenDriftY, waveAmplitude, greenWaveSpeed,
-		// Blue channel parameters
-		abs(blueDriftX)+waveAmplitude*2, abs(blueDriftY)+waveAmplitude*2,
-		max(0, blueDriftX)+waveAmplitude, max(0, blueDriftY)+waveAmplitude,
-		blueDriftX, waveAmplitude, blueWaveSpeed, blueWaveSpeed,
-		blueDriftY, waveAmplitude, blueWaveSpeed, blueWaveSpeed,
+		args...,
 	)
+	filterComplex = blendEnvelope(filterComplex, animate)
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30", "-y", outputPath)
-	return cmd.Run()
+	cmd := ffmpegCommand(r.runContext(), inputPath, outputPath, "-filter_complex", filterComplex, "-map", "0:a?", "-c:a", "copy", "-r", "30")
+	return r.progress.Run(cmd)
 }
 
 func (r *RGBDriftEffect) CreatePresets() []RGBDriftParams {
@@ -138,4 +228,7 @@ type RGBDriftParams struct {
 	RedWaveSpeed   float64 `json:"red_wave_speed"`
 	GreenWaveSpeed float64 `json:"green_wave_speed"`
 	BlueWaveSpeed  float64 `json:"blue_wave_speed"`
+	// Animate drives the drifts, the wave amplitude or the intensity with
+	// curves over time
+	Animate map[string]video.Curve `json:"animate,omitempty"`
 }
@@ -0,0 +1,305 @@
+package effects
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"moshr/internal/video"
+)
+
+// sonifyRate is the sample rate the raw frame bytes are played at. Filter
+// times in milliseconds are measured against it.
+const sonifyRate = 44100
+
+// Filters the sonify effect can run the picture through.
+var sonifyFilters = []string{"echo", "reverb", "phaser", "flanger", "chorus", "vibrato", "crusher", "lowpass", "highpass"}
+
+// SonifyEffect databends raw video as audio: frames are decoded to raw
+// pixels, the bytes are read as PCM samples, run through ffmpeg audio
+// filters and read back as pixels. Echoes smear the picture along its
+// scanlines and into later frames.
+type SonifyEffect struct {
+	seeded
+	runner
+	converter *video.Converter
+}
+
+func NewSonifyEffect() *SonifyEffect {
+	return NewSonifyEffectWithSeed(NewSeed())
+}
+
+func NewSonifyEffectWithSeed(seed int64) *SonifyEffect {
+	return &SonifyEffect{
+		seeded:    newSeeded(seed),
+		converter: video.NewConverter(),
+	}
+}
+
+func init() {
+	Register(&simpleEffect{
+		name:         "sonify",
+		description:  "Plays the raw pixels as audio through echo, phaser and other audio filters",
+		maxIntensity: 3,
+		presets:      []float64{0.5, 1, 2},
+		schema: []ParamSpec{
+			{Name: "filter", Type: "enum", Description: "Audio filter the pixels are run through", Default: "echo", Options: sonifyFilters},
+			{Name: "second_filter", Type: "enum", Description: "Audio filter run after the first one", Default: "", Options: append([]string{""}, sonifyFilters...)},
+			{Name: "delay", Type: "float", Description: "Delay of echoes, phasers, flangers and choruses in milliseconds of samples", Min: 0.1, Max: 2000, Default: 220.0},
+			{Name: "decay", Type: "float", Description: "Strength of every echo or modulation", Min: 0, Max: 1, Default: 0.5},
+			{Name: "speed", Type: "float", Description: "Modulation speed in Hz", Min: 0.1, Max: 10, Default: 1.5},
+			{Name: "depth", Type: "float", Description: "Modulation depth, also the strength of crushers and filters", Min: 0, Max: 1, Default: 0.45},
+			{Name: "sample_format", Type: "enum", Description: "How bytes are read as samples", Default: "u8", Options: []string{"u8", "s16le"}},
+			{Name: "pixel_format", Type: "enum", Description: "Pixel layout the frames are decoded to", Default: "rgb24", Options: []string{"rgb24", "yuv444p", "gray"}},
+		},
+		render: func(job Job) (map[string]interface{}, error) {
+			effect := NewSonifyEffectWithSeed(job.Seed)
+			effect.bind(job)
+			params := effect.ResolveParams(renderIntensity(job.Params))
+			if err := decodeParams(job.EffectParams, &params); err != nil {
+				return nil, err
+			}
+			err := effect.ApplyWithParams(job.InputPath, job.OutputPath, params)
+			return effect.ResolvedParams(), err
+		},
+	})
+}
+
+// SonifyParams are the values a sonify render is fully described by.
+type SonifyParams struct {
+	Intensity    float64 `json:"intensity"`
+	Filter       string  `json:"filter"`
+	SecondFilter string  `json:"second_filter,omitempty"`
+	Delay        float64 `json:"delay"`
+	Decay        float64 `json:"decay"`
+	Speed        float64 `json:"speed"`
+	Depth        float64 `json:"depth"`
+	SampleFormat string  `json:"sample_format"`
+	PixelFormat  string  `json:"pixel_format"`
+}
+
+// ResolveParams draws a filter and scales its settings with the intensity.
+func (s *SonifyEffect) ResolveParams(intensity float64) SonifyParams {
+	return SonifyParams{
+		Intensity:    intensity,
+		Filter:       sonifyFilters[s.rng.Intn(len(sonifyFilters))],
+		Delay:        20 + intensity*200,
+		Decay:        math.Min(0.3+intensity*0.2, 0.9),
+		Speed:        0.5 + intensity,
+		Depth:        math.Min(0.2+intensity*0.25, 1),
+		SampleFormat: "u8",
+		PixelFormat:  "rgb24",
+	}
+}
+
+func (s *SonifyEffect) Apply(inputPath, outputPath string, intensity float64) error {
+	return s.ApplyWithParams(inputPath, outputPath, s.ResolveParams(intensity))
+}
+
+// ApplyWithParams runs three ffmpeg processes at once: one decodes the
+// frames, one filters their bytes as audio and one encodes the result at
+// the source's size and frame rate, copying the source's audio.
+func (s *SonifyEffect) ApplyWithParams(inputPath, outputPath string, params SonifyParams) error {
+	filter, err := sonifyChain(params)
+	if err != nil {
+		return err
+	}
+	s.resolveParams(params)
+
+	ctx := s.runContext()
+	info, err := s.converter.WithContext(ctx).GetVideoInfo(inputPath)
+	if err != nil {
+		return err
+	}
+	if info.Width <= 0 || info.Height <= 0 || info.Framerate <= 0 {
+		return fmt.Errorf("could not read the size and frame rate of %s", inputPath)
+	}
+
+	decode := exec.CommandContext(ctx, "ffmpeg", "-i", inputPath, "-map", "0:v:0",
+		"-f", "rawvideo", "-pix_fmt", params.PixelFormat, "pipe:1")
+	bend := exec.CommandContext(ctx, "ffmpeg", "-f", params.SampleFormat, "-ar", strconv.Itoa(sonifyRate), "-ac", "1", "-i", "pipe:0",
+		"-af", filter, "-f", params.SampleFormat, "-ar", strconv.Itoa(sonifyRate), "-ac", "1", "pipe:1")
+	encode := exec.CommandContext(ctx, "ffmpeg",
+		"-f", "rawvideo", "-pix_fmt", params.PixelFormat, "-s", fmt.Sprintf("%dx%d", info.Width, info.Height),
+		"-r", strconv.FormatFloat(info.Framerate, 'f', -1, 64), "-i", "pipe:0",
+		"-i", inputPath, "-map", "0:v:0", "-map", "1:a?", "-c:a", "copy",
+		"-fflags", "+bitexact", "-flags:v", "+bitexact", "-flags:a", "+bitexact", "-y", outputPath)
+
+	var decodeErr, bendErr bytes.Buffer
+	decode.Stderr = &decodeErr
+	bend.Stderr = &bendErr
+
+	raw, err := decode.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	bendIn, err := bend.StdinPipe()
+	if err != nil {
+		return err
+	}
+	bent, err := bend.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	encodeIn, err := encode.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := decode.Start(); err != nil {
+		return fmt.Errorf("failed to start the frame decoder: %v", err)
+	}
+	if err := bend.Start(); err != nil {
+		decode.Process.Kill()
+		decode.Wait()
+		return fmt.Errorf("failed to start the audio filter: %v", err)
+	}
+
+	// The filter may add a tail, such as the last echoes, or hold back
+	// samples, so its output is cut or padded to the bytes read in and
+	// every frame keeps its place
+	var read atomic.Int64
+	var feedErr error
+	fed := make(chan struct{})
+	go func() {
+		_, feedErr = io.Copy(bendIn, &countingReader{r: raw, n: &read})
+		bendIn.Close()
+		close(fed)
+	}()
+
+	var copyErr error
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		defer encodeIn.Close()
+		written, err := io.Copy(&cappedWriter{w: encodeIn, limit: &read}, bent)
+		// Drain what the writer refused so the filter can exit
+		io.Copy(io.Discard, bent)
+		<-fed
+		if err == nil {
+			if pad := read.Load() - written; pad > 0 {
+				_, err = io.CopyN(encodeIn, zeroReader{}, pad)
+			}
+		}
+		copyErr = err
+	}()
+
+	encodeErr := s.progress.Run(encode)
+	<-copied
+	if err := decode.Wait(); err != nil {
+		return fmt.Errorf("frame decoding failed: %v\nOutput: %s", err, decodeErr.String())
+	}
+	if err := bend.Wait(); err != nil {
+		return fmt.Errorf("audio filtering failed: %v\nOutput: %s", err, bendErr.String())
+	}
+	if encodeErr != nil {
+		return fmt.Errorf("frame encoding failed: %v", encodeErr)
+	}
+	if feedErr != nil {
+		return feedErr
+	}
+	return copyErr
+}
+
+// sonifyChain builds the ffmpeg audio filter chain for the params, keeping
+// every setting inside the range its filter accepts.
+func sonifyChain(params SonifyParams) (string, error) {
+	var chain []string
+	for _, name := range []string{params.Filter, params.SecondFilter} {
+		if name == "" {
+			continue
+		}
+		filter, err := sonifyFilter(name, params)
+		if err != nil {
+			return "", err
+		}
+		chain = append(chain, filter)
+	}
+	if len(chain) == 0 {
+		return "", fmt.Errorf("sonify needs a filter")
+	}
+	return strings.Join(chain, ","), nil
+}
+
+func sonifyFilter(name string, p SonifyParams) (string, error) {
+	delay := clamp(p.Delay, 0.1, 2000)
+	decay := clamp(p.Decay, 0.01, 1)
+	speed := clamp(p.Speed, 0.1, 10)
+	depth := clamp(p.Depth, 0, 1)
+
+	switch name {
+	case "echo":
+		return fmt.Sprintf("aecho=0.8:0.9:%.2f:%.2f", delay, decay), nil
+	case "reverb":
+		// Several echoes at uneven delays blur into a reverb
+		return fmt.Sprintf("aecho=0.8:0.9:%.2f|%.2f|%.2f:%.2f|%.2f|%.2f",
+			delay, delay*1.7, delay*2.9, decay, decay*0.7, decay*0.5), nil
+	case "phaser":
+		return fmt.Sprintf("aphaser=in_gain=0.4:out_gain=0.74:delay=%.2f:decay=%.2f:speed=%.2f",
+			clamp(delay, 0.1, 5), clamp(decay, 0.01, 0.99), clamp(speed, 0.1, 2)), nil
+	case "flanger":
+		return fmt.Sprintf("flanger=delay=%.2f:depth=%.2f:speed=%.2f", clamp(delay, 0, 30), depth*10, speed), nil
+	case "chorus":
+		return fmt.Sprintf("chorus=0.5:0.9:%.2f:%.2f:%.2f:%.2f", clamp(delay, 0.1, 100), decay, speed, depth*10), nil
+	case "vibrato":
+		return fmt.Sprintf("vibrato=f=%.2f:d=%.2f", speed, depth), nil
+	case "crusher":
+		return fmt.Sprintf("acrusher=bits=%.1f:samples=%.0f:mode=lin", 16-depth*14, 1+depth*49), nil
+	case "lowpass":
+		return fmt.Sprintf("lowpass=f=%.0f", 200+(1-depth)*8000), nil
+	case "highpass":
+		return fmt.Sprintf("highpass=f=%.0f", 20+depth*4000), nil
+	}
+	return "", fmt.Errorf("unknown sonify filter %q", name)
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// countingReader counts the bytes read through it before handing them on,
+// so a cappedWriter never lags behind the reader.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// cappedWriter writes at most limit bytes and quietly drops the rest.
+type cappedWriter struct {
+	w       io.Writer
+	limit   *atomic.Int64
+	written int64
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if left := c.limit.Load() - c.written; int64(len(p)) > left {
+		p = p[:max(int(left), 0)]
+	}
+	written, err := c.w.Write(p)
+	c.written += int64(written)
+	if err != nil {
+		return written, err
+	}
+	return n, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,144 @@
+package effects
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSonifyChain(t *testing.T) {
+	base := SonifyParams{Delay: 100, Decay: 0.5, Speed: 2, Depth: 0.5}
+	with := func(filter, second string) SonifyParams {
+		p := base
+		p.Filter, p.SecondFilter = filter, second
+		return p
+	}
+	extreme := SonifyParams{Filter: "echo", Delay: 5000, Decay: 0, Speed: 50, Depth: 2}
+
+	tests := []struct {
+		name    string
+		params  SonifyParams
+		want    string
+		wantErr bool
+	}{
+		{"echo", with("echo", ""), "aecho=0.8:0.9:100.00:0.50", false},
+		{"reverb", with("reverb", ""), "aecho=0.8:0.9:100.00|170.00|290.00:0.50|0.35|0.25", false},
+		{"phaser", with("phaser", ""), "aphaser=in_gain=0.4:out_gain=0.74:delay=5.00:decay=0.50:speed=2.00", false},
+		{"flanger", with("flanger", ""), "flanger=delay=30.00:depth=5.00:speed=2.00", false},
+		{"chorus", with("chorus", ""), "chorus=0.5:0.9:100.00:0.50:2.00:5.00", false},
+		{"vibrato", with("vibrato", ""), "vibrato=f=2.00:d=0.50", false},
+		{"crusher", with("crusher", ""), "acrusher=bits=9.0:samples=26:mode=lin", false},
+		{"lowpass", with("lowpass", ""), "lowpass=f=4200", false},
+		{"highpass", with("highpass", ""), "highpass=f=2020", false},
+		{"two filters", with("echo", "lowpass"), "aecho=0.8:0.9:100.00:0.50,lowpass=f=4200", false},
+		{"only a second filter", with("", "vibrato"), "vibrato=f=2.00:d=0.50", false},
+		{"settings out of range", extreme, "aecho=0.8:0.9:2000.00:0.01", false},
+		{"no filter", with("", ""), "", true},
+		{"unknown filter", with("distortion", ""), "", true},
+		{"unknown second filter", with("echo", "distortion"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sonifyChain(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sonifyChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("sonifyChain() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSonifyResolveParams(t *testing.T) {
+	first := NewSonifyEffectWithSeed(9).ResolveParams(1)
+	if again := NewSonifyEffectWithSeed(9).ResolveParams(1); !reflect.DeepEqual(first, again) {
+		t.Errorf("the same seed resolved %+v and %+v", first, again)
+	}
+
+	for _, intensity := range []float64{0.1, 1, 3} {
+		params := NewSonifyEffectWithSeed(9).ResolveParams(intensity)
+		if _, err := sonifyChain(params); err != nil {
+			t.Errorf("params resolved at %v do not make a chain: %v", intensity, err)
+		}
+		if params.Decay > 0.9 || params.Depth > 1 || params.SampleFormat != "u8" || params.PixelFormat != "rgb24" {
+			t.Errorf("params resolved at %v = %+v", intensity, params)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	tests := []struct {
+		v, lo, hi float64
+		want      float64
+	}{
+		{5, 0, 10, 5},
+		{-1, 0, 10, 0},
+		{11, 0, 10, 10},
+		{0.1, 0.1, 5, 0.1},
+	}
+
+	for _, tt := range tests {
+		if got := clamp(tt.v, tt.lo, tt.hi); got != tt.want {
+			t.Errorf("clamp(%v, %v, %v) = %v, want %v", tt.v, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestCappedWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int64
+		writes []string
+		want   string
+	}{
+		{"under the limit", 10, []string{"abc", "def"}, "abcdef"},
+		{"cut at the limit", 4, []string{"abc", "def"}, "abcd"},
+		{"nothing after the limit", 3, []string{"abc", "def", "ghi"}, "abc"},
+		{"no limit yet", 0, []string{"abc"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var limit atomic.Int64
+			limit.Store(tt.limit)
+			var out bytes.Buffer
+			w := &cappedWriter{w: &out, limit: &limit}
+			for _, s := range tt.writes {
+				// Dropped bytes still count as written so the copy goes on
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Errorf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if out.String() != tt.want || w.written != int64(len(tt.want)) {
+				t.Errorf("wrote %q (%d), want %q", out.String(), w.written, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountingReader(t *testing.T) {
+	var n atomic.Int64
+	r := &countingReader{r: strings.NewReader("raw frame bytes"), n: &n}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Load() != int64(len(data)) || string(data) != "raw frame bytes" {
+		t.Errorf("read %q and counted %d", data, n.Load())
+	}
+}
+
+func TestZeroReader(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := io.CopyN(&out, zeroReader{}, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), make([]byte, 5000)) {
+		t.Error("zeroReader read something other than zeros")
+	}
+}
@@ -1,5 +1,19 @@
 package effects
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"time"
+
+	"moshr/internal/avi"
+	"moshr/internal/video"
+)
+
 // Common utility functions for effects
 
 func abs(x int) int {
@@ -22,3 +36,144 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// MaxSeed bounds seeds so they survive a round trip through JSON numbers,
+// both in the browser and in session metadata decoded into interface{}.
+const MaxSeed = 1<<53 - 1
+
+// NewSeed returns a seed for renders that were not given one.
+func NewSeed() int64 {
+	return time.Now().UnixNano()%MaxSeed + 1
+}
+
+// seeded is embedded by every effect that draws random values. It keeps the
+// seed the generator was created from and records the values drawn during
+// the last render, so a render can be stored and repeated exactly.
+type seeded struct {
+	rng      *rand.Rand
+	seed     int64
+	resolved map[string]interface{}
+}
+
+func newSeeded(seed int64) seeded {
+	return seeded{
+		rng:      rand.New(rand.NewSource(seed)),
+		seed:     seed,
+		resolved: make(map[string]interface{}),
+	}
+}
+
+// Seed returns the seed the effect's generator was created from.
+func (s *seeded) Seed() int64 {
+	return s.seed
+}
+
+// ResolvedParams returns the random values drawn by the last render.
+func (s *seeded) ResolvedParams() map[string]interface{} {
+	return s.resolved
+}
+
+func (s *seeded) resolve(name string, value interface{}) {
+	s.resolved[name] = value
+}
+
+// resolveParams records every field of a typed params struct.
+func (s *seeded) resolveParams(params interface{}) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &s.resolved)
+}
+
+// runner is embedded by effects that run ffmpeg or rewrite files. Render
+// binds the job to it, so cancelling the job kills the ffmpeg process or
+// stops the rewrite, and progress is reported while the work runs.
+type runner struct {
+	ctx      context.Context
+	progress *video.Reporter
+}
+
+func (r *runner) bind(job Job) {
+	r.ctx = job.Context
+	r.progress = job.Progress
+}
+
+func (r *runner) runContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// ffmpegCommand builds an ffmpeg run that writes bitexact output, leaving
+// out encoder version strings and other metadata that would make two
+// renders with the same seed differ. The process is killed when ctx is
+// done.
+func ffmpegCommand(ctx context.Context, inputPath, outputPath string, args ...string) *exec.Cmd {
+	full := append([]string{"-i", inputPath}, args...)
+	full = append(full, "-fflags", "+bitexact", "-flags:v", "+bitexact", "-flags:a", "+bitexact", "-y", outputPath)
+	return exec.CommandContext(ctx, "ffmpeg", full...)
+}
+
+// rewriteAVI streams inputPath into outputPath through a chunk transform
+// without loading either file into memory, counting chunks towards the
+// progress and giving up once the job is cancelled.
+func (r *runner) rewriteAVI(inputPath, outputPath string, newTransform func(header *avi.File) (avi.Transform, error)) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %v", err)
+	}
+	defer file.Close()
+	in := avi.ContextReader(r.runContext(), file)
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer out.Close()
+
+	if err := avi.Rewrite(in, out, r.progress.Transforms(newTransform)); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// byteCorrupter picks corruption positions across a stream of payloads.
+// Gaps between hits are drawn from a geometric distribution, which matches
+// flipping a coin for every byte without drawing a number per byte.
+type byteCorrupter struct {
+	rng  *rand.Rand
+	rate float64
+	gap  int64
+}
+
+func newByteCorrupter(rng *rand.Rand, rate float64) *byteCorrupter {
+	b := &byteCorrupter{rng: rng, rate: rate}
+	b.gap = b.nextGap()
+	return b
+}
+
+func (b *byteCorrupter) nextGap() int64 {
+	if b.rate <= 0 {
+		return math.MaxInt64
+	}
+	if b.rate >= 1 {
+		return 0
+	}
+	u := 1 - b.rng.Float64() // (0, 1]
+	return int64(math.Log(u) / math.Log1p(-b.rate))
+}
+
+// each calls fn with every position of data that should be corrupted.
+func (b *byteCorrupter) each(data []byte, fn func(i int)) {
+	pos := int64(0)
+	n := int64(len(data))
+	for b.gap < n-pos {
+		pos += b.gap
+		fn(int(pos))
+		pos++
+		b.gap = b.nextGap()
+	}
+	b.gap -= n - pos
+}
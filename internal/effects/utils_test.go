@@ -0,0 +1,119 @@
+package effects
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestNewSeed(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if seed := NewSeed(); seed < 1 || seed > MaxSeed {
+			t.Fatalf("NewSeed() = %d, want a seed between 1 and MaxSeed", seed)
+		}
+	}
+}
+
+func TestResolveParamsRepeatsPerSeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		resolve func(seed int64) interface{}
+	}{
+		{"chromaticblur", func(seed int64) interface{} { return NewChromaticBlurEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"corruption", func(seed int64) interface{} { return NewCorruptionEffectWithSeed(seed).ResolveParams("", 1.5) }},
+		{"duallayer", func(seed int64) interface{} { return NewDualLayerEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"echotrail", func(seed int64) interface{} { return NewEchoTrailEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"glitch", func(seed int64) interface{} { return NewGlitchEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"glitchmosaic", func(seed int64) interface{} { return NewGlitchMosaicEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"kaleidoscope", func(seed int64) interface{} { return NewKaleidoscopeEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"rgbdrift", func(seed int64) interface{} { return NewRGBDriftEffectWithSeed(seed).ResolveParams(1.5) }},
+		{"sonify", func(seed int64) interface{} { return NewSonifyEffectWithSeed(seed).ResolveParams(1.5) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := tt.resolve(42)
+			if second := tt.resolve(42); !reflect.DeepEqual(first, second) {
+				t.Errorf("seed 42 resolved %+v, then %+v", first, second)
+			}
+
+			// Some seed among a handful has to draw something else
+			for seed := int64(1); seed <= 20; seed++ {
+				if !reflect.DeepEqual(tt.resolve(seed), first) {
+					return
+				}
+			}
+			t.Error("every seed resolved the same params")
+		})
+	}
+}
+
+func TestCorruptionResolveParams(t *testing.T) {
+	tests := []struct {
+		mode     string
+		wantSeed bool
+	}{
+		{"byte_corruption", true},
+		{"channel_shift", false},
+		{"pixel_sort", false},
+		{"scanline_displace", false},
+	}
+
+	for _, tt := range tests {
+		params := NewCorruptionEffectWithSeed(7).ResolveParams(tt.mode, 1)
+		if params.Mode != tt.mode {
+			t.Errorf("mode %s resolved to %s", tt.mode, params.Mode)
+		}
+		if got := params.CorruptionSeed != 0; got != tt.wantSeed {
+			t.Errorf("mode %s drew a corruption seed: %v, want %v", tt.mode, got, tt.wantSeed)
+		}
+	}
+}
+
+func TestResolveParamsRecordsFields(t *testing.T) {
+	s := newSeeded(1)
+	s.resolve("extra", 3)
+	s.resolveParams(struct {
+		Mode  string  `json:"mode"`
+		Level float64 `json:"level"`
+	}{"echo", 0.5})
+
+	want := map[string]interface{}{"extra": 3, "mode": "echo", "level": 0.5}
+	if got := s.ResolvedParams(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolvedParams() = %v, want %v", got, want)
+	}
+	if s.Seed() != 1 {
+		t.Errorf("Seed() = %d, want 1", s.Seed())
+	}
+}
+
+func TestByteCorrupter(t *testing.T) {
+	hits := func(rate float64, sizes ...int) []int {
+		corrupter := newByteCorrupter(rand.New(rand.NewSource(3)), rate)
+		var positions []int
+		offset := 0
+		for _, size := range sizes {
+			corrupter.each(make([]byte, size), func(i int) {
+				positions = append(positions, offset+i)
+			})
+			offset += size
+		}
+		return positions
+	}
+
+	if got := hits(0, 1000); len(got) != 0 {
+		t.Errorf("rate 0 hit %d bytes", len(got))
+	}
+	if got := hits(1, 10, 5); len(got) != 15 {
+		t.Errorf("rate 1 hit %d of 15 bytes", len(got))
+	}
+
+	// Payload boundaries must not move the hits
+	whole := hits(0.05, 4000)
+	if split := hits(0.05, 1000, 1, 999, 2000); !reflect.DeepEqual(split, whole) {
+		t.Errorf("split payloads hit %v, whole payload hit %v", split, whole)
+	}
+	if len(whole) < 100 || len(whole) > 300 {
+		t.Errorf("rate 0.05 hit %d of 4000 bytes", len(whole))
+	}
+}
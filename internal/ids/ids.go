@@ -0,0 +1,67 @@
+// Package ids generates the identifiers of jobs, sessions and the other
+// records moshr stores.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Crockford's base32 alphabet, which sorts in the same order as the values
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+	mu       sync.Mutex
+	lastTime uint64
+	lastRand [10]byte
+)
+
+// New returns a unique ID of the given kind, such as
+// "mosh_01J9Z3K6Q8W4T2M5R7X0B1C3D5". The part after the kind is a ULID: a
+// millisecond timestamp followed by 80 random bits, so IDs of one kind
+// sort in the order they were made. IDs made within the same millisecond
+// increment the random bits instead of drawing new ones.
+func New(kind string) string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := uint64(time.Now().UnixMilli())
+	if now <= lastTime {
+		// Same millisecond, or the clock went back: stay monotonic
+		now = lastTime
+		for i := len(lastRand) - 1; i >= 0; i-- {
+			lastRand[i]++
+			if lastRand[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(lastRand[:]); err != nil {
+			panic("ids: failed to read random bytes: " + err.Error())
+		}
+		lastTime = now
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], now<<16)
+	copy(id[6:], lastRand[:])
+
+	return kind + "_" + encode(id)
+}
+
+// encode writes 128 bits as 26 base32 characters, the first of which
+// holds only the top three bits.
+func encode(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = alphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
@@ -0,0 +1,101 @@
+package ids
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		id   [16]byte
+		want string
+	}{
+		{"zero", [16]byte{}, "00000000000000000000000000"},
+		{"one", [16]byte{15: 1}, "00000000000000000000000001"},
+		{"last bits", [16]byte{15: 0x3f}, "0000000000000000000000001Z"},
+		{"top bits", [16]byte{0: 0xe0}, "70000000000000000000000000"},
+		{
+			"all ones",
+			[16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			"7ZZZZZZZZZZZZZZZZZZZZZZZZZ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encode(tt.id); got != tt.want {
+				t.Errorf("encode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		kind string
+	}{
+		{"mosh"},
+		{"session"},
+		{"pipeline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			id := New(tt.kind)
+			suffix, ok := strings.CutPrefix(id, tt.kind+"_")
+			if !ok {
+				t.Fatalf("%s does not start with the kind", id)
+			}
+			if len(suffix) != 26 {
+				t.Errorf("%s has %d characters after the kind, want 26", id, len(suffix))
+			}
+			for _, c := range suffix {
+				if !strings.ContainsRune(alphabet, c) {
+					t.Errorf("%s holds %q, which is not in the alphabet", id, c)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSortsInOrder(t *testing.T) {
+	seen := make(map[string]bool)
+	last := ""
+	// Most of these fall in the same millisecond, which tests the increment
+	for i := 0; i < 10000; i++ {
+		id := New("mosh")
+		if seen[id] {
+			t.Fatalf("%s was made twice", id)
+		}
+		if id <= last {
+			t.Fatalf("%s sorts before the earlier %s", id, last)
+		}
+		seen[id] = true
+		last = id
+	}
+}
+
+func TestNewCarriesIntoHigherBytes(t *testing.T) {
+	// A time far ahead of the clock keeps New in the same millisecond
+	mu.Lock()
+	lastTime = 1 << 44
+	lastRand = [10]byte{8: 0xff, 9: 0xff}
+	mu.Unlock()
+
+	want := "mosh_" + encode([16]byte{0: 0x10, 13: 0x01})
+	if id := New("mosh"); id != want {
+		t.Errorf("New() = %s, want %s", id, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lastTime != 1<<44 {
+		t.Errorf("time moved to %d within the same millisecond", lastTime)
+	}
+	if lastRand != [10]byte{7: 0x01} {
+		t.Errorf("random bits = %x, want the carry in byte 7", lastRand)
+	}
+	// Leave the clock as it was for the other tests
+	lastTime = 0
+}
@@ -0,0 +1,200 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"moshr/internal/video"
+)
+
+// Composition item kinds
+const (
+	ItemClip = "clip"
+	ItemMosh = "mosh"
+)
+
+// Composition is a project's timeline: items played one after another on a
+// single track and rendered into one video.
+type Composition struct {
+	Items []CompositionItem `json:"items"`
+
+	// Output format; zero values are taken from the first item
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Framerate float64 `json:"framerate,omitempty"`
+	// Mute drops all audio instead of joining the items' tracks
+	Mute bool `json:"mute,omitempty"`
+
+	Renders   []CompositionRender `json:"renders,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// CompositionItem places part of a clip or a finished mosh on the track.
+type CompositionItem struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+
+	ClipID    string `json:"clip_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	MoshID    string `json:"mosh_id,omitempty"`
+
+	// In and Out points in seconds; an Out of zero plays to the end
+	In  float64 `json:"in"`
+	Out float64 `json:"out,omitempty"`
+
+	// Transition from the previous item, a cut unless set
+	Transition         string  `json:"transition,omitempty"`
+	TransitionDuration float64 `json:"transition_duration,omitempty"`
+}
+
+// CompositionRender is a finished render of the composition.
+type CompositionRender struct {
+	ID        string    `json:"id"`
+	FilePath  string    `json:"file_path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c Composition) Validate() error {
+	if c.Width < 0 || c.Height < 0 || c.Width%2 != 0 || c.Height%2 != 0 {
+		return fmt.Errorf("width and height must be even")
+	}
+	if c.Framerate < 0 {
+		return fmt.Errorf("framerate must not be negative")
+	}
+
+	for i, item := range c.Items {
+		if err := item.Validate(); err != nil {
+			return fmt.Errorf("item %d: %v", i+1, err)
+		}
+		if i == 0 && item.Transition == video.TransitionCrossfade {
+			return fmt.Errorf("item 1: the first item cannot crossfade")
+		}
+	}
+	return nil
+}
+
+func (item CompositionItem) Validate() error {
+	switch item.Kind {
+	case ItemClip:
+		if item.ClipID == "" {
+			return fmt.Errorf("clip items need a clip_id")
+		}
+	case ItemMosh:
+		if item.SessionID == "" || item.MoshID == "" {
+			return fmt.Errorf("mosh items need a session_id and mosh_id")
+		}
+	default:
+		return fmt.Errorf("unknown item kind %q", item.Kind)
+	}
+
+	if item.In < 0 {
+		return fmt.Errorf("in point must not be negative")
+	}
+	if item.Out != 0 && item.Out <= item.In {
+		return fmt.Errorf("out point must be after the in point")
+	}
+
+	switch item.Transition {
+	case "", video.TransitionCut:
+	case video.TransitionCrossfade:
+		if item.TransitionDuration <= 0 {
+			return fmt.Errorf("crossfade needs a positive transition_duration")
+		}
+		if item.Out != 0 && item.TransitionDuration >= item.Out-item.In {
+			return fmt.Errorf("crossfade must be shorter than the item")
+		}
+	default:
+		return fmt.Errorf("unknown transition %q", item.Transition)
+	}
+	return nil
+}
+
+func (m *Manager) SaveComposition(projectID string, composition *Composition) error {
+	composition.UpdatedAt = time.Now()
+
+	compositionFile := filepath.Join(m.projectsDir, projectID, "composition.json")
+	data, err := json.MarshalIndent(composition, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(compositionFile, data, 0644)
+}
+
+// LoadComposition returns the project's composition, or an empty one if
+// nothing has been placed on the track yet.
+func (m *Manager) LoadComposition(projectID string) (*Composition, error) {
+	compositionFile := filepath.Join(m.projectsDir, projectID, "composition.json")
+
+	if _, err := os.Stat(compositionFile); os.IsNotExist(err) {
+		return &Composition{Items: []CompositionItem{}}, nil
+	}
+
+	data, err := os.ReadFile(compositionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var composition Composition
+	err = json.Unmarshal(data, &composition)
+	if err != nil {
+		return nil, err
+	}
+
+	if composition.Items == nil {
+		composition.Items = []CompositionItem{}
+	}
+	return &composition, nil
+}
+
+// Segments resolves the composition's items to the files they play, in
+// track order.
+func (m *Manager) Segments(projectID string, composition *Composition) ([]video.Segment, error) {
+	clips, err := m.LoadClips(projectID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := m.LoadMoshSessions(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]video.Segment, 0, len(composition.Items))
+	for i, item := range composition.Items {
+		path := ""
+		switch item.Kind {
+		case ItemClip:
+			for _, clip := range clips {
+				if clip.ID == item.ClipID {
+					path = clip.FilePath
+				}
+			}
+		case ItemMosh:
+			for _, session := range sessions {
+				if session.ID != item.SessionID {
+					continue
+				}
+				for _, mosh := range session.Moshes {
+					if mosh.ID == item.MoshID {
+						path = mosh.FilePath
+					}
+				}
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("item %d: %s not found", i+1, item.Kind)
+		}
+
+		segments = append(segments, video.Segment{
+			Path:               path,
+			In:                 item.In,
+			Out:                item.Out,
+			Transition:         item.Transition,
+			TransitionDuration: item.TransitionDuration,
+		})
+	}
+	return segments, nil
+}
@@ -0,0 +1,131 @@
+package project
+
+import (
+	"testing"
+
+	"moshr/internal/video"
+)
+
+func TestCompositionValidate(t *testing.T) {
+	clip := CompositionItem{Kind: ItemClip, ClipID: "c1"}
+	mosh := CompositionItem{Kind: ItemMosh, SessionID: "s1", MoshID: "m1"}
+	with := func(item CompositionItem, edit func(*CompositionItem)) CompositionItem {
+		edit(&item)
+		return item
+	}
+	crossfade := func(item *CompositionItem) {
+		item.Transition = video.TransitionCrossfade
+		item.TransitionDuration = 0.5
+	}
+
+	tests := []struct {
+		name        string
+		composition Composition
+		wantErr     bool
+	}{
+		{"empty", Composition{}, false},
+		{"clip and mosh", Composition{Items: []CompositionItem{clip, with(mosh, crossfade)}, Width: 640, Height: 360, Framerate: 30}, false},
+		{"odd width", Composition{Width: 641, Height: 360}, true},
+		{"negative framerate", Composition{Framerate: -1}, true},
+		{"first item crossfades", Composition{Items: []CompositionItem{with(clip, crossfade)}}, true},
+		{"clip without an ID", Composition{Items: []CompositionItem{{Kind: ItemClip}}}, true},
+		{"mosh without a session", Composition{Items: []CompositionItem{{Kind: ItemMosh, MoshID: "m1"}}}, true},
+		{"unknown kind", Composition{Items: []CompositionItem{{Kind: "title"}}}, true},
+		{"negative in point", Composition{Items: []CompositionItem{with(clip, func(i *CompositionItem) { i.In = -1 })}}, true},
+		{"out before in", Composition{Items: []CompositionItem{with(clip, func(i *CompositionItem) { i.In, i.Out = 2, 1 })}}, true},
+		{"crossfade without a duration", Composition{Items: []CompositionItem{clip, with(mosh, func(i *CompositionItem) {
+			i.Transition = video.TransitionCrossfade
+		})}}, true},
+		{"crossfade longer than the item", Composition{Items: []CompositionItem{clip, with(mosh, func(i *CompositionItem) {
+			crossfade(i)
+			i.In, i.Out = 1, 1.5
+		})}}, true},
+		{"unknown transition", Composition{Items: []CompositionItem{clip, with(mosh, func(i *CompositionItem) { i.Transition = "wipe" })}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.composition.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompositionRoundTrip(t *testing.T) {
+	m := testManager(t, "p1")
+
+	empty, err := m.LoadComposition("p1")
+	if err != nil {
+		t.Fatalf("LoadComposition failed: %v", err)
+	}
+	if empty.Items == nil || len(empty.Items) != 0 {
+		t.Errorf("a new project's composition has items %v", empty.Items)
+	}
+
+	composition := &Composition{Items: []CompositionItem{{ID: "i1", Kind: ItemClip, ClipID: "c1", Out: 2}}, Width: 640, Height: 360}
+	if err := m.SaveComposition("p1", composition); err != nil {
+		t.Fatalf("SaveComposition failed: %v", err)
+	}
+	if composition.UpdatedAt.IsZero() {
+		t.Error("saving did not stamp the composition")
+	}
+
+	loaded, err := m.LoadComposition("p1")
+	if err != nil {
+		t.Fatalf("LoadComposition failed: %v", err)
+	}
+	if len(loaded.Items) != 1 || loaded.Items[0] != composition.Items[0] || loaded.Width != 640 {
+		t.Errorf("loaded %+v, want %+v", loaded, composition)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	m := testManager(t, "p1")
+	if err := m.SaveClips("p1", []ClipMetadata{{ID: "c1", FilePath: "clips/c1.avi"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveMoshSession("p1", MoshSession{ID: "s1", Moshes: []MoshMetadata{{ID: "m1", FilePath: "moshes/s1/m1.avi"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		items   []CompositionItem
+		want    []video.Segment
+		wantErr bool
+	}{
+		{
+			"clip then mosh",
+			[]CompositionItem{
+				{Kind: ItemClip, ClipID: "c1", In: 1, Out: 3},
+				{Kind: ItemMosh, SessionID: "s1", MoshID: "m1", Transition: video.TransitionCrossfade, TransitionDuration: 0.5},
+			},
+			[]video.Segment{
+				{Path: "clips/c1.avi", In: 1, Out: 3},
+				{Path: "moshes/s1/m1.avi", Transition: video.TransitionCrossfade, TransitionDuration: 0.5},
+			},
+			false,
+		},
+		{"missing clip", []CompositionItem{{Kind: ItemClip, ClipID: "c2"}}, nil, true},
+		{"mosh in another session", []CompositionItem{{Kind: ItemMosh, SessionID: "s2", MoshID: "m1"}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, err := m.Segments("p1", &Composition{Items: tt.items})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Segments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(segments) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(tt.want))
+			}
+			for i := range tt.want {
+				if segments[i] != tt.want[i] {
+					t.Errorf("segment %d = %+v, want %+v", i, segments[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -2,12 +2,17 @@ package project
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"moshr/internal/effects"
+	"moshr/internal/ids"
+	"moshr/internal/video"
 )
 
 type Project struct {
@@ -18,6 +23,41 @@ type Project struct {
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 	BasePath      string    `json:"base_path"`
+
+	// Conversions are the AVIs the upload was converted to, one per set
+	// of intermediate options. ConvertedFile is the latest of them.
+	Conversions []Conversion `json:"conversions,omitempty"`
+}
+
+// Conversion is an AVI a project's upload was converted to.
+type Conversion struct {
+	Name      string           `json:"name"`
+	Options   video.AVIOptions `json:"options"`
+	Path      string           `json:"path"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// RecordConversion records the AVI at path, converted with opts, and makes
+// it the project's converted file.
+func (p *Project) RecordConversion(opts video.AVIOptions, path string) {
+	conversion := Conversion{
+		Name:      opts.Name(),
+		Options:   opts,
+		Path:      path,
+		CreatedAt: time.Now(),
+	}
+	if conversion.Name == "" {
+		conversion.Name = "default"
+	}
+
+	p.ConvertedFile = path
+	for i := range p.Conversions {
+		if p.Conversions[i].Path == path {
+			p.Conversions[i] = conversion
+			return
+		}
+	}
+	p.Conversions = append(p.Conversions, conversion)
 }
 
 type ClipMetadata struct {
@@ -34,6 +74,7 @@ type ClipMetadata struct {
 
 type MoshSession struct {
 	ID        string         `json:"id"`
+	ProjectID string         `json:"project_id,omitempty"`
 	Name      string         `json:"name"`
 	CreatedAt time.Time      `json:"created_at"`
 	Source    string         `json:"source"`
@@ -46,6 +87,54 @@ type MoshMetadata struct {
 	FilePath  string                 `json:"file_path"`
 	Params    map[string]interface{} `json:"params"`
 	CreatedAt time.Time              `json:"created_at"`
+
+	// Everything needed to render the mosh again: the source it was made
+	// from, the seed and the random values the seed resolved to.
+	InputPath      string                 `json:"input_path,omitempty"`
+	Seed           int64                  `json:"seed,omitempty"`
+	ResolvedParams map[string]interface{} `json:"resolved_params,omitempty"`
+
+	// Steps of a chain mosh in the order they were run
+	Steps []effects.Step `json:"steps,omitempty"`
+
+	// Mask the effect was limited to, if any
+	Mask *video.Mask `json:"mask,omitempty"`
+
+	// Parameter values of a mosh rendered by a sweep, and their label
+	SweepPoint map[string]interface{} `json:"sweep_point,omitempty"`
+	Label      string                 `json:"label,omitempty"`
+
+	// Exports made of the mosh, one per profile
+	Exports []ExportRecord `json:"exports,omitempty"`
+}
+
+// ExportRecord is an export of a mosh made with an export profile. Path is
+// a directory for image sequences.
+type ExportRecord struct {
+	Profile   string    `json:"profile"`
+	Container string    `json:"container"`
+	Path      string    `json:"path"`
+	JobID     string    `json:"job_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export returns the mosh's export made with a profile.
+func (m *MoshMetadata) Export(profile string) (*ExportRecord, bool) {
+	for i := range m.Exports {
+		if m.Exports[i].Profile == profile {
+			return &m.Exports[i], true
+		}
+	}
+	return nil, false
+}
+
+// MaskMetadata is a mask saved with a project so it can be reused by any
+// mosh in it.
+type MaskMetadata struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Mask      video.Mask `json:"mask"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 type Manager struct {
@@ -75,7 +164,7 @@ func (m *Manager) CreateProject(originalFileName string) (*Project, error) {
 		return nil, err
 	}
 
-	subdirs := []string{"timeline", "clips", "moshes"}
+	subdirs := []string{"timeline", "clips", "moshes", "masks", "renders"}
 	for _, dir := range subdirs {
 		err := os.MkdirAll(filepath.Join(projectPath, dir), 0755)
 		if err != nil {
@@ -182,6 +271,82 @@ func (m *Manager) LoadClips(projectID string) ([]ClipMetadata, error) {
 	return clips, nil
 }
 
+func (m *Manager) SaveMasks(projectID string, masks []MaskMetadata) error {
+	masksDir := filepath.Join(m.projectsDir, projectID, "masks")
+	if err := os.MkdirAll(masksDir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(masks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(masksDir, "masks.json"), data, 0644)
+}
+
+func (m *Manager) LoadMasks(projectID string) ([]MaskMetadata, error) {
+	masksFile := filepath.Join(m.projectsDir, projectID, "masks", "masks.json")
+
+	if _, err := os.Stat(masksFile); os.IsNotExist(err) {
+		return []MaskMetadata{}, nil
+	}
+
+	data, err := os.ReadFile(masksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var masks []MaskMetadata
+	err = json.Unmarshal(data, &masks)
+	if err != nil {
+		return nil, err
+	}
+
+	return masks, nil
+}
+
+// LoadMask returns a single saved mask.
+func (m *Manager) LoadMask(projectID, maskID string) (*MaskMetadata, error) {
+	masks, err := m.LoadMasks(projectID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range masks {
+		if masks[i].ID == maskID {
+			return &masks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("mask %s not found", maskID)
+}
+
+// LoadExportProfiles returns the export profiles users defined, which are
+// shared by every project.
+func (m *Manager) LoadExportProfiles() ([]video.ExportProfile, error) {
+	data, err := os.ReadFile(filepath.Join(m.projectsDir, "export_profiles.json"))
+	if os.IsNotExist(err) {
+		return []video.ExportProfile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []video.ExportProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+func (m *Manager) SaveExportProfiles(profiles []video.ExportProfile) error {
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(m.projectsDir, "export_profiles.json"), data, 0644)
+}
+
 func (m *Manager) SaveMoshSession(projectID string, session MoshSession) error {
 	sessionDir := filepath.Join(m.projectsDir, projectID, "moshes", session.ID)
 	err := os.MkdirAll(sessionDir, 0755)
@@ -226,6 +391,63 @@ func (m *Manager) LoadMoshSessions(projectID string) ([]MoshSession, error) {
 	return sessions, nil
 }
 
+// ErrNotFound is returned by the lookups when no project holds the ID.
+var ErrNotFound = errors.New("not found")
+
+// FindSession returns the project holding a mosh session and the session.
+// A session whose moshes have not finished yet has a directory but no
+// metadata, and comes back with only its ID and project set.
+func (m *Manager) FindSession(sessionID string) (string, *MoshSession, error) {
+	if sessionID == "" || sessionID != filepath.Base(sessionID) {
+		return "", nil, ErrNotFound
+	}
+
+	projects, err := m.ListProjects()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, project := range projects {
+		sessionDir := filepath.Join(m.projectsDir, project.ID, "moshes", sessionID)
+		if _, err := os.Stat(sessionDir); err != nil {
+			continue
+		}
+
+		session := MoshSession{ID: sessionID}
+		if data, err := os.ReadFile(filepath.Join(sessionDir, "session.json")); err == nil {
+			json.Unmarshal(data, &session)
+		}
+		session.ProjectID = project.ID
+		return project.ID, &session, nil
+	}
+	return "", nil, ErrNotFound
+}
+
+// FindMosh returns the project and session holding a finished mosh, along
+// with its metadata.
+func (m *Manager) FindMosh(moshID string) (string, *MoshSession, *MoshMetadata, error) {
+	projects, err := m.ListProjects()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	for _, project := range projects {
+		sessions, err := m.LoadMoshSessions(project.ID)
+		if err != nil {
+			continue
+		}
+		for i := range sessions {
+			for j := range sessions[i].Moshes {
+				if sessions[i].Moshes[j].ID == moshID {
+					sessions[i].ProjectID = project.ID
+					return project.ID, &sessions[i], &sessions[i].Moshes[j], nil
+				}
+			}
+		}
+	}
+	return "", nil, nil, ErrNotFound
+}
+
 func (m *Manager) SaveScenes(projectID string, scenes interface{}) error {
 	scenesFile := filepath.Join(m.projectsDir, projectID, "scenes.json")
 	data, err := json.MarshalIndent(scenes, "", "  ")
@@ -264,6 +486,8 @@ func (m *Manager) GetProjectPaths(projectID string) map[string]string {
 		"timeline": filepath.Join(basePath, "timeline"),
 		"clips":    filepath.Join(basePath, "clips"),
 		"moshes":   filepath.Join(basePath, "moshes"),
+		"masks":    filepath.Join(basePath, "masks"),
+		"renders":  filepath.Join(basePath, "renders"),
 	}
 }
 
@@ -355,7 +579,7 @@ func (m *Manager) RecoverOrphanedClips(projectID string) error {
 				}
 
 				clip := ClipMetadata{
-					ID:         fmt.Sprintf("recovered_%d", time.Now().UnixNano()),
+					ID:         ids.New("clip"),
 					Name:       name,
 					StartFrame: startFrame,
 					EndFrame:   endFrame,
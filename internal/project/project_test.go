@@ -0,0 +1,206 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"moshr/internal/video"
+)
+
+// testManager returns a manager over a temporary projects directory that
+// holds the directories of one project.
+func testManager(t *testing.T, projectID string) *Manager {
+	t.Helper()
+	m := &Manager{projectsDir: t.TempDir()}
+	for _, dir := range []string{"clips", "moshes", "masks"} {
+		if err := os.MkdirAll(filepath.Join(m.projectsDir, projectID, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestMasksRoundTrip(t *testing.T) {
+	m := testManager(t, "clip_1")
+
+	masks, err := m.LoadMasks("clip_1")
+	if err != nil {
+		t.Fatalf("LoadMasks failed: %v", err)
+	}
+	if len(masks) != 0 {
+		t.Fatalf("a project without masks has %d", len(masks))
+	}
+
+	saved := []MaskMetadata{
+		{ID: "a", Name: "corner", Mask: video.Mask{Kind: video.MaskRect, Width: 0.5, Height: 0.5}, CreatedAt: time.Unix(100, 0).UTC()},
+		{ID: "b", Name: "sky", Mask: video.Mask{Kind: video.MaskLuma, Low: 0.7, High: 1, Invert: true}, CreatedAt: time.Unix(200, 0).UTC()},
+	}
+	if err := m.SaveMasks("clip_1", saved); err != nil {
+		t.Fatalf("SaveMasks failed: %v", err)
+	}
+
+	masks, err = m.LoadMasks("clip_1")
+	if err != nil {
+		t.Fatalf("LoadMasks failed: %v", err)
+	}
+	if !reflect.DeepEqual(masks, saved) {
+		t.Errorf("loaded %+v, want %+v", masks, saved)
+	}
+
+	mask, err := m.LoadMask("clip_1", "b")
+	if err != nil {
+		t.Fatalf("LoadMask failed: %v", err)
+	}
+	if mask.Name != "sky" {
+		t.Errorf("LoadMask(b) returned %s", mask.Name)
+	}
+	if _, err := m.LoadMask("clip_1", "c"); err == nil {
+		t.Error("expected an error for a missing mask")
+	}
+}
+
+func TestFindSessionAndMosh(t *testing.T) {
+	m := testManager(t, "project_1")
+	for _, id := range []string{"project_1", "project_2"} {
+		project := &Project{ID: id, BasePath: filepath.Join(m.projectsDir, id)}
+		if err := os.MkdirAll(project.BasePath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SaveProject(project); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := MoshSession{ID: "session_a", Name: "first", Moshes: []MoshMetadata{{ID: "mosh_a"}, {ID: "mosh_b"}}}
+	if err := m.SaveMoshSession("project_2", session); err != nil {
+		t.Fatal(err)
+	}
+	// A running session has a directory but no metadata yet
+	if err := os.MkdirAll(filepath.Join(m.projectsDir, "project_1", "moshes", "session_b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := []struct {
+		id          string
+		wantProject string
+		wantName    string
+	}{
+		{"session_a", "project_2", "first"},
+		{"session_b", "project_1", ""},
+		{"session_c", "", ""},
+		{"", "", ""},
+		{"../project_1", "", ""},
+	}
+	for _, tt := range sessions {
+		projectID, session, err := m.FindSession(tt.id)
+		if tt.wantProject == "" {
+			if err != ErrNotFound {
+				t.Errorf("FindSession(%q) error = %v, want ErrNotFound", tt.id, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("FindSession(%q) failed: %v", tt.id, err)
+			continue
+		}
+		if projectID != tt.wantProject || session.ProjectID != tt.wantProject || session.ID != tt.id || session.Name != tt.wantName {
+			t.Errorf("FindSession(%q) = %s, %+v", tt.id, projectID, session)
+		}
+	}
+
+	moshes := []struct {
+		id          string
+		wantProject string
+	}{
+		{"mosh_a", "project_2"},
+		{"mosh_b", "project_2"},
+		{"mosh_c", ""},
+	}
+	for _, tt := range moshes {
+		projectID, session, mosh, err := m.FindMosh(tt.id)
+		if tt.wantProject == "" {
+			if err != ErrNotFound {
+				t.Errorf("FindMosh(%q) error = %v, want ErrNotFound", tt.id, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("FindMosh(%q) failed: %v", tt.id, err)
+			continue
+		}
+		if projectID != tt.wantProject || session.ID != "session_a" || mosh.ID != tt.id {
+			t.Errorf("FindMosh(%q) = %s, session %s, mosh %s", tt.id, projectID, session.ID, mosh.ID)
+		}
+	}
+}
+
+func TestExportProfilesRoundTrip(t *testing.T) {
+	m := testManager(t, "project_1")
+
+	profiles, err := m.LoadExportProfiles()
+	if err != nil || len(profiles) != 0 {
+		t.Fatalf("LoadExportProfiles() = %v, %v, want none", profiles, err)
+	}
+
+	saved := []video.ExportProfile{
+		{Name: "small_gif", Container: video.ContainerGIF, Width: 320, Colors: 64},
+		{Name: "edit", Container: video.ContainerMOV, Codec: "prores_ks", Profile: "lt", Audio: "pcm_s16le"},
+	}
+	if err := m.SaveExportProfiles(saved); err != nil {
+		t.Fatalf("SaveExportProfiles failed: %v", err)
+	}
+	profiles, err = m.LoadExportProfiles()
+	if err != nil {
+		t.Fatalf("LoadExportProfiles failed: %v", err)
+	}
+	if !reflect.DeepEqual(profiles, saved) {
+		t.Errorf("loaded %+v, want %+v", profiles, saved)
+	}
+}
+
+func TestMoshMetadataExport(t *testing.T) {
+	mosh := MoshMetadata{Exports: []ExportRecord{
+		{Profile: "mp4", Path: "moshed_mp4.mp4"},
+		{Profile: "png", Path: "moshed_png"},
+	}}
+
+	tests := []struct {
+		profile  string
+		wantPath string
+	}{
+		{"mp4", "moshed_mp4.mp4"},
+		{"png", "moshed_png"},
+		{"gif", ""},
+	}
+	for _, tt := range tests {
+		record, ok := mosh.Export(tt.profile)
+		if ok != (tt.wantPath != "") || (ok && record.Path != tt.wantPath) {
+			t.Errorf("Export(%s) = %+v, %v", tt.profile, record, ok)
+		}
+	}
+}
+
+func TestRecordConversion(t *testing.T) {
+	mpeg4 := video.AVIOptions{Codec: video.IntermediateMPEG4, GOP: 300}
+	project := &Project{}
+
+	project.RecordConversion(video.AVIOptions{}, "clips/converted.avi")
+	project.RecordConversion(mpeg4, "clips/converted_mpeg4_g300.avi")
+	project.RecordConversion(video.AVIOptions{}, "clips/converted.avi")
+
+	if project.ConvertedFile != "clips/converted.avi" {
+		t.Errorf("converted file = %s, want the latest conversion", project.ConvertedFile)
+	}
+	var names []string
+	for _, conversion := range project.Conversions {
+		names = append(names, conversion.Name)
+	}
+	if want := []string{"default", "mpeg4_g300"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("conversions %v, want %v with the repeat replacing the first", names, want)
+	}
+	if project.Conversions[1].Options != mpeg4 {
+		t.Errorf("conversion options = %+v", project.Conversions[1].Options)
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"moshr/internal/batch"
+	"moshr/internal/ids"
+	projectpkg "moshr/internal/project"
+	"moshr/internal/video"
+)
+
+func (s *Server) handleGetComposition(c *gin.Context) {
+	projectID := c.Param("id")
+
+	composition, err := s.projectManager.LoadComposition(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load composition"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"composition": composition})
+}
+
+// handleUpdateComposition replaces the track and output settings. Items
+// sent without an ID are given one; past renders are kept.
+func (s *Server) handleUpdateComposition(c *gin.Context) {
+	projectID := c.Param("id")
+
+	if _, err := s.projectManager.LoadProject(projectID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	existing, err := s.projectManager.LoadComposition(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load composition"})
+		return
+	}
+
+	var req projectpkg.Composition
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Items == nil {
+		req.Items = []projectpkg.CompositionItem{}
+	}
+	for i := range req.Items {
+		if req.Items[i].ID == "" {
+			req.Items[i].ID = newItemID()
+		}
+	}
+	req.Renders = existing.Renders
+
+	s.saveComposition(c, projectID, &req)
+}
+
+// handleAddCompositionItem appends an item to the track, or inserts it
+// before the zero-based position given in the query.
+func (s *Server) handleAddCompositionItem(c *gin.Context) {
+	projectID := c.Param("id")
+
+	composition, err := s.projectManager.LoadComposition(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load composition"})
+		return
+	}
+
+	var item projectpkg.CompositionItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	item.ID = newItemID()
+
+	position, ok := itemPosition(c, len(composition.Items))
+	if !ok {
+		return
+	}
+	composition.Items = append(composition.Items, projectpkg.CompositionItem{})
+	copy(composition.Items[position+1:], composition.Items[position:])
+	composition.Items[position] = item
+
+	s.saveComposition(c, projectID, composition)
+}
+
+// handleUpdateCompositionItem replaces one item, and moves it when a
+// position is given in the query.
+func (s *Server) handleUpdateCompositionItem(c *gin.Context) {
+	projectID := c.Param("id")
+	itemID := c.Param("itemId")
+
+	composition, err := s.projectManager.LoadComposition(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load composition"})
+		return
+	}
+
+	index := findItem(composition, itemID)
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	var item projectpkg.CompositionItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	item.ID = itemID
+
+	composition.Items = append(composition.Items[:index], composition.Items[index+1:]...)
+	position, ok := itemPosition(c, len(composition.Items))
+	if !ok {
+		return
+	}
+	if c.Query("position") == "" {
+		position = index
+	}
+	composition.Items = append(composition.Items, projectpkg.CompositionItem{})
+	copy(composition.Items[position+1:], composition.Items[position:])
+	composition.Items[position] = item
+
+	s.saveComposition(c, projectID, composition)
+}
+
+func (s *Server) handleDeleteCompositionItem(c *gin.Context) {
+	projectID := c.Param("id")
+	itemID := c.Param("itemId")
+
+	composition, err := s.projectManager.LoadComposition(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load composition"})
+		return
+	}
+
+	index := findItem(composition, itemID)
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+	composition.Items = append(composition.Items[:index], composition.Items[index+1:]...)
+
+	// The new first item has nothing to crossfade from
+	if index == 0 && len(composition.Items) > 0 && composition.Items[0].Transition == video.TransitionCrossfade {
+		composition.Items[0].Transition = video.TransitionCut
+		composition.Items[0].TransitionDuration = 0
+	}
+
+	s.saveComposition(c, projectID, composition)
+}
+
+// handleRenderComposition queues a render of the track into the project's
+// renders directory. Set "format" to "avi" for a render that can be moshed
+// again.
+func (s *Server) handleRenderComposition(c *gin.Context) {
+	projectID := c.Param("id")
+
+	composition, err := s.projectManager.LoadComposition(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load composition"})
+		return
+	}
+
+	var req struct {
+		Format string `json:"format"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	switch req.Format {
+	case "":
+		req.Format = "mp4"
+	case "mp4", "avi":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be mp4 or avi"})
+		return
+	}
+
+	if len(composition.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Composition has no items"})
+		return
+	}
+	if err := composition.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	segments, err := s.projectManager.Segments(projectID, composition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := video.ConformOptions{
+		Width:     composition.Width,
+		Height:    composition.Height,
+		Framerate: composition.Framerate,
+		Audio:     !composition.Mute,
+	}
+	if opts.Width == 0 || opts.Height == 0 || opts.Framerate == 0 {
+		info, err := s.converter.GetVideoInfo(segments[0].Path)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze first item"})
+			return
+		}
+		if opts.Width == 0 || opts.Height == 0 {
+			// Encoders need even dimensions
+			opts.Width, opts.Height = info.Width&^1, info.Height&^1
+		}
+		if opts.Framerate == 0 {
+			opts.Framerate = info.Framerate
+		}
+	}
+
+	rendersDir := s.projectManager.GetProjectPaths(projectID)["renders"]
+	if err := os.MkdirAll(rendersDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create renders directory"})
+		return
+	}
+
+	// The render runs in the queue and is added to the composition when it
+	// completes; its progress reaches clients over the WebSocket under the
+	// job ID
+	job := &batch.Mosh{
+		ID:        ids.New(batch.KindComposition),
+		Kind:      batch.KindComposition,
+		ProjectID: projectID,
+		OutputDir: rendersDir,
+		Format:    req.Format,
+		Segments:  segments,
+		Conform:   &opts,
+	}
+	s.processor.AddMosh(job)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Render queued",
+		"job_id":      job.ID,
+		"output_file": filepath.Base(batch.JobOutputPath(job)),
+	})
+}
+
+func (s *Server) saveComposition(c *gin.Context, projectID string, composition *projectpkg.Composition) {
+	if err := composition.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.projectManager.SaveComposition(projectID, composition); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save composition"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"composition": composition})
+}
+
+func findItem(composition *projectpkg.Composition, itemID string) int {
+	for i, item := range composition.Items {
+		if item.ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// itemPosition reads the optional "position" query parameter, defaulting to
+// the end of a track of n items. It writes the error response when the
+// position is invalid.
+func itemPosition(c *gin.Context, n int) (int, bool) {
+	query := c.Query("position")
+	if query == "" {
+		return n, true
+	}
+
+	var position int
+	if _, err := fmt.Sscanf(query, "%d", &position); err != nil || position < 0 || position > n {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Position must be between 0 and %d", n)})
+		return 0, false
+	}
+	return position, true
+}
+
+func newItemID() string {
+	return ids.New("item")
+}
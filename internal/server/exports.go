@@ -0,0 +1,207 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	projectpkg "moshr/internal/project"
+	"moshr/internal/video"
+)
+
+// exportProfiles returns the built-in export profiles followed by the ones
+// users defined.
+func (s *Server) exportProfiles() ([]video.ExportProfile, error) {
+	custom, err := s.projectManager.LoadExportProfiles()
+	if err != nil {
+		return nil, err
+	}
+	return append(video.BuiltinExportProfiles(), custom...), nil
+}
+
+// exportProfile looks up an export profile by name.
+func (s *Server) exportProfile(name string) (*video.ExportProfile, error) {
+	profiles, err := s.exportProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return &profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown export profile %q", name)
+}
+
+func (s *Server) handleListExportProfiles(c *gin.Context) {
+	profiles, err := s.exportProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles})
+}
+
+// handleSaveExportProfile creates or replaces a user defined export
+// profile. Built-in profiles cannot be replaced.
+func (s *Server) handleSaveExportProfile(c *gin.Context) {
+	name := c.Param("name")
+
+	var profile video.ExportProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	profile.Name = name
+	profile.Builtin = false
+	if err := profile.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, builtin := range video.BuiltinExportProfiles() {
+		if builtin.Name == name {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is a built-in profile", name)})
+			return
+		}
+	}
+
+	profiles, err := s.projectManager.LoadExportProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	replaced := false
+	for i := range profiles {
+		if profiles[i].Name == name {
+			profiles[i] = profile
+			replaced = true
+		}
+	}
+	if !replaced {
+		profiles = append(profiles, profile)
+	}
+
+	if err := s.projectManager.SaveExportProfiles(profiles); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save export profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
+}
+
+func (s *Server) handleDeleteExportProfile(c *gin.Context) {
+	name := c.Param("name")
+
+	profiles, err := s.projectManager.LoadExportProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	kept := make([]video.ExportProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.Name != name {
+			kept = append(kept, profile)
+		}
+	}
+	if len(kept) == len(profiles) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export profile not found"})
+		return
+	}
+
+	if err := s.projectManager.SaveExportProfiles(kept); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save export profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Export profile deleted"})
+}
+
+// moshExports returns the exports of a mosh that still exist. Exports
+// made before they were recorded with their mosh are found by the names
+// they were given then.
+func (s *Server) moshExports(projectID, sessionID, moshID string) []projectpkg.ExportRecord {
+	exports := []projectpkg.ExportRecord{}
+	seen := make(map[string]bool)
+
+	if _, _, metadata, err := s.projectManager.FindMosh(moshID); err == nil {
+		for _, export := range metadata.Exports {
+			if _, err := os.Stat(export.Path); err == nil {
+				exports = append(exports, export)
+				seen[export.Profile] = true
+			}
+		}
+	}
+
+	sessionDir := filepath.Join(s.projectManager.GetProjectPaths(projectID)["moshes"], sessionID)
+	for _, format := range []string{"mp4", "webm"} {
+		path := filepath.Join(sessionDir, fmt.Sprintf("moshed_%s_converted.%s", moshID, format))
+		if _, err := os.Stat(path); err == nil && !seen[format] {
+			exports = append(exports, projectpkg.ExportRecord{Profile: format, Container: format, Path: path})
+		}
+	}
+	return exports
+}
+
+// handleJobExports lists the exports made of a mosh.
+func (s *Server) handleJobExports(c *gin.Context) {
+	moshID := c.Param("id")
+
+	location, err := s.locateJob(moshID)
+	if errors.Is(err, projectpkg.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mosh_id": moshID,
+		"exports": s.moshExports(location.ProjectID, location.SessionID, moshID),
+	})
+}
+
+// handleDownloadExport serves the export of a mosh made with a profile.
+// Image sequences are sent as a zip of their frames.
+func (s *Server) handleDownloadExport(c *gin.Context) {
+	moshID := c.Param("id")
+	profile := c.Param("profile")
+
+	location, err := s.locateJob(moshID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	for _, export := range s.moshExports(location.ProjectID, location.SessionID, moshID) {
+		if export.Profile != profile {
+			continue
+		}
+
+		info, err := os.Stat(export.Path)
+		if err != nil {
+			break
+		}
+		if !info.IsDir() {
+			c.FileAttachment(export.Path, filepath.Base(export.Path))
+			return
+		}
+
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(export.Path)+".zip"))
+		if err := video.ZipFrames(c.Writer, export.Path); err != nil {
+			fmt.Printf("Failed to send export %s: %v\n", export.Path, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,7 +14,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"moshr/internal/batch"
+	"moshr/internal/cache"
 	"moshr/internal/effects"
+	"moshr/internal/ids"
 	projectpkg "moshr/internal/project"
 	"moshr/internal/video"
 )
@@ -28,15 +31,21 @@ type Server struct {
 	wsHub          *WSHub
 }
 
-func NewServer() *Server {
+func NewServer(opts Options) *Server {
 	wsHub := NewWSHub()
 	go wsHub.Run()
 
 	converter := video.NewConverter()
-	processor := batch.NewBatchProcessor(2, wsHub, converter)
-	processor.Start()
+	processor := batch.NewBatchProcessor(opts.Workers, wsHub, converter)
+	processor.SetMaxRunTime("", opts.MaxRunTime)
+	if opts.MaxSweepJobs > 0 {
+		processor.SetMaxSweepJobs(opts.MaxSweepJobs)
+	}
+	for effect, limit := range opts.EffectMaxRunTimes {
+		processor.SetMaxRunTime(effect, limit)
+	}
 
-	return &Server{
+	s := &Server{
 		processor:      processor,
 		converter:      converter,
 		analyzer:       video.NewAnalyzer(),
@@ -45,6 +54,22 @@ func NewServer() *Server {
 		projectManager: projectpkg.NewManager(),
 		wsHub:          wsHub,
 	}
+
+	if opts.CacheMaxBytes > 0 {
+		renderCache, err := cache.Open(filepath.Join("projects", "cache"), opts.CacheMaxBytes)
+		if err != nil {
+			fmt.Printf("Failed to open render cache: %v\n", err)
+		} else {
+			processor.SetCache(renderCache)
+		}
+	}
+	processor.SetOnComplete(s.jobCompleted)
+	if err := processor.Restore(filepath.Join("projects", "queue.json")); err != nil {
+		fmt.Printf("Failed to restore render queue: %v\n", err)
+	}
+	processor.Start()
+
+	return s
 }
 
 func (s *Server) SetupRoutes() *gin.Engine {
@@ -64,20 +89,50 @@ func (s *Server) SetupRoutes() *gin.Engine {
 
 		api.POST("/projects/:id/upload", s.handleUpload)
 		api.POST("/projects/:id/convert", s.handleConvert)
+		api.GET("/effects", s.handleListEffects)
 		api.POST("/projects/:id/mosh", s.handleMosh)
+		api.POST("/projects/:id/transfer", s.handleTransfer)
+		api.POST("/projects/:id/pipelines", s.handleCreatePipeline)
 		api.GET("/projects/:id/moshes", s.handleGetMoshes)
 		api.GET("/projects/:id/moshes/:moshId", s.handleGetMosh)
-		api.GET("/projects/:id/preview/:filename", s.handlePreview)
 		api.POST("/projects/:id/scenes", s.handleDetectScenes)
 		api.POST("/projects/:id/timeline", s.handleGenerateTimeline)
 		api.POST("/projects/:id/clip", s.handleExtractClip)
 		api.DELETE("/projects/:id/clips/:clipId", s.handleDeleteClip)
+		api.GET("/projects/:id/masks", s.handleListMasks)
+		api.POST("/projects/:id/masks", s.handleCreateMask)
+		api.POST("/projects/:id/masks/upload", s.handleUploadMask)
+		api.DELETE("/projects/:id/masks/:maskId", s.handleDeleteMask)
+		api.GET("/projects/:id/composition", s.handleGetComposition)
+		api.PUT("/projects/:id/composition", s.handleUpdateComposition)
+		api.POST("/projects/:id/composition/items", s.handleAddCompositionItem)
+		api.PUT("/projects/:id/composition/items/:itemId", s.handleUpdateCompositionItem)
+		api.DELETE("/projects/:id/composition/items/:itemId", s.handleDeleteCompositionItem)
+		api.POST("/projects/:id/composition/render", s.handleRenderComposition)
 		api.DELETE("/projects/:id/sessions/:sessionId", s.handleDeleteSession)
 		api.DELETE("/projects/:id/sessions/:sessionId/mosh/:moshId", s.handleDeleteMosh)
+		api.POST("/projects/:id/sessions/:sessionId/mosh/:moshId/rerender", s.handleRerenderMosh)
 		api.GET("/projects/:id/converted-files/:sessionId/:moshId", s.handleGetConvertedFiles)
 		api.GET("/projects/:id/play-converted/:moshId/:format", s.handlePlayConverted)
 		api.GET("/projects/:id/frame/:filename/:timestamp", s.handleGetFrame)
 		api.POST("/projects/:id/convert-mosh/:filename", s.handleConvertMosh)
+		api.GET("/jobs/:id", s.handleGetJob)
+		api.GET("/jobs/:id/preview", s.handleJobPreview)
+		api.GET("/jobs/:id/exports", s.handleJobExports)
+		api.GET("/jobs/:id/exports/:profile", s.handleDownloadExport)
+		api.GET("/export-profiles", s.handleListExportProfiles)
+		api.PUT("/export-profiles/:name", s.handleSaveExportProfile)
+		api.DELETE("/export-profiles/:name", s.handleDeleteExportProfile)
+		api.DELETE("/jobs/:id", s.handleCancelJob)
+		api.GET("/sessions/:id", s.handleGetSession)
+		api.GET("/pipelines/:id", s.handleGetPipeline)
+		api.GET("/cache", s.handleCacheStats)
+		api.POST("/workers/lease", s.handleLeaseJob)
+		api.GET("/workers/jobs/:id/inputs/:name", s.handleJobInput)
+		api.POST("/workers/jobs/:id/progress", s.handleJobProgress)
+		api.PUT("/workers/jobs/:id/output", s.handleJobOutput)
+		api.POST("/workers/jobs/:id/complete", s.handleCompleteJob)
+		api.POST("/workers/jobs/:id/fail", s.handleFailJob)
 		api.POST("/migrate", s.handleMigrateOldFiles)
 	}
 
@@ -210,9 +265,25 @@ func (s *Server) handleUpload(c *gin.Context) {
 	})
 }
 
+// handleConvert converts the project's upload to the AVI that effects
+// mosh. The body may set the intermediate encoding; each set of options
+// is stored as its own AVI, so one source can be moshed under several
+// encodings and compared.
 func (s *Server) handleConvert(c *gin.Context) {
 	projectID := c.Param("id")
 
+	var opts video.AVIOptions
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	project, err := s.projectManager.LoadProject(projectID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
@@ -224,15 +295,18 @@ func (s *Server) handleConvert(c *gin.Context) {
 		return
 	}
 
-	outputPath := filepath.Join(project.BasePath, "converted.avi")
+	outputPath := filepath.Join(project.BasePath, opts.FileName())
+	// A pipeline may have linked it to a cached render, which ffmpeg
+	// would otherwise overwrite in place
+	os.Remove(outputPath)
 
-	err = s.converter.MP4ToAVI(project.OriginalFile, outputPath)
+	err = s.converter.MP4ToAVIWithOptions(project.OriginalFile, outputPath, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	project.ConvertedFile = outputPath
+	project.RecordConversion(opts, outputPath)
 	err = s.projectManager.SaveProject(project)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
@@ -245,6 +319,143 @@ func (s *Server) handleConvert(c *gin.Context) {
 	})
 }
 
+func (s *Server) handleListEffects(c *gin.Context) {
+	list := make([]gin.H, 0)
+	for _, effect := range effects.All() {
+		list = append(list, gin.H{
+			"name":        effect.Name(),
+			"description": effect.Description(),
+			"params":      effect.Schema(),
+			"presets":     effect.Presets(nil),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"effects": list})
+}
+
+// moshRequest describes a single mosh, or the template of a batch or sweep.
+type moshRequest struct {
+	InputPath string             `json:"input_path"`
+	Effect    string             `json:"effect"`
+	Intensity float64            `json:"intensity"`
+	Windows   []video.MoshWindow `json:"windows"`
+	Seed      int64              `json:"seed"`
+	// Params sets any parameter from the effect's schema
+	Params map[string]interface{} `json:"params"`
+	// Animate drives animatable parameters with curves over time
+	Animate map[string]video.Curve `json:"animate"`
+	// A saved mask by ID, or an inline shape or key mask
+	MaskID string          `json:"mask_id"`
+	Mask   json.RawMessage `json:"mask"`
+	// Steps turns the mosh into a chain of effects run in order
+	Steps []struct {
+		Effect    string                 `json:"effect"`
+		Intensity float64                `json:"intensity"`
+		Windows   []video.MoshWindow     `json:"windows"`
+		Params    map[string]interface{} `json:"params"`
+		Animate   map[string]video.Curve `json:"animate"`
+		Seed      int64                  `json:"seed"`
+	} `json:"steps"`
+	// Priority moves the mosh ahead of queued moshes with a lower one
+	Priority int `json:"priority"`
+	// MaxRunTime in seconds stops the render if it runs longer
+	MaxRunTime float64 `json:"max_run_time"`
+}
+
+// newMosh validates a mosh request and builds the job it describes, without
+// an ID, session or output directory. It also returns the effect.
+func (s *Server) newMosh(projectID string, req moshRequest) (*batch.Mosh, effects.Effect, error) {
+	if err := (video.MoshParams{Windows: req.Windows}).Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var steps []effects.Step
+	if len(req.Steps) > 0 {
+		req.Effect = effects.ChainEffectName
+
+		for i, step := range req.Steps {
+			stepEffect, err := effects.Lookup(step.Effect)
+			if err != nil {
+				return nil, nil, err
+			}
+			intensity := step.Intensity
+			if v, ok := step.Params["intensity"].(float64); ok {
+				intensity = v
+			}
+			if curve, ok := step.Animate["intensity"]; ok {
+				intensity = curve.Peak()
+			}
+			intensity, err = effects.ResolveIntensity(stepEffect, intensity)
+			if err != nil {
+				return nil, nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+			params := stepEffect.NewParams(intensity, step.Windows)
+			params.Animate = step.Animate
+			steps = append(steps, effects.Step{
+				Effect:       step.Effect,
+				Params:       params,
+				EffectParams: step.Params,
+				Seed:         step.Seed,
+			})
+		}
+		if err := effects.ValidateSteps(steps); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	effect, err := effects.Lookup(req.Effect)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := effects.ValidateParams(effect, req.Params); err != nil {
+		return nil, nil, err
+	}
+	if intensity, ok := req.Params["intensity"].(float64); ok {
+		req.Intensity = intensity
+	}
+	if err := effects.ValidateAnimation(effect, req.Animate); err != nil {
+		return nil, nil, err
+	}
+	// Animated effects render at the peak of their intensity curve
+	if curve, ok := req.Animate["intensity"]; ok {
+		req.Intensity = curve.Peak()
+	}
+	req.Intensity, err = effects.ResolveIntensity(effect, req.Intensity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.Seed < 0 || req.Seed > effects.MaxSeed {
+		return nil, nil, fmt.Errorf("seed must be between 1 and %d", int64(effects.MaxSeed))
+	}
+
+	mask, err := s.resolveMask(projectID, req.MaskID, req.Mask)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.MaxRunTime < 0 {
+		return nil, nil, fmt.Errorf("max_run_time must not be negative")
+	}
+
+	params := effect.NewParams(req.Intensity, req.Windows)
+	params.Animate = req.Animate
+
+	return &batch.Mosh{
+		ProjectID:    projectID,
+		InputPath:    req.InputPath,
+		Effect:       req.Effect,
+		Params:       params,
+		Seed:         req.Seed,
+		EffectParams: req.Params,
+		Steps:        steps,
+		Mask:         mask,
+		Priority:     req.Priority,
+		MaxRunTime:   req.MaxRunTime,
+	}, effect, nil
+}
+
 func (s *Server) handleMosh(c *gin.Context) {
 	projectID := c.Param("id")
 
@@ -255,10 +466,11 @@ func (s *Server) handleMosh(c *gin.Context) {
 	}
 
 	var req struct {
-		InputPath string  `json:"input_path"`
-		Effect    string  `json:"effect"`
-		Intensity float64 `json:"intensity"`
-		Batch     bool    `json:"batch"`
+		moshRequest
+		Batch bool `json:"batch"`
+		// Sweep renders a grid or random sample of parameter values in
+		// place of the effect's presets
+		Sweep *batch.Sweep `json:"sweep"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -266,8 +478,19 @@ func (s *Server) handleMosh(c *gin.Context) {
 		return
 	}
 
+	if len(req.Steps) > 0 && (req.Batch || req.Sweep != nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Effect chains cannot be batched"})
+		return
+	}
+
+	template, effect, err := s.newMosh(projectID, req.moshRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Create session directory in project's moshes folder
-	sessionID := fmt.Sprintf("session_%d", time.Now().Unix())
+	sessionID := ids.New("session")
 	paths := s.projectManager.GetProjectPaths(projectID)
 	sessionDir := filepath.Join(paths["moshes"], sessionID)
 	err = os.MkdirAll(sessionDir, 0755)
@@ -275,151 +498,162 @@ func (s *Server) handleMosh(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session directory"})
 		return
 	}
+	template.SessionID = sessionID
+	template.OutputDir = sessionDir
 
-	if req.Batch {
-		var presets []video.MoshParams
-		switch req.Effect {
-		case "datamosh":
-			effect := effects.NewDatamoshEffect()
-			presets = effect.CreatePresets()
-		case "glitch":
-			effect := effects.NewGlitchEffect()
-			presets = effect.CreatePresets()
-		case "duallayer":
-			effect := effects.NewDualLayerEffect()
-			dualPresets := effect.CreatePresets()
-			// Convert DualLayerParams to MoshParams for compatibility
-			for _, preset := range dualPresets {
-				presets = append(presets, video.MoshParams{
-					Intensity:         preset.Intensity,
-					IFrameRemoval:     false,
-					PFrameDuplication: false,
-					DuplicationCount:  0,
-				})
-			}
-		case "rgbdrift", "echotrail", "glitchmosaic", "chromaticblur", "kaleidoscope":
-			// These effects use intensity-based presets
-			presets = []video.MoshParams{
-				{Intensity: 1.0, IFrameRemoval: false, PFrameDuplication: false, DuplicationCount: 0},
-				{Intensity: 2.0, IFrameRemoval: false, PFrameDuplication: false, DuplicationCount: 0},
-				{Intensity: 3.0, IFrameRemoval: false, PFrameDuplication: false, DuplicationCount: 0},
-			}
-		default:
-			// Default to datamosh-style presets
-			presets = []video.MoshParams{
-				{Intensity: 0.4, IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 44},
-				{Intensity: 0.7, IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 62},
-				{Intensity: 1.0, IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 80},
-			}
+	if req.Sweep != nil {
+		moshIDs, points, sweepSeed, err := s.processor.CreateSweep(*template, *req.Sweep)
+		if err != nil {
+			os.Remove(sessionDir)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"mosh_ids": moshIDs, "session_id": sessionID, "points": points, "sweep_seed": sweepSeed})
+	} else if req.Batch {
+		presets := effect.Presets(req.Windows)
+		for i := range presets {
+			presets[i].Animate = req.Animate
 		}
 
-		moshIDs := s.processor.CreateBatchFromPresets(req.InputPath, sessionDir, req.Effect, presets)
+		moshIDs := s.processor.CreateBatchFromPresets(*template, presets)
 
 		c.JSON(http.StatusOK, gin.H{"mosh_ids": moshIDs, "session_id": sessionID})
 	} else {
-		moshID := fmt.Sprintf("single_%d", time.Now().Unix())
-		// Generate effect-specific parameters for single mosh
-		var params video.MoshParams
-		switch req.Effect {
-		case "datamosh":
-			effect := effects.NewDatamoshEffect()
-			params = effect.GenerateParams(req.Intensity)
-		case "glitch":
-			effect := effects.NewGlitchEffect()
-			params = effect.GenerateRandomParams(req.Intensity)
-		case "duallayer":
-			params = video.MoshParams{
-				Intensity:         req.Intensity,
-				IFrameRemoval:     false,
-				PFrameDuplication: false,
-				DuplicationCount:  0,
-			}
-		case "rgbdrift", "echotrail", "glitchmosaic", "chromaticblur", "kaleidoscope":
-			params = video.MoshParams{
-				Intensity:         req.Intensity,
-				IFrameRemoval:     false,
-				PFrameDuplication: false,
-				DuplicationCount:  0,
-			}
-		default:
-			// Default datamosh-style parameters
-			params = video.MoshParams{
-				Intensity:         req.Intensity,
-				IFrameRemoval:     req.Intensity > 0.1,
-				PFrameDuplication: req.Intensity > 0.05,
-				DuplicationCount:  int(req.Intensity*60) + 20,
-			}
-		}
-
-		mosh := &batch.Mosh{
-			ID:        moshID,
-			InputPath: req.InputPath,
-			OutputDir: sessionDir,
-			Effect:    req.Effect,
-			Params:    params,
-		}
+		mosh := template
+		mosh.ID = ids.New("mosh")
 
 		s.processor.AddMosh(mosh)
 
-		c.JSON(http.StatusOK, gin.H{"mosh_id": moshID, "session_id": sessionID})
+		c.JSON(http.StatusOK, gin.H{"mosh_id": mosh.ID, "session_id": sessionID, "seed": mosh.Seed})
 	}
 }
 
-func (s *Server) handleGetMoshes(c *gin.Context) {
-	moshes := s.processor.GetAllMoshes()
+func (s *Server) handleTransfer(c *gin.Context) {
+	projectID := c.Param("id")
 
-	c.JSON(http.StatusOK, gin.H{"moshes": moshes})
-}
+	_, err := s.projectManager.LoadProject(projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
 
-func (s *Server) handleGetMosh(c *gin.Context) {
-	moshID := c.Param("id")
-	mosh, exists := s.processor.GetMosh(moshID)
+	var req struct {
+		KeyframeClipID     string  `json:"keyframe_clip_id"`
+		MotionClipID       string  `json:"motion_clip_id"`
+		Intensity          float64 `json:"intensity"`
+		TrimToLastKeyframe bool    `json:"trim_to_last_keyframe"`
+		DuplicationCount   *int    `json:"duplication_count"`
+	}
 
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Mosh not found"})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"mosh": mosh})
-}
+	clips, err := s.projectManager.LoadClips(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load clips"})
+		return
+	}
 
-func (s *Server) handlePreview(c *gin.Context) {
-	projectID := c.Param("id")
-	filename := c.Param("filename")
+	var keyframeClip, motionClip *projectpkg.ClipMetadata
+	for i := range clips {
+		if clips[i].ID == req.KeyframeClipID {
+			keyframeClip = &clips[i]
+		}
+		if clips[i].ID == req.MotionClipID {
+			motionClip = &clips[i]
+		}
+	}
+	if keyframeClip == nil || motionClip == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Clip not found"})
+		return
+	}
 
-	// Extract mosh ID from filename (moshed_moshID.avi -> moshID)
-	var moshID string
-	if strings.HasPrefix(filename, "moshed_") && strings.HasSuffix(filename, ".avi") {
-		moshID = strings.TrimSuffix(strings.TrimPrefix(filename, "moshed_"), ".avi")
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename format"})
+	effect := effects.NewMotionTransferEffect()
+	req.Intensity, err = effects.ResolveIntensity(effect, req.Intensity)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params := effect.GenerateParams(req.Intensity)
+	params.TrimToLastKeyframe = req.TrimToLastKeyframe
+	if req.DuplicationCount != nil {
+		params.DuplicationCount = *req.DuplicationCount
+	}
+	if err := params.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Look for the preview file in project moshes directory
+	sessionID := ids.New("session")
 	paths := s.projectManager.GetProjectPaths(projectID)
-	moshesDir := paths["moshes"]
+	sessionDir := filepath.Join(paths["moshes"], sessionID)
+	err = os.MkdirAll(sessionDir, 0755)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session directory"})
+		return
+	}
 
-	var previewPath string
-	entries, err := os.ReadDir(moshesDir)
-	if err == nil {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				sessionPreviewPath := filepath.Join(moshesDir, entry.Name(), fmt.Sprintf("preview_%s.jpg", moshID))
-				if _, err := os.Stat(sessionPreviewPath); err == nil {
-					previewPath = sessionPreviewPath
-					break
-				}
-			}
-		}
+	moshID := ids.New("mosh")
+	mosh := &batch.Mosh{
+		ID:              moshID,
+		ProjectID:       projectID,
+		SessionID:       sessionID,
+		InputPath:       keyframeClip.FilePath,
+		SecondInputPath: motionClip.FilePath,
+		OutputDir:       sessionDir,
+		Effect:          "transfer",
+		Params:          video.MoshParams{Intensity: req.Intensity},
+		Transfer:        &params,
 	}
 
-	if previewPath == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Preview not found"})
+	s.processor.AddMosh(mosh)
+
+	c.JSON(http.StatusOK, gin.H{"mosh_id": moshID, "session_id": sessionID})
+}
+
+// handleCancelJob stops a queued or running mosh. The cancelled status
+// reaches clients over the WebSocket like any other update.
+func (s *Server) handleCancelJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	err := s.processor.Cancel(jobID)
+	switch {
+	case errors.Is(err, batch.ErrMoshNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	case errors.Is(err, batch.ErrMoshFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job cancelled",
+		"job_id":  jobID,
+	})
+}
+
+func (s *Server) handleGetMoshes(c *gin.Context) {
+	moshes := s.processor.GetAllMoshes()
+
+	c.JSON(http.StatusOK, gin.H{"moshes": moshes})
+}
+
+func (s *Server) handleGetMosh(c *gin.Context) {
+	moshID := c.Param("moshId")
+	mosh, exists := s.processor.GetMosh(moshID)
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mosh not found"})
 		return
 	}
 
-	c.File(previewPath)
+	c.JSON(http.StatusOK, gin.H{"mosh": mosh})
 }
 
 func (s *Server) handleDetectScenes(c *gin.Context) {
@@ -561,7 +795,7 @@ func (s *Server) handleExtractClip(c *gin.Context) {
 
 	// Save clip metadata
 	clipMetadata := projectpkg.ClipMetadata{
-		ID:         fmt.Sprintf("clip_%d", time.Now().Unix()),
+		ID:         ids.New("clip"),
 		Name:       req.OutputName,
 		StartFrame: req.FrameRange.StartFrame,
 		EndFrame:   req.FrameRange.EndFrame,
@@ -789,12 +1023,17 @@ func (s *Server) scanExistingTimeline(timelineDir, projectID string) ([]video.Fr
 	return frames, nil
 }
 
+// handleConvertMosh queues an export of a moshed AVI with an export
+// profile, mp4 unless the request names another, and returns the job ID
+// without waiting for it. The export is recorded with the mosh when done.
 func (s *Server) handleConvertMosh(c *gin.Context) {
 	projectID := c.Param("id")
 	filename := c.Param("filename")
 
 	var req struct {
-		Format string `json:"format"` // "mp4" or "webm"
+		// Format names the export profile
+		Format string `json:"format"`
+		MoshID string `json:"mosh_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -802,10 +1041,14 @@ func (s *Server) handleConvertMosh(c *gin.Context) {
 		return
 	}
 
-	if req.Format != "mp4" && req.Format != "webm" {
-		req.Format = "mp4" // Default to MP4
+	if req.Format == "" {
+		req.Format = "mp4"
+	}
+	profile, err := s.exportProfile(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
 	// Find the moshed file in project moshes directory
 	paths := s.projectManager.GetProjectPaths(projectID)
 	moshesDir := paths["moshes"]
@@ -833,159 +1076,130 @@ func (s *Server) handleConvertMosh(c *gin.Context) {
 		return
 	}
 
-	// Generate output filename
-	baseName := strings.TrimSuffix(filename, ".avi")
-	outputFilename := fmt.Sprintf("%s_converted.%s", baseName, req.Format)
-	outputPath := filepath.Join(filepath.Dir(inputPath), outputFilename)
-
-	// Generate conversion ID for WebSocket updates
-	conversionID := fmt.Sprintf("convert_%s_%s_%d", filename, req.Format, time.Now().Unix())
-
-	// Create progress callback for WebSocket updates
-	progressCallback := func(progress float64) {
-		s.wsHub.BroadcastMoshUpdate(conversionID, "processing", progress)
+	// The export is recorded against its mosh, so the mosh must be known
+	// before anything is queued
+	sessionDir := filepath.Dir(inputPath)
+	moshID := s.moshForFile(sessionDir, inputPath, req.MoshID)
+	if moshID == "" && req.MoshID != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Mosh %s is not in the session of %s", req.MoshID, filename)})
+		return
 	}
-
-	// Convert the file with progress tracking
-	var convertErr error
-	if req.Format == "mp4" {
-		convertErr = s.converter.MoshedAVIToMP4WithProgress(inputPath, outputPath, progressCallback)
-	} else {
-		convertErr = s.converter.MoshedAVIToWebMWithProgress(inputPath, outputPath, progressCallback)
+	if moshID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No mosh in this session wrote %s, pass its mosh_id", filename)})
+		return
 	}
 
-	if convertErr != nil {
-		s.wsHub.BroadcastMoshUpdate(conversionID, "failed", 0)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": convertErr.Error()})
-		return
+	// The export runs in the queue; its progress and result reach clients
+	// over the WebSocket under the job ID
+	job := &batch.Mosh{
+		ID:        ids.New(batch.KindExport),
+		Kind:      batch.KindExport,
+		ProjectID: projectID,
+		SessionID: filepath.Base(sessionDir),
+		InputPath: inputPath,
+		OutputDir: sessionDir,
+		Format:    profile.Name,
+		Profile:   profile,
+		MoshID:    moshID,
 	}
+	s.processor.AddMosh(job)
 
-	s.wsHub.BroadcastMoshUpdate(conversionID, "completed", 1.0)
 	c.JSON(http.StatusOK, gin.H{
-		"message":       "Conversion completed",
-		"output_file":   outputFilename,
-		"output_path":   outputPath,
-		"format":        req.Format,
-		"conversion_id": conversionID,
+		"message":       "Conversion queued",
+		"job_id":        job.ID,
+		"conversion_id": job.ID,
+		"output_file":   filepath.Base(batch.JobOutputPath(job)),
+		"format":        profile.Name,
 	})
 }
 
+// moshForFile returns the ID of the mosh behind a file in sessionDir. A
+// requested ID must name a mosh of the session or a job still writing the
+// file; without one, the mosh is looked up by the file it wrote. It
+// returns "" when no mosh matches.
+func (s *Server) moshForFile(sessionDir, path, requested string) string {
+	matches := func(id, filePath string) bool {
+		if requested != "" {
+			return id == requested
+		}
+		return filepath.Base(filePath) == filepath.Base(path)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(sessionDir, "session.json")); err == nil {
+		var session projectpkg.MoshSession
+		if json.Unmarshal(data, &session) == nil {
+			for _, mosh := range session.Moshes {
+				if matches(mosh.ID, mosh.FilePath) {
+					return mosh.ID
+				}
+			}
+		}
+	}
+
+	// Moshes still being rendered are not in the session yet
+	for _, job := range s.processor.GetAllMoshes() {
+		if job.Kind != "" && job.Kind != batch.KindMosh {
+			continue
+		}
+		output := batch.JobOutputPath(job)
+		if job.OutputDir == sessionDir && matches(job.ID, output) && filepath.Base(output) == filepath.Base(path) {
+			return job.ID
+		}
+	}
+	return ""
+}
+
+// handleGetConvertedFiles reports which export profiles a mosh has been
+// exported with.
 func (s *Server) handleGetConvertedFiles(c *gin.Context) {
 	projectID := c.Param("id")
 	sessionID := c.Param("sessionId")
 	moshID := c.Param("moshId")
 
-	paths := s.projectManager.GetProjectPaths(projectID)
-	moshesDir := paths["moshes"]
-
 	convertedFiles := map[string]bool{
 		"mp4":  false,
 		"webm": false,
 	}
-
-	// Check specific session directory for converted files
-	sessionDir := filepath.Join(moshesDir, sessionID)
-
-	// Check for MP4
-	mp4Path := filepath.Join(sessionDir, fmt.Sprintf("moshed_%s_converted.mp4", moshID))
-	fmt.Printf("DEBUG: Checking MP4 path: %s\n", mp4Path)
-	if _, err := os.Stat(mp4Path); err == nil {
-		fmt.Printf("DEBUG: Found MP4 file!\n")
-		convertedFiles["mp4"] = true
-	} else {
-		fmt.Printf("DEBUG: MP4 file not found: %v\n", err)
-	}
-
-	// Check for WebM
-	webmPath := filepath.Join(sessionDir, fmt.Sprintf("moshed_%s_converted.webm", moshID))
-	fmt.Printf("DEBUG: Checking WebM path: %s\n", webmPath)
-	if _, err := os.Stat(webmPath); err == nil {
-		fmt.Printf("DEBUG: Found WebM file!\n")
-		convertedFiles["webm"] = true
-	} else {
-		fmt.Printf("DEBUG: WebM file not found: %v\n", err)
-	}
-
-	// Also list what files actually exist in the session directory
-	entries, err := os.ReadDir(sessionDir)
-	if err != nil {
-		fmt.Printf("DEBUG: Cannot read session dir %s: %v\n", sessionDir, err)
-	} else {
-		fmt.Printf("DEBUG: Files in session dir %s:\n", sessionDir)
-		for _, entry := range entries {
-			fmt.Printf("  - %s\n", entry.Name())
-		}
+	exports := s.moshExports(projectID, sessionID, moshID)
+	for _, export := range exports {
+		convertedFiles[export.Profile] = true
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"mosh_id":         moshID,
 		"session_id":      sessionID,
 		"converted_files": convertedFiles,
-		"debug": map[string]string{
-			"session_dir": sessionDir,
-			"mp4_path":    mp4Path,
-			"webm_path":   webmPath,
-		},
+		"exports":         exports,
 	})
 }
 
-func (s *Server) checkMoshConvertedFiles(projectID, sessionID, moshID string) map[string]bool {
-	paths := s.projectManager.GetProjectPaths(projectID)
-	moshesDir := paths["moshes"]
-
-	convertedFiles := map[string]bool{
-		"mp4":  false,
-		"webm": false,
-	}
-
-	// Check only the specific session directory
-	sessionDir := filepath.Join(moshesDir, sessionID)
-
-	// Check for MP4
-	mp4Path := filepath.Join(sessionDir, fmt.Sprintf("moshed_%s_converted.mp4", moshID))
-	if _, err := os.Stat(mp4Path); err == nil {
-		convertedFiles["mp4"] = true
-	}
-
-	// Check for WebM
-	webmPath := filepath.Join(sessionDir, fmt.Sprintf("moshed_%s_converted.webm", moshID))
-	if _, err := os.Stat(webmPath); err == nil {
-		convertedFiles["webm"] = true
-	}
-
-	return convertedFiles
-}
-
+// handlePlayConverted returns where the export of a mosh made with a
+// profile can be opened. Image sequences are downloaded as a zip.
 func (s *Server) handlePlayConverted(c *gin.Context) {
 	projectID := c.Param("id")
 	moshID := c.Param("moshId")
 	format := c.Param("format")
 
-	paths := s.projectManager.GetProjectPaths(projectID)
-	moshesDir := paths["moshes"]
-
-	// Search all session directories for the converted file
-	entries, err := os.ReadDir(moshesDir)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read moshes directory"})
+	location, err := s.locateJob(moshID)
+	if err != nil || location.ProjectID != projectID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mosh not found"})
 		return
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			sessionDir := filepath.Join(moshesDir, entry.Name())
-			convertedFile := filepath.Join(sessionDir, fmt.Sprintf("moshed_%s_converted.%s", moshID, format))
-
-			if _, err := os.Stat(convertedFile); err == nil {
-				// Found the file, return the relative path for serving
-				relativePath := fmt.Sprintf("/projects/%s/moshes/%s/moshed_%s_converted.%s", projectID, entry.Name(), moshID, format)
-				c.JSON(http.StatusOK, gin.H{
-					"file_path":   relativePath,
-					"session_dir": entry.Name(),
-				})
-				return
-			}
+	for _, export := range s.moshExports(projectID, location.SessionID, moshID) {
+		if export.Profile != format {
+			continue
+		}
+
+		filePath := "/" + filepath.ToSlash(export.Path)
+		if info, err := os.Stat(export.Path); err == nil && info.IsDir() {
+			filePath = fmt.Sprintf("/api/jobs/%s/exports/%s", moshID, format)
 		}
+		c.JSON(http.StatusOK, gin.H{
+			"file_path":   filePath,
+			"session_dir": location.SessionID,
+		})
+		return
 	}
 
 	c.JSON(http.StatusNotFound, gin.H{"error": "Converted file not found"})
@@ -1003,12 +1217,15 @@ func (s *Server) handleDeleteMosh(c *gin.Context) {
 	// Delete all files related to this mosh
 	filesToDelete := []string{
 		fmt.Sprintf("moshed_%s.avi", moshID),
-		fmt.Sprintf("moshed_%s_converted.mp4", moshID),
-		fmt.Sprintf("moshed_%s_converted.webm", moshID),
 		fmt.Sprintf("preview_%s.jpg", moshID),
 	}
 
 	deletedFiles := []string{}
+	for _, export := range s.moshExports(projectID, sessionID, moshID) {
+		if err := os.RemoveAll(export.Path); err == nil {
+			deletedFiles = append(deletedFiles, filepath.Base(export.Path))
+		}
+	}
 	for _, filename := range filesToDelete {
 		filePath := filepath.Join(sessionDir, filename)
 		if err := os.Remove(filePath); err == nil {
@@ -1024,17 +1241,7 @@ func (s *Server) handleDeleteMosh(c *gin.Context) {
 			// Remove the mosh with matching mosh ID from the session
 			updatedMoshes := []projectpkg.MoshMetadata{}
 			for _, mosh := range session.Moshes {
-				// Check both the stored ID and extract ID from filename
-				storedMoshID := mosh.ID
-				if mosh.FilePath != "" {
-					filename := filepath.Base(mosh.FilePath)
-					if strings.HasPrefix(filename, "moshed_") && strings.HasSuffix(filename, ".avi") {
-						storedMoshID = strings.TrimSuffix(strings.TrimPrefix(filename, "moshed_"), ".avi")
-					}
-				}
-
-				// Keep moshes that don't match the deleted mosh ID
-				if storedMoshID != moshID {
+				if mosh.ID != moshID {
 					updatedMoshes = append(updatedMoshes, mosh)
 				}
 			}
@@ -1056,6 +1263,106 @@ func (s *Server) handleDeleteMosh(c *gin.Context) {
 	})
 }
 
+// handleRerenderMosh queues a mosh again with its recorded effect, params
+// and seed. With the same input the output matches the original byte for
+// byte; a different input_path renders the same look on another source,
+// such as a longer or higher resolution cut of the clip.
+func (s *Server) handleRerenderMosh(c *gin.Context) {
+	projectID := c.Param("id")
+	sessionID := c.Param("sessionId")
+	moshID := c.Param("moshId")
+
+	var req struct {
+		InputPath string `json:"input_path"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	sessions, err := s.projectManager.LoadMoshSessions(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sessions"})
+		return
+	}
+
+	var original *projectpkg.MoshMetadata
+	for _, session := range sessions {
+		if session.ID != sessionID {
+			continue
+		}
+		for i := range session.Moshes {
+			if session.Moshes[i].ID == moshID {
+				original = &session.Moshes[i]
+				break
+			}
+		}
+	}
+	if original == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mosh not found"})
+		return
+	}
+
+	if original.Seed == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Mosh was rendered without a recorded seed"})
+		return
+	}
+
+	inputPath := req.InputPath
+	if inputPath == "" {
+		inputPath = original.InputPath
+	}
+	if inputPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Mosh has no recorded input, pass input_path"})
+		return
+	}
+
+	data, err := json.Marshal(original.Params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read mosh params"})
+		return
+	}
+	var params video.MoshParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recorded params"})
+		return
+	}
+
+	paths := s.projectManager.GetProjectPaths(projectID)
+	mosh := &batch.Mosh{
+		ID:        ids.New("mosh"),
+		ProjectID: projectID,
+		SessionID: sessionID,
+		InputPath: inputPath,
+		OutputDir: filepath.Join(paths["moshes"], sessionID),
+		Effect:    original.Effect,
+		Params:    params,
+		Seed:      original.Seed,
+	}
+	mosh.Steps = original.Steps
+	mosh.Mask = original.Mask
+	mosh.SweepPoint = original.SweepPoint
+	mosh.Label = original.Label
+	if effectParams, ok := original.Params["effect_params"].(map[string]interface{}); ok {
+		mosh.EffectParams = effectParams
+	}
+	if second, ok := original.Params["second_input_path"].(string); ok {
+		mosh.SecondInputPath = second
+	}
+	if original.Effect == "transfer" {
+		var transfer video.TransferParams
+		if err := json.Unmarshal(data, &transfer); err == nil {
+			mosh.Transfer = &transfer
+		}
+	}
+
+	s.processor.AddMosh(mosh)
+
+	c.JSON(http.StatusOK, gin.H{"mosh_id": mosh.ID, "session_id": sessionID, "seed": mosh.Seed})
+}
+
 func (s *Server) handleDeleteSession(c *gin.Context) {
 	projectID := c.Param("id")
 	sessionID := c.Param("sessionId")
@@ -1076,12 +1383,3 @@ func (s *Server) handleDeleteSession(c *gin.Context) {
 		"session_id": sessionID,
 	})
 }
-
-func (s *Server) extractJobIDFromFilename(filename string, fallbackIndex int) string {
-	// Extract job ID from filename like "moshed_batch_0.avi" -> "batch_0" or "moshed_single_1749018199.avi" -> "single_1749018199"
-	if strings.HasPrefix(filename, "moshed_") && strings.HasSuffix(filename, ".avi") {
-		return strings.TrimSuffix(strings.TrimPrefix(filename, "moshed_"), ".avi")
-	}
-	// Fallback should match current job ID patterns
-	return fmt.Sprintf("unknown_%d", fallbackIndex)
-}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"moshr/internal/batch"
+	projectpkg "moshr/internal/project"
+)
+
+// jobLocation ties a job to the session and project it belongs to. Job is
+// set while the processor still holds it and Metadata once it has been
+// recorded in its session; a finished job can have both.
+type jobLocation struct {
+	ProjectID string                   `json:"project_id"`
+	SessionID string                   `json:"session_id"`
+	Job       *batch.Mosh              `json:"job,omitempty"`
+	Metadata  *projectpkg.MoshMetadata `json:"metadata,omitempty"`
+}
+
+// locateJob finds a job by ID among the processor's jobs and the sessions
+// of every project.
+func (s *Server) locateJob(jobID string) (*jobLocation, error) {
+	location := &jobLocation{}
+	if job, exists := s.processor.GetMosh(jobID); exists {
+		location.Job = job
+		location.ProjectID = job.ProjectID
+		location.SessionID = job.SessionID
+	}
+
+	projectID, session, metadata, err := s.projectManager.FindMosh(jobID)
+	switch {
+	case err == nil:
+		location.ProjectID = projectID
+		location.SessionID = session.ID
+		location.Metadata = metadata
+	case !errors.Is(err, projectpkg.ErrNotFound):
+		return nil, err
+	case location.Job == nil:
+		return nil, projectpkg.ErrNotFound
+	}
+	return location, nil
+}
+
+func (s *Server) handleGetJob(c *gin.Context) {
+	location, err := s.locateJob(c.Param("id"))
+	if errors.Is(err, projectpkg.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, location)
+}
+
+// handleJobPreview serves the preview image generated when a job finished.
+func (s *Server) handleJobPreview(c *gin.Context) {
+	jobID := c.Param("id")
+
+	location, err := s.locateJob(jobID)
+	if err != nil || location.ProjectID == "" || location.SessionID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	sessionDir := filepath.Join(s.projectManager.GetProjectPaths(location.ProjectID)["moshes"], location.SessionID)
+	previewPath := filepath.Join(sessionDir, fmt.Sprintf("preview_%s.jpg", jobID))
+	if _, err := os.Stat(previewPath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preview not found"})
+		return
+	}
+
+	c.File(previewPath)
+}
+
+// handleGetSession returns a session with the project it belongs to and
+// any of its jobs that are queued, running or recently finished.
+func (s *Server) handleGetSession(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	projectID, session, err := s.projectManager.FindSession(sessionID)
+	if errors.Is(err, projectpkg.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	jobs := s.processor.SessionMoshes(sessionID)
+	if jobs == nil {
+		jobs = []*batch.Mosh{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id": projectID,
+		"session":    session,
+		"jobs":       jobs,
+	})
+}
+
+// handleCacheStats reports how full the render cache is and how often it
+// saved a render.
+func (s *Server) handleCacheStats(c *gin.Context) {
+	stats, enabled := s.processor.CacheStats()
+	if !enabled {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"stats":   stats,
+	})
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"moshr/internal/ids"
+	projectpkg "moshr/internal/project"
+	"moshr/internal/video"
+)
+
+func (s *Server) handleListMasks(c *gin.Context) {
+	projectID := c.Param("id")
+
+	masks, err := s.projectManager.LoadMasks(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load masks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"masks": masks})
+}
+
+// handleCreateMask saves a shape or key mask described in JSON. File masks
+// are created through handleUploadMask.
+func (s *Server) handleCreateMask(c *gin.Context) {
+	projectID := c.Param("id")
+
+	if _, err := s.projectManager.LoadProject(projectID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var req struct {
+		Name string     `json:"name"`
+		Mask video.Mask `json:"mask"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if req.Mask.Kind == video.MaskFile {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload file masks to /masks/upload"})
+		return
+	}
+	if err := req.Mask.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mask, err := s.addMask(projectID, req.Name, req.Mask)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save mask"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mask": mask})
+}
+
+// handleUploadMask stores a grayscale mask video or image in the project's
+// masks directory. Feather and invert can be sent as form fields.
+func (s *Server) handleUploadMask(c *gin.Context) {
+	projectID := c.Param("id")
+
+	if _, err := s.projectManager.LoadProject(projectID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("mask")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	mask := video.Mask{Kind: video.MaskFile}
+	if feather := c.PostForm("feather"); feather != "" {
+		if _, err := fmt.Sscanf(feather, "%g", &mask.Feather); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feather"})
+			return
+		}
+	}
+	mask.Invert = c.PostForm("invert") == "true"
+
+	masksDir := s.projectManager.GetProjectPaths(projectID)["masks"]
+	if err := os.MkdirAll(masksDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create masks directory"})
+		return
+	}
+
+	mask.Path = filepath.Join(masksDir, ids.New("mask")+filepath.Ext(header.Filename))
+	out, err := os.Create(mask.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, file); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	if err := mask.Validate(); err != nil {
+		os.Remove(mask.Path)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.PostForm("name")
+	if name == "" {
+		name = header.Filename
+	}
+
+	saved, err := s.addMask(projectID, name, mask)
+	if err != nil {
+		os.Remove(mask.Path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save mask"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mask": saved})
+}
+
+func (s *Server) addMask(projectID, name string, mask video.Mask) (*projectpkg.MaskMetadata, error) {
+	masks, err := s.projectManager.LoadMasks(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("%s mask", mask.Kind)
+	}
+	saved := projectpkg.MaskMetadata{
+		ID:        ids.New("mask"),
+		Name:      name,
+		Mask:      mask,
+		CreatedAt: time.Now(),
+	}
+
+	masks = append(masks, saved)
+	if err := s.projectManager.SaveMasks(projectID, masks); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (s *Server) handleDeleteMask(c *gin.Context) {
+	projectID := c.Param("id")
+	maskID := c.Param("maskId")
+
+	masks, err := s.projectManager.LoadMasks(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load masks"})
+		return
+	}
+
+	index := -1
+	for i, mask := range masks {
+		if mask.ID == maskID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mask not found"})
+		return
+	}
+
+	if masks[index].Mask.Kind == video.MaskFile {
+		if err := os.Remove(masks[index].Mask.Path); err != nil && !os.IsNotExist(err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mask file"})
+			return
+		}
+	}
+
+	masks = append(masks[:index], masks[index+1:]...)
+	if err := s.projectManager.SaveMasks(projectID, masks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update masks metadata"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Mask deleted successfully",
+		"deleted_mask_id": maskID,
+	})
+}
+
+// resolveMask picks the mask of a mosh request: a saved mask by ID or an
+// inline mask. It returns nil when the request has neither.
+func (s *Server) resolveMask(projectID, maskID string, inline json.RawMessage) (*video.Mask, error) {
+	if maskID != "" {
+		saved, err := s.projectManager.LoadMask(projectID, maskID)
+		if err != nil {
+			return nil, err
+		}
+		return &saved.Mask, nil
+	}
+
+	if len(inline) == 0 || string(inline) == "null" {
+		return nil, nil
+	}
+	var mask video.Mask
+	if err := json.Unmarshal(inline, &mask); err != nil {
+		return nil, fmt.Errorf("invalid mask: %v", err)
+	}
+	if mask.Kind == video.MaskFile {
+		return nil, fmt.Errorf("file masks must be uploaded and referenced by mask_id")
+	}
+	if err := mask.Validate(); err != nil {
+		return nil, err
+	}
+	return &mask, nil
+}
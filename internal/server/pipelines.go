@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"moshr/internal/batch"
+	"moshr/internal/ids"
+	projectpkg "moshr/internal/project"
+	"moshr/internal/video"
+)
+
+// pipelineStepRequest is one step of a pipeline request. Mosh steps take
+// the same fields as a single mosh; convert steps may set the intermediate
+// encoding and export steps name an export profile in format.
+type pipelineStepRequest struct {
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"`
+	Needs        []string          `json:"needs"`
+	Format       string            `json:"format"`
+	Intermediate *video.AVIOptions `json:"intermediate"`
+	moshRequest
+}
+
+// handleCreatePipeline queues a graph of jobs from one request, such as
+// converting the upload, moshing it five ways and exporting and previewing
+// every mosh. Steps start as soon as the steps they need complete. A
+// convert step without an input converts the project's upload into the
+// project's converted AVI for its intermediate options.
+func (s *Server) handleCreatePipeline(c *gin.Context) {
+	projectID := c.Param("id")
+
+	project, err := s.projectManager.LoadProject(projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var req struct {
+		Steps    []pipelineStepRequest `json:"steps"`
+		Priority int                   `json:"priority"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	sessionID := ids.New("session")
+	sessionDir := filepath.Join(s.projectManager.GetProjectPaths(projectID)["moshes"], sessionID)
+
+	steps := make([]batch.PipelineStep, 0, len(req.Steps))
+	for _, step := range req.Steps {
+		var job *batch.Mosh
+		switch step.Kind {
+		case "", batch.KindMosh:
+			job, _, err = s.newMosh(projectID, step.moshRequest)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("step %q: %v", step.Name, err)})
+				return
+			}
+		case batch.KindConvert:
+			job = &batch.Mosh{InputPath: step.InputPath, Intermediate: step.Intermediate}
+			if job.InputPath == "" && len(step.Needs) == 0 {
+				if project.OriginalFile == "" {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("step %q: no original file to convert", step.Name)})
+					return
+				}
+				var opts video.AVIOptions
+				if step.Intermediate != nil {
+					opts = *step.Intermediate
+				}
+				job.InputPath = project.OriginalFile
+				job.OutputPath = filepath.Join(project.BasePath, opts.FileName())
+			}
+		case batch.KindExport:
+			if step.Format == "" {
+				step.Format = "mp4"
+			}
+			profile, err := s.exportProfile(step.Format)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("step %q: %v", step.Name, err)})
+				return
+			}
+			job = &batch.Mosh{InputPath: step.InputPath, Format: profile.Name, Profile: profile}
+		default:
+			job = &batch.Mosh{InputPath: step.InputPath}
+		}
+
+		job.Kind = step.Kind
+		job.ProjectID = projectID
+		job.SessionID = sessionID
+		job.OutputDir = sessionDir
+		if job.Priority == 0 {
+			job.Priority = req.Priority
+		}
+		steps = append(steps, batch.PipelineStep{Name: step.Name, Needs: step.Needs, Job: *job})
+	}
+
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session directory"})
+		return
+	}
+
+	pipelineID, jobIDs, err := s.processor.CreatePipeline(steps)
+	if err != nil {
+		os.Remove(sessionDir)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pipeline_id": pipelineID,
+		"session_id":  sessionID,
+		"jobs":        jobIDs,
+	})
+}
+
+func (s *Server) handleGetPipeline(c *gin.Context) {
+	pipelineID := c.Param("id")
+
+	jobs := s.processor.PipelineMoshes(pipelineID)
+	if len(jobs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pipeline_id": pipelineID,
+		"jobs":        jobs,
+	})
+}
+
+// jobCompleted records the results of finished jobs that belong outside
+// their session, such as the project's converted AVI and renders of its
+// composition.
+func (s *Server) jobCompleted(job *batch.Mosh) {
+	if job.Kind == batch.KindComposition && job.ProjectID != "" {
+		s.recordRender(job)
+		return
+	}
+	if job.Kind != batch.KindConvert || job.ProjectID == "" {
+		return
+	}
+
+	project, err := s.projectManager.LoadProject(job.ProjectID)
+	if err != nil {
+		return
+	}
+	var opts video.AVIOptions
+	if job.Intermediate != nil {
+		opts = *job.Intermediate
+	}
+	if job.OutputPath != filepath.Join(project.BasePath, opts.FileName()) {
+		return
+	}
+	project.RecordConversion(opts, job.OutputPath)
+	if err := s.projectManager.SaveProject(project); err != nil {
+		fmt.Printf("Failed to record converted file of project %s: %v\n", project.ID, err)
+	}
+}
+
+// recordRender adds a finished composition job to the project's renders.
+func (s *Server) recordRender(job *batch.Mosh) {
+	composition, err := s.projectManager.LoadComposition(job.ProjectID)
+	if err != nil {
+		fmt.Printf("Failed to load composition of project %s: %v\n", job.ProjectID, err)
+		return
+	}
+	composition.Renders = append(composition.Renders, projectpkg.CompositionRender{
+		ID:        job.ID,
+		FilePath:  job.OutputPath,
+		CreatedAt: time.Now(),
+	})
+	if err := s.projectManager.SaveComposition(job.ProjectID, composition); err != nil {
+		fmt.Printf("Failed to record render %s of project %s: %v\n", job.ID, job.ProjectID, err)
+	}
+}
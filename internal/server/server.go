@@ -3,10 +3,28 @@ package server
 import (
 	"fmt"
 	"log"
+	"time"
 )
 
-func Start(port string) error {
-	server := NewServer()
+// Options configure the server at startup.
+type Options struct {
+	// MaxRunTime limits how long any render may run, zero for no limit
+	MaxRunTime time.Duration
+	// EffectMaxRunTimes override MaxRunTime for individual effects
+	EffectMaxRunTimes map[string]time.Duration
+	// MaxSweepJobs caps the moshes one parameter sweep may queue, zero
+	// for the processor's default
+	MaxSweepJobs int
+	// Workers is how many jobs the server renders at once itself. Zero
+	// leaves every job to remote workers.
+	Workers int
+	// CacheMaxBytes is the most disk space kept renders may use, zero to
+	// render every job afresh
+	CacheMaxBytes int64
+}
+
+func Start(port string, opts Options) error {
+	server := NewServer(opts)
 
 	r := server.SetupRoutes()
 	r.GET("/ws", server.handleWebSocket)
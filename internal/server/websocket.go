@@ -7,6 +7,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+
+	"moshr/internal/video"
 )
 
 var upgrader = websocket.Upgrader{
@@ -75,6 +77,38 @@ func (h *WSHub) BroadcastMoshUpdate(moshID, status string, progress float64) {
 	h.broadcast <- message
 }
 
+// BroadcastMoshProgress sends a mosh_update that also carries the frame
+// rate and the estimated seconds left.
+func (h *WSHub) BroadcastMoshProgress(moshID, status string, progress video.Progress) {
+	message := WSMessage{
+		Type: "mosh_update",
+		Data: map[string]interface{}{
+			"mosh_id":  moshID,
+			"status":   status,
+			"progress": progress.Fraction,
+			"fps":      progress.FPS,
+			"eta":      progress.ETA,
+			"frame":    progress.Frame,
+		},
+	}
+	h.broadcast <- message
+}
+
+// BroadcastStepUpdate announces the step a chain mosh has moved on to.
+// Steps are numbered from zero.
+func (h *WSHub) BroadcastStepUpdate(moshID string, step, steps int, effect string) {
+	message := WSMessage{
+		Type: "mosh_step_update",
+		Data: map[string]interface{}{
+			"mosh_id": moshID,
+			"step":    step,
+			"steps":   steps,
+			"effect":  effect,
+		},
+	}
+	h.broadcast <- message
+}
+
 func (s *Server) handleWebSocket(c *gin.Context) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"moshr/internal/batch"
+	"moshr/internal/effects"
+	"moshr/internal/video"
+)
+
+// Remote workers lease queued jobs over these endpoints, fetch the job's
+// inputs, render them with the same effect code and upload the output.
+// Every call names the worker, and calls about a job the worker no longer
+// holds are refused with 409 Conflict so the worker can stop.
+
+// handleLeaseJob hands the next queued job to a worker, or answers 204 No
+// Content when nothing is queued.
+func (s *Server) handleLeaseJob(c *gin.Context) {
+	var req struct {
+		Worker string `json:"worker"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Worker == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Worker name is required"})
+		return
+	}
+
+	job, limit, ok := s.processor.Lease(req.Worker)
+	if !ok {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	inputs := make([]string, 0, 3)
+	for name := range batch.JobInputs(job) {
+		inputs = append(inputs, name)
+	}
+	sort.Strings(inputs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"job":           job,
+		"inputs":        inputs,
+		"lease_seconds": batch.LeaseTimeout.Seconds(),
+		"max_run_time":  limit.Seconds(),
+	})
+}
+
+// handleJobInput serves one of the files a leased job reads.
+func (s *Server) handleJobInput(c *gin.Context) {
+	inputs, err := s.processor.LeaseInputs(c.Param("id"), c.Query("worker"))
+	if err != nil {
+		leaseError(c, err)
+		return
+	}
+
+	path, exists := inputs[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job has no such input"})
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Input file not found"})
+		return
+	}
+
+	c.File(path)
+}
+
+// handleJobProgress renews a worker's lease and records the progress or
+// chain step it reports, if any.
+func (s *Server) handleJobProgress(c *gin.Context) {
+	jobID := c.Param("id")
+
+	var req struct {
+		Worker   string          `json:"worker"`
+		Progress *video.Progress `json:"progress"`
+		Step     *struct {
+			Index int          `json:"index"`
+			Total int          `json:"total"`
+			Step  effects.Step `json:"step"`
+		} `json:"step"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var err error
+	switch {
+	case req.Step != nil:
+		err = s.processor.RemoteStep(jobID, req.Worker, req.Step.Index, req.Step.Total, req.Step.Step)
+	case req.Progress != nil:
+		err = s.processor.RemoteProgress(jobID, req.Worker, *req.Progress)
+	default:
+		err = s.processor.Renew(jobID, req.Worker)
+	}
+	if err != nil {
+		leaseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lease_seconds": batch.LeaseTimeout.Seconds()})
+}
+
+// handleJobOutput stores the file a worker rendered for a job. The body is
+// the file itself, or a zip of the frames of an image sequence; it only
+// replaces the output once fully received.
+func (s *Server) handleJobOutput(c *gin.Context) {
+	outputPath, err := s.processor.LeaseOutputPath(c.Param("id"), c.Query("worker"))
+	if err != nil {
+		leaseError(c, err)
+		return
+	}
+
+	partPath := outputPath + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create output file"})
+		return
+	}
+	_, err = io.Copy(file, c.Request.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if c.ContentType() == "application/zip" {
+			err = video.UnzipFrames(partPath, outputPath)
+			os.Remove(partPath)
+		} else {
+			err = os.Rename(partPath, outputPath)
+		}
+	}
+	if err != nil {
+		os.Remove(partPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to store output: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Output stored"})
+}
+
+// handleCompleteJob finishes a job whose output the worker uploaded.
+func (s *Server) handleCompleteJob(c *gin.Context) {
+	var req struct {
+		Worker         string                 `json:"worker"`
+		ResolvedParams map[string]interface{} `json:"resolved_params"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := s.processor.CompleteRemote(c.Param("id"), req.Worker, req.ResolvedParams); err != nil {
+		leaseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job completed"})
+}
+
+// handleFailJob records why a worker could not finish a job.
+func (s *Server) handleFailJob(c *gin.Context) {
+	var req struct {
+		Worker string `json:"worker"`
+		Error  string `json:"error"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := s.processor.FailRemote(c.Param("id"), req.Worker, req.Error); err != nil {
+		leaseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job failed"})
+}
+
+func leaseError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, batch.ErrMoshNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, batch.ErrLeaseLost):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
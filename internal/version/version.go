@@ -0,0 +1,10 @@
+// Package version identifies the build of moshr.
+package version
+
+// Version of moshr. Release builds set it with
+//
+//	go build -ldflags "-X moshr/internal/version.Version=v1.2.3"
+//
+// Cached renders are only reused by the version that made them, since
+// effects may render differently from one version to the next.
+var Version = "dev"
@@ -0,0 +1,172 @@
+package video
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+
+	"moshr/internal/avi"
+)
+
+// AudioBend databends the audio chunks of an AVI alongside the video.
+// Corruption works on any audio; the sample effects need 16-bit PCM audio,
+// which the AVI conversion writes when asked for PCM audio, and are left
+// out for other codecs.
+type AudioBend struct {
+	// Bitcrush keeps this many bits of every sample, 0 to leave them
+	Bitcrush int `json:"audio_bitcrush,omitempty"`
+	// Corruption is the share of audio bytes replaced with random ones
+	Corruption float64 `json:"audio_corruption,omitempty"`
+	// Reverse plays every audio chunk backwards
+	Reverse bool `json:"audio_reverse,omitempty"`
+	// TapeStop winds the audio repeated with a bloom down until it stalls,
+	// like a tape machine switched off. It stutters the audio like the
+	// glitch audio mode.
+	TapeStop bool `json:"audio_tape_stop,omitempty"`
+	// Seed makes the corruption repeatable. Datamosh renders use the
+	// mosh's seed when it is 0.
+	Seed int64 `json:"audio_seed,omitempty"`
+}
+
+// Active reports whether any audio operation is set.
+func (b AudioBend) Active() bool {
+	return b.Bitcrush > 0 || b.Corruption > 0 || b.Reverse || b.TapeStop
+}
+
+func (b AudioBend) Validate() error {
+	if b.Bitcrush < 0 || b.Bitcrush > 15 {
+		return fmt.Errorf("audio bitcrush must be between 0 and 15 bits, 0 to leave the samples alone")
+	}
+	if b.Seed < 0 {
+		return fmt.Errorf("audio seed must not be negative")
+	}
+	if b.Corruption < 0 || b.Corruption > 1 {
+		return fmt.Errorf("audio corruption must be between 0 and 1")
+	}
+	return nil
+}
+
+// pcmFormat describes 16-bit PCM audio by its block size, the bytes of
+// one sample for every channel.
+type pcmFormat struct {
+	blockAlign int
+}
+
+// pcmAudio returns the format of the first audio stream when it holds
+// 16-bit PCM, and nil otherwise.
+func pcmAudio(header *avi.File) *pcmFormat {
+	for _, stream := range header.Streams() {
+		if stream.Header.Type != "auds" {
+			continue
+		}
+		// WAVEFORMATEX: format tag, channels, rate, byte rate, block
+		// align and bits per sample
+		format := stream.Format
+		if len(format) < 16 {
+			return nil
+		}
+		tag := binary.LittleEndian.Uint16(format[0:2])
+		channels := int(binary.LittleEndian.Uint16(format[2:4]))
+		bits := binary.LittleEndian.Uint16(format[14:16])
+		if tag != 1 || bits != 16 || channels == 0 {
+			return nil
+		}
+		return &pcmFormat{blockAlign: 2 * channels}
+	}
+	return nil
+}
+
+// audioBender applies an AudioBend to the chunks of one pass. Every pass
+// gets a fresh bender, so the corruption repeats byte for byte.
+type audioBender struct {
+	bend AudioBend
+	pcm  *pcmFormat
+	rng  *rand.Rand
+	// phase is the read position of a tape stop within the chunk it
+	// repeats, in blocks
+	phase float64
+}
+
+func newAudioBender(header *avi.File, bend AudioBend) *audioBender {
+	pcm := pcmAudio(header)
+	if pcm == nil && (bend.Bitcrush > 0 || bend.Reverse || bend.TapeStop) {
+		fmt.Printf("MOSH: Audio is not 16-bit PCM, only corrupting its bytes\n")
+	}
+	return &audioBender{
+		bend: bend,
+		pcm:  pcm,
+		rng:  rand.New(rand.NewSource(bend.Seed)),
+	}
+}
+
+// apply bends one audio chunk as it passes through.
+func (b *audioBender) apply(chunk *avi.Chunk) *avi.Chunk {
+	if !b.bend.Active() {
+		return chunk
+	}
+
+	bent := chunk.Copy()
+	bent.Data = make([]byte, len(chunk.Data))
+	copy(bent.Data, chunk.Data)
+
+	if b.bend.Corruption > 0 {
+		// The first bytes are kept so compressed frames still sync
+		for i := 4; i < len(bent.Data); i++ {
+			if b.rng.Float64() < b.bend.Corruption {
+				bent.Data[i] = byte(b.rng.Intn(256))
+			}
+		}
+	}
+	if b.pcm == nil {
+		return bent
+	}
+
+	if b.bend.Bitcrush > 0 {
+		mask := uint16(0xffff) << (16 - b.bend.Bitcrush)
+		for i := 0; i+1 < len(bent.Data); i += 2 {
+			sample := binary.LittleEndian.Uint16(bent.Data[i:])
+			binary.LittleEndian.PutUint16(bent.Data[i:], sample&mask)
+		}
+	}
+	if b.bend.Reverse {
+		blocks := len(bent.Data) / b.pcm.blockAlign
+		for i, j := 0, blocks-1; i < j; i, j = i+1, j-1 {
+			a := bent.Data[i*b.pcm.blockAlign : (i+1)*b.pcm.blockAlign]
+			z := bent.Data[j*b.pcm.blockAlign : (j+1)*b.pcm.blockAlign]
+			for k := range a {
+				a[k], z[k] = z[k], a[k]
+			}
+		}
+	}
+	return bent
+}
+
+// repeat returns the audio of copy index of a frame repeated count times.
+// A tape stop plays the copies ever slower until the last one stalls.
+func (b *audioBender) repeat(chunk *avi.Chunk, index, count int) *avi.Chunk {
+	if !b.bend.TapeStop || b.pcm == nil || count < 2 {
+		return chunk.Copy()
+	}
+	if index == 1 {
+		b.phase = 0
+	}
+
+	blockAlign := b.pcm.blockAlign
+	blocks := len(chunk.Data) / blockAlign
+	stopped := chunk.Copy()
+	stopped.Data = make([]byte, len(chunk.Data))
+	if blocks == 0 {
+		return stopped
+	}
+
+	// The speed falls from 1 to 0 across all the copies after the first
+	total := float64((count - 1) * blocks)
+	done := float64((index - 1) * blocks)
+	for i := 0; i < blocks; i++ {
+		speed := 1 - (done+float64(i))/total
+		src := int(b.phase) % blocks
+		copy(stopped.Data[i*blockAlign:(i+1)*blockAlign], chunk.Data[src*blockAlign:(src+1)*blockAlign])
+		b.phase += speed
+	}
+	return stopped
+}
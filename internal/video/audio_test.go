@@ -0,0 +1,187 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"moshr/internal/avi"
+)
+
+// pcmHeader returns the header of a test AVI with 16-bit stereo PCM audio.
+func pcmHeader(t *testing.T) *avi.File {
+	t.Helper()
+	header, err := avi.Parse(testAVI(t, "IP", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// samples returns an audio chunk holding 16-bit samples.
+func samples(values ...uint16) *avi.Chunk {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	return &avi.Chunk{ID: "01wb", Data: data}
+}
+
+func TestAudioBendValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		bend    AudioBend
+		wantErr bool
+	}{
+		{"zero", AudioBend{}, false},
+		{"everything", AudioBend{Bitcrush: 15, Corruption: 1, Reverse: true, TapeStop: true, Seed: 3}, false},
+		{"bitcrush above 15", AudioBend{Bitcrush: 16}, true},
+		{"negative bitcrush", AudioBend{Bitcrush: -1}, true},
+		{"negative seed", AudioBend{Seed: -1}, true},
+		{"corruption above 1", AudioBend{Corruption: 1.5}, true},
+		{"negative corruption", AudioBend{Corruption: -0.1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.bend.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPCMAudio(t *testing.T) {
+	if pcm := pcmAudio(pcmHeader(t)); pcm == nil || pcm.blockAlign != 4 {
+		t.Errorf("pcmAudio() = %+v, want stereo blocks of 4 bytes", pcm)
+	}
+
+	silent, err := avi.Parse(testAVI(t, "IP", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcm := pcmAudio(silent); pcm != nil {
+		t.Errorf("pcmAudio() of a clip without audio = %+v", pcm)
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		value  uint16
+	}{
+		{"mp3", 0, 0x55},
+		{"8-bit", 14, 8},
+		{"no channels", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := pcmHeader(t)
+			for _, stream := range header.Streams() {
+				if stream.Header.Type == "auds" {
+					binary.LittleEndian.PutUint16(stream.Format[tt.offset:], tt.value)
+				}
+			}
+			if pcm := pcmAudio(header); pcm != nil {
+				t.Errorf("pcmAudio() = %+v, want nil", pcm)
+			}
+		})
+	}
+}
+
+func TestAudioBenderApply(t *testing.T) {
+	tests := []struct {
+		name string
+		bend AudioBend
+		in   []uint16
+		want []uint16
+	}{
+		{"inactive", AudioBend{}, []uint16{0x1234, 0xffff}, []uint16{0x1234, 0xffff}},
+		{"bitcrush to 4 bits", AudioBend{Bitcrush: 4}, []uint16{0x1234, 0xffff}, []uint16{0x1000, 0xf000}},
+		{"bitcrush to 15 bits", AudioBend{Bitcrush: 15}, []uint16{0x1235, 0x0001}, []uint16{0x1234, 0x0000}},
+		// Stereo blocks swap whole, keeping left and right in place
+		{"reverse", AudioBend{Reverse: true}, []uint16{1, 2, 3, 4, 5, 6}, []uint16{5, 6, 3, 4, 1, 2}},
+		{"reverse and crush", AudioBend{Reverse: true, Bitcrush: 8}, []uint16{0x0101, 2, 0x0303, 4}, []uint16{0x0300, 0, 0x0100, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := samples(tt.in...)
+			original := append([]byte(nil), in.Data...)
+
+			got := newAudioBender(pcmHeader(t), tt.bend).apply(in)
+			if want := samples(tt.want...).Data; !bytes.Equal(got.Data, want) {
+				t.Errorf("apply() = %x, want %x", got.Data, want)
+			}
+			if !bytes.Equal(in.Data, original) {
+				t.Error("apply() changed the chunk it was given")
+			}
+		})
+	}
+}
+
+func TestAudioBenderCorruption(t *testing.T) {
+	chunk := &avi.Chunk{ID: "01wb", Data: bytes.Repeat([]byte{0x80}, 400)}
+	bend := func(seed int64) []byte {
+		// A clip without PCM audio only has its bytes corrupted
+		silent, err := avi.Parse(testAVI(t, "IP", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := newAudioBender(silent, AudioBend{Corruption: 0.5, Bitcrush: 1, Reverse: true, Seed: seed})
+		return append(b.apply(chunk).Data, b.apply(chunk).Data...)
+	}
+
+	first := bend(7)
+	if !bytes.Equal(first, bend(7)) {
+		t.Error("the same seed corrupted different bytes")
+	}
+	if bytes.Equal(first, bend(8)) {
+		t.Error("seeds 7 and 8 corrupted the same bytes")
+	}
+	if !bytes.Equal(first[:4], chunk.Data[:4]) || !bytes.Equal(first[400:404], chunk.Data[:4]) {
+		t.Error("the first bytes of a chunk were corrupted")
+	}
+	if changed := bytes.Count(first, []byte{0x80}); changed > 700 || changed < 300 {
+		t.Errorf("%d of 800 bytes were kept, want about half", changed)
+	}
+}
+
+func TestAudioBenderRepeat(t *testing.T) {
+	chunk := samples(1, 1, 2, 2, 3, 3, 4, 4)
+
+	tests := []struct {
+		name  string
+		bend  AudioBend
+		index int
+		count int
+		want  []uint16
+	}{
+		{"plain repeat", AudioBend{}, 2, 3, []uint16{1, 1, 2, 2, 3, 3, 4, 4}},
+		{"tape stop with one copy", AudioBend{TapeStop: true}, 1, 1, []uint16{1, 1, 2, 2, 3, 3, 4, 4}},
+		// Speeds 1, 7/8, 6/8 and 5/8 from the start of the chunk
+		{"tape stop first copy", AudioBend{TapeStop: true}, 1, 3, []uint16{1, 1, 2, 2, 2, 2, 3, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newAudioBender(pcmHeader(t), tt.bend)
+			got := b.repeat(chunk, tt.index, tt.count)
+			if want := samples(tt.want...).Data; !bytes.Equal(got.Data, want) {
+				t.Errorf("repeat() = %v, want %v", got.Data, want)
+			}
+			if got == chunk {
+				t.Error("repeat() returned the chunk it was given")
+			}
+		})
+	}
+
+	// The last copy slows to a stall, holding one block
+	b := newAudioBender(pcmHeader(t), AudioBend{TapeStop: true})
+	b.repeat(chunk, 1, 3)
+	last := b.repeat(chunk, 2, 3)
+	tail := last.Data[len(last.Data)-8:]
+	if !bytes.Equal(tail[:4], tail[4:]) {
+		t.Errorf("the end of a tape stop moves: %x", tail)
+	}
+}
@@ -1,214 +1,155 @@
 package video
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"io"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
-type Converter struct{}
+type Converter struct {
+	ctx context.Context
+}
 
 func NewConverter() *Converter {
 	return &Converter{}
 }
 
-func (c *Converter) MP4ToAVI(inputPath, outputPath string) error {
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-c:v", "libxvid", "-c:a", "libmp3lame", outputPath, "-y")
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("ffmpeg conversion failed: %v\nOutput: %s", err, string(output))
-	}
-
-	return nil
+// WithContext returns a converter whose ffmpeg and ffprobe runs are killed
+// when ctx is done.
+func (c *Converter) WithContext(ctx context.Context) *Converter {
+	copy := *c
+	copy.ctx = ctx
+	return &copy
 }
 
-func (c *Converter) GetVideoInfo(inputPath string) (*VideoInfo, error) {
-	cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", inputPath)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("ffprobe failed: %v", err)
+func (c *Converter) command(name string, args ...string) *exec.Cmd {
+	if c.ctx == nil {
+		return exec.Command(name, args...)
 	}
+	return exec.CommandContext(c.ctx, name, args...)
+}
 
-	return parseVideoInfo(string(output))
+func (c *Converter) MP4ToAVI(inputPath, outputPath string) error {
+	return c.MP4ToAVIWithOptions(inputPath, outputPath, AVIOptions{})
 }
 
-func (c *Converter) GeneratePreview(inputPath, outputPath string, width, height int) error {
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-vf", fmt.Sprintf("scale=%d:%d", width, height), "-frames:v", "1", outputPath, "-y")
+// MP4ToAVIWithOptions converts a source to an AVI encoded with opts.
+func (c *Converter) MP4ToAVIWithOptions(inputPath, outputPath string, opts AVIOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	cmd := c.command("ffmpeg", aviArgs(inputPath, outputPath, opts)...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("preview generation failed: %v\nOutput: %s", err, string(output))
+		return fmt.Errorf("ffmpeg conversion failed: %v\nOutput: %s", err, string(output))
 	}
 
 	return nil
 }
 
-func GetOutputPath(inputPath, suffix string) string {
-	ext := filepath.Ext(inputPath)
-	base := strings.TrimSuffix(inputPath, ext)
-	return fmt.Sprintf("%s_%s%s", base, suffix, ext)
-}
-
-func (c *Converter) MoshedAVIToMP4(inputPath, outputPath string) error {
-	cmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-c:v", "libx264",
-		"-preset", "medium",
-		"-crf", "18", // High quality
-		"-c:a", "aac",
-		"-b:a", "128k",
-		"-movflags", "+faststart", // Web-optimized
-		"-pix_fmt", "yuv420p", // Broad compatibility
-		"-progress", "pipe:1", // Enable progress reporting
-		outputPath,
-		"-y")
+// MP4ToAVIWithProgress converts like MP4ToAVIWithOptions while reporting
+// ffmpeg's progress.
+func (c *Converter) MP4ToAVIWithProgress(inputPath, outputPath string, opts AVIOptions, progress *Reporter) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	cmd := c.command("ffmpeg", aviArgs(inputPath, outputPath, opts)...)
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("moshed AVI to MP4 conversion failed: %v\nOutput: %s", err, string(output))
+	if err := progress.Run(cmd); err != nil {
+		return fmt.Errorf("ffmpeg conversion failed: %v", err)
 	}
 
 	return nil
 }
 
-func (c *Converter) MoshedAVIToWebM(inputPath, outputPath string) error {
-	cmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-c:v", "libvpx-vp9",
-		"-crf", "30", // Good quality for VP9
-		"-b:v", "0", // Variable bitrate
-		"-c:a", "libvorbis",
-		"-b:a", "128k",
-		"-progress", "pipe:1", // Enable progress reporting
-		outputPath,
-		"-y")
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("moshed AVI to WebM conversion failed: %v\nOutput: %s", err, string(output))
-	}
-
-	return nil
+func aviArgs(inputPath, outputPath string, opts AVIOptions) []string {
+	args := append([]string{"-i", inputPath}, opts.args()...)
+	return append(args, outputPath, "-y")
 }
 
-func (c *Converter) MoshedAVIToMP4WithProgress(inputPath, outputPath string, progressCallback func(float64)) error {
-	cmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-c:v", "libx264",
-		"-preset", "medium",
-		"-crf", "18",
-		"-c:a", "aac",
-		"-b:a", "128k",
-		"-movflags", "+faststart",
-		"-pix_fmt", "yuv420p",
-		"-progress", "pipe:1",
-		outputPath,
-		"-y")
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
-	}
+// ConformOptions describe the shared encoding that clips must have before
+// their AVI chunks can be spliced together.
+type ConformOptions struct {
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Framerate float64 `json:"framerate"`
+	// Audio forces an audio track, adding silence to clips without one.
+	Audio bool `json:"audio"`
+}
 
-	stderr, err := cmd.StderrPipe()
+// ConformAVI re-encodes a clip to Xvid with fixed settings so its bitstream
+// can follow another clip conformed with the same options. B-frames are
+// disabled since a spliced stream cannot reorder across the cut.
+func (c *Converter) ConformAVI(inputPath, outputPath string, opts ConformOptions) error {
+	info, err := c.GetVideoInfo(inputPath)
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+		return err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start ffmpeg: %v", err)
+	args := []string{"-i", inputPath}
+	silence := opts.Audio && info.AudioCodec == ""
+	if silence {
+		args = append(args, "-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100")
 	}
 
-	scanner := bufio.NewScanner(stdout)
-	var totalTime float64
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "out_time_ms=") {
-			timeStr := strings.TrimPrefix(line, "out_time_ms=")
-			if timeMs, err := strconv.ParseFloat(timeStr, 64); err == nil {
-				currentTime := timeMs / 1000000.0
-				if totalTime == 0 {
-					totalTime = currentTime * 2
-				}
-				if totalTime > 0 {
-					progress := currentTime / totalTime
-					if progress > 1.0 {
-						progress = 1.0
-					}
-					progressCallback(progress)
-				}
-			}
+	args = append(args,
+		"-vf", fmt.Sprintf("scale=%d:%d,setsar=1,fps=%s", opts.Width, opts.Height, strconv.FormatFloat(opts.Framerate, 'f', -1, 64)),
+		"-c:v", "libxvid",
+		"-bf", "0",
+		"-g", "250",
+		"-qscale:v", "3",
+	)
+
+	if opts.Audio {
+		if silence {
+			args = append(args, "-map", "0:v:0", "-map", "1:a:0", "-shortest")
+		} else {
+			args = append(args, "-map", "0:v:0", "-map", "0:a:0")
 		}
+		args = append(args, "-c:a", "libmp3lame", "-ar", "44100", "-ac", "2", "-b:a", "192k")
+	} else {
+		args = append(args, "-an")
 	}
+	args = append(args, "-f", "avi", outputPath, "-y")
 
-	stderrBytes, _ := io.ReadAll(stderr)
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("moshed AVI to MP4 conversion failed: %v\nOutput: %s", err, string(stderrBytes))
+	cmd := c.command("ffmpeg", args...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg conform failed: %v\nOutput: %s", err, string(output))
 	}
 
 	return nil
 }
 
-func (c *Converter) MoshedAVIToWebMWithProgress(inputPath, outputPath string, progressCallback func(float64)) error {
-	cmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-c:v", "libvpx-vp9",
-		"-crf", "30",
-		"-b:v", "0",
-		"-c:a", "libvorbis",
-		"-b:a", "128k",
-		"-progress", "pipe:1",
-		outputPath,
-		"-y")
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
-	}
+func (c *Converter) GetVideoInfo(inputPath string) (*VideoInfo, error) {
+	cmd := c.command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", inputPath)
 
-	stderr, err := cmd.StderrPipe()
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
 	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start ffmpeg: %v", err)
-	}
+	return parseVideoInfo(string(output))
+}
 
-	scanner := bufio.NewScanner(stdout)
-	var totalTime float64
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "out_time_ms=") {
-			timeStr := strings.TrimPrefix(line, "out_time_ms=")
-			if timeMs, err := strconv.ParseFloat(timeStr, 64); err == nil {
-				currentTime := timeMs / 1000000.0
-				if totalTime == 0 {
-					totalTime = currentTime * 2
-				}
-				if totalTime > 0 {
-					progress := currentTime / totalTime
-					if progress > 1.0 {
-						progress = 1.0
-					}
-					progressCallback(progress)
-				}
-			}
-		}
-	}
+func (c *Converter) GeneratePreview(inputPath, outputPath string, width, height int) error {
+	cmd := c.command("ffmpeg", "-i", inputPath, "-vf", fmt.Sprintf("scale=%d:%d", width, height), "-frames:v", "1", outputPath, "-y")
 
-	stderrBytes, _ := io.ReadAll(stderr)
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("moshed AVI to WebM conversion failed: %v\nOutput: %s", err, string(stderrBytes))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("preview generation failed: %v\nOutput: %s", err, string(output))
 	}
 
 	return nil
 }
+
+func GetOutputPath(inputPath, suffix string) string {
+	ext := filepath.Ext(inputPath)
+	base := strings.TrimSuffix(inputPath, ext)
+	return fmt.Sprintf("%s_%s%s", base, suffix, ext)
+}
@@ -0,0 +1,245 @@
+package video
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Keyframe interpolation modes, describing how a value moves towards the
+// next keyframe
+const (
+	InterpLinear = "linear"
+	InterpEase   = "ease"
+	InterpStep   = "step"
+)
+
+// LFO shapes
+const (
+	LFOSine     = "sine"
+	LFOTriangle = "triangle"
+	LFOSquare   = "square"
+	LFOSaw      = "saw"
+)
+
+// Curve animates a parameter over the clip. Keyframes give an envelope, an
+// LFO gives a repeating wave. When both are set the LFO output scales the
+// keyframed value, so an LFO between 0 and 1 acts as a tremolo on top of
+// the envelope.
+type Curve struct {
+	Keyframes []Keyframe `json:"keyframes,omitempty"`
+	LFO       *LFO       `json:"lfo,omitempty"`
+}
+
+// Keyframe pins a value at a time in seconds. Interpolation controls the
+// segment up to the next keyframe and defaults to linear.
+type Keyframe struct {
+	Time          float64 `json:"time"`
+	Value         float64 `json:"value"`
+	Interpolation string  `json:"interpolation,omitempty"`
+}
+
+// LFO oscillates between Min and Max at Frequency cycles per second. Phase
+// shifts the wave by a fraction of a cycle.
+type LFO struct {
+	Shape     string  `json:"shape"`
+	Frequency float64 `json:"frequency"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Phase     float64 `json:"phase,omitempty"`
+}
+
+func (c Curve) Validate() error {
+	if len(c.Keyframes) == 0 && c.LFO == nil {
+		return fmt.Errorf("curve needs keyframes or an lfo")
+	}
+
+	for i, k := range c.Keyframes {
+		if k.Time < 0 {
+			return fmt.Errorf("keyframe %d has a negative time", i)
+		}
+		if i > 0 && k.Time <= c.Keyframes[i-1].Time {
+			return fmt.Errorf("keyframe %d is not after keyframe %d", i, i-1)
+		}
+		switch k.Interpolation {
+		case "", InterpLinear, InterpEase, InterpStep:
+		default:
+			return fmt.Errorf("keyframe %d: unknown interpolation %q", i, k.Interpolation)
+		}
+	}
+
+	if c.LFO != nil {
+		switch c.LFO.Shape {
+		case LFOSine, LFOTriangle, LFOSquare, LFOSaw:
+		default:
+			return fmt.Errorf("unknown lfo shape %q", c.LFO.Shape)
+		}
+		if c.LFO.Frequency <= 0 {
+			return fmt.Errorf("lfo frequency must be positive")
+		}
+	}
+	return nil
+}
+
+// At evaluates the curve at a time in seconds.
+func (c Curve) At(t float64) float64 {
+	value := 1.0
+	if len(c.Keyframes) > 0 {
+		value = c.envelopeAt(t)
+	}
+	if c.LFO != nil {
+		value *= c.LFO.at(t)
+	}
+	return value
+}
+
+func (c Curve) envelopeAt(t float64) float64 {
+	first, last := c.Keyframes[0], c.Keyframes[len(c.Keyframes)-1]
+	if t <= first.Time {
+		return first.Value
+	}
+	if t >= last.Time {
+		return last.Value
+	}
+
+	for i := 0; i < len(c.Keyframes)-1; i++ {
+		a, b := c.Keyframes[i], c.Keyframes[i+1]
+		if t >= b.Time {
+			continue
+		}
+		u := (t - a.Time) / (b.Time - a.Time)
+		switch a.Interpolation {
+		case InterpStep:
+			return a.Value
+		case InterpEase:
+			u = u * u * (3 - 2*u)
+		}
+		return a.Value + (b.Value-a.Value)*u
+	}
+	return last.Value
+}
+
+func (l *LFO) at(t float64) float64 {
+	phase := l.Frequency*t + l.Phase
+	phase -= math.Floor(phase)
+
+	var wave float64 // -1 to 1
+	switch l.Shape {
+	case LFOTriangle:
+		wave = 1 - 4*math.Abs(phase-0.5)
+	case LFOSquare:
+		wave = 1
+		if phase >= 0.5 {
+			wave = -1
+		}
+	case LFOSaw:
+		wave = 2*phase - 1
+	default:
+		wave = math.Sin(2 * math.Pi * phase)
+	}
+	return l.Min + (l.Max-l.Min)*(wave+1)/2
+}
+
+// Peak returns the largest absolute value the curve can reach.
+func (c Curve) Peak() float64 {
+	peak := 1.0
+	if len(c.Keyframes) > 0 {
+		peak = 0
+		for _, k := range c.Keyframes {
+			peak = math.Max(peak, math.Abs(k.Value))
+		}
+	}
+	if c.LFO != nil {
+		peak *= math.Max(math.Abs(c.LFO.Min), math.Abs(c.LFO.Max))
+	}
+	return peak
+}
+
+// Scale multiplies every value of the curve by k.
+func (c Curve) Scale(k float64) Curve {
+	if len(c.Keyframes) > 0 {
+		keyframes := make([]Keyframe, len(c.Keyframes))
+		for i, kf := range c.Keyframes {
+			kf.Value *= k
+			keyframes[i] = kf
+		}
+		c.Keyframes = keyframes
+		return c
+	}
+
+	lfo := *c.LFO
+	lfo.Min *= k
+	lfo.Max *= k
+	c.LFO = &lfo
+	return c
+}
+
+// Expr compiles the curve into an ffmpeg expression of the time variable
+// t, which is "t" for most filters and "T" for geq and blend. The result
+// contains commas, so it has to be quoted inside a filtergraph.
+func (c Curve) Expr(t string) string {
+	var parts []string
+	if len(c.Keyframes) > 0 {
+		parts = append(parts, c.envelopeExpr(t))
+	}
+	if c.LFO != nil {
+		parts = append(parts, c.LFO.expr(t))
+	}
+	if len(parts) == 0 {
+		return "1"
+	}
+	return strings.Join(parts, "*")
+}
+
+func (c Curve) envelopeExpr(t string) string {
+	last := c.Keyframes[len(c.Keyframes)-1]
+	expr := num(last.Value)
+
+	// Built from the last segment backwards so every if() nests the later
+	// segments in its else branch
+	for i := len(c.Keyframes) - 2; i >= 0; i-- {
+		a, b := c.Keyframes[i], c.Keyframes[i+1]
+		u := fmt.Sprintf("(%s-%s)/%s", t, num(a.Time), num(b.Time-a.Time))
+
+		var segment string
+		switch a.Interpolation {
+		case InterpStep:
+			segment = num(a.Value)
+		case InterpEase:
+			segment = fmt.Sprintf("%s+%s*pow(%s,2)*(3-2*%s)", num(a.Value), num(b.Value-a.Value), u, u)
+		default:
+			segment = fmt.Sprintf("%s+%s*%s", num(a.Value), num(b.Value-a.Value), u)
+		}
+		expr = fmt.Sprintf("if(lt(%s,%s),%s,%s)", t, num(b.Time), segment, expr)
+	}
+
+	first := c.Keyframes[0]
+	return fmt.Sprintf("(if(lt(%s,%s),%s,%s))", t, num(first.Time), num(first.Value), expr)
+}
+
+func (l *LFO) expr(t string) string {
+	phase := fmt.Sprintf("mod(%s*%s+%s,1)", num(l.Frequency), t, num(l.Phase-math.Floor(l.Phase)))
+
+	var wave string
+	switch l.Shape {
+	case LFOTriangle:
+		wave = fmt.Sprintf("(1-4*abs(%s-0.5))", phase)
+	case LFOSquare:
+		wave = fmt.Sprintf("if(lt(%s,0.5),1,-1)", phase)
+	case LFOSaw:
+		wave = fmt.Sprintf("(2*%s-1)", phase)
+	default:
+		wave = fmt.Sprintf("sin(2*PI*%s)", phase)
+	}
+	return fmt.Sprintf("(%s+%s*(%s+1)/2)", num(l.Min), num(l.Max-l.Min), wave)
+}
+
+// num formats a constant for an ffmpeg expression, wrapping negative
+// values so they can follow an operator.
+func num(v float64) string {
+	s := fmt.Sprintf("%g", v)
+	if v < 0 {
+		return "(" + s + ")"
+	}
+	return s
+}
@@ -0,0 +1,186 @@
+package video
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCurveValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		curve   Curve
+		wantErr bool
+	}{
+		{"empty", Curve{}, true},
+		{"keyframes", Curve{Keyframes: []Keyframe{{Time: 0, Value: 1}, {Time: 2, Value: 3, Interpolation: InterpEase}}}, false},
+		{"lfo", Curve{LFO: &LFO{Shape: LFOSaw, Frequency: 0.5}}, false},
+		{"negative time", Curve{Keyframes: []Keyframe{{Time: -1, Value: 1}}}, true},
+		{"keyframes out of order", Curve{Keyframes: []Keyframe{{Time: 2}, {Time: 1}}}, true},
+		{"keyframes at the same time", Curve{Keyframes: []Keyframe{{Time: 1}, {Time: 1}}}, true},
+		{"unknown interpolation", Curve{Keyframes: []Keyframe{{Time: 0, Interpolation: "cubic"}}}, true},
+		{"unknown lfo shape", Curve{LFO: &LFO{Shape: "noise", Frequency: 1}}, true},
+		{"zero lfo frequency", Curve{LFO: &LFO{Shape: LFOSine}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.curve.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCurveAt(t *testing.T) {
+	linear := Curve{Keyframes: []Keyframe{{Time: 1, Value: 0}, {Time: 3, Value: 10}}}
+	mixed := Curve{Keyframes: []Keyframe{
+		{Time: 0, Value: 2, Interpolation: InterpStep},
+		{Time: 1, Value: 4, Interpolation: InterpEase},
+		{Time: 3, Value: 8},
+	}}
+	lfo := func(shape string) Curve {
+		return Curve{LFO: &LFO{Shape: shape, Frequency: 1, Min: 0, Max: 2}}
+	}
+
+	tests := []struct {
+		name  string
+		curve Curve
+		at    float64
+		want  float64
+	}{
+		{"before the first keyframe", linear, 0, 0},
+		{"linear midpoint", linear, 2, 5},
+		{"linear quarter", linear, 1.5, 2.5},
+		{"after the last keyframe", linear, 9, 10},
+		{"step holds", mixed, 0.99, 2},
+		{"step lands", mixed, 1, 4},
+		{"ease midpoint", mixed, 2, 6},
+		{"ease is slow to start", mixed, 1.5, 4 + 4*0.15625},
+		{"sine start", lfo(LFOSine), 0, 1},
+		{"sine peak", lfo(LFOSine), 0.25, 2},
+		{"sine wraps", lfo(LFOSine), 1.75, 0},
+		{"triangle trough", lfo(LFOTriangle), 0, 0},
+		{"triangle peak", lfo(LFOTriangle), 0.5, 2},
+		{"square high", lfo(LFOSquare), 0.25, 2},
+		{"square low", lfo(LFOSquare), 0.75, 0},
+		{"saw", lfo(LFOSaw), 0.25, 0.5},
+		{"phase shifts the wave", Curve{LFO: &LFO{Shape: LFOSaw, Frequency: 1, Max: 1, Phase: 0.5}}, 0, 0.5},
+		{"lfo scales the envelope", Curve{Keyframes: linear.Keyframes, LFO: &LFO{Shape: LFOSquare, Frequency: 1, Min: 0.5, Max: 0.5}}, 2, 2.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.curve.At(tt.at); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("At(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurvePeakAndScale(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve Curve
+		peak  float64
+	}{
+		{"keyframes", Curve{Keyframes: []Keyframe{{Time: 0, Value: 2}, {Time: 1, Value: -5}}}, 5},
+		{"lfo", Curve{LFO: &LFO{Shape: LFOSine, Frequency: 1, Min: -3, Max: 1}}, 3},
+		{"both", Curve{Keyframes: []Keyframe{{Time: 0, Value: 4}}, LFO: &LFO{Shape: LFOSine, Frequency: 1, Min: 0, Max: 0.5}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.curve.Peak(); got != tt.peak {
+				t.Errorf("Peak() = %v, want %v", got, tt.peak)
+			}
+			scaled := tt.curve.Scale(2)
+			if got := scaled.Peak(); got != 2*tt.peak {
+				t.Errorf("scaled Peak() = %v, want %v", got, 2*tt.peak)
+			}
+			if got, want := scaled.At(0.3), 2*tt.curve.At(0.3); math.Abs(got-want) > 1e-9 {
+				t.Errorf("scaled At(0.3) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCurveExpr(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve Curve
+		t     string
+		want  string
+	}{
+		{"empty", Curve{}, "t", "1"},
+		{
+			"linear",
+			Curve{Keyframes: []Keyframe{{Time: 0, Value: 0}, {Time: 2, Value: 10}}},
+			"t",
+			"(if(lt(t,0),0,if(lt(t,2),0+10*(t-0)/2,10)))",
+		},
+		{
+			"step with a negative value",
+			Curve{Keyframes: []Keyframe{{Time: 1, Value: -2, Interpolation: InterpStep}, {Time: 3, Value: 4}}},
+			"T",
+			"(if(lt(T,1),(-2),if(lt(T,3),(-2),4)))",
+		},
+		{
+			"ease",
+			Curve{Keyframes: []Keyframe{{Time: 0, Value: 1, Interpolation: InterpEase}, {Time: 1, Value: 3}}},
+			"t",
+			"(if(lt(t,0),1,if(lt(t,1),1+2*pow((t-0)/1,2)*(3-2*(t-0)/1),3)))",
+		},
+		{
+			"square lfo with a wrapped phase",
+			Curve{LFO: &LFO{Shape: LFOSquare, Frequency: 2, Min: 0, Max: 1, Phase: 1.25}},
+			"t",
+			"(0+1*(if(lt(mod(2*t+0.25,1),0.5),1,-1)+1)/2)",
+		},
+		{
+			"envelope times lfo",
+			Curve{Keyframes: []Keyframe{{Time: 0, Value: 3}}, LFO: &LFO{Shape: LFOSaw, Frequency: 1, Min: 0, Max: 1}},
+			"t",
+			"(if(lt(t,0),3,3))*(0+1*((2*mod(1*t+0,1)-1)+1)/2)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.curve.Expr(tt.t); got != tt.want {
+				t.Errorf("Expr(%q) =\n%s\nwant\n%s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameOperationAnimated(t *testing.T) {
+	params := MoshParams{
+		PFrameDuplication: true,
+		DuplicationCount:  3,
+		Animate: map[string]Curve{
+			"duplication_count": {Keyframes: []Keyframe{{Time: 0, Value: -4}, {Time: 2, Value: 8}}},
+		},
+		Windows: []MoshWindow{{StartTime: 0, EndTime: 1.5, Operation: MoshOpBloom}},
+	}
+
+	tests := []struct {
+		frame int
+		want  int
+	}{
+		// Negative counts clamp to zero
+		{0, 0},
+		{20, 0},
+		{30, 2},
+		{40, 4},
+		// Outside the window nothing is duplicated
+		{45, 0},
+		{60, 0},
+	}
+
+	for _, tt := range tests {
+		if _, got := params.frameOperation(tt.frame, 30); got != tt.want {
+			t.Errorf("frame %d duplication = %d, want %d", tt.frame, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package video
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Export containers. ContainerPNG writes a sequence of PNG frames into a
+// directory instead of a single file.
+const (
+	ContainerMP4  = "mp4"
+	ContainerWebM = "webm"
+	ContainerMOV  = "mov"
+	ContainerMKV  = "mkv"
+	ContainerGIF  = "gif"
+	ContainerWebP = "webp"
+	ContainerAPNG = "apng"
+	ContainerPNG  = "png"
+)
+
+// ExportProfile describes how a moshed AVI is exported for playback or
+// editing elsewhere.
+type ExportProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Builtin profiles come with moshr and cannot be changed
+	Builtin bool `json:"builtin,omitempty"`
+
+	Container string `json:"container"`
+	// Codec is the ffmpeg video encoder. Containers that only hold one
+	// kind of video ignore it.
+	Codec string `json:"codec,omitempty"`
+	// Quality is the CRF of x264, x265 and VP9, where lower is better,
+	// or the quality of WebP from 0 to 100, where higher is better. Zero
+	// leaves it to the encoder.
+	Quality int `json:"quality,omitempty"`
+	// Profile is the encoder profile, such as "hq" for ProRes
+	Profile     string `json:"profile,omitempty"`
+	Preset      string `json:"preset,omitempty"`
+	PixelFormat string `json:"pixel_format,omitempty"`
+
+	// Width and Height scale the video. Leaving one out keeps the aspect
+	// ratio and leaving both out keeps the size.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// FPS changes the frame rate, zero keeps it
+	FPS float64 `json:"fps,omitempty"`
+
+	// Audio is the ffmpeg audio encoder, empty to drop the audio.
+	// Animations and image sequences never have audio.
+	Audio        string `json:"audio,omitempty"`
+	AudioBitrate string `json:"audio_bitrate,omitempty"`
+
+	// Colors and Dither set up the palette a GIF is reduced to
+	Colors int    `json:"colors,omitempty"`
+	Dither string `json:"dither,omitempty"`
+	// PlayOnce stops GIF, WebP and APNG animations after one play
+	// instead of looping
+	PlayOnce bool `json:"play_once,omitempty"`
+}
+
+var (
+	profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+	proResProfiles = map[string]int{"proxy": 0, "lt": 1, "standard": 2, "hq": 3, "4444": 4, "4444xq": 5}
+	ditherModes    = map[string]bool{"none": true, "bayer": true, "heckbert": true, "floyd_steinberg": true, "sierra2": true, "sierra2_4a": true}
+)
+
+// BuiltinExportProfiles returns the profiles moshr ships with, sorted by
+// name. mp4 and webm match the exports made before profiles existed.
+func BuiltinExportProfiles() []ExportProfile {
+	profiles := []ExportProfile{
+		{
+			Name:         "mp4",
+			Description:  "H.264 MP4 for the web",
+			Container:    ContainerMP4,
+			Codec:        "libx264",
+			Quality:      18,
+			Preset:       "medium",
+			PixelFormat:  "yuv420p",
+			Audio:        "aac",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "webm",
+			Description:  "VP9 WebM for the web",
+			Container:    ContainerWebM,
+			Codec:        "libvpx-vp9",
+			Quality:      30,
+			Audio:        "libvorbis",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:        "gif",
+			Description: "Palette optimized GIF, 480 pixels wide at 15 fps",
+			Container:   ContainerGIF,
+			Width:       480,
+			FPS:         15,
+			Colors:      256,
+			Dither:      "sierra2_4a",
+		},
+		{
+			Name:        "prores",
+			Description: "ProRes 422 HQ in a MOV for editors",
+			Container:   ContainerMOV,
+			Codec:       "prores_ks",
+			Profile:     "hq",
+			PixelFormat: "yuv422p10le",
+			Audio:       "pcm_s16le",
+		},
+		{
+			Name:        "webp",
+			Description: "Animated WebP for social posts, 640 pixels wide at 20 fps",
+			Container:   ContainerWebP,
+			Quality:     75,
+			Width:       640,
+			FPS:         20,
+		},
+		{
+			Name:        "apng",
+			Description: "Animated PNG for social posts, 640 pixels wide at 20 fps",
+			Container:   ContainerAPNG,
+			Width:       640,
+			FPS:         20,
+		},
+		{
+			Name:        "png",
+			Description: "PNG sequence, one lossless file per frame",
+			Container:   ContainerPNG,
+		},
+	}
+
+	for i := range profiles {
+		profiles[i].Builtin = true
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles
+}
+
+// Validate checks that a profile can be exported with.
+func (p ExportProfile) Validate() error {
+	if !profileName.MatchString(p.Name) {
+		return fmt.Errorf("profile name must be lowercase letters, digits, dashes and underscores")
+	}
+
+	switch p.Container {
+	case ContainerMP4, ContainerWebM, ContainerMOV, ContainerMKV:
+	case ContainerGIF, ContainerWebP, ContainerAPNG, ContainerPNG:
+		if p.Audio != "" {
+			return fmt.Errorf("%s exports cannot have audio", p.Container)
+		}
+	default:
+		return fmt.Errorf("unknown container %q", p.Container)
+	}
+
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("quality must be between 0 and 100")
+	}
+	if p.Width < 0 || p.Height < 0 || p.Width > 8192 || p.Height > 8192 {
+		return fmt.Errorf("width and height must be between 0 and 8192")
+	}
+	if p.FPS < 0 || p.FPS > 120 {
+		return fmt.Errorf("fps must be between 0 and 120")
+	}
+	if p.Colors != 0 && (p.Colors < 2 || p.Colors > 256) {
+		return fmt.Errorf("colors must be between 2 and 256")
+	}
+	if p.Dither != "" && !ditherModes[p.Dither] {
+		return fmt.Errorf("unknown dither %q", p.Dither)
+	}
+	if p.Codec == "prores_ks" && p.Profile != "" {
+		if _, ok := proResProfiles[p.Profile]; !ok {
+			return fmt.Errorf("unknown ProRes profile %q", p.Profile)
+		}
+	}
+
+	// Everything passed to ffmpeg as a single argument
+	for _, value := range []string{p.Codec, p.Profile, p.Preset, p.PixelFormat, p.Audio, p.AudioBitrate} {
+		if strings.ContainsAny(value, " :,;=[]'\"") || strings.HasPrefix(value, "-") {
+			return fmt.Errorf("invalid encoder setting %q", value)
+		}
+	}
+	return nil
+}
+
+// Extension is the file extension of the profile's exports, empty for
+// image sequences, which are written into a directory.
+func (p ExportProfile) Extension() string {
+	switch p.Container {
+	case ContainerAPNG:
+		return ".png"
+	case ContainerPNG:
+		return ""
+	}
+	return "." + p.Container
+}
+
+// Export converts a moshed AVI according to a profile, reporting ffmpeg's
+// progress. Image sequences replace the directory at outputPath with one
+// holding frame_00001.png and onwards.
+func (c *Converter) Export(inputPath, outputPath string, profile ExportProfile, progress *Reporter) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+
+	args := []string{"-i", inputPath}
+	filters := profile.filters()
+	target := outputPath
+
+	switch profile.Container {
+	case ContainerGIF:
+		colors := profile.Colors
+		if colors == 0 {
+			colors = 256
+		}
+		dither := profile.Dither
+		if dither == "" {
+			dither = "sierra2_4a"
+		}
+		// One palette for the whole clip, weighted towards what moves
+		graph := fmt.Sprintf("split[a][b];[a]palettegen=max_colors=%d:stats_mode=diff[p];[b][p]paletteuse=dither=%s", colors, dither)
+		if filters != "" {
+			graph = filters + "," + graph
+		}
+		args = append(args, "-filter_complex", graph, "-loop", loopValue(profile, "-1"))
+	case ContainerWebP:
+		args = appendFilters(args, filters)
+		args = append(args, "-c:v", "libwebp", "-loop", loopValue(profile, "1"))
+		if profile.Quality > 0 {
+			args = append(args, "-quality", strconv.Itoa(profile.Quality))
+		}
+	case ContainerAPNG:
+		args = appendFilters(args, filters)
+		args = append(args, "-c:v", "apng", "-f", "apng", "-plays", loopValue(profile, "1"))
+	case ContainerPNG:
+		if err := os.RemoveAll(outputPath); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(outputPath, 0755); err != nil {
+			return err
+		}
+		target = filepath.Join(outputPath, "frame_%05d.png")
+		args = appendFilters(args, filters)
+		args = append(args, "-c:v", "png")
+	default:
+		args = appendFilters(args, filters)
+		args = append(args, profile.videoArgs()...)
+	}
+
+	if profile.Audio == "" {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-c:a", profile.Audio)
+		if profile.AudioBitrate != "" {
+			args = append(args, "-b:a", profile.AudioBitrate)
+		}
+	}
+	if profile.Container == ContainerMP4 {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, target, "-y")
+
+	if err := progress.Run(c.command("ffmpeg", args...)); err != nil {
+		if profile.Container == ContainerPNG {
+			os.RemoveAll(outputPath)
+		}
+		return fmt.Errorf("%s export failed: %v", profile.Name, err)
+	}
+	return nil
+}
+
+// videoArgs are the encoder settings of video containers.
+func (p ExportProfile) videoArgs() []string {
+	var args []string
+	if p.Codec != "" {
+		args = append(args, "-c:v", p.Codec)
+	}
+	if p.Quality > 0 {
+		args = append(args, "-crf", strconv.Itoa(p.Quality))
+		if p.Codec == "libvpx-vp9" {
+			// Constant quality rather than constrained
+			args = append(args, "-b:v", "0")
+		}
+	}
+	if p.Profile != "" {
+		profile := p.Profile
+		if n, ok := proResProfiles[profile]; ok && p.Codec == "prores_ks" {
+			profile = strconv.Itoa(n)
+		}
+		args = append(args, "-profile:v", profile)
+	}
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt", p.PixelFormat)
+	}
+	return args
+}
+
+// filters resamples and scales the video as the profile asks.
+func (p ExportProfile) filters() string {
+	var filters []string
+	if p.FPS > 0 {
+		filters = append(filters, "fps="+strconv.FormatFloat(p.FPS, 'f', -1, 64))
+	}
+	if p.Width > 0 || p.Height > 0 {
+		width, height := p.Width, p.Height
+		// -2 keeps the aspect ratio at an even size, which most
+		// encoders need
+		if width == 0 {
+			width = -2
+		}
+		if height == 0 {
+			height = -2
+		}
+		filters = append(filters, fmt.Sprintf("scale=%d:%d:flags=lanczos", width, height))
+	}
+	return strings.Join(filters, ",")
+}
+
+func appendFilters(args []string, filters string) []string {
+	if filters == "" {
+		return args
+	}
+	return append(args, "-vf", filters)
+}
+
+// loopValue is the value of an animation's loop option: 0 loops forever
+// for every format, while playing once is spelled differently by each.
+func loopValue(p ExportProfile, once string) string {
+	if p.PlayOnce {
+		return once
+	}
+	return "0"
+}
+
+// ZipFrames writes the frames of an image sequence export to w as a zip
+// archive, which is how sequences are downloaded and uploaded.
+func ZipFrames(w io.Writer, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		// Frames are already compressed
+		dst, err := archive.CreateHeader(&zip.FileHeader{Name: entry.Name(), Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// UnzipFrames replaces dir with the frames of a zip archive written by
+// ZipFrames.
+func UnzipFrames(archivePath, dir string) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	tmp := dir + ".tmp"
+	os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+
+	for _, file := range archive.File {
+		name := filepath.Base(file.Name)
+		if name != file.Name || strings.HasPrefix(name, ".") {
+			os.RemoveAll(tmp)
+			return fmt.Errorf("unexpected file %q in archive", file.Name)
+		}
+		if err := extract(file, filepath.Join(tmp, name)); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+
+	os.RemoveAll(dir)
+	return os.Rename(tmp, dir)
+}
+
+func extract(file *zip.File, path string) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
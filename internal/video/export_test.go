@@ -0,0 +1,240 @@
+package video
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinExportProfiles(t *testing.T) {
+	profiles := BuiltinExportProfiles()
+	if !sort.SliceIsSorted(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name }) {
+		t.Error("builtin profiles are not sorted by name")
+	}
+	for _, profile := range profiles {
+		if err := profile.Validate(); err != nil {
+			t.Errorf("builtin profile %s is invalid: %v", profile.Name, err)
+		}
+		if !profile.Builtin || profile.Description == "" {
+			t.Errorf("builtin profile %s = %+v", profile.Name, profile)
+		}
+	}
+}
+
+func TestExportProfileValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile ExportProfile
+		wantErr bool
+	}{
+		{"mp4", ExportProfile{Name: "mine", Container: ContainerMP4, Codec: "libx265", Quality: 28, Audio: "aac"}, false},
+		{"gif", ExportProfile{Name: "small_gif", Container: ContainerGIF, Width: 320, Colors: 64, Dither: "bayer"}, false},
+		{"prores", ExportProfile{Name: "edit-4444", Container: ContainerMOV, Codec: "prores_ks", Profile: "4444"}, false},
+		{"empty name", ExportProfile{Container: ContainerMP4}, true},
+		{"upper case name", ExportProfile{Name: "Mine", Container: ContainerMP4}, true},
+		{"name with a slash", ExportProfile{Name: "a/b", Container: ContainerMP4}, true},
+		{"unknown container", ExportProfile{Name: "mine", Container: "avi"}, true},
+		{"animation with audio", ExportProfile{Name: "mine", Container: ContainerWebP, Audio: "aac"}, true},
+		{"sequence with audio", ExportProfile{Name: "mine", Container: ContainerPNG, Audio: "aac"}, true},
+		{"quality above 100", ExportProfile{Name: "mine", Container: ContainerMP4, Quality: 101}, true},
+		{"negative width", ExportProfile{Name: "mine", Container: ContainerMP4, Width: -2}, true},
+		{"huge height", ExportProfile{Name: "mine", Container: ContainerMP4, Height: 10000}, true},
+		{"fps above 120", ExportProfile{Name: "mine", Container: ContainerMP4, FPS: 240}, true},
+		{"one color", ExportProfile{Name: "mine", Container: ContainerGIF, Colors: 1}, true},
+		{"unknown dither", ExportProfile{Name: "mine", Container: ContainerGIF, Dither: "noise"}, true},
+		{"unknown ProRes profile", ExportProfile{Name: "mine", Container: ContainerMOV, Codec: "prores_ks", Profile: "ultra"}, true},
+		{"codec with an option", ExportProfile{Name: "mine", Container: ContainerMP4, Codec: "libx264 -vf"}, true},
+		{"preset like a flag", ExportProfile{Name: "mine", Container: ContainerMP4, Preset: "-y"}, true},
+		{"bitrate with a filter", ExportProfile{Name: "mine", Container: ContainerMP4, Audio: "aac", AudioBitrate: "128k,x"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExportProfileExtension(t *testing.T) {
+	tests := []struct {
+		container string
+		want      string
+	}{
+		{ContainerMP4, ".mp4"},
+		{ContainerMOV, ".mov"},
+		{ContainerGIF, ".gif"},
+		{ContainerAPNG, ".png"},
+		{ContainerPNG, ""},
+	}
+
+	for _, tt := range tests {
+		if got := (ExportProfile{Container: tt.container}).Extension(); got != tt.want {
+			t.Errorf("Extension() of %s = %q, want %q", tt.container, got, tt.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	builtin := make(map[string]ExportProfile)
+	for _, profile := range BuiltinExportProfiles() {
+		builtin[profile.Name] = profile
+	}
+	once := builtin["gif"]
+	once.PlayOnce, once.Colors, once.Dither, once.FPS = true, 0, "", 0
+	scaled := ExportProfile{Name: "tall", Container: ContainerMKV, Height: 720, FPS: 23.976}
+
+	tests := []struct {
+		name    string
+		profile ExportProfile
+		want    string
+	}{
+		{"mp4", builtin["mp4"], "-i in.avi -c:v libx264 -crf 18 -preset medium -pix_fmt yuv420p -c:a aac -b:a 128k -movflags +faststart out -y"},
+		{"webm", builtin["webm"], "-i in.avi -c:v libvpx-vp9 -crf 30 -b:v 0 -c:a libvorbis -b:a 128k out -y"},
+		{"prores", builtin["prores"], "-i in.avi -c:v prores_ks -profile:v 3 -pix_fmt yuv422p10le -c:a pcm_s16le out -y"},
+		{
+			"gif",
+			builtin["gif"],
+			"-i in.avi -filter_complex fps=15,scale=480:-2:flags=lanczos,split[a][b];[a]palettegen=max_colors=256:stats_mode=diff[p];[b][p]paletteuse=dither=sierra2_4a -loop 0 -an out -y",
+		},
+		{
+			"gif played once",
+			once,
+			"-i in.avi -filter_complex scale=480:-2:flags=lanczos,split[a][b];[a]palettegen=max_colors=256:stats_mode=diff[p];[b][p]paletteuse=dither=sierra2_4a -loop -1 -an out -y",
+		},
+		{"webp", builtin["webp"], "-i in.avi -vf fps=20,scale=640:-2:flags=lanczos -c:v libwebp -loop 0 -quality 75 -an out -y"},
+		{"apng", builtin["apng"], "-i in.avi -vf fps=20,scale=640:-2:flags=lanczos -c:v apng -f apng -plays 0 -an out -y"},
+		{"scaled", scaled, "-i in.avi -vf fps=23.976,scale=-2:720:flags=lanczos -an out -y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, args := fakeFFmpeg(t, "", 0)
+			t.Setenv("PATH", filepath.Dir(script)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			if err := NewConverter().Export("in.avi", "out", tt.profile, nil); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			called, err := os.ReadFile(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(called)); got != tt.want {
+				t.Errorf("ffmpeg was called with\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportSequence(t *testing.T) {
+	script, args := fakeFFmpeg(t, "", 0)
+	t.Setenv("PATH", filepath.Dir(script)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := filepath.Join(t.TempDir(), "frames")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old.png"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := ExportProfile{Name: "png", Container: ContainerPNG}
+	if err := NewConverter().Export("in.avi", dir, profile, nil); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	called, _ := os.ReadFile(args)
+	if want := "-i in.avi -c:v png -an " + filepath.Join(dir, "frame_%05d.png") + " -y"; strings.TrimSpace(string(called)) != want {
+		t.Errorf("ffmpeg was called with %s", called)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.png")); !os.IsNotExist(err) {
+		t.Error("frames of the earlier export were kept")
+	}
+
+	failing, _ := fakeFFmpeg(t, "", 1)
+	t.Setenv("PATH", filepath.Dir(failing)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if err := NewConverter().Export("in.avi", dir, profile, nil); err == nil {
+		t.Fatal("expected an error from a failed export")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("a failed sequence export left its directory")
+	}
+	if err := NewConverter().Export("in.avi", dir, ExportProfile{Name: "bad", Container: "avi"}, nil); err == nil {
+		t.Error("exported with an invalid profile")
+	}
+}
+
+func TestZipFrames(t *testing.T) {
+	dir := t.TempDir()
+	frames := map[string]string{"frame_00001.png": "one", "frame_00002.png": "two"}
+	for name, data := range frames {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := ZipFrames(&archive, dir); err != nil {
+		t.Fatalf("ZipFrames failed: %v", err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "frames.zip")
+	if err := os.WriteFile(archivePath, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "frames")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "stale.png"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnzipFrames(archivePath, out); err != nil {
+		t.Fatalf("UnzipFrames failed: %v", err)
+	}
+
+	entries, _ := os.ReadDir(out)
+	if len(entries) != len(frames) {
+		t.Errorf("unzipped %d files, want %d", len(entries), len(frames))
+	}
+	for name, want := range frames {
+		if data, _ := os.ReadFile(filepath.Join(out, name)); string(data) != want {
+			t.Errorf("%s holds %q, want %q", name, data, want)
+		}
+	}
+}
+
+func TestUnzipFramesRejectsPaths(t *testing.T) {
+	tests := []string{"../escape.png", "nested/frame.png", ".hidden"}
+
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			var archive bytes.Buffer
+			w := zip.NewWriter(&archive)
+			if _, err := w.Create(name); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			archivePath := filepath.Join(t.TempDir(), "frames.zip")
+			if err := os.WriteFile(archivePath, archive.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			out := filepath.Join(t.TempDir(), "frames")
+			if err := UnzipFrames(archivePath, out); err == nil {
+				t.Error("expected an error")
+			}
+			if _, err := os.Stat(out + ".tmp"); !os.IsNotExist(err) {
+				t.Error("the partial extraction was left behind")
+			}
+		})
+	}
+}
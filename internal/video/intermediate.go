@@ -0,0 +1,145 @@
+package video
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Codecs the AVI conversion can encode the intermediate with. Each breaks
+// differently when moshed: the MPEG-4 family smears motion across frames,
+// mjpeg has only keyframes and so only glitches within a frame.
+const (
+	IntermediateXvid      = "xvid"
+	IntermediateMPEG4     = "mpeg4"
+	IntermediateMSMPEG4V2 = "msmpeg4v2"
+	IntermediateH263P     = "h263p"
+	IntermediateMJPEG     = "mjpeg"
+)
+
+// intermediateEncoders maps the intermediate codecs to ffmpeg encoders.
+var intermediateEncoders = map[string]string{
+	IntermediateXvid:      "libxvid",
+	IntermediateMPEG4:     "mpeg4",
+	IntermediateMSMPEG4V2: "msmpeg4v2",
+	IntermediateH263P:     "h263p",
+	IntermediateMJPEG:     "mjpeg",
+}
+
+// MaxGOP is the longest keyframe interval a conversion may ask for.
+const MaxGOP = 100000
+
+// AVIOptions control how a source is encoded into the AVI that effects
+// mosh. The zero value is the conversion moshr has always done: Xvid with
+// the encoder's own keyframe interval, quantizer and scene cuts, and no
+// B-frames.
+type AVIOptions struct {
+	// Codec is one of the Intermediate codecs, xvid when empty
+	Codec string `json:"codec,omitempty"`
+	// GOP is the most frames between keyframes, the encoder default when 0
+	GOP int `json:"gop,omitempty"`
+	// BFrames lets the encoder use bidirectional frames
+	BFrames bool `json:"b_frames,omitempty"`
+	// Quantizer fixes the quality from 1, the best, to 31
+	Quantizer int `json:"quantizer,omitempty"`
+	// NoSceneCut stops the encoder inserting keyframes at scene changes, so
+	// only the GOP places them
+	NoSceneCut bool `json:"no_scene_cut,omitempty"`
+	// PCMAudio stores the audio as 16-bit PCM instead of MP3, so audio
+	// databending can work on its samples
+	PCMAudio bool `json:"pcm_audio,omitempty"`
+}
+
+func (o AVIOptions) codec() string {
+	if o.Codec == "" {
+		return IntermediateXvid
+	}
+	return o.Codec
+}
+
+// Validate reports options that are out of range or that the codec cannot
+// honour.
+func (o AVIOptions) Validate() error {
+	codec := o.codec()
+	if _, ok := intermediateEncoders[codec]; !ok {
+		return fmt.Errorf("unknown intermediate codec %q", o.Codec)
+	}
+	if o.GOP < 0 || o.GOP > MaxGOP {
+		return fmt.Errorf("gop must be between 1 and %d", MaxGOP)
+	}
+	if o.Quantizer < 0 || o.Quantizer > 31 {
+		return fmt.Errorf("quantizer must be between 1 and 31")
+	}
+
+	switch codec {
+	case IntermediateMJPEG:
+		if o.GOP > 0 || o.BFrames || o.NoSceneCut {
+			return fmt.Errorf("mjpeg makes every frame a keyframe, so it takes no gop, b-frame or scene-cut options")
+		}
+	case IntermediateMSMPEG4V2, IntermediateH263P:
+		if o.BFrames {
+			return fmt.Errorf("%s does not support b-frames", codec)
+		}
+	case IntermediateXvid:
+		if o.NoSceneCut {
+			return fmt.Errorf("xvid cannot turn off scene-cut keyframes, use mpeg4 instead")
+		}
+	}
+	return nil
+}
+
+// Name identifies the option set in file names, such as mpeg4_g300_bf_q4
+// or xvid_nosc. It is empty for the default conversion.
+func (o AVIOptions) Name() string {
+	if o == (AVIOptions{}) || o == (AVIOptions{Codec: IntermediateXvid}) {
+		return ""
+	}
+
+	parts := []string{o.codec()}
+	if o.GOP > 0 {
+		parts = append(parts, "g"+strconv.Itoa(o.GOP))
+	}
+	if o.BFrames {
+		parts = append(parts, "bf")
+	}
+	if o.Quantizer > 0 {
+		parts = append(parts, "q"+strconv.Itoa(o.Quantizer))
+	}
+	if o.NoSceneCut {
+		parts = append(parts, "nosc")
+	}
+	if o.PCMAudio {
+		parts = append(parts, "pcm")
+	}
+	return strings.Join(parts, "_")
+}
+
+// FileName is the name of the AVI a source converted with the options is
+// stored under.
+func (o AVIOptions) FileName() string {
+	if name := o.Name(); name != "" {
+		return "converted_" + name + ".avi"
+	}
+	return "converted.avi"
+}
+
+// args returns the ffmpeg arguments that encode the streams.
+func (o AVIOptions) args() []string {
+	args := []string{"-c:v", intermediateEncoders[o.codec()]}
+	if o.GOP > 0 {
+		args = append(args, "-g", strconv.Itoa(o.GOP))
+	}
+	if o.BFrames {
+		args = append(args, "-bf", "2")
+	}
+	if o.Quantizer > 0 {
+		args = append(args, "-qscale:v", strconv.Itoa(o.Quantizer))
+	}
+	if o.NoSceneCut {
+		args = append(args, "-sc_threshold", "1000000000")
+	}
+	if o.PCMAudio {
+		return append(args, "-c:a", "pcm_s16le")
+	}
+	return append(args, "-c:a", "libmp3lame")
+}
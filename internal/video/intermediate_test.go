@@ -0,0 +1,111 @@
+package video
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAVIOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    AVIOptions
+		wantErr bool
+	}{
+		{"default", AVIOptions{}, false},
+		{"xvid with b-frames", AVIOptions{Codec: IntermediateXvid, GOP: 300, BFrames: true, Quantizer: 4}, false},
+		{"mpeg4 without scene cuts", AVIOptions{Codec: IntermediateMPEG4, GOP: MaxGOP, NoSceneCut: true}, false},
+		{"mjpeg", AVIOptions{Codec: IntermediateMJPEG, Quantizer: 31, PCMAudio: true}, false},
+		{"h263p", AVIOptions{Codec: IntermediateH263P, GOP: 12}, false},
+		{"unknown codec", AVIOptions{Codec: "h264"}, true},
+		{"negative gop", AVIOptions{GOP: -1}, true},
+		{"gop too long", AVIOptions{Codec: IntermediateMPEG4, GOP: MaxGOP + 1}, true},
+		{"quantizer above 31", AVIOptions{Quantizer: 32}, true},
+		{"mjpeg with a gop", AVIOptions{Codec: IntermediateMJPEG, GOP: 10}, true},
+		{"mjpeg with b-frames", AVIOptions{Codec: IntermediateMJPEG, BFrames: true}, true},
+		{"msmpeg4v2 with b-frames", AVIOptions{Codec: IntermediateMSMPEG4V2, BFrames: true}, true},
+		{"h263p with b-frames", AVIOptions{Codec: IntermediateH263P, BFrames: true}, true},
+		{"xvid without scene cuts", AVIOptions{NoSceneCut: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAVIOptionsNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     AVIOptions
+		wantName string
+		wantFile string
+		wantArgs string
+	}{
+		{
+			"default",
+			AVIOptions{},
+			"", "converted.avi",
+			"-c:v libxvid -c:a libmp3lame",
+		},
+		{
+			"explicit xvid",
+			AVIOptions{Codec: IntermediateXvid},
+			"", "converted.avi",
+			"-c:v libxvid -c:a libmp3lame",
+		},
+		{
+			"xvid with pcm audio",
+			AVIOptions{PCMAudio: true},
+			"xvid_pcm", "converted_xvid_pcm.avi",
+			"-c:v libxvid -c:a pcm_s16le",
+		},
+		{
+			"everything",
+			AVIOptions{Codec: IntermediateMPEG4, GOP: 300, BFrames: true, Quantizer: 4, NoSceneCut: true},
+			"mpeg4_g300_bf_q4_nosc", "converted_mpeg4_g300_bf_q4_nosc.avi",
+			"-c:v mpeg4 -g 300 -bf 2 -qscale:v 4 -sc_threshold 1000000000 -c:a libmp3lame",
+		},
+		{
+			"mjpeg",
+			AVIOptions{Codec: IntermediateMJPEG, Quantizer: 2},
+			"mjpeg_q2", "converted_mjpeg_q2.avi",
+			"-c:v mjpeg -qscale:v 2 -c:a libmp3lame",
+		},
+		{
+			"msmpeg4v2",
+			AVIOptions{Codec: IntermediateMSMPEG4V2, GOP: 600},
+			"msmpeg4v2_g600", "converted_msmpeg4v2_g600.avi",
+			"-c:v msmpeg4v2 -g 600 -c:a libmp3lame",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Name(); got != tt.wantName {
+				t.Errorf("Name() = %q, want %q", got, tt.wantName)
+			}
+			if got := tt.opts.FileName(); got != tt.wantFile {
+				t.Errorf("FileName() = %q, want %q", got, tt.wantFile)
+			}
+			want := "-i in.mp4 " + tt.wantArgs + " out.avi -y"
+			if got := strings.Join(aviArgs("in.mp4", "out.avi", tt.opts), " "); got != want {
+				t.Errorf("aviArgs() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMP4ToAVIRejectsBadOptions(t *testing.T) {
+	c := NewConverter()
+	bad := AVIOptions{Codec: IntermediateMJPEG, BFrames: true}
+	if err := c.MP4ToAVIWithOptions("in.mp4", "out.avi", bad); err == nil {
+		t.Error("MP4ToAVIWithOptions converted with invalid options")
+	}
+	if err := c.MP4ToAVIWithProgress("in.mp4", "out.avi", bad, nil); err == nil {
+		t.Error("MP4ToAVIWithProgress converted with invalid options")
+	}
+}
@@ -0,0 +1,217 @@
+package video
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mask kinds
+const (
+	MaskRect    = "rect"
+	MaskEllipse = "ellipse"
+	MaskLuma    = "luma"
+	MaskChroma  = "chroma"
+	MaskFile    = "file"
+)
+
+// Mask limits an effect to part of the frame. White areas of the mask show
+// the effected video, black areas show the original.
+type Mask struct {
+	Kind string `json:"kind"`
+
+	// Shapes are placed in fractions of the frame size. Animate can drive
+	// "x", "y", "width" and "height" with curves to move the shape.
+	X       float64          `json:"x,omitempty"`
+	Y       float64          `json:"y,omitempty"`
+	Width   float64          `json:"width,omitempty"`
+	Height  float64          `json:"height,omitempty"`
+	Animate map[string]Curve `json:"animate,omitempty"`
+
+	// Luma keys select original pixels with a brightness between Low and
+	// High, both from 0 to 1
+	Low  float64 `json:"low,omitempty"`
+	High float64 `json:"high,omitempty"`
+
+	// Chroma keys select original pixels close to Color, a hex RGB value.
+	// Similarity and Blend follow ffmpeg's chromakey filter.
+	Color      string  `json:"color,omitempty"`
+	Similarity float64 `json:"similarity,omitempty"`
+	Blend      float64 `json:"blend,omitempty"`
+
+	// Path is a grayscale mask video or image
+	Path string `json:"path,omitempty"`
+
+	// Feather softens the mask edge by a blur radius in pixels
+	Feather float64 `json:"feather,omitempty"`
+	Invert  bool    `json:"invert,omitempty"`
+}
+
+func (m Mask) Validate() error {
+	switch m.Kind {
+	case MaskRect, MaskEllipse:
+		_, animatedWidth := m.Animate["width"]
+		_, animatedHeight := m.Animate["height"]
+		if (m.Width <= 0 && !animatedWidth) || (m.Height <= 0 && !animatedHeight) {
+			return fmt.Errorf("%s mask needs a width and height", m.Kind)
+		}
+		for name, curve := range m.Animate {
+			switch name {
+			case "x", "y", "width", "height":
+			default:
+				return fmt.Errorf("cannot animate mask %q", name)
+			}
+			if err := curve.Validate(); err != nil {
+				return fmt.Errorf("animate %s: %v", name, err)
+			}
+		}
+	case MaskLuma:
+		if m.Low < 0 || m.High > 1 || m.Low >= m.High {
+			return fmt.Errorf("luma range must satisfy 0 <= low < high <= 1")
+		}
+	case MaskChroma:
+		if !isHexColor(m.Color) {
+			return fmt.Errorf("chroma color must be a hex RGB value such as #00ff00")
+		}
+		if m.Similarity <= 0 || m.Similarity > 1 {
+			return fmt.Errorf("chroma similarity must be between 0 and 1")
+		}
+		if m.Blend < 0 || m.Blend > 1 {
+			return fmt.Errorf("chroma blend must be between 0 and 1")
+		}
+	case MaskFile:
+		if _, err := os.Stat(m.Path); err != nil {
+			return fmt.Errorf("mask file not found: %s", m.Path)
+		}
+	default:
+		return fmt.Errorf("unknown mask kind %q", m.Kind)
+	}
+
+	if m.Feather < 0 {
+		return fmt.Errorf("feather must not be negative")
+	}
+	return nil
+}
+
+func isHexColor(s string) bool {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return false
+	}
+	for _, r := range strings.ToLower(s) {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isImage reports whether a mask file is a still image that has to be
+// looped for the length of the clip.
+func (m Mask) isImage() bool {
+	switch strings.ToLower(filepath.Ext(m.Path)) {
+	case ".png", ".jpg", ".jpeg", ".bmp", ".tif", ".tiff", ".webp":
+		return true
+	}
+	return false
+}
+
+// shapeExpr is the geq expression of a rect or ellipse mask, with the
+// shape position and size following their curves over time.
+func (m Mask) shapeExpr() string {
+	value := func(name string, v float64) string {
+		if curve, ok := m.Animate[name]; ok {
+			return "(" + curve.Expr("T") + ")"
+		}
+		return num(v)
+	}
+	x, y := value("x", m.X), value("y", m.Y)
+	w, h := value("width", m.Width), value("height", m.Height)
+
+	if m.Kind == MaskEllipse {
+		return fmt.Sprintf("255*lte(pow((X/W-%s-%s/2)/(%s/2),2)+pow((Y/H-%s-%s/2)/(%s/2),2),1)",
+			x, w, w, y, h, h)
+	}
+	return fmt.Sprintf("255*between(X/W,%s,%s+%s)*between(Y/H,%s,%s+%s)", x, x, w, y, y, h)
+}
+
+// maskFilter builds the filter that turns its input into the grayscale
+// mask. The input is the original video, or the mask file for file masks.
+func (m Mask) maskFilter() string {
+	var filters []string
+
+	switch m.Kind {
+	case MaskRect, MaskEllipse:
+		filters = append(filters, "format=gray", fmt.Sprintf("geq=lum='%s'", m.shapeExpr()))
+	case MaskLuma:
+		filters = append(filters, "format=gray",
+			fmt.Sprintf("lut=c0='255*between(val,%d,%d)'", int(m.Low*255), int(m.High*255)))
+	case MaskChroma:
+		// chromakey clears the alpha of matching pixels, so the extracted
+		// alpha is negated to select them
+		filters = append(filters, "format=yuva420p",
+			fmt.Sprintf("chromakey=0x%s:%g:%g", strings.TrimPrefix(m.Color, "#"), m.Similarity, m.Blend),
+			"alphaextract", "negate")
+	case MaskFile:
+		filters = append(filters, "format=gray")
+	}
+
+	if m.Feather > 0 {
+		filters = append(filters, fmt.Sprintf("gblur=sigma=%g", m.Feather))
+	}
+	if m.Invert {
+		filters = append(filters, "negate")
+	}
+	return strings.Join(filters, ",")
+}
+
+// ApplyMask composites an effected render over the original it was made
+// from. The effected video keeps its own timing and size; the original is
+// scaled to match and shows through wherever the mask is black.
+func (c *Converter) ApplyMask(originalPath, effectedPath, outputPath string, mask Mask) error {
+	if err := mask.Validate(); err != nil {
+		return err
+	}
+
+	info, err := c.GetVideoInfo(effectedPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe effected video: %v", err)
+	}
+	size := fmt.Sprintf("scale=%d:%d", info.Width, info.Height)
+
+	args := []string{"-i", effectedPath, "-i", originalPath}
+
+	var filter string
+	if mask.Kind == MaskFile {
+		if mask.isImage() {
+			args = append(args, "-loop", "1")
+		}
+		args = append(args, "-i", mask.Path)
+		filter = fmt.Sprintf("[1:v]%s[orig];[2:v]%s,%s[mask];", size, size, mask.maskFilter())
+	} else {
+		filter = fmt.Sprintf("[1:v]%s,split[orig][key];[key]%s[mask];", size, mask.maskFilter())
+	}
+
+	// The original is laid over the effected video through the negated
+	// mask, so the output follows the effected video's frame count
+	filter += "[mask]negate[orig_alpha];" +
+		"[orig][orig_alpha]alphamerge[orig_masked];" +
+		"[0:v][orig_masked]overlay=0:0:format=auto:shortest=0"
+
+	args = append(args,
+		"-filter_complex", filter,
+		"-map", "0:a?", "-c:a", "copy",
+		"-fflags", "+bitexact", "-flags:v", "+bitexact", "-flags:a", "+bitexact",
+		outputPath, "-y",
+	)
+
+	cmd := c.command("ffmpeg", args...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg mask composite failed: %v\nOutput: %s", err, string(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package video
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaskValidate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mask.png")
+	if err := os.WriteFile(file, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	move := Curve{Keyframes: []Keyframe{{Time: 0, Value: 0.1}, {Time: 1, Value: 0.5}}}
+
+	tests := []struct {
+		name    string
+		mask    Mask
+		wantErr bool
+	}{
+		{"rect", Mask{Kind: MaskRect, X: 0.1, Y: 0.1, Width: 0.5, Height: 0.5}, false},
+		{"rect without a size", Mask{Kind: MaskRect, Width: 0.5}, true},
+		{"ellipse with an animated size", Mask{Kind: MaskEllipse, Width: 0.5, Animate: map[string]Curve{"height": move}}, false},
+		{"animating an unknown field", Mask{Kind: MaskRect, Width: 0.5, Height: 0.5, Animate: map[string]Curve{"angle": move}}, true},
+		{"animating with a bad curve", Mask{Kind: MaskRect, Width: 0.5, Height: 0.5, Animate: map[string]Curve{"x": {}}}, true},
+		{"luma", Mask{Kind: MaskLuma, Low: 0.2, High: 0.8}, false},
+		{"luma range reversed", Mask{Kind: MaskLuma, Low: 0.8, High: 0.2}, true},
+		{"luma above one", Mask{Kind: MaskLuma, Low: 0.5, High: 1.5}, true},
+		{"chroma", Mask{Kind: MaskChroma, Color: "#00FF00", Similarity: 0.3, Blend: 0.1}, false},
+		{"chroma without a hash", Mask{Kind: MaskChroma, Color: "00ff00", Similarity: 0.3}, false},
+		{"chroma color too short", Mask{Kind: MaskChroma, Color: "#0f0", Similarity: 0.3}, true},
+		{"chroma color not hex", Mask{Kind: MaskChroma, Color: "#00gg00", Similarity: 0.3}, true},
+		{"chroma without similarity", Mask{Kind: MaskChroma, Color: "#00ff00"}, true},
+		{"chroma blend above one", Mask{Kind: MaskChroma, Color: "#00ff00", Similarity: 0.3, Blend: 2}, true},
+		{"file", Mask{Kind: MaskFile, Path: file}, false},
+		{"missing file", Mask{Kind: MaskFile, Path: filepath.Join(t.TempDir(), "missing.png")}, true},
+		{"negative feather", Mask{Kind: MaskLuma, Low: 0, High: 1, Feather: -1}, true},
+		{"unknown kind", Mask{Kind: "star"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.mask.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaskFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		mask Mask
+		want string
+	}{
+		{
+			"rect",
+			Mask{Kind: MaskRect, X: 0.25, Y: 0.5, Width: 0.5, Height: 0.25},
+			"format=gray,geq=lum='255*between(X/W,0.25,0.25+0.5)*between(Y/H,0.5,0.5+0.25)'",
+		},
+		{
+			"ellipse",
+			Mask{Kind: MaskEllipse, Width: 1, Height: 0.5},
+			"format=gray,geq=lum='255*lte(pow((X/W-0-1/2)/(1/2),2)+pow((Y/H-0-0.5/2)/(0.5/2),2),1)'",
+		},
+		{
+			"moving rect",
+			Mask{Kind: MaskRect, Width: 0.5, Height: 0.5, Animate: map[string]Curve{"x": {Keyframes: []Keyframe{{Time: 0, Value: 0.5}}}}},
+			"format=gray,geq=lum='255*between(X/W,((if(lt(T,0),0.5,0.5))),((if(lt(T,0),0.5,0.5)))+0.5)*between(Y/H,0,0+0.5)'",
+		},
+		{
+			"luma",
+			Mask{Kind: MaskLuma, Low: 0.2, High: 1},
+			"format=gray,lut=c0='255*between(val,51,255)'",
+		},
+		{
+			"chroma",
+			Mask{Kind: MaskChroma, Color: "#00ff00", Similarity: 0.3, Blend: 0.1},
+			"format=yuva420p,chromakey=0x00ff00:0.3:0.1,alphaextract,negate",
+		},
+		{
+			"feathered and inverted file",
+			Mask{Kind: MaskFile, Path: "mask.png", Feather: 4, Invert: true},
+			"format=gray,gblur=sigma=4,negate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mask.maskFilter(); got != tt.want {
+				t.Errorf("maskFilter() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskIsImage(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"mask.png", true},
+		{"mask.JPG", true},
+		{"masks/frame.webp", true},
+		{"mask.mp4", false},
+		{"mask", false},
+	}
+
+	for _, tt := range tests {
+		if got := (Mask{Kind: MaskFile, Path: tt.path}).isImage(); got != tt.want {
+			t.Errorf("isImage(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,196 +1,601 @@
 package video
 
 import (
-	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+
+	"moshr/internal/avi"
 )
 
 type MoshParams struct {
-	Intensity         float64 `json:"intensity"`
-	IFrameRemoval     bool    `json:"iframe_removal"`
-	PFrameDuplication bool    `json:"pframe_duplication"`
-	DuplicationCount  int     `json:"duplication_count"`
+	Intensity         float64      `json:"intensity"`
+	IFrameRemoval     bool         `json:"iframe_removal"`
+	PFrameDuplication bool         `json:"pframe_duplication"`
+	DuplicationCount  int          `json:"duplication_count"`
+	Windows           []MoshWindow `json:"windows,omitempty"`
+	// Animate drives parameters with curves over time, keyed by the
+	// parameter name. The mosher follows "duplication_count".
+	Animate map[string]Curve `json:"animate,omitempty"`
+
+	// DurationMode keeps the frame count of the input, so the audio stays
+	// in sync, with one of the Duration modes. Empty lets blooms lengthen
+	// the clip.
+	DurationMode string `json:"duration_mode,omitempty"`
+	// Audio is one of the Audio modes, AudioOriginal when empty
+	Audio string `json:"audio,omitempty"`
+	// AudioBend databends the audio chunks in time with the picture
+	AudioBend
+}
+
+// Duration modes. Drop pays for every repeated frame of a bloom by
+// dropping a frame after it, so the picture catches up once the bloom
+// ends. Budget never drops a frame that would be seen: blooms may only
+// spend the frames freed by removed keyframes, so they are as long as the
+// keyframe interval allows.
+const (
+	DurationDrop   = "drop"
+	DurationBudget = "budget"
+)
+
+// Audio modes. Original passes the source audio through untouched. Stretch
+// time-stretches it to the length of the moshed video; it only applies to
+// whole moshes, not to moshes run as a step of another effect. Mute
+// removes it and glitch repeats the audio of a frame with every copy of
+// the frame.
+const (
+	AudioOriginal = "original"
+	AudioStretch  = "stretch"
+	AudioMute     = "mute"
+	AudioGlitch   = "glitch"
+)
+
+// Window operations
+const (
+	MoshOpDropKeyframes = "drop_keyframes"
+	MoshOpBloom         = "bloom"
+	MoshOpMosh          = "mosh"
+	MoshOpNone          = "none"
+)
+
+// MoshWindow applies one operation to a range of video frames. The range is
+// given in frames, or in seconds when EndTime is set, and its end is
+// exclusive. When a MoshParams has windows, frames outside every window are
+// left alone and the global IFrameRemoval/PFrameDuplication flags are ignored.
+type MoshWindow struct {
+	StartFrame       int     `json:"start_frame"`
+	EndFrame         int     `json:"end_frame"`
+	StartTime        float64 `json:"start_time,omitempty"`
+	EndTime          float64 `json:"end_time,omitempty"`
+	Operation        string  `json:"operation"`
+	DuplicationCount int     `json:"duplication_count,omitempty"`
+}
+
+func (w MoshWindow) Validate() error {
+	switch w.Operation {
+	case MoshOpDropKeyframes, MoshOpBloom, MoshOpMosh, MoshOpNone:
+	default:
+		return fmt.Errorf("unknown window operation %q", w.Operation)
+	}
+
+	if w.EndTime > 0 {
+		if w.StartTime < 0 || w.EndTime <= w.StartTime {
+			return fmt.Errorf("invalid window time range %.3f-%.3f", w.StartTime, w.EndTime)
+		}
+	} else if w.StartFrame < 0 || w.EndFrame <= w.StartFrame {
+		return fmt.Errorf("invalid window frame range %d-%d", w.StartFrame, w.EndFrame)
+	}
+
+	if w.DuplicationCount < 0 {
+		return fmt.Errorf("duplication count must not be negative")
+	}
+	return nil
+}
+
+func (w MoshWindow) contains(frame int, framerate float64) bool {
+	if w.EndTime > 0 {
+		t := float64(frame) / framerate
+		return t >= w.StartTime && t < w.EndTime
+	}
+	return frame >= w.StartFrame && frame < w.EndFrame
+}
+
+func (p MoshParams) Validate() error {
+	switch p.DurationMode {
+	case "", DurationDrop, DurationBudget:
+	default:
+		return fmt.Errorf("unknown duration mode %q", p.DurationMode)
+	}
+	switch p.Audio {
+	case "", AudioOriginal, AudioStretch, AudioMute, AudioGlitch:
+	default:
+		return fmt.Errorf("unknown audio mode %q", p.Audio)
+	}
+	if err := p.AudioBend.Validate(); err != nil {
+		return err
+	}
+	for i, w := range p.Windows {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("window %d: %v", i, err)
+		}
+	}
+	for name, curve := range p.Animate {
+		if err := curve.Validate(); err != nil {
+			return fmt.Errorf("animate %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// frameOperation resolves what happens to the video frame at the given
+// index: whether keyframes are dropped and how often P-frames are repeated.
+// An animated duplication count replaces the fixed one wherever frames are
+// duplicated.
+func (p MoshParams) frameOperation(frame int, framerate float64) (bool, int) {
+	dropKeyframes, duplicates, count := p.windowOperation(frame, framerate)
+	if !duplicates {
+		return dropKeyframes, 0
+	}
+
+	if curve, ok := p.Animate["duplication_count"]; ok {
+		animated := math.Round(curve.At(float64(frame) / framerate))
+		if animated < 0 {
+			animated = 0
+		}
+		return dropKeyframes, int(animated)
+	}
+	return dropKeyframes, count
+}
+
+// windowOperation reports whether keyframes are dropped and whether
+// P-frames are duplicated at a frame, along with the fixed duplication
+// count that applies there.
+func (p MoshParams) windowOperation(frame int, framerate float64) (bool, bool, int) {
+	if len(p.Windows) == 0 {
+		return p.IFrameRemoval, p.PFrameDuplication, p.DuplicationCount
+	}
+
+	for _, w := range p.Windows {
+		if !w.contains(frame, framerate) {
+			continue
+		}
+
+		count := w.DuplicationCount
+		if count == 0 {
+			count = p.DuplicationCount
+		}
+
+		switch w.Operation {
+		case MoshOpDropKeyframes:
+			return true, false, 0
+		case MoshOpBloom:
+			return false, true, count
+		case MoshOpMosh:
+			return true, true, count
+		default:
+			return false, false, 0
+		}
+	}
+	return false, false, 0
 }
 
-type Mosher struct{}
+type Mosher struct {
+	ctx      context.Context
+	progress *Reporter
+}
 
 func NewMosher() *Mosher {
 	return &Mosher{}
 }
 
+// WithContext returns a mosher that stops reading its inputs, failing the
+// rewrite, once ctx is done.
+func (m *Mosher) WithContext(ctx context.Context) *Mosher {
+	copy := *m
+	copy.ctx = ctx
+	return &copy
+}
+
+// WithProgress returns a mosher that reports the chunks it has processed.
+func (m *Mosher) WithProgress(progress *Reporter) *Mosher {
+	copy := *m
+	copy.progress = progress
+	return &copy
+}
+
+// input wraps a file the mosher reads so reads fail after cancellation.
+func (m *Mosher) input(f *os.File) io.ReadSeeker {
+	if m.ctx == nil {
+		return f
+	}
+	return avi.ContextReader(m.ctx, f)
+}
+
 func (m *Mosher) MoshVideo(inputPath, outputPath string, params MoshParams) error {
 	fmt.Printf("MOSH: Starting mosh of %s -> %s with params: %+v\n", inputPath, outputPath, params)
 
-	data, err := os.ReadFile(inputPath)
+	in, err := os.Open(inputPath)
 	if err != nil {
 		return fmt.Errorf("failed to read input file: %v", err)
 	}
+	defer in.Close()
 
-	fmt.Printf("MOSH: Read %d bytes from input file\n", len(data))
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer out.Close()
 
-	// Check if it's actually an AVI file
-	if len(data) < 12 {
-		return fmt.Errorf("file too small to be a valid AVI file")
+	stats, err := m.moshStream(m.input(in), out, params)
+	if err != nil {
+		return fmt.Errorf("failed to process video data: %v", err)
 	}
 
-	if string(data[0:4]) != "RIFF" {
-		return fmt.Errorf("not a RIFF file (first 4 bytes: %v)", data[0:4])
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %v", err)
 	}
 
-	if string(data[8:12]) != "AVI " {
-		return fmt.Errorf("not an AVI file (bytes 8-12: %v)", data[8:12])
+	if params.Audio == AudioStretch && stats.audio && stats.written > 0 && stats.written != stats.read {
+		if err := m.stretchAudio(outputPath, float64(stats.read)/float64(stats.written), stats.pcm); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("MOSH: Confirmed AVI file format\n")
+	fmt.Printf("MOSH: Successfully wrote moshed file to %s\n", outputPath)
+	return nil
+}
+
+// MoshStream moshes an AVI read from r into w one chunk at a time, so memory
+// use does not grow with the size of the input.
+func (m *Mosher) MoshStream(r io.ReadSeeker, w io.Writer, params MoshParams) error {
+	_, err := m.moshStream(r, w, params)
+	return err
+}
 
-	moshedData, err := m.processAVIData(data, params)
+func (m *Mosher) moshStream(r io.ReadSeeker, w io.Writer, params MoshParams) (*moshStats, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	plan, err := planMosh(r, params)
 	if err != nil {
-		return fmt.Errorf("failed to process video data: %v", err)
+		return nil, err
+	}
+
+	var stats *moshStats
+	err = avi.Rewrite(r, w, m.progress.Transforms(func(header *avi.File) (avi.Transform, error) {
+		stats = &moshStats{frames: make(map[avi.FrameType]int)}
+		return m.newTransform(header, params, plan, stats)
+	}))
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("MOSH: Processed data, output size: %d bytes\n", len(moshedData))
+	fmt.Printf("MOSH DEBUG: Frames I=%d P=%d B=%d S=%d skip=%d unknown=%d, Removed I: %d, Duplicated P: %d, Dropped: %d, Frames in: %d out: %d\n",
+		stats.frames[avi.FrameI], stats.frames[avi.FrameP], stats.frames[avi.FrameB], stats.frames[avi.FrameS],
+		stats.frames[avi.FrameSkip], stats.frames[avi.FrameUnknown], stats.removed, stats.duplicated, stats.dropped,
+		stats.read, stats.written)
+	return stats, nil
+}
 
-	if err := os.WriteFile(outputPath, moshedData, 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %v", err)
+// stretchAudio time-stretches the audio of an AVI by tempo in place,
+// copying the video as it is. PCM audio stays PCM so it can be bent again.
+// The output is bitexact so a stretched mosh renders the same bytes again.
+func (m *Mosher) stretchAudio(path string, tempo float64, pcm bool) error {
+	codec := "libmp3lame"
+	if pcm {
+		codec = "pcm_s16le"
 	}
+	tmpPath := strings.TrimSuffix(path, filepath.Ext(path)) + "_stretch.avi"
+	args := []string{"-i", path, "-map", "0:v:0", "-map", "0:a:0",
+		"-c:v", "copy", "-af", atempoChain(tempo), "-c:a", codec,
+		"-fflags", "+bitexact", "-flags:v", "+bitexact", "-flags:a", "+bitexact",
+		"-f", "avi", tmpPath, "-y"}
+
+	var cmd *exec.Cmd
+	if m.ctx != nil {
+		cmd = exec.CommandContext(m.ctx, "ffmpeg", args...)
+	} else {
+		cmd = exec.Command("ffmpeg", args...)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("audio stretch failed: %v\nOutput: %s", err, string(output))
+	}
+	return os.Rename(tmpPath, path)
+}
 
-	fmt.Printf("MOSH: Successfully wrote moshed file to %s\n", outputPath)
-	return nil
+// atempoChain builds an atempo filter for any tempo, chaining filters since
+// each one only accepts a tempo between 0.5 and 2.
+func atempoChain(tempo float64) string {
+	var filters []string
+	for tempo < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		tempo /= 0.5
+	}
+	for tempo > 2 {
+		filters = append(filters, "atempo=2")
+		tempo /= 2
+	}
+	filters = append(filters, "atempo="+strconv.FormatFloat(tempo, 'f', 6, 64))
+	return strings.Join(filters, ",")
 }
 
-func (m *Mosher) processAVIData(data []byte, params MoshParams) ([]byte, error) {
-	var result bytes.Buffer
-
-	removedCount := 0
-	duplicatedCount := 0
-	totalVideoChunks := 0
-
-	// Write RIFF header first
-	result.Write(data[0:12])
-
-	// Process the AVI file structure properly - skip RIFF header
-	chunkPos := 12 // Skip RIFF header (4 bytes ID + 4 bytes size + 4 bytes AVI)
-	for chunkPos < len(data)-8 {
-		if chunkPos+8 > len(data) {
-			result.Write(data[chunkPos:])
-			break
-		}
-
-		chunkID := string(data[chunkPos : chunkPos+4])
-		chunkSize := int(data[chunkPos+4]) | int(data[chunkPos+5])<<8 | int(data[chunkPos+6])<<16 | int(data[chunkPos+7])<<24
-
-		if chunkPos+8+chunkSize > len(data) {
-			result.Write(data[chunkPos:])
-			break
-		}
-
-		// Check if this is a LIST movi chunk
-		if chunkID == "LIST" && chunkSize > 4 {
-			listType := string(data[chunkPos+8 : chunkPos+12])
-			fmt.Printf("MOSH: Found LIST chunk, type='%s'\n", listType)
-			if listType == "movi" {
-				fmt.Printf("MOSH: Processing movi LIST chunk\n")
-				// Write LIST header
-				result.Write(data[chunkPos : chunkPos+12])
-
-				// Process contents of movi chunk
-				moviStart := chunkPos + 12
-				moviEnd := chunkPos + 8 + chunkSize
-				framePos := moviStart
-
-				for framePos < moviEnd-8 {
-					if framePos+8 > len(data) {
-						result.Write(data[framePos:])
-						break
-					}
-
-					frameID := string(data[framePos : framePos+4])
-					frameSize := int(data[framePos+4]) | int(data[framePos+5])<<8 | int(data[framePos+6])<<16 | int(data[framePos+7])<<24
-
-					if framePos+8+frameSize > len(data) {
-						result.Write(data[framePos:])
-						break
-					}
-
-					if m.isVideoChunk(frameID) {
-						totalVideoChunks++
-
-						if params.IFrameRemoval && (totalVideoChunks%2 == 0) {
-							removedCount++
-							framePos += 8 + frameSize
-							if frameSize%2 == 1 {
-								framePos++
-							}
-							continue
-						}
-
-						if params.PFrameDuplication && (totalVideoChunks%2 == 0) {
-							for i := 0; i < params.DuplicationCount; i++ {
-								if i%3 == 0 && frameSize > 20 {
-									corruptedChunk := make([]byte, 8+frameSize)
-									copy(corruptedChunk, data[framePos:framePos+8+frameSize])
-									for j := 16; j < len(corruptedChunk)-4 && j < 50; j += 4 {
-										corruptedChunk[j] = byte((int(corruptedChunk[j]) + 127) % 255)
-									}
-									result.Write(corruptedChunk)
-								} else {
-									result.Write(data[framePos : framePos+8+frameSize])
-								}
-								duplicatedCount++
-							}
-						} else {
-							result.Write(data[framePos : framePos+8+frameSize])
-						}
-					} else {
-						result.Write(data[framePos : framePos+8+frameSize])
-					}
-
-					framePos += 8 + frameSize
-					if frameSize%2 == 1 {
-						framePos++
-					}
-				}
+// NewTransform returns a chunk transform that applies the mosh parameters,
+// for use with avi.Rewrite and avi.Chain. A fresh transform is needed for
+// every pass since it tracks the frame position. Duration modes need to
+// see the whole input first, so they only work through MoshVideo and
+// MoshStream.
+func (m *Mosher) NewTransform(header *avi.File, params MoshParams) (avi.Transform, error) {
+	if params.DurationMode != "" {
+		return nil, fmt.Errorf("duration mode %q needs the whole input, use MoshStream", params.DurationMode)
+	}
+	return m.newTransform(header, params, nil, &moshStats{frames: make(map[avi.FrameType]int)})
+}
 
-				chunkPos = moviEnd
-				if chunkSize%2 == 1 {
-					chunkPos++
-				}
-				continue
-			}
+type moshStats struct {
+	frames     map[avi.FrameType]int
+	removed    int
+	duplicated int
+	dropped    int
+	// read and written count video frames in and out; audio is set when
+	// the input has an audio stream
+	read    int
+	written int
+	audio   bool
+	pcm     bool
+}
+
+// moshPlan is what the duration modes need to know about the input before
+// the rewrite starts: how many video frames it has and how many frames
+// after each one a bloom can be paid for with. Rewrite updates the header
+// to the output after its first pass, so none of this can be read from
+// the header of a later pass.
+type moshPlan struct {
+	total int
+	// payable holds, for every frame, the number of later frames drop mode
+	// may drop: every frame but the I-frames it keeps
+	payable []int32
+}
+
+// planMosh scans the video frames of the input once when a duration mode
+// needs it and returns nil otherwise. It costs four bytes per frame.
+func planMosh(r io.ReadSeeker, params MoshParams) (*moshPlan, error) {
+	if params.DurationMode == "" {
+		return nil, nil
+	}
+
+	reader, err := avi.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	framerate := reader.Header().FrameRate()
+	if framerate <= 0 {
+		framerate = 30
+	}
+
+	var droppable []bool
+	seenIFrame := false
+	err = reader.Chunks(func(c *avi.Chunk) error {
+		if !avi.IsVideo(c.ID) {
+			return nil
+		}
+		// This follows moshTransform.video, which drops any frame but an
+		// I-frame to pay for a repeat and removes I-frames after the first
+		// one where keyframes are dropped
+		canDrop := true
+		if avi.FrameTypeOf(c) == avi.FrameI {
+			dropKeyframes, _ := params.frameOperation(len(droppable), framerate)
+			canDrop = dropKeyframes && seenIFrame
+			seenIFrame = seenIFrame || !canDrop
+		}
+		droppable = append(droppable, canDrop)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &moshPlan{total: len(droppable), payable: make([]int32, len(droppable))}
+	later := int32(0)
+	for i := len(droppable) - 1; i >= 0; i-- {
+		plan.payable[i] = later
+		if droppable[i] {
+			later++
+		}
+	}
+	return plan, nil
+}
+
+func hasAudio(header *avi.File) bool {
+	for _, stream := range header.Streams() {
+		if stream.Header.Type == "auds" {
+			return true
 		}
+	}
+	return false
+}
+
+func (m *Mosher) newTransform(header *avi.File, params MoshParams, plan *moshPlan, stats *moshStats) (avi.Transform, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	framerate := header.FrameRate()
+	if framerate <= 0 {
+		framerate = 30
+	}
+	stats.audio = hasAudio(header)
+	stats.pcm = pcmAudio(header) != nil
+
+	t := &moshTransform{
+		mosher:    m,
+		params:    params,
+		stats:     stats,
+		framerate: framerate,
+		plan:      plan,
+		stutter:   params.Audio == AudioGlitch || params.TapeStop,
+		bender:    newAudioBender(header, params.AudioBend),
+	}
+	return t.apply, nil
+}
+
+// moshTransform tracks the position of a mosh pass through the movi list.
+type moshTransform struct {
+	mosher    *Mosher
+	params    MoshParams
+	stats     *moshStats
+	framerate float64
+	plan      *moshPlan
+	// stutter repeats the audio of a frame with its copies
+	stutter bool
+	bender  *audioBender
+
+	frame      int
+	seenIFrame bool
+	// debt counts repeated frames not yet paid for by a dropped frame and
+	// credit the frames removed keyframes freed for blooms. audioDebt
+	// counts glitched audio chunks to drop so the audio keeps its length.
+	debt, credit, audioDebt int
+	// frameAudio holds the audio chunks since the last video frame, which
+	// glitched audio repeats with the frame's copies
+	frameAudio []*avi.Chunk
+}
+
+func (t *moshTransform) apply(chunk *avi.Chunk) ([]*avi.Chunk, error) {
+	if avi.IsAudio(chunk.ID) {
+		return t.audio(chunk), nil
+	}
+	if !avi.IsVideo(chunk.ID) {
+		return []*avi.Chunk{chunk}, nil
+	}
+
+	chunks := t.video(chunk)
 
-		// Regular chunk processing for non-movi chunks
-		result.Write(data[chunkPos : chunkPos+8+chunkSize])
-		chunkPos += 8 + chunkSize
-		if chunkSize%2 == 1 {
-			chunkPos++
+	// Budget left over at the end is spent on repeats of the last picture
+	// so the clip keeps its length
+	if t.params.DurationMode == DurationBudget && t.frame == t.plan.total {
+		for ; t.credit > 0; t.credit-- {
+			chunks = append(chunks, &avi.Chunk{ID: chunk.ID})
 		}
 	}
 
-	fmt.Printf("MOSH DEBUG: Total video chunks: %d, Removed: %d, Duplicated: %d\n", totalVideoChunks, removedCount, duplicatedCount)
-	return result.Bytes(), nil
+	for _, c := range chunks {
+		if avi.IsVideo(c.ID) {
+			t.stats.written++
+		}
+	}
+	return chunks, nil
 }
 
-func (m *Mosher) isVideoChunk(chunkID string) bool {
-	return chunkID == "00dc" || chunkID == "01dc" || chunkID == "00db" || chunkID == "01db" ||
-		chunkID == "02dc" || chunkID == "03dc" || chunkID == "02db" || chunkID == "03db" ||
-		chunkID == "vids" || chunkID == "DIB " || chunkID == "RGB " || chunkID == "MJPG"
+func (t *moshTransform) audio(chunk *avi.Chunk) []*avi.Chunk {
+	if t.params.Audio == AudioMute {
+		return nil
+	}
+	if t.audioDebt > 0 {
+		t.audioDebt--
+		return nil
+	}
+
+	chunk = t.bender.apply(chunk)
+	if t.stutter {
+		t.frameAudio = append(t.frameAudio, chunk)
+	}
+	return []*avi.Chunk{chunk}
 }
 
-func (m *Mosher) isIFrame(data []byte) bool {
-	if len(data) < 4 {
-		return false
+func (t *moshTransform) video(chunk *avi.Chunk) []*avi.Chunk {
+	frameType := avi.FrameTypeOf(chunk)
+	t.stats.frames[frameType]++
+	t.stats.read++
+	index := t.frame
+	dropKeyframes, duplication := t.params.frameOperation(index, t.framerate)
+	t.frame++
+	audio := t.frameAudio
+	t.frameAudio = nil
+
+	if t.params.DurationMode == DurationDrop && t.debt > 0 && frameType != avi.FrameI {
+		t.debt--
+		t.stats.dropped++
+		return nil
+	}
+
+	switch frameType {
+	case avi.FrameI:
+		// The first I-frame is kept so the decoder has an image to smear
+		if dropKeyframes && t.seenIFrame {
+			t.stats.removed++
+			switch {
+			case t.params.DurationMode == DurationBudget:
+				t.credit++
+			case t.params.DurationMode == DurationDrop && t.debt > 0:
+				t.debt--
+			case t.params.DurationMode == DurationDrop:
+				// An empty chunk shows the last picture again
+				return []*avi.Chunk{{ID: chunk.ID}}
+			}
+			return nil
+		}
+		t.seenIFrame = true
+	case avi.FrameP, avi.FrameS:
+		if duplication > 1 {
+			switch t.params.DurationMode {
+			case DurationDrop:
+				// Never repeat more frames than later frames can pay for,
+				// or the clip ends longer than it started
+				if left := int(t.plan.payable[index]) - t.debt; duplication-1 > left {
+					duplication = max(left, 0) + 1
+				}
+				t.debt += duplication - 1
+			case DurationBudget:
+				duplication = min(duplication, t.credit+1)
+				t.credit -= duplication - 1
+			}
+		}
+		if duplication > 0 {
+			return t.duplicate(chunk, duplication, audio)
+		}
+	}
+	return []*avi.Chunk{chunk}
+}
+
+// duplicate repeats a frame, corrupting every third copy. Glitched audio
+// stutters along with it.
+func (t *moshTransform) duplicate(chunk *avi.Chunk, duplication int, audio []*avi.Chunk) []*avi.Chunk {
+	chunks := make([]*avi.Chunk, 0, duplication*(len(audio)+1))
+	for i := 0; i < duplication; i++ {
+		if i > 0 && t.stutter {
+			for _, a := range audio {
+				chunks = append(chunks, t.bender.repeat(a, i, duplication))
+			}
+			if t.params.DurationMode != "" {
+				t.audioDebt += len(audio)
+			}
+		}
+		if i%3 == 0 && len(chunk.Data) > 12 {
+			chunks = append(chunks, t.mosher.corruptedCopy(chunk))
+		} else {
+			chunks = append(chunks, chunk.Copy())
+		}
+		t.stats.duplicated++
 	}
-	return data[0] == 0x00 && data[1] == 0x00 && data[2] == 0x01 && (data[3]&0x1F) == 0x07
+	return chunks
 }
 
-func (m *Mosher) isPFrame(data []byte) bool {
-	if len(data) < 4 {
-		return false
+func (m *Mosher) corruptedCopy(chunk *avi.Chunk) *avi.Chunk {
+	corrupted := chunk.Copy()
+	corrupted.Data = make([]byte, len(chunk.Data))
+	copy(corrupted.Data, chunk.Data)
+	for j := 8; j < len(corrupted.Data)-4 && j < 42; j += 4 {
+		corrupted.Data[j] = byte((int(corrupted.Data[j]) + 127) % 255)
 	}
-	return data[0] == 0x00 && data[1] == 0x00 && data[2] == 0x01 && (data[3]&0x1F) == 0x01
+	return corrupted
 }
 
 func (m *Mosher) CreateVariations(inputPath string, outputDir string, variations []MoshParams) ([]string, error) {
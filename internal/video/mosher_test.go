@@ -0,0 +1,390 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"moshr/internal/avi"
+)
+
+// testAVI builds a 30 fps AVI whose video frames carry MPEG-4 VOP headers
+// of the given types, one per character: I, P or B. A 16-bit stereo PCM
+// audio chunk precedes every frame when withAudio is set.
+func testAVI(t *testing.T, frames string, withAudio bool) []byte {
+	t.Helper()
+
+	avih := make([]byte, 56)
+	binary.LittleEndian.PutUint32(avih[0:], 33333)
+	strh := make([]byte, 56)
+	copy(strh, "vidsXVID")
+	binary.LittleEndian.PutUint32(strh[20:], 1)
+	binary.LittleEndian.PutUint32(strh[24:], 30)
+	hdrl := &avi.Chunk{ID: "LIST", ListType: "hdrl", Children: []*avi.Chunk{
+		{ID: "avih", Data: avih},
+		{ID: "LIST", ListType: "strl", Children: []*avi.Chunk{
+			{ID: "strh", Data: strh},
+			{ID: "strf", Data: make([]byte, 40)},
+		}},
+	}}
+
+	if withAudio {
+		strh := make([]byte, 56)
+		copy(strh, "auds")
+		binary.LittleEndian.PutUint32(strh[20:], 1)
+		binary.LittleEndian.PutUint32(strh[24:], 44100)
+		binary.LittleEndian.PutUint32(strh[44:], 4)
+		format := make([]byte, 18)
+		binary.LittleEndian.PutUint16(format[0:], 1)
+		binary.LittleEndian.PutUint16(format[2:], 2)
+		binary.LittleEndian.PutUint16(format[12:], 4)
+		binary.LittleEndian.PutUint16(format[14:], 16)
+		hdrl.Children = append(hdrl.Children, &avi.Chunk{ID: "LIST", ListType: "strl", Children: []*avi.Chunk{
+			{ID: "strh", Data: strh},
+			{ID: "strf", Data: format},
+		}})
+	}
+
+	movi := &avi.Chunk{ID: "LIST", ListType: "movi"}
+	for i, frame := range frames {
+		if withAudio {
+			movi.Children = append(movi.Children, &avi.Chunk{ID: "01wb", Data: bytes.Repeat([]byte{byte(i)}, 16), Flags: avi.FlagKeyframe})
+		}
+		coding := map[rune]byte{'I': 0, 'P': 1, 'B': 2}[frame]
+		data := append([]byte{0, 0, 1, 0xb6, coding << 6}, bytes.Repeat([]byte{0x55}, 40)...)
+		chunk := &avi.Chunk{ID: "00dc", Data: data}
+		if frame == 'I' {
+			chunk.Flags = avi.FlagKeyframe
+		}
+		movi.Children = append(movi.Children, chunk)
+	}
+
+	file := &avi.File{Root: &avi.Chunk{ID: "RIFF", ListType: "AVI ", Children: []*avi.Chunk{hdrl, movi}}}
+	var buf bytes.Buffer
+	if _, err := file.WriteTo(&buf); err != nil {
+		t.Fatalf("failed to write test AVI: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// countChunks parses an AVI and counts its video and audio chunks.
+func countChunks(t *testing.T, data []byte) (int, int) {
+	t.Helper()
+
+	file, err := avi.Parse(data)
+	if err != nil {
+		t.Fatalf("output does not parse: %v", err)
+	}
+	video, audio := 0, 0
+	for _, c := range file.Movi().Children {
+		switch {
+		case avi.IsVideo(c.ID):
+			video++
+		case avi.IsAudio(c.ID):
+			audio++
+		}
+	}
+
+	main, err := file.MainHeader()
+	if err != nil {
+		t.Fatalf("output has no main header: %v", err)
+	}
+	if int(main.TotalFrames) != video {
+		t.Errorf("header declares %d frames, movi holds %d", main.TotalFrames, video)
+	}
+	return video, audio
+}
+
+func TestMoshStreamDurationModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames string
+		audio  bool
+		params MoshParams
+		want   int
+	}{
+		{
+			name:   "no duration mode lengthens the clip",
+			frames: "IPPP",
+			params: MoshParams{PFrameDuplication: true, DuplicationCount: 3},
+			want:   10,
+		},
+		{
+			name:   "drop with keyframes removed",
+			frames: "IPII",
+			params: MoshParams{IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 5, DurationMode: DurationDrop},
+			want:   4,
+		},
+		{
+			name:   "drop with only kept keyframes left",
+			frames: "IPII",
+			params: MoshParams{PFrameDuplication: true, DuplicationCount: 5, DurationMode: DurationDrop},
+			want:   4,
+		},
+		{
+			name:   "drop pays with later P-frames",
+			frames: "IPPPPPPP",
+			params: MoshParams{PFrameDuplication: true, DuplicationCount: 3, DurationMode: DurationDrop},
+			want:   8,
+		},
+		{
+			name:   "drop with glitched audio",
+			frames: "IPPPIPPP",
+			audio:  true,
+			params: MoshParams{IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 4, DurationMode: DurationDrop, Audio: AudioGlitch},
+			want:   8,
+		},
+		{
+			name:   "budget spends removed keyframes",
+			frames: "IPII",
+			params: MoshParams{IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 5, DurationMode: DurationBudget},
+			want:   4,
+		},
+		{
+			name:   "budget without removed keyframes",
+			frames: "IPPPIPPP",
+			params: MoshParams{PFrameDuplication: true, DuplicationCount: 5, DurationMode: DurationBudget},
+			want:   8,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := testAVI(t, tt.frames, tt.audio)
+			var out bytes.Buffer
+			if err := NewMosher().MoshStream(bytes.NewReader(input), &out, tt.params); err != nil {
+				t.Fatalf("MoshStream failed: %v", err)
+			}
+
+			video, audio := countChunks(t, out.Bytes())
+			if video != tt.want {
+				t.Errorf("got %d video frames, want %d", video, tt.want)
+			}
+			if tt.audio && tt.params.DurationMode != "" && audio != len(tt.frames) {
+				t.Errorf("got %d audio chunks, want %d", audio, len(tt.frames))
+			}
+		})
+	}
+}
+
+func TestMoshStreamIsRepeatable(t *testing.T) {
+	input := testAVI(t, "IPPPIPPPIPPP", true)
+	params := MoshParams{
+		IFrameRemoval:     true,
+		PFrameDuplication: true,
+		DuplicationCount:  3,
+		DurationMode:      DurationDrop,
+		AudioBend:         AudioBend{Corruption: 0.5, Seed: 7},
+	}
+
+	var first, second bytes.Buffer
+	if err := NewMosher().MoshStream(bytes.NewReader(input), &first, params); err != nil {
+		t.Fatalf("first mosh failed: %v", err)
+	}
+	if err := NewMosher().MoshStream(bytes.NewReader(input), &second, params); err != nil {
+		t.Fatalf("second mosh failed: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("the same params and seed gave different output")
+	}
+}
+
+func TestNewTransformRejectsDurationModes(t *testing.T) {
+	file, err := avi.Parse(testAVI(t, "IP", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMosher().NewTransform(file, MoshParams{DurationMode: DurationDrop}); err == nil {
+		t.Error("expected an error for a duration mode")
+	}
+}
+
+func TestMoshWindowValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  MoshWindow
+		wantErr bool
+	}{
+		{"frame range", MoshWindow{StartFrame: 0, EndFrame: 10, Operation: MoshOpMosh}, false},
+		{"time range", MoshWindow{StartTime: 1, EndTime: 2.5, Operation: MoshOpBloom}, false},
+		{"unknown operation", MoshWindow{EndFrame: 10, Operation: "melt"}, true},
+		{"empty frame range", MoshWindow{StartFrame: 5, EndFrame: 5, Operation: MoshOpNone}, true},
+		{"negative start frame", MoshWindow{StartFrame: -1, EndFrame: 5, Operation: MoshOpNone}, true},
+		{"reversed time range", MoshWindow{StartTime: 3, EndTime: 2, Operation: MoshOpDropKeyframes}, true},
+		{"negative duplication", MoshWindow{EndFrame: 5, Operation: MoshOpBloom, DuplicationCount: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.window.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFrameOperationWindows(t *testing.T) {
+	params := MoshParams{
+		IFrameRemoval:     true,
+		PFrameDuplication: true,
+		DuplicationCount:  4,
+		Windows: []MoshWindow{
+			{StartFrame: 0, EndFrame: 10, Operation: MoshOpDropKeyframes},
+			{StartFrame: 10, EndFrame: 20, Operation: MoshOpBloom, DuplicationCount: 2},
+			{StartTime: 1, EndTime: 2, Operation: MoshOpMosh},
+			// Overlaps the window above, which comes first and wins
+			{StartFrame: 30, EndFrame: 90, Operation: MoshOpNone},
+		},
+	}
+
+	tests := []struct {
+		frame         int
+		dropKeyframes bool
+		duplication   int
+	}{
+		{0, true, 0},
+		{9, true, 0},
+		{10, false, 2},
+		{19, false, 2},
+		{20, false, 0},
+		{30, true, 4},
+		{59, true, 4},
+		{60, false, 0},
+		{90, false, 0},
+	}
+
+	for _, tt := range tests {
+		dropKeyframes, duplication := params.frameOperation(tt.frame, 30)
+		if dropKeyframes != tt.dropKeyframes || duplication != tt.duplication {
+			t.Errorf("frame %d = (%v, %d), want (%v, %d)", tt.frame, dropKeyframes, duplication, tt.dropKeyframes, tt.duplication)
+		}
+	}
+}
+
+func TestFrameOperationWithoutWindows(t *testing.T) {
+	params := MoshParams{IFrameRemoval: true, PFrameDuplication: true, DuplicationCount: 3}
+	if dropKeyframes, duplication := params.frameOperation(42, 30); !dropKeyframes || duplication != 3 {
+		t.Errorf("got (%v, %d), want the global flags (true, 3)", dropKeyframes, duplication)
+	}
+
+	params.PFrameDuplication = false
+	if _, duplication := params.frameOperation(42, 30); duplication != 0 {
+		t.Errorf("got duplication %d without P-frame duplication", duplication)
+	}
+}
+
+func TestMoshParamsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  MoshParams
+		wantErr bool
+	}{
+		{"zero", MoshParams{}, false},
+		{"drop with stretched audio", MoshParams{DurationMode: DurationDrop, Audio: AudioStretch}, false},
+		{"budget with muted audio", MoshParams{DurationMode: DurationBudget, Audio: AudioMute}, false},
+		{"original audio", MoshParams{Audio: AudioOriginal}, false},
+		{"glitched audio", MoshParams{Audio: AudioGlitch}, false},
+		{"unknown duration mode", MoshParams{DurationMode: "loop"}, true},
+		{"unknown audio mode", MoshParams{Audio: "reverb"}, true},
+		{"bad audio bend", MoshParams{AudioBend: AudioBend{Bitcrush: 16}}, true},
+		{"bad window", MoshParams{Windows: []MoshWindow{{Operation: MoshOpBloom, StartFrame: 5, EndFrame: 5}}}, true},
+		{"bad curve", MoshParams{Animate: map[string]Curve{"duplication_count": {}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.params.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAtempoChain(t *testing.T) {
+	tests := []struct {
+		tempo float64
+		want  string
+	}{
+		{1, "atempo=1.000000"},
+		{0.5, "atempo=0.500000"},
+		{2, "atempo=2.000000"},
+		{1.25, "atempo=1.250000"},
+		{0.2, "atempo=0.5,atempo=0.5,atempo=0.800000"},
+		{5, "atempo=2,atempo=2,atempo=1.250000"},
+	}
+
+	for _, tt := range tests {
+		if got := atempoChain(tt.tempo); got != tt.want {
+			t.Errorf("atempoChain(%v) = %s, want %s", tt.tempo, got, tt.want)
+		}
+	}
+}
+
+func TestMoshStreamAudioModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    MoshParams
+		wantVideo int
+		wantAudio int
+	}{
+		{"original", MoshParams{PFrameDuplication: true, DuplicationCount: 2}, 5, 3},
+		{"stretch leaves the stream alone", MoshParams{PFrameDuplication: true, DuplicationCount: 2, Audio: AudioStretch}, 5, 3},
+		{"mute", MoshParams{PFrameDuplication: true, DuplicationCount: 2, Audio: AudioMute}, 5, 0},
+		{"glitch repeats with the frames", MoshParams{PFrameDuplication: true, DuplicationCount: 2, Audio: AudioGlitch}, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := testAVI(t, "IPP", true)
+			var out bytes.Buffer
+			if err := NewMosher().MoshStream(bytes.NewReader(input), &out, tt.params); err != nil {
+				t.Fatalf("MoshStream failed: %v", err)
+			}
+			video, audio := countChunks(t, out.Bytes())
+			if video != tt.wantVideo || audio != tt.wantAudio {
+				t.Errorf("got %d video and %d audio chunks, want %d and %d", video, audio, tt.wantVideo, tt.wantAudio)
+			}
+		})
+	}
+}
+
+func TestMoshVideoStretchesAudio(t *testing.T) {
+	script, args := fakeFFmpeg(t, "", 1)
+	t.Setenv("PATH", filepath.Dir(script)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.avi")
+	if err := os.WriteFile(input, testAVI(t, "IPPP", true), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.avi")
+
+	// Four frames become 19, too slow for a single atempo filter
+	params := MoshParams{PFrameDuplication: true, DuplicationCount: 6, Audio: AudioStretch}
+	err := NewMosher().MoshVideo(input, output, params)
+	if err == nil || !strings.Contains(err.Error(), "audio stretch failed") {
+		t.Fatalf("got error %v, want the failed stretch", err)
+	}
+	called, _ := os.ReadFile(args)
+	if !strings.Contains(string(called), "-af atempo=0.5,atempo=0.5,atempo=0.842105 -c:a pcm_s16le") {
+		t.Errorf("ffmpeg was called with %s", called)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out_stretch.avi")); !os.IsNotExist(err) {
+		t.Error("the failed stretch left its temporary file")
+	}
+
+	// Nothing to stretch when the length is kept
+	os.Remove(args)
+	params.DurationMode = DurationDrop
+	if err := NewMosher().MoshVideo(input, output, params); err != nil {
+		t.Fatalf("MoshVideo failed: %v", err)
+	}
+	if _, err := os.Stat(args); !os.IsNotExist(err) {
+		t.Error("ffmpeg ran for a mosh that kept its length")
+	}
+}
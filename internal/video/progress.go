@@ -0,0 +1,202 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"moshr/internal/avi"
+)
+
+// Progress is a snapshot of a running render.
+type Progress struct {
+	// Fraction of the work done, from 0 to 1
+	Fraction float64 `json:"progress"`
+	// Frames of the input processed per second
+	FPS float64 `json:"fps"`
+	// ETA is the estimated number of seconds left, zero while unknown
+	ETA   float64 `json:"eta"`
+	Frame int     `json:"frame"`
+}
+
+// reportInterval throttles updates from chunk counting, which would
+// otherwise report every frame.
+const reportInterval = 250 * time.Millisecond
+
+// Reporter turns ffmpeg -progress output and processed AVI chunks into
+// Progress updates. Span hands a stage of a longer job its own reporter
+// that covers part of the parent's range. A nil Reporter runs commands
+// without reporting.
+type Reporter struct {
+	duration float64
+	fn       func(Progress)
+
+	start time.Time
+	last  time.Time
+
+	parent   *Reporter
+	from, to float64
+}
+
+// NewReporter reports the progress of a render of an input that plays for
+// duration seconds, as measured by Analyzer. fn receives every update.
+func NewReporter(duration float64, fn func(Progress)) *Reporter {
+	return &Reporter{duration: duration, fn: fn, start: time.Now()}
+}
+
+// Span returns a reporter for a stage covering from to to of this
+// reporter's range.
+func (r *Reporter) Span(from, to float64) *Reporter {
+	if r == nil {
+		return nil
+	}
+	return &Reporter{duration: r.duration, parent: r, from: from, to: to}
+}
+
+// lasting returns a reporter covering this reporter's whole range that
+// measures against an output of duration seconds, for renders whose
+// length differs from their input's.
+func (r *Reporter) lasting(duration float64) *Reporter {
+	if r == nil {
+		return nil
+	}
+	return &Reporter{duration: duration, parent: r, from: 0, to: 1}
+}
+
+func (r *Reporter) report(fraction, fps float64, frame int, final bool) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	if r.parent != nil {
+		r.parent.report(r.from+fraction*(r.to-r.from), fps, frame, final)
+		return
+	}
+
+	now := time.Now()
+	if !final && now.Sub(r.last) < reportInterval {
+		return
+	}
+	r.last = now
+
+	p := Progress{Fraction: fraction, FPS: fps, Frame: frame}
+	if fraction > 0 && fraction < 1 {
+		elapsed := now.Sub(r.start).Seconds()
+		p.ETA = elapsed * (1 - fraction) / fraction
+	}
+	r.fn(p)
+}
+
+// Run runs an ffmpeg command and reports its progress against the input
+// duration. The error includes ffmpeg's output.
+func (r *Reporter) Run(cmd *exec.Cmd) error {
+	if r == nil {
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v\nOutput: %s", err, string(output))
+		}
+		return nil
+	}
+
+	// -progress is a global option, so it can go right after the binary
+	cmd.Args = append([]string{cmd.Args[0], "-progress", "pipe:1", "-nostats"}, cmd.Args[1:]...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+
+	var outTime, fps float64
+	var frame int
+
+	// ffmpeg writes blocks of key=value lines, each ending with a
+	// progress line
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// Both are in microseconds
+			if us, err := strconv.ParseFloat(value, 64); err == nil {
+				outTime = us / 1e6
+			}
+		case "fps":
+			fps, _ = strconv.ParseFloat(value, 64)
+		case "frame":
+			frame, _ = strconv.Atoi(value)
+		case "progress":
+			fraction := 0.0
+			if r.duration > 0 {
+				fraction = outTime / r.duration
+			}
+			if value == "end" {
+				fraction = 1
+			}
+			r.report(fraction, fps, frame, value == "end")
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%v\nOutput: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Transforms wraps the transform factory of avi.Rewrite so every video
+// chunk read advances the progress. Rewrite walks the input three times,
+// and each pass counts as a third of the work.
+func (r *Reporter) Transforms(newTransform func(header *avi.File) (avi.Transform, error)) func(header *avi.File) (avi.Transform, error) {
+	if r == nil {
+		return newTransform
+	}
+
+	const passes = 3
+	pass, total := 0, 0
+	var start time.Time
+
+	return func(header *avi.File) (avi.Transform, error) {
+		// Later passes see the header already updated for the output
+		if pass == 0 {
+			start = time.Now()
+			if main, err := header.MainHeader(); err == nil {
+				total = int(main.TotalFrames)
+			}
+		}
+		pass++
+
+		t, err := newTransform(header)
+		if err != nil {
+			return nil, err
+		}
+
+		done := float64(pass-1) / passes
+		frames := 0
+		return func(c *avi.Chunk) ([]*avi.Chunk, error) {
+			if avi.IsVideo(c.ID) && total > 0 {
+				frames++
+				fraction := done + float64(min(frames, total))/float64(total)/passes
+				elapsed := time.Since(start).Seconds()
+				fps := 0.0
+				if elapsed > 0 {
+					fps = fraction * float64(total) / elapsed
+				}
+				r.report(fraction, fps, frames, false)
+			}
+			return t(c)
+		}, nil
+	}
+}
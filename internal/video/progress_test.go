@@ -0,0 +1,182 @@
+package video
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"moshr/internal/avi"
+)
+
+// fakeFFmpeg writes a script that prints output the way ffmpeg -progress
+// does and records the arguments it was called with in args.txt.
+func fakeFFmpeg(t *testing.T, output string, exitCode int) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "output.txt"), []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := filepath.Join(dir, "args.txt")
+	script := filepath.Join(dir, "ffmpeg")
+	body := "#!/bin/sh\necho \"$@\" > " + args + "\ncat " + filepath.Join(dir, "output.txt") +
+		"\necho failure output >&2\nexit " + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, args
+}
+
+func TestReporterRun(t *testing.T) {
+	output := strings.Join([]string{
+		"frame=30", "fps=15.5", "out_time_us=1000000", "progress=continue",
+		"frame=60", "fps=20", "out_time_ms=2000000", "progress=continue",
+		"bitrate=N/A", "garbage",
+		"frame=90", "out_time_us=3000000", "progress=end",
+	}, "\n") + "\n"
+	script, args := fakeFFmpeg(t, output, 0)
+
+	var reports []Progress
+	r := NewReporter(4, func(p Progress) { reports = append(reports, p) })
+	if err := r.Run(exec.Command(script, "-i", "in.avi", "out.avi")); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	called, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(called)); got != "-progress pipe:1 -nostats -i in.avi out.avi" {
+		t.Errorf("ffmpeg was called with %q", got)
+	}
+
+	// The second update comes too soon after the first and is dropped
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2: %+v", len(reports), reports)
+	}
+	if first := reports[0]; first.Fraction != 0.25 || first.FPS != 15.5 || first.Frame != 30 || first.ETA <= 0 {
+		t.Errorf("first report = %+v", first)
+	}
+	if last := reports[1]; last.Fraction != 1 || last.Frame != 90 || last.ETA != 0 {
+		t.Errorf("final report = %+v", last)
+	}
+}
+
+func TestReporterRunFailure(t *testing.T) {
+	script, _ := fakeFFmpeg(t, "progress=end\n", 1)
+
+	r := NewReporter(4, func(Progress) {})
+	err := r.Run(exec.Command(script))
+	if err == nil || !strings.Contains(err.Error(), "failure output") {
+		t.Errorf("got error %v, want one with ffmpeg's output", err)
+	}
+
+	var nilReporter *Reporter
+	err = nilReporter.Run(exec.Command(script))
+	if err == nil || !strings.Contains(err.Error(), "failure output") {
+		t.Errorf("a nil reporter got error %v, want one with ffmpeg's output", err)
+	}
+}
+
+func TestReporterSpan(t *testing.T) {
+	var reports []float64
+	var r *Reporter
+	r = NewReporter(10, func(p Progress) {
+		reports = append(reports, p.Fraction)
+		// Every update is wanted here, so the throttle is reset
+		r.last = time.Time{}
+	})
+
+	stage := r.Span(0.2, 0.6)
+	inner := stage.Span(0.5, 1)
+	stage.report(0, 0, 0, false)
+	stage.report(0.5, 0, 0, false)
+	stage.report(2, 0, 0, false)
+	inner.report(0.5, 0, 0, false)
+	inner.report(-1, 0, 0, false)
+
+	want := []float64{0.2, 0.4, 0.6, 0.5, 0.4}
+	if len(reports) != len(want) {
+		t.Fatalf("got %v, want %v", reports, want)
+	}
+	for i := range want {
+		if math.Abs(reports[i]-want[i]) > 1e-9 {
+			t.Errorf("report %d = %v, want %v", i, reports[i], want[i])
+		}
+	}
+
+	var nilReporter *Reporter
+	if nilReporter.Span(0, 1) != nil {
+		t.Error("the span of a nil reporter is not nil")
+	}
+}
+
+func TestReporterLasting(t *testing.T) {
+	output := "out_time_us=1000000\nprogress=continue\nout_time_us=4000000\nprogress=end\n"
+	script, _ := fakeFFmpeg(t, output, 0)
+
+	var reports []float64
+	r := NewReporter(10, func(p Progress) { reports = append(reports, p.Fraction) })
+	if err := r.Span(0.5, 1).lasting(4).Run(exec.Command(script)); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// Measured against the 4 seconds of output, not the 10 of input
+	want := []float64{0.625, 1}
+	if len(reports) != len(want) {
+		t.Fatalf("got %v, want %v", reports, want)
+	}
+	for i := range want {
+		if math.Abs(reports[i]-want[i]) > 1e-9 {
+			t.Errorf("report %d = %v, want %v", i, reports[i], want[i])
+		}
+	}
+
+	var nilReporter *Reporter
+	if nilReporter.lasting(4) != nil {
+		t.Error("a nil reporter lasting 4 seconds is not nil")
+	}
+}
+
+func TestReporterTransforms(t *testing.T) {
+	input := testAVI(t, "IPPP", true)
+
+	var fractions []float64
+	var frames []int
+	var r *Reporter
+	r = NewReporter(0, func(p Progress) {
+		fractions = append(fractions, p.Fraction)
+		frames = append(frames, p.Frame)
+		r.last = time.Time{}
+	})
+
+	identity := func(*avi.File) (avi.Transform, error) {
+		return func(c *avi.Chunk) ([]*avi.Chunk, error) { return []*avi.Chunk{c}, nil }, nil
+	}
+	if err := avi.Rewrite(bytes.NewReader(input), &bytes.Buffer{}, r.Transforms(identity)); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+
+	// Four frames in each of three passes
+	if len(fractions) != 12 {
+		t.Fatalf("got %d reports, want 12", len(fractions))
+	}
+	for i, fraction := range fractions {
+		if want := float64(i+1) / 12; math.Abs(fraction-want) > 1e-9 {
+			t.Errorf("report %d = %v, want %v", i, fraction, want)
+		}
+		if want := i%4 + 1; frames[i] != want {
+			t.Errorf("report %d is at frame %d, want %d", i, frames[i], want)
+		}
+	}
+
+	var nilReporter *Reporter
+	if nilReporter.Transforms(identity) == nil {
+		t.Error("a nil reporter dropped the transform")
+	}
+}
@@ -0,0 +1,135 @@
+package video
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Transitions into a sequence segment from the one before it
+const (
+	TransitionCut       = "cut"
+	TransitionCrossfade = "crossfade"
+)
+
+// Segment is a trimmed part of a video placed in a sequence.
+type Segment struct {
+	Path string `json:"path"`
+	// In and Out are in seconds; an Out of zero plays to the end
+	In  float64 `json:"in"`
+	Out float64 `json:"out,omitempty"`
+	// Transition from the previous segment, a cut unless set
+	Transition         string  `json:"transition,omitempty"`
+	TransitionDuration float64 `json:"transition_duration,omitempty"`
+}
+
+// RenderSequence conforms every segment to one size, framerate and audio
+// layout and joins them into a single video with cuts or crossfades. An
+// .avi output is encoded as Xvid without B-frames so the sequence can be
+// moshed again; anything else gets H.264. Progress is measured against
+// the length of the joined sequence.
+func (c *Converter) RenderSequence(segments []Segment, outputPath string, opts ConformOptions, progress *Reporter) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("sequence has no segments")
+	}
+	if opts.Width <= 0 || opts.Height <= 0 || opts.Framerate <= 0 {
+		return fmt.Errorf("sequence needs a width, height and framerate")
+	}
+
+	fps := strconv.FormatFloat(opts.Framerate, 'f', -1, 64)
+	conform := fmt.Sprintf("fps=%s,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,format=yuv420p",
+		fps, opts.Width, opts.Height, opts.Width, opts.Height)
+
+	var args, filters []string
+	var length float64 // of the sequence so far
+	lastVideo, lastAudio := "", ""
+
+	for i, seg := range segments {
+		info, err := c.GetVideoInfo(seg.Path)
+		if err != nil {
+			return fmt.Errorf("segment %d: %v", i+1, err)
+		}
+
+		// Moshed files can probe without a duration, in which case only
+		// an explicit out point bounds the segment
+		out := seg.Out
+		if out <= 0 || (info.Duration > 0 && out > info.Duration) {
+			out = info.Duration
+		}
+		duration := out - seg.In
+		if duration <= 0 {
+			return fmt.Errorf("segment %d is empty: in point %gs is past its end", i+1, seg.In)
+		}
+
+		args = append(args, "-i", seg.Path)
+		filters = append(filters, fmt.Sprintf("[%d:v]trim=start=%g:duration=%g,setpts=PTS-STARTPTS,%s[v%d]",
+			i, seg.In, duration, conform, i))
+		if opts.Audio {
+			// Every segment gets exactly its video's length of audio, with
+			// silence standing in for clips that have none
+			source := "anullsrc=r=44100:cl=stereo"
+			if info.AudioCodec != "" {
+				source = fmt.Sprintf("[%d:a]atrim=start=%g:duration=%g,asetpts=PTS-STARTPTS,aresample=44100", i, seg.In, duration)
+			}
+			filters = append(filters, fmt.Sprintf("%s,aformat=sample_fmts=fltp:channel_layouts=stereo,apad,atrim=duration=%g[a%d]",
+				source, duration, i))
+		}
+
+		if i == 0 {
+			lastVideo, lastAudio = "[v0]", "[a0]"
+			length = duration
+			continue
+		}
+
+		nextVideo, nextAudio := fmt.Sprintf("[vj%d]", i), fmt.Sprintf("[aj%d]", i)
+		switch seg.Transition {
+		case TransitionCrossfade:
+			fade := seg.TransitionDuration
+			if fade <= 0 || fade >= duration || fade >= length {
+				return fmt.Errorf("segment %d: crossfade of %gs does not fit between segments of %gs and %gs",
+					i+1, fade, length, duration)
+			}
+			filters = append(filters, fmt.Sprintf("%s[v%d]xfade=transition=fade:duration=%g:offset=%g%s",
+				lastVideo, i, fade, length-fade, nextVideo))
+			if opts.Audio {
+				filters = append(filters, fmt.Sprintf("%s[a%d]acrossfade=d=%g%s", lastAudio, i, fade, nextAudio))
+			}
+			length += duration - fade
+		case "", TransitionCut:
+			filters = append(filters, fmt.Sprintf("%s[v%d]concat=n=2:v=1:a=0%s", lastVideo, i, nextVideo))
+			if opts.Audio {
+				filters = append(filters, fmt.Sprintf("%s[a%d]concat=n=2:v=0:a=1%s", lastAudio, i, nextAudio))
+			}
+			length += duration
+		default:
+			return fmt.Errorf("segment %d: unknown transition %q", i+1, seg.Transition)
+		}
+		lastVideo, lastAudio = nextVideo, nextAudio
+	}
+
+	args = append(args, "-filter_complex", strings.Join(filters, ";"), "-map", lastVideo)
+	if opts.Audio {
+		args = append(args, "-map", lastAudio)
+	}
+
+	if strings.EqualFold(filepath.Ext(outputPath), ".avi") {
+		args = append(args, "-c:v", "libxvid", "-bf", "0", "-qscale:v", "3")
+		if opts.Audio {
+			args = append(args, "-c:a", "libmp3lame", "-b:a", "192k")
+		}
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "medium", "-crf", "18", "-pix_fmt", "yuv420p", "-movflags", "+faststart")
+		if opts.Audio {
+			args = append(args, "-c:a", "aac", "-b:a", "128k")
+		}
+	}
+	args = append(args, outputPath, "-y")
+
+	cmd := c.command("ffmpeg", args...)
+	if err := progress.lasting(length).Run(cmd); err != nil {
+		return fmt.Errorf("ffmpeg sequence render failed: %v", err)
+	}
+
+	return nil
+}
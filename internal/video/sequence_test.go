@@ -0,0 +1,23 @@
+package video
+
+import "testing"
+
+func TestRenderSequenceRejectsBadInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []Segment
+		opts     ConformOptions
+	}{
+		{"no segments", nil, ConformOptions{Width: 640, Height: 360, Framerate: 30}},
+		{"no size", []Segment{{Path: "a.avi"}}, ConformOptions{Framerate: 30}},
+		{"no framerate", []Segment{{Path: "a.avi"}}, ConformOptions{Width: 640, Height: 360}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewConverter().RenderSequence(tt.segments, "out.mp4", tt.opts, nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package video
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"moshr/internal/avi"
+)
+
+// TransferParams control a two clip motion transfer. Clip A provides the
+// image, clip B provides the motion that smears it.
+type TransferParams struct {
+	Intensity float64 `json:"intensity"`
+	// TrimToLastKeyframe starts the output at the last keyframe of clip A
+	// instead of playing clip A in full before the splice.
+	TrimToLastKeyframe bool `json:"trim_to_last_keyframe"`
+	// DuplicationCount repeats each P-frame of clip B; 0 or 1 plays them once.
+	DuplicationCount int `json:"duplication_count"`
+}
+
+func (p TransferParams) Validate() error {
+	if p.DuplicationCount < 0 {
+		return fmt.Errorf("duplication count must not be negative")
+	}
+	return nil
+}
+
+// TransferMotion splices two AVIs that were encoded with identical settings.
+// Clip A plays up to and including its last keyframe and the frames after
+// it, then every keyframe of clip B is dropped so B's motion vectors and
+// residuals are applied to A's last image.
+func (m *Mosher) TransferMotion(keyframePath, motionPath, outputPath string, params TransferParams) error {
+	fmt.Printf("TRANSFER: Moving motion of %s onto %s -> %s with params: %+v\n", motionPath, keyframePath, outputPath, params)
+
+	if err := params.Validate(); err != nil {
+		return err
+	}
+
+	a, err := os.Open(keyframePath)
+	if err != nil {
+		return fmt.Errorf("failed to read keyframe clip: %v", err)
+	}
+	defer a.Close()
+
+	b, err := os.Open(motionPath)
+	if err != nil {
+		return fmt.Errorf("failed to read motion clip: %v", err)
+	}
+	defer b.Close()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer out.Close()
+
+	if err := m.TransferStream(m.input(a), m.input(b), out, params); err != nil {
+		return fmt.Errorf("failed to transfer motion: %v", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+
+	fmt.Printf("TRANSFER: Successfully wrote transfer to %s\n", outputPath)
+	return nil
+}
+
+// TransferStream is the streaming form of TransferMotion.
+func (m *Mosher) TransferStream(a, b io.ReadSeeker, w io.Writer, params TransferParams) error {
+	lastKeyframe, err := lastKeyframeIndex(a)
+	if err != nil {
+		return fmt.Errorf("keyframe clip: %v", err)
+	}
+	if lastKeyframe < 0 {
+		return fmt.Errorf("keyframe clip has no keyframes")
+	}
+
+	removed := 0
+	err = avi.Splice([]io.ReadSeeker{a, b}, w, func(header *avi.File, source int) (avi.Transform, error) {
+		if source == 0 {
+			return keyframeClipTransform(lastKeyframe, params.TrimToLastKeyframe), nil
+		}
+		removed = 0
+		return motionClipTransform(m, params.DuplicationCount, &removed), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("TRANSFER DEBUG: Spliced at chunk %d of clip A, removed %d keyframes from clip B\n", lastKeyframe, removed)
+	return nil
+}
+
+// lastKeyframeIndex returns the movi position of the last video keyframe.
+func lastKeyframeIndex(rs io.ReadSeeker) (int, error) {
+	r, err := avi.NewReader(rs)
+	if err != nil {
+		return -1, err
+	}
+
+	last, i := -1, 0
+	err = r.Chunks(func(c *avi.Chunk) error {
+		if avi.IsVideo(c.ID) && avi.FrameTypeOf(c) == avi.FrameI {
+			last = i
+		}
+		i++
+		return nil
+	})
+	return last, err
+}
+
+func keyframeClipTransform(lastKeyframe int, trim bool) avi.Transform {
+	i := 0
+	return func(c *avi.Chunk) ([]*avi.Chunk, error) {
+		pos := i
+		i++
+		if trim && pos < lastKeyframe {
+			return nil, nil
+		}
+		return []*avi.Chunk{c}, nil
+	}
+}
+
+func motionClipTransform(m *Mosher, duplication int, removed *int) avi.Transform {
+	return func(c *avi.Chunk) ([]*avi.Chunk, error) {
+		if !avi.IsVideo(c.ID) {
+			return []*avi.Chunk{c}, nil
+		}
+
+		switch avi.FrameTypeOf(c) {
+		case avi.FrameI:
+			*removed++
+			return nil, nil
+		case avi.FrameP, avi.FrameS:
+			if duplication > 1 {
+				chunks := make([]*avi.Chunk, 0, duplication)
+				for i := 0; i < duplication; i++ {
+					if i%3 == 2 && len(c.Data) > 12 {
+						chunks = append(chunks, m.corruptedCopy(c))
+					} else {
+						chunks = append(chunks, c.Copy())
+					}
+				}
+				return chunks, nil
+			}
+		}
+		return []*avi.Chunk{c}, nil
+	}
+}
@@ -0,0 +1,56 @@
+package video
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTransferStream(t *testing.T) {
+	tests := []struct {
+		name           string
+		a, b           string
+		aAudio, bAudio bool
+		params         TransferParams
+		want           int
+		wantErr        bool
+	}{
+		{"plays clip A in full", "IPPIPP", "IPPIPP", false, false, TransferParams{}, 10, false},
+		{"trims to the last keyframe", "IPPIPP", "IPPIPP", false, false, TransferParams{TrimToLastKeyframe: true}, 7, false},
+		{"trims with audio", "IPPIPP", "IPPIPP", true, true, TransferParams{TrimToLastKeyframe: true}, 7, false},
+		{"duplicates motion", "IPPIPP", "IPPIPP", false, false, TransferParams{DuplicationCount: 3}, 18, false},
+		{"duplication of one plays once", "IPP", "IPP", false, false, TransferParams{DuplicationCount: 1}, 5, false},
+		{"keyframe clip without keyframes", "PPP", "IPP", false, false, TransferParams{}, 0, true},
+		{"streams differ", "IPP", "IPP", true, false, TransferParams{}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAVI(t, tt.a, tt.aAudio)
+			b := testAVI(t, tt.b, tt.bAudio)
+
+			var out bytes.Buffer
+			err := NewMosher().TransferStream(bytes.NewReader(a), bytes.NewReader(b), &out, tt.params)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransferStream failed: %v", err)
+			}
+			if video, _ := countChunks(t, out.Bytes()); video != tt.want {
+				t.Errorf("got %d video frames, want %d", video, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferParamsValidate(t *testing.T) {
+	if err := (TransferParams{DuplicationCount: 2}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (TransferParams{DuplicationCount: -1}).Validate(); err == nil {
+		t.Error("expected an error for a negative duplication count")
+	}
+}
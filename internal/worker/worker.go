@@ -0,0 +1,415 @@
+// Package worker runs moshr as a remote worker: it leases jobs from a
+// coordinating server over HTTP, renders them with the same code the
+// server uses and sends the results back.
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"moshr/internal/batch"
+	"moshr/internal/effects"
+	"moshr/internal/video"
+)
+
+// errLeaseLost means the coordinator no longer considers the job ours.
+var errLeaseLost = errors.New("lease lost")
+
+// Worker leases and renders jobs from a coordinator.
+type Worker struct {
+	coordinator string
+	name        string
+	client      *http.Client
+	converter   *video.Converter
+	analyzer    *video.Analyzer
+
+	// PollInterval is how long to wait before asking again when nothing
+	// is queued
+	PollInterval time.Duration
+}
+
+// leasedJob is the coordinator's answer to a lease request.
+type leasedJob struct {
+	Job          batch.Mosh `json:"job"`
+	Inputs       []string   `json:"inputs"`
+	LeaseSeconds float64    `json:"lease_seconds"`
+	MaxRunTime   float64    `json:"max_run_time"`
+}
+
+// New returns a worker that leases jobs from the server at coordinator,
+// such as http://localhost:8080, under name.
+func New(coordinator, name string) *Worker {
+	return &Worker{
+		coordinator:  strings.TrimSuffix(coordinator, "/"),
+		name:         name,
+		client:       &http.Client{},
+		converter:    video.NewConverter(),
+		analyzer:     video.NewAnalyzer(),
+		PollInterval: 2 * time.Second,
+	}
+}
+
+// Run leases and renders jobs one at a time until ctx is done. A job that
+// is interrupted is left for its lease to expire, so the coordinator
+// queues it again.
+func (w *Worker) Run(ctx context.Context) error {
+	fmt.Printf("Worker %s leasing jobs from %s\n", w.name, w.coordinator)
+
+	for {
+		leased, err := w.lease(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Failed to lease a job: %v\n", err)
+		}
+		if leased != nil {
+			w.runJob(ctx, leased)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+// lease asks the coordinator for a job, returning nil when none is queued.
+func (w *Worker) lease(ctx context.Context) (*leasedJob, error) {
+	resp, err := w.post(ctx, "/api/workers/lease", map[string]string{"worker": w.name})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var leased leasedJob
+	if err := json.NewDecoder(resp.Body).Decode(&leased); err != nil {
+		return nil, fmt.Errorf("failed to parse lease: %v", err)
+	}
+	return &leased, nil
+}
+
+// runJob fetches a leased job's inputs into a scratch directory, renders
+// it and hands in the output or the reason it failed.
+func (w *Worker) runJob(ctx context.Context, leased *leasedJob) {
+	job := &leased.Job
+	fmt.Printf("Processing %s %s\n", kind(job), job.ID)
+
+	dir, err := os.MkdirTemp("", "moshr-"+job.ID+"-")
+	if err != nil {
+		w.fail(ctx, job.ID, fmt.Sprintf("Worker %s could not create a scratch directory: %v", w.name, err))
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if leased.MaxRunTime > 0 {
+		var stop context.CancelFunc
+		jobCtx, stop = context.WithTimeout(jobCtx, time.Duration(leased.MaxRunTime*float64(time.Second)))
+		defer stop()
+	}
+
+	// The heartbeat renews the lease and carries the latest progress. It
+	// stops the render when the coordinator takes the job back.
+	hb := &heartbeat{}
+	interval := time.Duration(leased.LeaseSeconds * float64(time.Second) / 3)
+	if interval <= 0 || interval > time.Second {
+		interval = time.Second
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go w.beat(jobCtx, job.ID, interval, hb, cancel, done)
+
+	// Outputs keep their extension so ffmpeg picks the same format
+	outputPath := filepath.Join(dir, "output"+filepath.Ext(batch.JobOutputPath(job)))
+	original := batch.JobInputs(job)
+	for _, name := range leased.Inputs {
+		path := filepath.Join(dir, name+filepath.Ext(original[name]))
+		if err := w.download(jobCtx, job.ID, name, path); err != nil {
+			w.finishFailed(ctx, jobCtx, job, hb, fmt.Sprintf("Worker %s could not fetch the %s input: %v", w.name, name, err))
+			return
+		}
+		if err := batch.SetJobInput(job, name, path); err != nil {
+			w.finishFailed(ctx, jobCtx, job, hb, err.Error())
+			return
+		}
+	}
+	job.OutputDir = dir
+
+	var duration float64
+	if info, err := w.analyzer.AnalyzeVideo(job.InputPath); err == nil {
+		duration = info.Duration
+	}
+	progress := video.NewReporter(duration, hb.setProgress)
+	if kind(job) == batch.KindMosh {
+		// The coordinator takes the bar from 0.9 while it makes the preview
+		progress = progress.Span(0.1, 0.9)
+	}
+
+	resolved, err := batch.RunJob(jobCtx, w.converter.WithContext(jobCtx), job, outputPath, progress, func(index, total int, step effects.Step) {
+		hb.setStep(index, total, step)
+	})
+	if err != nil {
+		w.finishFailed(ctx, jobCtx, job, hb, err.Error())
+		return
+	}
+
+	if err := w.upload(jobCtx, job.ID, outputPath); err != nil {
+		w.finishFailed(ctx, jobCtx, job, hb, fmt.Sprintf("Worker %s could not upload the output: %v", w.name, err))
+		return
+	}
+
+	resp, err := w.post(ctx, fmt.Sprintf("/api/workers/jobs/%s/complete", job.ID), map[string]interface{}{
+		"worker":          w.name,
+		"resolved_params": resolved,
+	})
+	if err == nil {
+		err = checkResponse(resp)
+		resp.Body.Close()
+	}
+	if err != nil {
+		fmt.Printf("Failed to complete %s: %v\n", job.ID, err)
+		return
+	}
+	fmt.Printf("Completed %s %s\n", kind(job), job.ID)
+}
+
+// finishFailed reports a failed job unless the coordinator already took it
+// back or the worker is stopping, in which case the lease runs out and the
+// job is queued again.
+func (w *Worker) finishFailed(ctx, jobCtx context.Context, job *batch.Mosh, hb *heartbeat, reason string) {
+	switch {
+	case hb.lost():
+		fmt.Printf("Stopped %s: the coordinator took it back\n", job.ID)
+	case ctx.Err() != nil:
+		fmt.Printf("Stopped %s: the worker is shutting down\n", job.ID)
+	case errors.Is(jobCtx.Err(), context.DeadlineExceeded):
+		// The coordinator fails it when the lease reaches its deadline
+		fmt.Printf("Stopped %s: it ran out of time\n", job.ID)
+	default:
+		fmt.Printf("Failed %s: %s\n", job.ID, reason)
+		w.fail(ctx, job.ID, reason)
+	}
+}
+
+func (w *Worker) fail(ctx context.Context, jobID, reason string) {
+	resp, err := w.post(ctx, fmt.Sprintf("/api/workers/jobs/%s/fail", jobID), map[string]string{
+		"worker": w.name,
+		"error":  reason,
+	})
+	if err == nil {
+		err = checkResponse(resp)
+		resp.Body.Close()
+	}
+	if err != nil {
+		fmt.Printf("Failed to report failure of %s: %v\n", jobID, err)
+	}
+}
+
+// beat reports to the coordinator every interval until done is closed.
+func (w *Worker) beat(ctx context.Context, jobID string, interval time.Duration, hb *heartbeat, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		body := hb.take()
+		body["worker"] = w.name
+		resp, err := w.post(ctx, fmt.Sprintf("/api/workers/jobs/%s/progress", jobID), body)
+		if err == nil {
+			err = checkResponse(resp)
+			resp.Body.Close()
+		}
+		if errors.Is(err, errLeaseLost) {
+			hb.markLost()
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Failed to report progress of %s: %v\n", jobID, err)
+		}
+	}
+}
+
+// download fetches an input of a job to path.
+func (w *Worker) download(ctx context.Context, jobID, name, path string) error {
+	endpoint := fmt.Sprintf("%s/api/workers/jobs/%s/inputs/%s?worker=%s", w.coordinator, jobID, name, url.QueryEscape(w.name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// upload sends the rendered output of a job to the coordinator. Image
+// sequences are sent as a zip of their frames.
+func (w *Worker) upload(ctx context.Context, jobID, path string) error {
+	contentType := "application/octet-stream"
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		archive, err := os.Create(path + ".zip")
+		if err != nil {
+			return err
+		}
+		err = video.ZipFrames(archive, path)
+		if closeErr := archive.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		path += ".zip"
+		contentType = "application/zip"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	endpoint := fmt.Sprintf("%s/api/workers/jobs/%s/output?worker=%s", w.coordinator, jobID, url.QueryEscape(w.name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (w *Worker) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.coordinator+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return w.client.Do(req)
+}
+
+// checkResponse turns an error status into an error, errLeaseLost for
+// 409 Conflict.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return errLeaseLost
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Error == "" {
+		body.Error = resp.Status
+	}
+	return fmt.Errorf("coordinator: %s", body.Error)
+}
+
+func kind(job *batch.Mosh) string {
+	if job.Kind == "" {
+		return batch.KindMosh
+	}
+	return job.Kind
+}
+
+// heartbeat holds what the next report to the coordinator carries: the
+// latest progress and any chain step started since the last report.
+type heartbeat struct {
+	mu       sync.Mutex
+	progress *video.Progress
+	step     map[string]interface{}
+	isLost   bool
+}
+
+func (hb *heartbeat) setProgress(p video.Progress) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.progress = &p
+}
+
+func (hb *heartbeat) setStep(index, total int, step effects.Step) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.step = map[string]interface{}{"index": index, "total": total, "step": step}
+}
+
+// take returns the body of the next report and clears what it carries.
+// A step goes first; the progress made since follows in the next report.
+func (hb *heartbeat) take() map[string]interface{} {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	body := map[string]interface{}{}
+	switch {
+	case hb.step != nil:
+		body["step"] = hb.step
+		hb.step = nil
+	case hb.progress != nil:
+		body["progress"] = hb.progress
+		hb.progress = nil
+	}
+	return body
+}
+
+func (hb *heartbeat) markLost() {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.isLost = true
+}
+
+func (hb *heartbeat) lost() bool {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	return hb.isLost
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"moshr/internal/effects"
+	"moshr/internal/video"
+)
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"ok", http.StatusOK, "", ""},
+		{"no content", http.StatusNoContent, "", ""},
+		{"lease lost", http.StatusConflict, `{"error":"lease is no longer held"}`, errLeaseLost.Error()},
+		{"error message", http.StatusBadRequest, `{"error":"unknown input"}`, "coordinator: unknown input"},
+		{"no message", http.StatusInternalServerError, "oops", "coordinator: 500 Internal Server Error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Status:     "500 Internal Server Error",
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			err := checkResponse(resp)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkResponse() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("checkResponse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeartbeatTake(t *testing.T) {
+	var hb heartbeat
+	if body := hb.take(); len(body) != 0 {
+		t.Errorf("an empty heartbeat carries %v", body)
+	}
+
+	hb.setProgress(video.Progress{Fraction: 0.2})
+	hb.setStep(1, 3, effects.Step{Effect: "glitch"})
+	hb.setProgress(video.Progress{Fraction: 0.4})
+
+	// The step goes first and the latest progress follows
+	if body := hb.take(); body["step"] == nil || body["progress"] != nil {
+		t.Errorf("first report = %v, want only the step", body)
+	}
+	body := hb.take()
+	if progress, ok := body["progress"].(*video.Progress); !ok || progress.Fraction != 0.4 {
+		t.Errorf("second report = %v, want the latest progress", body)
+	}
+	if body := hb.take(); len(body) != 0 {
+		t.Errorf("third report = %v, want nothing left", body)
+	}
+
+	if hb.lost() {
+		t.Error("a new heartbeat is lost")
+	}
+	hb.markLost()
+	if !hb.lost() {
+		t.Error("markLost did not stick")
+	}
+}
@@ -11,11 +11,58 @@ class MoshrApp {
         this.createdClips = [];
         this.moshSessions = [];
         this.selectedClip = null;
+        this.exportProfiles = [];
         
         this.initializeElements();
         this.setupEventListeners();
         this.connectWebSocket();
         this.loadProjects();
+        this.loadExportProfiles();
+    }
+
+    async loadExportProfiles() {
+        try {
+            const response = await fetch('/api/export-profiles');
+            if (response.ok) {
+                const data = await response.json();
+                this.exportProfiles = data.profiles || [];
+            }
+        } catch (error) {
+            console.error('Error loading export profiles:', error);
+        }
+    }
+
+    exportProfileOptions() {
+        return this.exportProfiles
+            .filter(profile => profile.name !== 'mp4' && profile.name !== 'webm')
+            .map(profile => `<option value="${profile.name}" title="${profile.description || ''}">${profile.name}</option>`)
+            .join('');
+    }
+
+    exportWithProfile(filename, moshId, selectId) {
+        const select = document.getElementById(selectId);
+        if (!select || !select.value) return;
+
+        const profile = select.value;
+        const option = select.options[select.selectedIndex];
+        if (option && option.dataset.exported === 'true') {
+            this.playConvertedFile(moshId, profile);
+        } else {
+            this.convertMosh(filename, moshId, profile);
+        }
+    }
+
+    markExportedProfiles(moshId, convertedFiles) {
+        [`export-profile-${moshId}`, `history-export-profile-${moshId}`].forEach(id => {
+            const select = document.getElementById(id);
+            if (!select) return;
+            Array.from(select.options).forEach(option => {
+                if (convertedFiles[option.value]) {
+                    option.dataset.exported = 'true';
+                    option.textContent = `▶ ${option.value}`;
+                }
+            });
+        });
     }
 
     initializeElements() {
@@ -56,7 +103,27 @@ class MoshrApp {
         this.intensity = document.getElementById('intensity');
         this.intensityValue = document.getElementById('intensityValue');
         this.batchMode = document.getElementById('batchMode');
+        this.durationOptions = document.getElementById('durationOptions');
+        this.durationMode = document.getElementById('durationMode');
+        this.audioMode = document.getElementById('audioMode');
+        this.audioBitcrush = document.getElementById('audioBitcrush');
+        this.audioCorruption = document.getElementById('audioCorruption');
+        this.audioReverse = document.getElementById('audioReverse');
+        this.audioTapeStop = document.getElementById('audioTapeStop');
+        this.sonifyOptions = document.getElementById('sonifyOptions');
+        this.sonifyFilter = document.getElementById('sonifyFilter');
+        this.sonifySecondFilter = document.getElementById('sonifySecondFilter');
+        this.sonifySampleFormat = document.getElementById('sonifySampleFormat');
+        this.sonifyPixelFormat = document.getElementById('sonifyPixelFormat');
         this.clipSource = document.getElementById('clipSource');
+        this.intermediateCodec = document.getElementById('intermediateCodec');
+        this.intermediateGop = document.getElementById('intermediateGop');
+        this.intermediateQuantizer = document.getElementById('intermediateQuantizer');
+        this.intermediateBFrames = document.getElementById('intermediateBFrames');
+        this.intermediateNoSceneCut = document.getElementById('intermediateNoSceneCut');
+        this.intermediatePcmAudio = document.getElementById('intermediatePcmAudio');
+        this.conversionGroup = document.getElementById('conversionGroup');
+        this.conversionSelect = document.getElementById('conversionSelect');
         this.progress = document.getElementById('progress');
         this.progressBar = document.getElementById('progressBar');
         this.progressText = document.getElementById('progressText');
@@ -84,6 +151,11 @@ class MoshrApp {
         this.createClipBtn.addEventListener('click', this.createClipFromSelection.bind(this));
         this.convertBtn.addEventListener('click', this.convertVideo.bind(this));
         this.moshBtn.addEventListener('click', this.generateMosh.bind(this));
+        this.effectType.addEventListener('change', this.updateEffectOptions.bind(this));
+        this.updateEffectOptions();
+        this.conversionSelect.addEventListener('change', () => {
+            this.convertedPath = this.conversionSelect.value;
+        });
         
         this.intensity.addEventListener('input', (e) => {
             this.intensityValue.textContent = e.target.value;
@@ -188,15 +260,18 @@ class MoshrApp {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json'
-                }
+                },
+                body: JSON.stringify(this.getIntermediateOptions())
             });
 
             if (!response.ok) {
-                throw new Error('Conversion failed');
+                const error = await response.json().catch(() => ({}));
+                throw new Error(error.error || 'Conversion failed');
             }
 
             const result = await response.json();
             this.convertedPath = result.output_path;
+            this.showConversions(result.project);
             
             this.moshBtn.disabled = false;
             this.updateProgress('Conversion completed', 100);
@@ -231,7 +306,8 @@ class MoshrApp {
                     input_path: inputPath,
                     effect: this.effectType.value,
                     intensity: parseFloat(this.intensity.value),
-                    batch: this.batchMode.checked
+                    batch: this.batchMode.checked,
+                    params: this.getEffectParams()
                 })
             });
 
@@ -258,6 +334,90 @@ class MoshrApp {
         }
     }
 
+    // Shows only the options of the selected effect. Browsers may restore
+    // the selection on reload, so this also runs once at startup.
+    updateEffectOptions() {
+        this.durationOptions.style.display = this.effectType.value === 'datamosh' ? 'block' : 'none';
+        this.sonifyOptions.style.display = this.effectType.value === 'sonify' ? 'block' : 'none';
+    }
+
+    getEffectParams() {
+        switch (this.effectType.value) {
+            case 'datamosh':
+                return this.getDurationParams();
+            case 'sonify':
+                return this.getSonifyParams();
+        }
+        return undefined;
+    }
+
+    getDurationParams() {
+
+        const params = {};
+        if (this.durationMode.value) {
+            params.duration_mode = this.durationMode.value;
+        }
+        if (this.audioMode.value !== 'original') {
+            params.audio = this.audioMode.value;
+        }
+        const bitcrush = parseInt(this.audioBitcrush.value) || 0;
+        if (bitcrush > 0) {
+            params.audio_bitcrush = bitcrush;
+        }
+        const corruption = parseFloat(this.audioCorruption.value) || 0;
+        if (corruption > 0) {
+            params.audio_corruption = corruption;
+        }
+        if (this.audioReverse.checked) {
+            params.audio_reverse = true;
+        }
+        if (this.audioTapeStop.checked) {
+            params.audio_tape_stop = true;
+        }
+        return Object.keys(params).length > 0 ? params : undefined;
+    }
+
+    getSonifyParams() {
+        // Without a filter the effect picks one, like the other effects
+        // pick their settings
+        const params = {
+            sample_format: this.sonifySampleFormat.value,
+            pixel_format: this.sonifyPixelFormat.value
+        };
+        if (this.sonifyFilter.value) {
+            params.filter = this.sonifyFilter.value;
+        }
+        if (this.sonifySecondFilter.value) {
+            params.second_filter = this.sonifySecondFilter.value;
+        }
+        return params;
+    }
+
+    getIntermediateOptions() {
+        return {
+            codec: this.intermediateCodec.value,
+            gop: parseInt(this.intermediateGop.value) || 0,
+            quantizer: parseInt(this.intermediateQuantizer.value) || 0,
+            b_frames: this.intermediateBFrames.checked,
+            no_scene_cut: this.intermediateNoSceneCut.checked,
+            pcm_audio: this.intermediatePcmAudio.checked
+        };
+    }
+
+    showConversions(project) {
+        const conversions = (project && project.conversions) || [];
+        if (conversions.length === 0) {
+            this.conversionGroup.style.display = 'none';
+            return;
+        }
+
+        this.conversionSelect.innerHTML = conversions
+            .map(conversion => `<option value="${conversion.path}">${conversion.name}</option>`)
+            .join('');
+        this.conversionSelect.value = this.convertedPath;
+        this.conversionGroup.style.display = 'block';
+    }
+
     getSelectedInputPath() {
         if (this.clipSource.value === 'clip' && this.selectedClip) {
             console.log('Using clip path:', this.selectedClip.file_path || this.selectedClip.path);
@@ -687,7 +847,7 @@ class MoshrApp {
     handleWebSocketMessage(message) {
         switch (message.type) {
             case 'mosh_update':
-                this.updateMoshStatus(message.data.mosh_id, message.data.status, message.data.progress);
+                this.updateMoshStatus(message.data.mosh_id, message.data.status, message.data.progress, message.data);
                 break;
             case 'moshes_update':
                 this.updateAllMoshes(message.data);
@@ -695,7 +855,7 @@ class MoshrApp {
         }
     }
 
-    updateMoshStatus(moshId, status, progress) {
+    updateMoshStatus(moshId, status, progress, details = {}) {
         console.log('WebSocket mosh update:', { moshId, status, progress });
         console.log('Moshes in map:', Array.from(this.moshesMap.keys()));
         
@@ -704,6 +864,8 @@ class MoshrApp {
             console.log('Found mosh:', mosh);
             mosh.status = status;
             mosh.progress = progress;
+            mosh.fps = details.fps || 0;
+            mosh.eta = details.eta || 0;
             this.moshesMap.set(moshId, mosh);
             this.updateMoshesDisplay();
             
@@ -714,7 +876,7 @@ class MoshrApp {
                     this.showLocalProgress(mosh.moshId, `Converting to ${mosh.format.toUpperCase()}... (${Math.round(progress * 100)}%)`, progress * 100);
                 } else if (status === 'completed') {
                     this.showLocalProgress(mosh.moshId, `Conversion to ${mosh.format.toUpperCase()} completed`, 100);
-                    this.showConvertedFile(moshId, mosh.format);
+                    this.showConvertedFile(mosh.moshId, mosh.format);
                 } else if (status === 'failed') {
                     this.showLocalProgress(mosh.moshId, `Conversion to ${mosh.format.toUpperCase()} failed`, 0);
                 }
@@ -722,12 +884,14 @@ class MoshrApp {
                 console.log('Mosh is not conversion or missing moshId:', mosh);
             }
             
-            if (status === 'completed' && !mosh.isConversion) {
-                this.loadResults();
+            if ((status === 'completed' || status === 'cancelled') && !mosh.isConversion) {
+                if (status === 'completed') {
+                    this.loadResults();
+                }
                 
-                // Check if all moshes are completed and hide main progress
+                // Check if all moshes are finished and hide main progress
                 const allCompleted = Array.from(this.moshesMap.values()).every(mosh => 
-                    mosh.status === 'completed' || mosh.status === 'failed'
+                    mosh.status === 'completed' || mosh.status === 'failed' || mosh.status === 'cancelled'
                 );
                 
                 if (allCompleted) {
@@ -738,75 +902,6 @@ class MoshrApp {
             }
         } else {
             console.log('Mosh not found in map for moshId:', moshId);
-            
-            // Handle conversion progress updates that might not be in the map yet
-            if (moshId.startsWith('convert_')) {
-                console.log('Handling conversion progress update for:', moshId);
-                
-                // New format: convert_moshed_MOSHID.avi_FORMAT_TIMESTAMP
-                const match = moshId.match(/convert_moshed_(.+?)\.avi_(.+?)_(\d+)/);
-                if (match) {
-                    const actualMoshId = match[1];
-                    const format = match[2];
-                    console.log('NEW FORMAT - Extracted mosh ID:', actualMoshId, 'format:', format);
-                    
-                    // Create a temporary mosh entry for this conversion
-                    this.moshesMap.set(moshId, {
-                        status: status,
-                        progress: progress,
-                        isConversion: true,
-                        format: format,
-                        moshId: actualMoshId
-                    });
-                    
-                    // Update the progress display
-                    if (status === 'processing') {
-                        this.showLocalProgress(actualMoshId, `Converting to ${format.toUpperCase()}... (${Math.round(progress * 100)}%)`, progress * 100);
-                    } else if (status === 'completed') {
-                        this.showLocalProgress(actualMoshId, `Conversion to ${format.toUpperCase()} completed`, 100);
-                        this.showConvertedFile(moshId, format);
-                        // Also reload the project to refresh button states
-                        setTimeout(() => this.reloadCurrentProject(), 1000);
-                    } else if (status === 'failed') {
-                        this.showLocalProgress(actualMoshId, `Conversion to ${format.toUpperCase()} failed`, 0);
-                    }
-                } else {
-                    // Fallback for old format: convert_moshed_MOSHID.avi_TIMESTAMP
-                    const oldMatch = moshId.match(/convert_moshed_(.+?)\.avi_(\d+)/);
-                    if (oldMatch) {
-                        const actualMoshId = oldMatch[1];
-                        // Try to detect format from the conversion ID string
-                        let format = 'mp4'; // default
-                        
-                        // Check multiple ways to detect WebM
-                        if (moshId.includes('webm') || 
-                            moshId.includes('_webm_') || 
-                            moshId.toLowerCase().includes('webm')) {
-                            format = 'webm';
-                        }
-                        
-                        console.log('FALLBACK FORMAT - Using format detection for mosh ID:', actualMoshId, 'format:', format, 'full ID:', moshId);
-                        
-                        this.moshesMap.set(moshId, {
-                            status: status,
-                            progress: progress,
-                            isConversion: true,
-                            format: format,
-                            moshId: actualMoshId
-                        });
-                        
-                        if (status === 'processing') {
-                            this.showLocalProgress(actualMoshId, `Converting to ${format.toUpperCase()}... (${Math.round(progress * 100)}%)`, progress * 100);
-                        } else if (status === 'completed') {
-                            this.showLocalProgress(actualMoshId, `Conversion to ${format.toUpperCase()} completed`, 100);
-                            this.showConvertedFile(moshId, format);
-                            setTimeout(() => this.reloadCurrentProject(), 1000);
-                        } else if (status === 'failed') {
-                            this.showLocalProgress(actualMoshId, `Conversion to ${format.toUpperCase()} failed`, 0);
-                        }
-                    }
-                }
-            }
         }
     }
 
@@ -831,6 +926,9 @@ class MoshrApp {
                     <div class="mosh-progress">
                         <div class="mosh-progress-bar" style="width: ${mosh.progress * 100}%"></div>
                     </div>
+                    ${mosh.status === 'processing' && mosh.eta ? `
+                        <div class="mosh-eta">${Math.round(mosh.progress * 100)}% · ${mosh.fps.toFixed(1)} fps · ${Math.ceil(mosh.eta)}s left</div>
+                    ` : ''}
                 </div>
             `;
         });
@@ -848,7 +946,8 @@ class MoshrApp {
             // Only get completed moshes from the current session
             const currentSessionMoshIds = Array.from(this.moshesMap.keys());
             const completedMoshes = data.moshes.filter(mosh => 
-                mosh.status === 'completed' && currentSessionMoshIds.includes(mosh.id)
+                mosh.status === 'completed' && currentSessionMoshIds.includes(mosh.id) &&
+                (!mosh.kind || mosh.kind === 'mosh')
             );
             
             if (completedMoshes.length > 0) {
@@ -872,7 +971,7 @@ class MoshrApp {
             
             html += `
                 <div class="preview-item" id="preview-${mosh.id}">
-                    <img src="/api/projects/${this.currentProjectData.id}/preview/${filename}?width=300&height=200" alt="Preview ${index + 1}" />
+                    <img src="/api/jobs/${mosh.id}/preview" alt="Preview ${index + 1}" />
                     <h4>Variation ${index + 1}</h4>
                     <div class="status completed">Completed</div>
                     <p>Intensity: ${mosh.params.intensity}</p>
@@ -889,6 +988,10 @@ class MoshrApp {
                         <button onclick="app.handleWebmAction('${filename}', '${mosh.id}')" class="convert-btn" id="webm-btn-${mosh.id}">
                             Convert to WebM
                         </button>
+                        <select class="export-profile" id="export-profile-${mosh.id}">${this.exportProfileOptions()}</select>
+                        <button onclick="app.exportWithProfile('${filename}', '${mosh.id}', 'export-profile-${mosh.id}')" class="convert-btn" title="Export with the selected profile">
+                            Export
+                        </button>
                         <button onclick="app.deleteMosh('${mosh.id}')" class="delete-btn" title="Delete this mosh">
                             🗑️
                         </button>
@@ -957,7 +1060,7 @@ class MoshrApp {
             const timestamp = new Date(group.created_at || group.timestamp).toLocaleString();
             
             // Extract session ID from group data or generate from timestamp
-            const sessionId = group.id || group.session_id || this.getSessionIdFromTimestamp(group.created_at || group.timestamp);
+            const sessionId = group.id;
             
             // Get source information
             const sourceInfo = group.source || (group.moshes && group.moshes[0] ? group.moshes[0].source : null) || 'Unknown Source';
@@ -1008,28 +1111,21 @@ class MoshrApp {
 
                 // Extract filename from file_path for API compatibility
                 const filename = mosh.filename || (mosh.file_path ? mosh.file_path.split('/').pop() : 'unknown.avi');
-                // Always extract mosh ID from filename for history items since stored IDs are often wrong
-                let moshId;
-                if (filename.startsWith('moshed_')) {
-                    // Extract mosh ID from filename like "moshed_single_1749018199.avi" -> "single_1749018199"
-                    moshId = filename.substring(7).replace('.avi', ''); // Remove "moshed_" and ".avi"
-                } else {
-                    // Fallback to stored ID if filename extraction fails
-                    moshId = mosh.id || 'unknown';
-                }
+                const moshId = mosh.id || 'unknown';
 
                 // Get effect name, fallback to 'mosh' if not available
                 const effectName = mosh.effect || 'mosh';
 
                 moshElement.innerHTML = `
-                    <img src="/api/projects/${this.currentProjectData.id}/preview/${filename}?width=200&height=150" alt="${effectName}" />
+                    <img src="/api/jobs/${moshId}/preview" alt="${effectName}" />
                     <div class="mosh-info">
                         ${effectName.charAt(0).toUpperCase() + effectName.slice(1)} Effect
                     </div>
                     <div class="mosh-params">
+                        ${mosh.label ? `<span class="mosh-label">${mosh.label}</span>` : `
                         Intensity: ${mosh.params.intensity}<br>
                         ${mosh.params.iframe_removal ? 'I-Frame Removal' : ''}<br>
-                        ${mosh.params.pframe_duplication ? `P-Frame Dup: ${mosh.params.duplication_count}` : ''}
+                        ${mosh.params.pframe_duplication ? `P-Frame Dup: ${mosh.params.duplication_count}` : ''}`}
                     </div>
                     <div class="conversion-progress" id="history-conversion-progress-${moshId}" style="display: none;">
                         <div class="progress-label" id="history-progress-label-${moshId}">Converting...</div>
@@ -1040,6 +1136,8 @@ class MoshrApp {
                     <div class="convert-actions">
                         <button onclick="app.handleMp4Action('${filename}', '${moshId}')" class="convert-btn small" id="history-mp4-btn-${moshId}">MP4</button>
                         <button onclick="app.handleWebmAction('${filename}', '${moshId}')" class="convert-btn small" id="history-webm-btn-${moshId}">WebM</button>
+                        <select class="export-profile small" id="history-export-profile-${moshId}">${this.exportProfileOptions()}</select>
+                        <button onclick="app.exportWithProfile('${filename}', '${moshId}', 'history-export-profile-${moshId}')" class="convert-btn small" title="Export with the selected profile">Export</button>
                         <button onclick="app.deleteMoshFromHistory('${sessionId}', '${moshId}')" class="delete-btn small" title="Delete this mosh">
                             🗑️
                         </button>
@@ -1224,6 +1322,7 @@ class MoshrApp {
         // Set converted file if it exists, OR if original is already AVI
         if (project.converted_file) {
             this.convertedPath = project.converted_file;
+            this.showConversions(project);
             this.moshBtn.disabled = false;
         } else if (project.original_file.toLowerCase().endsWith('.avi')) {
             // Original file is already AVI, can use it directly for moshing
@@ -1271,13 +1370,10 @@ class MoshrApp {
         this.progressBar.style.width = percentage + '%';
     }
 
-    async convertMosh(filename, format) {
+    async convertMosh(filename, moshId, format) {
         if (!this.currentProjectData) return;
 
         try {
-            // Extract mosh ID from filename (moshed_moshId.avi -> moshId)
-            const moshId = filename.replace('moshed_', '').replace('.avi', '');
-            
             // Show local progress bar immediately
             this.showLocalProgress(moshId, `Starting ${format.toUpperCase()} conversion...`, 5);
 
@@ -1287,7 +1383,8 @@ class MoshrApp {
                     'Content-Type': 'application/json'
                 },
                 body: JSON.stringify({
-                    format: format
+                    format: format,
+                    mosh_id: moshId
                 })
             });
 
@@ -1311,8 +1408,6 @@ class MoshrApp {
 
         } catch (error) {
             console.error('Conversion error:', error);
-            // Extract mosh ID for error display
-            const moshId = filename.replace('moshed_', '').replace('.avi', '');
             this.showLocalProgress(moshId, 'Conversion failed', 0);
             alert('Conversion failed: ' + error.message);
         }
@@ -1383,21 +1478,7 @@ class MoshrApp {
         }
     }
     
-    showConvertedFile(conversionId, format) {
-        // Extract mosh ID from conversion ID 
-        // New format: convert_moshed_MOSHID.avi_FORMAT_TIMESTAMP
-        let match = conversionId.match(/convert_moshed_(.+?)\.avi_(.+?)_(\d+)/);
-        let moshId;
-        
-        if (match) {
-            moshId = match[1];
-        } else {
-            // Fallback for old format: convert_moshed_MOSHID.avi_TIMESTAMP
-            match = conversionId.match(/convert_moshed_(.+?)\.avi_/);
-            if (!match) return;
-            moshId = match[1];
-        }
-        
+    showConvertedFile(moshId, format) {
         console.log('Updating converted file UI for mosh ID:', moshId, 'format:', format);
         
         // Update all convert buttons for this mosh ID and format
@@ -1419,6 +1500,8 @@ class MoshrApp {
     }
     
     updateConvertedFileUI(moshId, convertedFiles) {
+        this.markExportedProfiles(moshId, convertedFiles);
+
         // Update MP4 button if file exists
         if (convertedFiles.mp4) {
             const mp4Btn = document.getElementById(`mp4-btn-${moshId}`);
@@ -1448,6 +1531,7 @@ class MoshrApp {
             
             const data = await response.json();
             const convertedFiles = data.converted_files;
+            this.markExportedProfiles(moshId, convertedFiles);
 
             // Update MP4 button if file exists
             if (convertedFiles.mp4) {
@@ -1473,13 +1557,6 @@ class MoshrApp {
     }
     
     
-    getSessionIdFromTimestamp(timestamp) {
-        // Convert timestamp to session directory format (session_UNIX_TIMESTAMP)
-        const date = new Date(timestamp);
-        const unixTimestamp = Math.floor(date.getTime() / 1000);
-        return `session_${unixTimestamp}`;
-    }
-    
     async handleMp4Action(filename, moshId) {
         // Check if MP4 already exists
         const mp4Btn = document.getElementById(`mp4-btn-${moshId}`) || document.getElementById(`history-mp4-btn-${moshId}`);
@@ -1488,7 +1565,7 @@ class MoshrApp {
             this.playConvertedFile(moshId, 'mp4');
         } else {
             // MP4 doesn't exist, convert it
-            this.convertMosh(filename, 'mp4');
+            this.convertMosh(filename, moshId, 'mp4');
         }
     }
     
@@ -1500,7 +1577,7 @@ class MoshrApp {
             this.playConvertedFile(moshId, 'webm');
         } else {
             // WebM doesn't exist, convert it
-            this.convertMosh(filename, 'webm');
+            this.convertMosh(filename, moshId, 'webm');
         }
     }
     
@@ -1532,9 +1609,14 @@ class MoshrApp {
                 return;
             }
             
-            // Generate session ID from current time (approximation for current session)
-            const sessionId = this.getCurrentSessionId();
-            const response = await fetch(`/api/projects/${this.currentProjectData.id}/sessions/${sessionId}/mosh/${moshId}`, {
+            // Look up the session the mosh was rendered in
+            const jobResponse = await fetch(`/api/jobs/${moshId}`);
+            if (!jobResponse.ok) {
+                alert('Failed to find mosh.');
+                return;
+            }
+            const job = await jobResponse.json();
+            const response = await fetch(`/api/projects/${job.project_id}/sessions/${job.session_id}/mosh/${moshId}`, {
                 method: 'DELETE'
             });
             
@@ -1598,13 +1680,6 @@ class MoshrApp {
         }
     }
     
-    getCurrentSessionId() {
-        // Generate session ID for current time
-        const now = new Date();
-        const unixTimestamp = Math.floor(now.getTime() / 1000);
-        return `session_${unixTimestamp}`;
-    }
-    
     async reloadCurrentProject() {
         if (!this.currentProjectData) return;
         